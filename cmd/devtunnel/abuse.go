@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/auditmos/devtunnel/tunnel"
+)
+
+type abuseReportRepoAdapter struct {
+	repo *storage.SQLiteAbuseReportRepo
+}
+
+func (a *abuseReportRepoAdapter) Save(report *tunnel.AbuseReport) error {
+	stored := &storage.AbuseReport{
+		ID:         report.ID,
+		Subdomain:  report.Subdomain,
+		URL:        report.URL,
+		Reason:     report.Reason,
+		RemoteAddr: report.RemoteAddr,
+		UserAgent:  report.UserAgent,
+		CreatedAt:  report.CreatedAt,
+	}
+	if err := a.repo.Save(stored); err != nil {
+		return err
+	}
+	report.ID = stored.ID
+	return nil
+}
+
+func (a *abuseReportRepoAdapter) List(subdomain string, limit int) ([]*tunnel.AbuseReport, error) {
+	reports, err := a.repo.List(subdomain, limit)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*tunnel.AbuseReport, len(reports))
+	for i, report := range reports {
+		out[i] = &tunnel.AbuseReport{
+			ID:         report.ID,
+			Subdomain:  report.Subdomain,
+			URL:        report.URL,
+			Reason:     report.Reason,
+			RemoteAddr: report.RemoteAddr,
+			UserAgent:  report.UserAgent,
+			CreatedAt:  report.CreatedAt,
+		}
+	}
+	return out, nil
+}
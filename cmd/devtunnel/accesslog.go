@@ -0,0 +1,78 @@
+package main
+
+import (
+	"time"
+
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/auditmos/devtunnel/tunnel"
+)
+
+type accessLogRepoAdapter struct {
+	repo *storage.SQLiteAccessLogRepo
+}
+
+func (a *accessLogRepoAdapter) SaveBatch(entries []*tunnel.AccessLogEntry) error {
+	stored := make([]*storage.AccessLogEntry, len(entries))
+	for i, e := range entries {
+		stored[i] = &storage.AccessLogEntry{
+			ID:        e.ID,
+			CreatedAt: e.CreatedAt,
+			Subdomain: e.Subdomain,
+			Method:    e.Method,
+			Path:      e.Path,
+			Status:    e.Status,
+			BytesIn:   e.BytesIn,
+			BytesOut:  e.BytesOut,
+			LatencyMs: e.LatencyMs,
+			RemoteIP:  e.RemoteIP,
+			UserAgent: e.UserAgent,
+			TraceID:   e.TraceID,
+		}
+	}
+	return a.repo.SaveBatch(stored)
+}
+
+func (a *accessLogRepoAdapter) List(filter tunnel.AccessLogFilter) ([]*tunnel.AccessLogEntry, error) {
+	entries, err := a.repo.List(storage.AccessLogFilter{
+		Subdomain:   filter.Subdomain,
+		Method:      filter.Method,
+		Path:        filter.Path,
+		Status:      filter.Status,
+		StatusClass: filter.StatusClass,
+		RemoteIP:    filter.RemoteIP,
+		TraceID:     filter.TraceID,
+		Since:       filter.Since,
+		Until:       filter.Until,
+		Before:      filter.Before,
+		Limit:       filter.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*tunnel.AccessLogEntry, len(entries))
+	for i, e := range entries {
+		out[i] = &tunnel.AccessLogEntry{
+			ID:        e.ID,
+			CreatedAt: e.CreatedAt,
+			Subdomain: e.Subdomain,
+			Method:    e.Method,
+			Path:      e.Path,
+			Status:    e.Status,
+			BytesIn:   e.BytesIn,
+			BytesOut:  e.BytesOut,
+			LatencyMs: e.LatencyMs,
+			RemoteIP:  e.RemoteIP,
+			UserAgent: e.UserAgent,
+			TraceID:   e.TraceID,
+		}
+	}
+	return out, nil
+}
+
+func (a *accessLogRepoAdapter) Prune(olderThan time.Time) (int64, error) {
+	return a.repo.Prune(olderThan)
+}
+
+func (a *accessLogRepoAdapter) PruneToCount(max int) (int64, error) {
+	return a.repo.PruneToCount(max)
+}
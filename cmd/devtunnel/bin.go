@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/auditmos/devtunnel/tunnel"
+	"github.com/urfave/cli/v2"
+)
+
+func binCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "bin",
+		Usage: "manage request bins hosted by the server",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "create",
+				Usage: "register a subdomain that captures requests without a running client",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "server",
+						Aliases: []string{"s"},
+						Value:   "localhost:8080",
+						Usage:   "upstream server address",
+					},
+					&cli.StringFlag{
+						Name:    "admin-token",
+						EnvVars: []string{"DEVTUNNEL_ADMIN_TOKEN"},
+						Usage:   "server admin token, to create a bin on any free subdomain",
+					},
+					&cli.StringFlag{
+						Name:  "token",
+						Usage: "reservation token, to turn the reserved --subdomain into a bin",
+					},
+					&cli.StringFlag{
+						Name:  "subdomain",
+						Usage: "subdomain for the bin (random if not set)",
+					},
+					&cli.IntFlag{
+						Name:  "status",
+						Value: http.StatusOK,
+						Usage: "status code of the canned response",
+					},
+					&cli.StringFlag{
+						Name:  "body",
+						Usage: "body of the canned response",
+					},
+					&cli.StringSliceFlag{
+						Name:  "header",
+						Usage: "canned response header as 'Name: value' (repeatable)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					headers, err := parseHeaderFlags(c.StringSlice("header"))
+					if err != nil {
+						return err
+					}
+					token := c.String("token")
+					if token == "" {
+						token = c.String("admin-token")
+					}
+					if token == "" {
+						return fmt.Errorf("--admin-token or --token required")
+					}
+					return runBinCreate(c.String("server"), token, tunnel.CreateBinRequest{
+						Subdomain: c.String("subdomain"),
+						Status:    c.Int("status"),
+						Headers:   headers,
+						Body:      c.String("body"),
+					})
+				},
+			},
+		},
+	}
+}
+
+func parseHeaderFlags(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	headers := make(map[string]string, len(values))
+	for _, v := range values {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, want 'Name: value'", v)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+func runBinCreate(server, token string, req tunnel.CreateBinRequest) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal bin: %w", err)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/api/bins", server), bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("create bin: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("create bin: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("create bin failed: %s", body)
+	}
+
+	var created tunnel.CreateBinResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return fmt.Errorf("decode bin response: %w", err)
+	}
+
+	// bins on an existing reservation keep the token used to make them
+	if created.Token == "" {
+		created.Token = token
+		created.ViewURL += "#" + token
+	}
+	fmt.Printf("Bin URL:  %s\n", created.PublicURL)
+	fmt.Printf("View:     %s\n", created.ViewURL)
+	fmt.Printf("Token:    %s\n", created.Token)
+	fmt.Printf("Expires:  %s\n", time.UnixMilli(created.ExpiresAt).Format(time.RFC3339))
+	fmt.Printf("Attach:   devtunnel start --subdomain %s --token %s --bin\n", created.Subdomain, created.Token)
+	return nil
+}
+
+// pullBin copies the captures of the attached bin into the local database.
+func pullBin(ctx context.Context, client *tunnel.Client, logger logging.Logger) {
+	n, err := client.PullBin(ctx)
+	if err != nil {
+		logger.WithError(err).Error("client", "bin", "Failed to pull bin captures")
+		return
+	}
+	logger.WithFields(logging.Fields{"count": n}).Info("client", "bin", "Pulled bin captures")
+}
+
+type binRepoAdapter struct {
+	repo *storage.SQLiteBinRepo
+}
+
+func (a *binRepoAdapter) Save(bin *tunnel.Bin) error {
+	return a.repo.Save(&storage.Bin{
+		Subdomain:       bin.Subdomain,
+		ResponseStatus:  bin.ResponseStatus,
+		ResponseHeaders: bin.ResponseHeaders,
+		ResponseBody:    bin.ResponseBody,
+		CreatedAt:       bin.CreatedAt,
+		OwnsReservation: bin.OwnsReservation,
+	})
+}
+
+func (a *binRepoAdapter) Get(subdomain string) (*tunnel.Bin, error) {
+	bin, err := a.repo.Get(subdomain)
+	if err != nil || bin == nil {
+		return nil, err
+	}
+	return toTunnelBin(bin), nil
+}
+
+func (a *binRepoAdapter) ListCreatedBefore(before int64) ([]*tunnel.Bin, error) {
+	bins, err := a.repo.ListCreatedBefore(before)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*tunnel.Bin, len(bins))
+	for i, bin := range bins {
+		out[i] = toTunnelBin(bin)
+	}
+	return out, nil
+}
+
+func toTunnelBin(bin *storage.Bin) *tunnel.Bin {
+	return &tunnel.Bin{
+		Subdomain:       bin.Subdomain,
+		ResponseStatus:  bin.ResponseStatus,
+		ResponseHeaders: bin.ResponseHeaders,
+		ResponseBody:    bin.ResponseBody,
+		CreatedAt:       bin.CreatedAt,
+		OwnsReservation: bin.OwnsReservation,
+	}
+}
+
+func (a *binRepoAdapter) Delete(subdomain string) error {
+	return a.repo.Delete(subdomain)
+}
+
+func (a *binRepoAdapter) SaveRequest(req *tunnel.BinRequest) error {
+	stored := &storage.BinRequest{
+		ID:         req.ID,
+		Subdomain:  req.Subdomain,
+		Method:     req.Method,
+		URL:        req.URL,
+		Headers:    req.Headers,
+		Body:       req.Body,
+		RemoteAddr: req.RemoteAddr,
+		ReceivedAt: req.ReceivedAt,
+	}
+	if err := a.repo.SaveRequest(stored); err != nil {
+		return err
+	}
+	req.ID = stored.ID
+	return nil
+}
+
+func (a *binRepoAdapter) ListRequests(subdomain, after string, limit int) ([]*tunnel.BinRequest, error) {
+	reqs, err := a.repo.ListRequests(subdomain, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*tunnel.BinRequest, len(reqs))
+	for i, req := range reqs {
+		out[i] = &tunnel.BinRequest{
+			ID:         req.ID,
+			Subdomain:  req.Subdomain,
+			Method:     req.Method,
+			URL:        req.URL,
+			Headers:    req.Headers,
+			Body:       req.Body,
+			RemoteAddr: req.RemoteAddr,
+			ReceivedAt: req.ReceivedAt,
+		}
+	}
+	return out, nil
+}
+
+func (a *binRepoAdapter) TrimRequests(subdomain string, keep int) error {
+	return a.repo.TrimRequests(subdomain, keep)
+}
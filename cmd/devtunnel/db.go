@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/urfave/cli/v2"
+)
+
+// dbMaintenanceInterval is how often the client and server apply retention
+// while running.
+const dbMaintenanceInterval = 10 * time.Minute
+
+// retentionFlags bound the client database, for start and db prune.
+func retentionFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "retention-max-age",
+			Value: 30 * 24 * time.Hour,
+			Usage: "how long captured requests are kept (negative to keep forever)",
+		},
+		&cli.IntFlag{
+			Name:  "retention-max-per-tunnel",
+			Value: 10000,
+			Usage: "requests kept per tunnel, oldest removed first (negative for no cap)",
+		},
+		&cli.Int64Flag{
+			Name:  "retention-max-size",
+			Value: 1 << 30,
+			Usage: "remove the oldest requests while the database uses more than this many bytes (negative for no cap)",
+		},
+	}
+}
+
+func retentionFromFlags(c *cli.Context) storage.RequestRetention {
+	return storage.RequestRetention{
+		MaxAge:       c.Duration("retention-max-age"),
+		MaxPerTunnel: c.Int("retention-max-per-tunnel"),
+		MaxSize:      c.Int64("retention-max-size"),
+	}
+}
+
+func dbCommand() *cli.Command {
+	serverDB := &cli.BoolFlag{
+		Name:  "server-db",
+		Usage: "use the server database (server.db) instead of the client one",
+	}
+	return &cli.Command{
+		Name:  "db",
+		Usage: "inspect and shrink the local databases",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "stats",
+				Usage: "show the database size and what it holds",
+				Flags: []cli.Flag{
+					serverDB,
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print stats as JSON",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return runDBStats(c.Bool("server-db"), c.Bool("json"))
+				},
+			},
+			{
+				Name:  "prune",
+				Usage: "apply the retention policies now; pinned requests are kept",
+				Flags: append([]cli.Flag{serverDB}, retentionFlags()...),
+				Action: func(c *cli.Context) error {
+					return runDBPrune(c.Bool("server-db"), retentionFromFlags(c))
+				},
+			},
+			{
+				Name:  "vacuum",
+				Usage: "rebuild the database to return free space to the filesystem",
+				Flags: []cli.Flag{serverDB},
+				Action: func(c *cli.Context) error {
+					return runDBVacuum(c.Bool("server-db"))
+				},
+			},
+		},
+	}
+}
+
+// openLocalDB opens the client database, or the server one when server is
+// set.
+func openLocalDB(server bool) (*sql.DB, error) {
+	if server {
+		path, err := getServerDBPath()
+		if err != nil {
+			return nil, fmt.Errorf("get server db path: %w", err)
+		}
+		db, err := storage.OpenServerDB(path)
+		if err != nil {
+			return nil, fmt.Errorf("open server db: %w", err)
+		}
+		return db, nil
+	}
+	path, err := getDBPath()
+	if err != nil {
+		return nil, fmt.Errorf("get db path: %w", err)
+	}
+	db, err := storage.OpenDB(path)
+	if err != nil {
+		return nil, fmt.Errorf("open db: %w", err)
+	}
+	return db, nil
+}
+
+func runDBStats(server, jsonOutput bool) error {
+	db, err := openLocalDB(server)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stats, err := storage.Stats(db)
+	if err != nil {
+		return err
+	}
+	var requests *storage.RequestStats
+	if !server {
+		if requests, err = storage.NewSQLiteRequestRepo(db).Stats(); err != nil {
+			return err
+		}
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			*storage.DBStats
+			Requests *storage.RequestStats `json:"requests,omitempty"`
+		}{stats, requests})
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "size\t%d bytes\n", stats.SizeBytes)
+	fmt.Fprintf(w, "free\t%d bytes\n", stats.FreeBytes)
+	fmt.Fprintf(w, "auto_vacuum\t%s\n", stats.AutoVacuum)
+	if requests != nil {
+		fmt.Fprintf(w, "requests\t%d (%d pinned) in %d tunnels\n", requests.Requests, requests.Pinned, requests.Tunnels)
+		fmt.Fprintf(w, "bodies\t%d, %d bytes\n", requests.Bodies, requests.BodyBytes)
+		if requests.Oldest > 0 {
+			fmt.Fprintf(w, "oldest\t%s\n", time.UnixMilli(requests.Oldest).Format(time.RFC3339))
+			fmt.Fprintf(w, "newest\t%s\n", time.UnixMilli(requests.Newest).Format(time.RFC3339))
+		}
+	}
+	tables := make([]string, 0, len(stats.Tables))
+	for name := range stats.Tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "TABLE\tROWS")
+	for _, name := range tables {
+		fmt.Fprintf(w, "%s\t%d\n", name, stats.Tables[name])
+	}
+	return w.Flush()
+}
+
+func runDBPrune(server bool, policy storage.RequestRetention) error {
+	db, err := openLocalDB(server)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if server {
+		n, err := pruneServerDB(db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d expired shared blobs\n", n)
+	} else {
+		stats, err := storage.NewSQLiteRequestRepo(db).ApplyRetention(policy, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d requests: %d expired, %d over the per-tunnel cap, %d over the size cap\n",
+			stats.Total(), stats.Expired, stats.OverCount, stats.OverSize)
+	}
+	return storage.IncrementalVacuum(db)
+}
+
+func runDBVacuum(server bool) error {
+	db, err := openLocalDB(server)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	before, err := storage.Stats(db)
+	if err != nil {
+		return err
+	}
+	if err := storage.Vacuum(db); err != nil {
+		return err
+	}
+	after, err := storage.Stats(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Vacuumed: %d -> %d bytes\n", before.SizeBytes, after.SizeBytes)
+	return nil
+}
+
+// pruneServerDB removes expired shared blobs.
+func pruneServerDB(db *sql.DB) (int64, error) {
+	if err := storage.InitBlobSchema(db); err != nil {
+		return 0, err
+	}
+	return storage.NewSQLiteBlobRepo(db).Prune()
+}
+
+// maintainDB runs prune now and then every interval in the background,
+// handing the pages it frees back to the filesystem after each pass. It
+// runs until ctx is done or the returned stop is called; stop waits for a
+// pass in progress, so db can be closed once it returns.
+func maintainDB(ctx context.Context, db *sql.DB, interval time.Duration, logger logging.Logger, component string, prune func() (logging.Fields, error)) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			fields, err := prune()
+			if err != nil {
+				logger.WithError(err).Error(component, "retention", "Prune database failed")
+			} else if fields != nil {
+				logger.WithFields(fields).Info(component, "retention", "Database pruned")
+			}
+			if err := storage.IncrementalVacuum(db); err != nil {
+				logger.WithError(err).Error(component, "retention", "Vacuum database failed")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/auditmos/devtunnel/dashboard"
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/urfave/cli/v2"
+)
+
+func exportCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "export",
+		Usage:     "export captured requests as HAR, cURL, HTTPie, Postman or Go tests",
+		ArgsUsage: "[id...]",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:    "format",
+				Aliases: []string{"f"},
+				Value:   dashboard.FormatHAR,
+				Usage:   "one of " + strings.Join(dashboard.ExportFormats(), ", "),
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "write to this file instead of stdout",
+			},
+			&cli.StringFlag{
+				Name:  "base-url",
+				Value: "http://localhost:3000",
+				Usage: "scheme and host the exported requests point at",
+			},
+			&cli.BoolFlag{
+				Name:  "unmasked",
+				Usage: "export headers matched by scrub rules as captured",
+			},
+			&cli.StringFlag{
+				Name:  "name",
+				Usage: "Postman collection name",
+			},
+			&cli.StringFlag{
+				Name:  "package",
+				Value: "main",
+				Usage: "package of the generated Go tests",
+			},
+		}, requestFilterFlags()...),
+		Action: runExport,
+	}
+}
+
+func runExport(c *cli.Context) error {
+	opts := dashboard.ExportOptions{
+		Format:  c.String("format"),
+		BaseURL: c.String("base-url"),
+		Name:    c.String("name"),
+		Package: c.String("package"),
+		Version: version,
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return fmt.Errorf("get db path: %w", err)
+	}
+	db, err := storage.OpenDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("open db: %w", err)
+	}
+	defer db.Close()
+
+	if !c.Bool("unmasked") {
+		scrubRuleRepo := storage.NewSQLiteScrubRuleRepo(db)
+		if err := scrubRuleRepo.Seed(); err != nil {
+			return fmt.Errorf("seed scrub rules: %w", err)
+		}
+		opts.Scrubber, err = storage.NewScrubberWithRepo(scrubRuleRepo)
+		if err != nil {
+			return fmt.Errorf("init scrubber: %w", err)
+		}
+	}
+
+	replayer := &dashboard.Replayer{Repo: storage.NewSQLiteRequestRepo(db)}
+	ids := append(c.Args().Slice(), c.StringSlice("id")...)
+	requests, err := replayer.Select(ids, requestFilterFromFlags(c))
+	if err != nil {
+		return fmt.Errorf("select requests: %w", err)
+	}
+	if len(requests) == 0 {
+		return fmt.Errorf("no captured requests match")
+	}
+
+	var out io.Writer = os.Stdout
+	if path := c.String("output"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("create output: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := dashboard.Export(out, requests, opts); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	if c.String("output") != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d requests to %s\n", len(requests), c.String("output"))
+	}
+	return nil
+}
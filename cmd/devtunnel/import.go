@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/auditmos/devtunnel/dashboard"
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/urfave/cli/v2"
+)
+
+func importCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "import",
+		Usage:     "import a HAR file or cURL commands into the request store",
+		ArgsUsage: "<file.har|curl.txt|->",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "har or curl (detected when unset)",
+			},
+			&cli.StringFlag{
+				Name:  "tunnel",
+				Usage: "tunnel ID to file the requests under (default: a new import-... ID)",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() < 1 {
+				return fmt.Errorf("file argument required")
+			}
+			return runImport(c.Args().First(), c.String("format"), c.String("tunnel"))
+		},
+	}
+}
+
+func runImport(path, format, tunnelID string) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("read import: %w", err)
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return fmt.Errorf("get db path: %w", err)
+	}
+	db, err := storage.OpenDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("open db: %w", err)
+	}
+	defer db.Close()
+
+	scrubRuleRepo := storage.NewSQLiteScrubRuleRepo(db)
+	if err := scrubRuleRepo.Seed(); err != nil {
+		return fmt.Errorf("seed scrub rules: %w", err)
+	}
+	scrubber, err := storage.NewScrubberWithRepo(scrubRuleRepo)
+	if err != nil {
+		return fmt.Errorf("init scrubber: %w", err)
+	}
+
+	result, err := dashboard.Import(storage.NewSQLiteRequestRepo(db), data, dashboard.ImportOptions{
+		Format:   format,
+		TunnelID: tunnelID,
+		Scrubber: scrubber,
+	})
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+
+	fmt.Printf("Imported %d requests (%s) as tunnel %s\n", len(result.IDs), result.Format, result.TunnelID)
+	fmt.Printf("Replay them with: devtunnel replay --from-db --tunnel %s\n", result.TunnelID)
+	return nil
+}
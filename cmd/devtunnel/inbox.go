@@ -0,0 +1,133 @@
+package main
+
+import (
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/auditmos/devtunnel/tunnel"
+)
+
+type reservationRepoAdapter struct {
+	repo *storage.SQLiteReservationRepo
+}
+
+func (a *reservationRepoAdapter) Save(res *tunnel.Reservation) error {
+	return a.repo.Save(&storage.Reservation{
+		Subdomain:   res.Subdomain,
+		TokenHash:   res.TokenHash,
+		Inbox:       res.Inbox,
+		InboxStatus: res.InboxStatus,
+		SkipWarning: res.SkipWarning,
+		CreatedAt:   res.CreatedAt,
+	})
+}
+
+func (a *reservationRepoAdapter) Get(subdomain string) (*tunnel.Reservation, error) {
+	res, err := a.repo.Get(subdomain)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return toTunnelReservation(res), nil
+}
+
+func (a *reservationRepoAdapter) List() ([]*tunnel.Reservation, error) {
+	all, err := a.repo.List()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*tunnel.Reservation, len(all))
+	for i, res := range all {
+		out[i] = toTunnelReservation(res)
+	}
+	return out, nil
+}
+
+func (a *reservationRepoAdapter) Delete(subdomain string) error {
+	return a.repo.Delete(subdomain)
+}
+
+func toTunnelReservation(res *storage.Reservation) *tunnel.Reservation {
+	return &tunnel.Reservation{
+		Subdomain:   res.Subdomain,
+		TokenHash:   res.TokenHash,
+		Inbox:       res.Inbox,
+		InboxStatus: res.InboxStatus,
+		SkipWarning: res.SkipWarning,
+		CreatedAt:   res.CreatedAt,
+	}
+}
+
+type inboxRepoAdapter struct {
+	repo *storage.SQLiteInboxRepo
+}
+
+func (a *inboxRepoAdapter) Save(item *tunnel.InboxItem) error {
+	stored := &storage.InboxItem{
+		ID:         item.ID,
+		Subdomain:  item.Subdomain,
+		Method:     item.Method,
+		URL:        item.URL,
+		Headers:    item.Headers,
+		Body:       item.Body,
+		TraceID:    item.TraceID,
+		ReceivedAt: item.ReceivedAt,
+		ExpiresAt:  item.ExpiresAt,
+	}
+	if err := a.repo.Save(stored); err != nil {
+		return err
+	}
+	item.ID = stored.ID
+	return nil
+}
+
+func (a *inboxRepoAdapter) List(subdomain string) ([]*tunnel.InboxItem, error) {
+	items, err := a.repo.List(subdomain)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*tunnel.InboxItem, len(items))
+	for i, item := range items {
+		out[i] = &tunnel.InboxItem{
+			ID:         item.ID,
+			Subdomain:  item.Subdomain,
+			Method:     item.Method,
+			URL:        item.URL,
+			Headers:    item.Headers,
+			Body:       item.Body,
+			TraceID:    item.TraceID,
+			ReceivedAt: item.ReceivedAt,
+			ExpiresAt:  item.ExpiresAt,
+		}
+	}
+	return out, nil
+}
+
+func (a *inboxRepoAdapter) Delete(id string) error {
+	return a.repo.Delete(id)
+}
+
+func (a *inboxRepoAdapter) DeleteBySubdomain(subdomain string) error {
+	return a.repo.DeleteBySubdomain(subdomain)
+}
+
+func (a *inboxRepoAdapter) Stats(subdomain string) (*tunnel.InboxStats, error) {
+	st, err := a.repo.Stats(subdomain)
+	if err != nil {
+		return nil, err
+	}
+	return &tunnel.InboxStats{Subdomain: st.Subdomain, Count: st.Count, Bytes: st.Bytes}, nil
+}
+
+func (a *inboxRepoAdapter) ListStats() ([]*tunnel.InboxStats, error) {
+	all, err := a.repo.ListStats()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*tunnel.InboxStats, len(all))
+	for i, st := range all {
+		out[i] = &tunnel.InboxStats{Subdomain: st.Subdomain, Count: st.Count, Bytes: st.Bytes}
+	}
+	return out, nil
+}
+
+func (a *inboxRepoAdapter) Prune() (int64, error) {
+	return a.repo.Prune()
+}
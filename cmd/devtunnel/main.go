@@ -15,6 +15,7 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/auditmos/devtunnel/crypto"
 	"github.com/auditmos/devtunnel/dashboard"
@@ -48,6 +49,11 @@ func NewApp() *cli.App {
 			serverCommand(),
 			clientCommand(),
 			replayCommand(),
+			binCommand(),
+			usageCommand(),
+			exportCommand(),
+			importCommand(),
+			dbCommand(),
 		},
 	}
 }
@@ -75,6 +81,115 @@ func serverCommand() *cli.Command {
 				Name:  "certs-dir",
 				Usage: "certificate cache directory (default: ~/.devtunnel/certs)",
 			},
+			&cli.BoolFlag{
+				Name:  "tls-passthrough",
+				Usage: "route TLS connections by SNI to passthrough tunnels without decrypting",
+			},
+			&cli.IntFlag{
+				Name:  "tls-port",
+				Value: 443,
+				Usage: "port for the TLS listener (HTTPS and passthrough)",
+			},
+			&cli.DurationFlag{
+				Name:  "drain-timeout",
+				Value: 30 * time.Second,
+				Usage: "on shutdown, wait this long for in-flight requests while clients reconnect (0 to exit immediately)",
+			},
+			&cli.DurationFlag{
+				Name:  "resume-grace",
+				Value: 30 * time.Second,
+				Usage: "hold a dropped tunnel's subdomain this long for the client to resume it (0 to disable)",
+			},
+			&cli.BoolFlag{
+				Name:  "reuse-port",
+				Usage: "bind with SO_REUSEPORT so a new server process can start before this one exits",
+			},
+			&cli.StringFlag{
+				Name:    "admin-token",
+				EnvVars: []string{"DEVTUNNEL_ADMIN_TOKEN"},
+				Usage:   "bearer token for /api/admin (reservations and offline inbox)",
+			},
+			&cli.DurationFlag{
+				Name:  "bin-ttl",
+				Value: 7 * 24 * time.Hour,
+				Usage: "how long a request bin lives before it and its captures are deleted",
+			},
+			&cli.StringFlag{
+				Name:  "trusted-proxy-header",
+				Usage: "header carrying the visitor IP when behind a reverse proxy (e.g. X-Forwarded-For)",
+			},
+			&cli.DurationFlag{
+				Name:  "inbox-ttl",
+				Value: 24 * time.Hour,
+				Usage: "how long the offline inbox keeps a request",
+			},
+			&cli.IntFlag{
+				Name:  "inbox-max-items",
+				Value: 100,
+				Usage: "offline inbox requests kept per subdomain",
+			},
+			&cli.Int64Flag{
+				Name:  "inbox-max-bytes",
+				Value: 10 << 20,
+				Usage: "offline inbox body bytes kept per subdomain",
+			},
+			&cli.DurationFlag{
+				Name:  "read-header-timeout",
+				Value: 10 * time.Second,
+				Usage: "time allowed to read request headers (negative to disable)",
+			},
+			&cli.DurationFlag{
+				Name:  "read-timeout",
+				Value: 60 * time.Second,
+				Usage: "time allowed to read a whole request (negative to disable)",
+			},
+			&cli.DurationFlag{
+				Name:  "write-timeout",
+				Value: 2 * time.Minute,
+				Usage: "time allowed to handle a request and write its response (negative to disable)",
+			},
+			&cli.DurationFlag{
+				Name:  "idle-timeout",
+				Value: 2 * time.Minute,
+				Usage: "how long idle keep-alive connections stay open (negative to disable)",
+			},
+			&cli.IntFlag{
+				Name:  "max-header-bytes",
+				Value: 64 << 10,
+				Usage: "largest request header block accepted",
+			},
+			&cli.Int64Flag{
+				Name:  "max-body-bytes",
+				Value: 32 << 20,
+				Usage: "largest request body proxied to a tunnel, larger ones get 413 (negative to disable)",
+			},
+			&cli.DurationFlag{
+				Name:  "upstream-timeout",
+				Value: 30 * time.Second,
+				Usage: "how long a proxied request may wait for the client's local app before 504",
+			},
+			&cli.BoolFlag{
+				Name:  "interstitial",
+				Usage: "warn first-time browser visitors that a tunnel is a dev site, with an abuse report form",
+			},
+			&cli.BoolFlag{
+				Name:  "access-log",
+				Usage: "record every tunnel request (without bodies) for /api/admin/access-log",
+			},
+			&cli.DurationFlag{
+				Name:  "access-log-max-age",
+				Value: 7 * 24 * time.Hour,
+				Usage: "how long the access log keeps a request (negative to keep forever)",
+			},
+			&cli.IntFlag{
+				Name:  "access-log-max-rows",
+				Value: 1000000,
+				Usage: "access log requests kept, oldest removed first (negative for no cap)",
+			},
+			&cli.StringFlag{
+				Name:  "overrides-dir",
+				Usage: "directory of *.html files replacing the built-in pages, e.g. error.html (default: ~/.devtunnel/server-overrides)",
+			},
 			&cli.BoolFlag{
 				Name:  "json",
 				Usage: "output logs in JSONL format",
@@ -90,14 +205,49 @@ func serverCommand() *cli.Command {
 			},
 		},
 		Action: func(c *cli.Context) error {
-			port := c.Int("port")
-			domain := c.String("domain")
-			https := c.Bool("https")
-			certsDir := c.String("certs-dir")
-			jsonOutput := c.Bool("json")
-			logLevel := c.String("log-level")
-			logFile := c.String("log-file")
-			return runServer(port, domain, https, certsDir, jsonOutput, logLevel, logFile)
+			inboxLimits := tunnel.InboxLimits{
+				MaxItems: c.Int("inbox-max-items"),
+				MaxBytes: c.Int64("inbox-max-bytes"),
+				TTL:      c.Duration("inbox-ttl"),
+			}
+			limits := tunnel.ServerLimits{
+				ReadHeaderTimeout: c.Duration("read-header-timeout"),
+				ReadTimeout:       c.Duration("read-timeout"),
+				WriteTimeout:      c.Duration("write-timeout"),
+				IdleTimeout:       c.Duration("idle-timeout"),
+				MaxHeaderBytes:    c.Int("max-header-bytes"),
+				MaxBodyBytes:      c.Int64("max-body-bytes"),
+				UpstreamTimeout:   c.Duration("upstream-timeout"),
+			}
+			var accessLog *tunnel.AccessLogRetention
+			if c.Bool("access-log") {
+				accessLog = &tunnel.AccessLogRetention{
+					MaxAge:  c.Duration("access-log-max-age"),
+					MaxRows: c.Int("access-log-max-rows"),
+				}
+			}
+			return runServer(serverOptions{
+				port:           c.Int("port"),
+				domain:         c.String("domain"),
+				https:          c.Bool("https"),
+				certsDir:       c.String("certs-dir"),
+				tlsPassthrough: c.Bool("tls-passthrough"),
+				tlsPort:        c.Int("tls-port"),
+				drainTimeout:   c.Duration("drain-timeout"),
+				reusePort:      c.Bool("reuse-port"),
+				resumeGrace:    c.Duration("resume-grace"),
+				adminToken:     c.String("admin-token"),
+				binTTL:         c.Duration("bin-ttl"),
+				trustedProxy:   c.String("trusted-proxy-header"),
+				inboxLimits:    inboxLimits,
+				limits:         limits,
+				overridesDir:   c.String("overrides-dir"),
+				interstitial:   c.Bool("interstitial"),
+				accessLog:      accessLog,
+				jsonOutput:     c.Bool("json"),
+				logLevel:       c.String("log-level"),
+				logFile:        c.String("log-file"),
+			})
 		},
 	}
 }
@@ -107,7 +257,7 @@ func clientCommand() *cli.Command {
 		Name:      "start",
 		Usage:     "expose local port to the internet",
 		ArgsUsage: "[port]",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:    "port",
 				Aliases: []string{"p"},
@@ -128,6 +278,10 @@ func clientCommand() *cli.Command {
 				Name:  "json",
 				Usage: "output logs in JSONL format",
 			},
+			&cli.IntFlag{
+				Name:  "json-max-body",
+				Usage: "keep at most this many bytes of each body in --json output (0 for all)",
+			},
 			&cli.StringFlag{
 				Name:  "log-level",
 				Value: "info",
@@ -142,19 +296,86 @@ func clientCommand() *cli.Command {
 				Value: "127.0.0.1:4040",
 				Usage: "dashboard listen address",
 			},
-		},
+			&cli.BoolFlag{
+				Name:  "tls-passthrough",
+				Usage: "receive raw TLS from the server instead of decrypted HTTP",
+			},
+			&cli.StringFlag{
+				Name:  "tls-cert",
+				Usage: "certificate file to terminate passthrough TLS locally",
+			},
+			&cli.StringFlag{
+				Name:  "tls-key",
+				Usage: "private key file for --tls-cert",
+			},
+			&cli.StringFlag{
+				Name:  "subdomain",
+				Usage: "request this subdomain",
+			},
+			&cli.StringFlag{
+				Name:    "token",
+				EnvVars: []string{"DEVTUNNEL_TOKEN"},
+				Usage:   "token for a subdomain reserved on the server",
+			},
+			&cli.StringFlag{
+				Name:  "pool",
+				Usage: "share the reserved --subdomain with other clients, balanced by round-robin, least-inflight or sticky",
+			},
+			&cli.BoolFlag{
+				Name:  "bin",
+				Usage: "attach to the bin reserved as --subdomain and pull its captures into the dashboard",
+			},
+			&cli.BoolFlag{
+				Name:  "no-compression",
+				Usage: "send tunnel frames uncompressed even if the server supports compression",
+			},
+			&cli.Int64Flag{
+				Name:  "max-body-bytes",
+				Usage: "ask the server to refuse request bodies over this size (capped by the server's limit)",
+			},
+			&cli.IntFlag{
+				Name:  "max-stored-body",
+				Value: storage.DefaultMaxStoredBody,
+				Usage: "keep at most this many bytes of each body in the request database (0 for all)",
+			},
+			&cli.StringFlag{
+				Name:  "health-path",
+				Usage: "HTTP path to health-check the local app (default: TCP connect to the port)",
+			},
+			&cli.DurationFlag{
+				Name:  "health-interval",
+				Value: 10 * time.Second,
+				Usage: "how often to health-check the local app (negative to disable)",
+			},
+		}, retentionFlags()...),
 		Action: func(c *cli.Context) error {
 			port := c.String("port")
 			if c.NArg() > 0 {
 				port = c.Args().First()
 			}
-			server := c.String("server")
-			safe := c.Bool("safe")
-			jsonOutput := c.Bool("json")
-			logLevel := c.String("log-level")
-			logFile := c.String("log-file")
-			dashboardAddr := c.String("dashboard-addr")
-			return runClient(port, server, safe, jsonOutput, logLevel, logFile, dashboardAddr)
+			return runClient(clientOptions{
+				port:           port,
+				server:         c.String("server"),
+				safe:           c.Bool("safe"),
+				jsonOutput:     c.Bool("json"),
+				jsonMaxBody:    c.Int("json-max-body"),
+				logLevel:       c.String("log-level"),
+				logFile:        c.String("log-file"),
+				dashboardAddr:  c.String("dashboard-addr"),
+				tlsPassthrough: c.Bool("tls-passthrough"),
+				tlsCert:        c.String("tls-cert"),
+				tlsKey:         c.String("tls-key"),
+				noCompression:  c.Bool("no-compression"),
+				maxBodyBytes:   c.Int64("max-body-bytes"),
+				maxStoredBody:  c.Int("max-stored-body"),
+				retention:      retentionFromFlags(c),
+				healthPath:     c.String("health-path"),
+				healthInterval: c.Duration("health-interval"),
+				subdomain:      c.String("subdomain"),
+				token:          c.String("token"),
+				bin:            c.Bool("bin"),
+				pool:           c.String("pool"),
+			})
 		},
 	}
 }
@@ -164,15 +385,18 @@ func replayCommand() *cli.Command {
 		Name:      "replay",
 		Usage:     "replay a shared request to localhost",
 		ArgsUsage: "<url>",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.IntFlag{
 				Name:    "port",
 				Aliases: []string{"p"},
 				Value:   3000,
 				Usage:   "local port to replay to",
 			},
-		},
+		}, bulkReplayFlags()...),
 		Action: func(c *cli.Context) error {
+			if c.Bool("from-db") {
+				return runBulkReplay(c)
+			}
 			if c.NArg() < 1 {
 				return fmt.Errorf("url argument required")
 			}
@@ -183,11 +407,35 @@ func replayCommand() *cli.Command {
 	}
 }
 
-func runServer(port int, domain string, https bool, certsDir string, jsonOutput bool, logLevel, logFile string) error {
+type serverOptions struct {
+	port           int
+	domain         string
+	https          bool
+	certsDir       string
+	tlsPassthrough bool
+	tlsPort        int
+	drainTimeout   time.Duration
+	reusePort      bool
+	resumeGrace    time.Duration
+	adminToken     string
+	binTTL         time.Duration
+	trustedProxy   string
+	inboxLimits    tunnel.InboxLimits
+	limits         tunnel.ServerLimits
+	overridesDir   string
+	interstitial   bool
+	// accessLog enables the access log with this retention when set.
+	accessLog  *tunnel.AccessLogRetention
+	jsonOutput bool
+	logLevel   string
+	logFile    string
+}
+
+func runServer(opts serverOptions) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	logger, logCleanup, err := initLogger(jsonOutput, logLevel, logFile, false)
+	logger, logCleanup, err := initLogger(opts.jsonOutput, opts.logLevel, opts.logFile, false)
 	if err != nil {
 		return fmt.Errorf("init logger: %w", err)
 	}
@@ -198,7 +446,7 @@ func runServer(port int, domain string, https bool, certsDir string, jsonOutput
 
 	go func() {
 		<-sigCh
-		logger.Info("server", "shutdown", "Shutting down")
+		logger.WithFields(logging.Fields{"drain_timeout": opts.drainTimeout.String()}).Info("server", "shutdown", "Shutting down")
 		cancel()
 	}()
 
@@ -225,6 +473,44 @@ func runServer(port int, domain string, https bool, certsDir string, jsonOutput
 		return fmt.Errorf("seed rate_limits: %w", err)
 	}
 
+	if err := storage.InitRatePlansSchema(db); err != nil {
+		return fmt.Errorf("init rate_plans schema: %w", err)
+	}
+
+	if err := storage.SeedRatePlans(db); err != nil {
+		return fmt.Errorf("seed rate_plans: %w", err)
+	}
+
+	if err := storage.InitReservationsSchema(db); err != nil {
+		return fmt.Errorf("init reservations schema: %w", err)
+	}
+
+	if err := storage.InitInboxSchema(db); err != nil {
+		return fmt.Errorf("init inbox schema: %w", err)
+	}
+
+	if err := storage.InitBinsSchema(db); err != nil {
+		return fmt.Errorf("init bins schema: %w", err)
+	}
+
+	if err := storage.InitAbuseReportsSchema(db); err != nil {
+		return fmt.Errorf("init abuse_reports schema: %w", err)
+	}
+
+	if err := storage.InitUsageSchema(db); err != nil {
+		return fmt.Errorf("init usage schema: %w", err)
+	}
+
+	var accessLog tunnel.AccessLogRepo
+	var accessLogRetention tunnel.AccessLogRetention
+	if opts.accessLog != nil {
+		if err := storage.InitAccessLogSchema(db); err != nil {
+			return fmt.Errorf("init access_log schema: %w", err)
+		}
+		accessLog = &accessLogRepoAdapter{repo: storage.NewSQLiteAccessLogRepo(db)}
+		accessLogRetention = *opts.accessLog
+	}
+
 	rateLimitRepo := storage.NewSQLiteRateLimitRepo(db)
 	limits, err := rateLimitRepo.Get()
 	if err != nil {
@@ -232,25 +518,76 @@ func runServer(port int, domain string, https bool, certsDir string, jsonOutput
 	}
 
 	blobRepo := &blobRepoAdapter{repo: storage.NewSQLiteBlobRepo(db)}
+	stopMaintenance := maintainDB(ctx, db, dbMaintenanceInterval, logger, "server", func() (logging.Fields, error) {
+		n, err := blobRepo.repo.Prune()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		return logging.Fields{"blobs": n}, nil
+	})
+	// deferred after db.Close, so it runs first and a pass never hits a closed db
+	defer stopMaintenance()
 
-	httpPort := port
-	if https {
+	overridesDir := opts.overridesDir
+	if overridesDir == "" {
+		overridesDir = filepath.Join(filepath.Dir(dbPath), "server-overrides")
+	}
+
+	httpPort := opts.port
+	if opts.https {
 		httpPort = 80
 	}
 
 	srv := tunnel.NewServer(tunnel.ServerConfig{
-		Addr:           fmt.Sprintf(":%d", httpPort),
-		Domain:         domain,
-		BlobRepo:       blobRepo,
-		AutoDomain:     domain == "",
-		EnableHTTPS:    https,
-		CertsDir:       certsDir,
-		Version:        version,
-		RequestsPerMin: limits.RequestsPerMin,
-		MaxConns:       limits.MaxConcurrentConns,
-		Logger:         logger,
+		Addr:               fmt.Sprintf(":%d", httpPort),
+		Domain:             opts.domain,
+		BlobRepo:           blobRepo,
+		AutoDomain:         opts.domain == "",
+		EnableHTTPS:        opts.https,
+		TLSAddr:            fmt.Sprintf(":%d", opts.tlsPort),
+		Passthrough:        opts.tlsPassthrough,
+		CertsDir:           opts.certsDir,
+		Version:            version,
+		RequestsPerMin:     limits.RequestsPerMin,
+		MaxConns:           limits.MaxConcurrentConns,
+		Logger:             logger,
+		DrainTimeout:       opts.drainTimeout,
+		ReusePort:          opts.reusePort,
+		ResumeGrace:        opts.resumeGrace,
+		Reservations:       &reservationRepoAdapter{repo: storage.NewSQLiteReservationRepo(db)},
+		Inbox:              &inboxRepoAdapter{repo: storage.NewSQLiteInboxRepo(db)},
+		InboxLimits:        opts.inboxLimits,
+		Limits:             opts.limits,
+		AdminToken:         opts.adminToken,
+		Bins:               &binRepoAdapter{repo: storage.NewSQLiteBinRepo(db)},
+		BinTTL:             opts.binTTL,
+		OverridesDir:       overridesDir,
+		Interstitial:       opts.interstitial,
+		AbuseReports:       &abuseReportRepoAdapter{repo: storage.NewSQLiteAbuseReportRepo(db)},
+		RatePlans:          &ratePlanRepoAdapter{repo: storage.NewSQLiteRatePlanRepo(db)},
+		Usage:              &usageRepoAdapter{repo: storage.NewSQLiteUsageRepo(db)},
+		AccessLog:          accessLog,
+		AccessLogRetention: accessLogRetention,
+		TrustedProxyHeader: opts.trustedProxy,
 	})
 
+	// SIGHUP rereads rate plans, for edits made to the database directly
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupCh:
+				if err := srv.ReloadRatePlans(); err != nil {
+					logger.WithError(err).Error("server", "ratelimit", "Reload rate plans failed")
+				}
+			}
+		}
+	}()
+
 	srv.SetReadyCallback(func() {
 		logger.WithFields(logging.Fields{"addr": srv.Addr()}).Info("server", "start", "Server ready")
 	})
@@ -270,11 +607,44 @@ func getServerDBPath() (string, error) {
 	return filepath.Join(dir, "server.db"), nil
 }
 
-func runClient(port, server string, safe, jsonOutput bool, logLevel, logFile, dashboardAddr string) error {
+type clientOptions struct {
+	port           string
+	server         string
+	safe           bool
+	jsonOutput     bool
+	jsonMaxBody    int
+	logLevel       string
+	logFile        string
+	dashboardAddr  string
+	tlsPassthrough bool
+	tlsCert        string
+	tlsKey         string
+	noCompression  bool
+	maxBodyBytes   int64
+	maxStoredBody  int
+	retention      storage.RequestRetention
+	healthPath     string
+	healthInterval time.Duration
+	subdomain      string
+	token          string
+	bin            bool
+	pool           string
+}
+
+func runClient(opts clientOptions) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	logger, logCleanup, err := initLogger(jsonOutput, logLevel, logFile, safe)
+	if opts.bin && (opts.subdomain == "" || opts.token == "") {
+		return fmt.Errorf("--bin requires --subdomain and --token")
+	}
+	if opts.pool != "" && (opts.subdomain == "" || opts.token == "") {
+		return fmt.Errorf("--pool requires --subdomain and --token")
+	}
+
+	port, server, safe := opts.port, opts.server, opts.safe
+
+	logger, logCleanup, err := initLogger(opts.jsonOutput, opts.logLevel, opts.logFile, safe)
 	if err != nil {
 		return fmt.Errorf("init logger: %w", err)
 	}
@@ -305,6 +675,15 @@ func runClient(port, server string, safe, jsonOutput bool, logLevel, logFile, da
 	defer db.Close()
 
 	repo := storage.NewSQLiteRequestRepo(db)
+	repo.SetMaxBodySize(opts.maxStoredBody)
+	stopMaintenance := maintainDB(ctx, db, dbMaintenanceInterval, logger, "client", func() (logging.Fields, error) {
+		stats, err := repo.ApplyRetention(opts.retention, time.Now())
+		if err != nil || stats.Total() == 0 {
+			return nil, err
+		}
+		return logging.Fields{"expired": stats.Expired, "over_count": stats.OverCount, "over_size": stats.OverSize}, nil
+	})
+	defer stopMaintenance()
 	tunnelRepo := storage.NewSQLiteTunnelRepo(db)
 	scrubRuleRepo := storage.NewSQLiteScrubRuleRepo(db)
 
@@ -321,11 +700,15 @@ func runClient(port, server string, safe, jsonOutput bool, logLevel, logFile, da
 	}
 
 	tunnelID := ulid.Make().String()
-	dbLogger := storage.NewDBLogger(repo, tunnelID, scrubber)
+	// requests are saved off the proxy path; Close runs before db.Close
+	// so whatever is queued gets written
+	dbLogger := storage.NewAsyncDBLogger(repo, tunnelID, scrubber, logger)
+	defer dbLogger.Close()
 
 	var reqLogger tunnel.RequestLogger = dbLogger
-	if jsonOutput {
+	if opts.jsonOutput {
 		jsonLogger := storage.NewJSONLogger(os.Stdout, scrubber)
+		jsonLogger.SetMaxBody(opts.jsonMaxBody)
 		reqLogger = storage.NewMultiLogger(dbLogger, jsonLogger)
 	}
 
@@ -333,13 +716,14 @@ func runClient(port, server string, safe, jsonOutput bool, logLevel, logFile, da
 
 	overridesDir := filepath.Join(filepath.Dir(dbPath), "overrides")
 	dashSrv, err := dashboard.NewServer(dashboard.ServerConfig{
-		Addr:          dashboardAddr,
+		Addr:          opts.dashboardAddr,
 		Repo:          repo,
 		ScrubRuleRepo: scrubRuleRepo,
 		OverridesDir:  overridesDir,
 		LocalAddr:     "localhost:" + port,
 		ServerAddr:    server,
 		Logger:        logger,
+		RequestLog:    dbLogger.Stats,
 	})
 	if err != nil {
 		return fmt.Errorf("init dashboard: %w", err)
@@ -356,9 +740,20 @@ func runClient(port, server string, safe, jsonOutput bool, logLevel, logFile, da
 	}()
 
 	client := tunnel.NewClient(tunnel.ClientConfig{
-		ServerAddr: server,
-		LocalPort:  port,
-		Logger:     logger,
+		ServerAddr:     server,
+		LocalPort:      port,
+		Subdomain:      opts.subdomain,
+		AuthToken:      opts.token,
+		Pool:           opts.pool,
+		Logger:         logger,
+		TLSPassthrough: opts.tlsPassthrough,
+		TLSCertFile:    opts.tlsCert,
+		TLSKeyFile:     opts.tlsKey,
+
+		DisableCompression: opts.noCompression,
+		MaxBodyBytes:       opts.maxBodyBytes,
+		HealthPath:         opts.healthPath,
+		HealthInterval:     opts.healthInterval,
 	})
 
 	client.SetLogger(reqLogger)
@@ -366,6 +761,16 @@ func runClient(port, server string, safe, jsonOutput bool, logLevel, logFile, da
 	client.OnConnected(func(publicURL string) {
 		subdomain := extractSubdomain(publicURL)
 		logger.WithFields(logging.Fields{"public_url": publicURL, "local_port": port}).Info("client", "connect", "Forwarding")
+		if opts.bin {
+			go pullBin(ctx, client, logger)
+		}
+		// reconnects reuse the tunnel row saved on first connect
+		if existing, err := tunnelRepo.Get(tunnelID); err == nil && existing != nil {
+			if err := tunnelRepo.UpdateStatus(tunnelID, "active", 0); err != nil {
+				logger.WithError(err).Error("client", "tunnel_save", "Failed to update tunnel")
+			}
+			return
+		}
 		t := &storage.Tunnel{
 			ID:        tunnelID,
 			Subdomain: subdomain,
@@ -377,6 +782,17 @@ func runClient(port, server string, safe, jsonOutput bool, logLevel, logFile, da
 		}
 	})
 
+	client.OnControl(func(msg tunnel.ControlMessage) {
+		if msg.Type == tunnel.ControlConfig {
+			return
+		}
+		dashSrv.AddNotice(dashboard.Notice{
+			Type:      msg.Type,
+			Message:   msg.Message,
+			Timestamp: msg.Timestamp,
+		})
+	})
+
 	client.OnDisconnect(func(err error) {
 		logger.WithError(err).Warn("client", "disconnect", "Disconnected")
 		if updateErr := tunnelRepo.UpdateStatus(tunnelID, "disconnected", time.Now().UnixMilli()); updateErr != nil {
@@ -416,15 +832,19 @@ func extractSubdomain(publicURL string) string {
 	return ""
 }
 
+// SharedRequest is the decrypted form of dashboard.ShareableRequest. Shares
+// made before bodies carried an encoding leave it empty, meaning UTF-8.
 type SharedRequest struct {
-	Method          string            `json:"method"`
-	URL             string            `json:"url"`
-	RequestHeaders  map[string]string `json:"request_headers"`
-	RequestBody     string            `json:"request_body"`
-	StatusCode      int               `json:"status_code"`
-	ResponseHeaders map[string]string `json:"response_headers"`
-	ResponseBody    string            `json:"response_body"`
-	DurationMs      int64             `json:"duration_ms"`
+	Method               string            `json:"method"`
+	URL                  string            `json:"url"`
+	RequestHeaders       map[string]string `json:"request_headers"`
+	RequestBody          string            `json:"request_body"`
+	RequestBodyEncoding  string            `json:"request_body_encoding"`
+	StatusCode           int               `json:"status_code"`
+	ResponseHeaders      map[string]string `json:"response_headers"`
+	ResponseBody         string            `json:"response_body"`
+	ResponseBodyEncoding string            `json:"response_body_encoding"`
+	DurationMs           int64             `json:"duration_ms"`
 }
 
 func runReplay(shareURL string, port int) error {
@@ -485,10 +905,15 @@ func runReplay(shareURL string, port int) error {
 		return fmt.Errorf("unmarshal request: %w", err)
 	}
 
+	reqBody, err := storage.DecodeBodyString(req.RequestBody, req.RequestBodyEncoding)
+	if err != nil {
+		return fmt.Errorf("decode request body: %w", err)
+	}
+
 	fmt.Printf("Replaying %s %s to localhost:%d\n", req.Method, req.URL, port)
 
 	localURL := fmt.Sprintf("http://localhost:%d%s", port, req.URL)
-	httpReq, err := http.NewRequest(req.Method, localURL, bytes.NewReader([]byte(req.RequestBody)))
+	httpReq, err := http.NewRequest(req.Method, localURL, bytes.NewReader(reqBody))
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
@@ -509,7 +934,11 @@ func runReplay(shareURL string, port int) error {
 	}
 
 	fmt.Printf("Response: %d %s\n", localResp.StatusCode, localResp.Status)
-	fmt.Printf("Body:\n%s\n", respBody)
+	if utf8.Valid(respBody) {
+		fmt.Printf("Body:\n%s\n", respBody)
+	} else {
+		fmt.Printf("Body: %d bytes of binary data\n", len(respBody))
+	}
 
 	return nil
 }
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/auditmos/devtunnel/logging"
+	"github.com/auditmos/devtunnel/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/urfave/cli/v2"
@@ -12,7 +16,7 @@ func TestNewApp(t *testing.T) {
 	app := NewApp()
 	assert.Equal(t, "devtunnel", app.Name)
 	assert.Equal(t, "expose localhost to the internet", app.Usage)
-	assert.Len(t, app.Commands, 3)
+	assert.Len(t, app.Commands, 8)
 }
 
 func TestServerCommand(t *testing.T) {
@@ -120,6 +124,36 @@ func TestReplayRequiresURL(t *testing.T) {
 	assert.Contains(t, err.Error(), "url argument required")
 }
 
+func TestReplayFromDBFlagsExist(t *testing.T) {
+	cmd := replayCommand()
+	names := map[string]bool{}
+	for _, f := range cmd.Flags {
+		names[f.Names()[0]] = true
+	}
+	for _, name := range []string{"from-db", "id", "concurrency", "preserve-timing", "max-gap", "stop-on-failure"} {
+		assert.True(t, names[name], "%s flag not found", name)
+	}
+}
+
+func TestDBCommand(t *testing.T) {
+	cmd := dbCommand()
+	subcommands := map[string]*cli.Command{}
+	for _, sub := range cmd.Subcommands {
+		subcommands[sub.Name] = sub
+	}
+	for _, name := range []string{"stats", "prune", "vacuum"} {
+		assert.NotNil(t, subcommands[name], "%s subcommand not found", name)
+	}
+
+	names := map[string]bool{}
+	for _, f := range clientCommand().Flags {
+		names[f.Names()[0]] = true
+	}
+	for _, f := range subcommands["prune"].Flags {
+		assert.True(t, names[f.Names()[0]] || f.Names()[0] == "server-db", "start lacks %s", f.Names()[0])
+	}
+}
+
 func TestClientJSONFlagExists(t *testing.T) {
 	cmd := clientCommand()
 	var found bool
@@ -223,3 +257,36 @@ func TestInitLoggerToFile(t *testing.T) {
 	defer cleanup()
 	assert.NotNil(t, logger)
 }
+
+func TestMaintainDBStopWaitsForPass(t *testing.T) {
+	db, err := storage.OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	stop := maintainDB(context.Background(), db, time.Hour, logging.NopLogger{}, "test", func() (logging.Fields, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stop returned while a pass was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop did not return after the pass finished")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/auditmos/devtunnel/tunnel"
+)
+
+type ratePlanRepoAdapter struct {
+	repo *storage.SQLiteRatePlanRepo
+}
+
+func (a *ratePlanRepoAdapter) ListPlans() ([]*tunnel.RatePlan, error) {
+	plans, err := a.repo.ListPlans()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*tunnel.RatePlan, len(plans))
+	for i, plan := range plans {
+		out[i] = &tunnel.RatePlan{
+			Name:            plan.Name,
+			RequestsPerMin:  plan.RequestsPerMin,
+			Burst:           plan.Burst,
+			MaxConns:        plan.MaxConns,
+			DailyRequests:   plan.DailyRequests,
+			MonthlyRequests: plan.MonthlyRequests,
+			DailyBytes:      plan.DailyBytes,
+			MonthlyBytes:    plan.MonthlyBytes,
+		}
+	}
+	return out, nil
+}
+
+func (a *ratePlanRepoAdapter) SavePlan(plan *tunnel.RatePlan) error {
+	return a.repo.SavePlan(&storage.RatePlan{
+		Name:            plan.Name,
+		RequestsPerMin:  plan.RequestsPerMin,
+		Burst:           plan.Burst,
+		MaxConns:        plan.MaxConns,
+		DailyRequests:   plan.DailyRequests,
+		MonthlyRequests: plan.MonthlyRequests,
+		DailyBytes:      plan.DailyBytes,
+		MonthlyBytes:    plan.MonthlyBytes,
+	})
+}
+
+func (a *ratePlanRepoAdapter) DeletePlan(name string) error {
+	return a.repo.DeletePlan(name)
+}
+
+func (a *ratePlanRepoAdapter) ListTenantPlans() ([]*tunnel.TenantPlan, error) {
+	overrides, err := a.repo.ListTenantPlans()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*tunnel.TenantPlan, len(overrides))
+	for i, o := range overrides {
+		out[i] = &tunnel.TenantPlan{Tenant: o.Tenant, Plan: o.Plan}
+	}
+	return out, nil
+}
+
+func (a *ratePlanRepoAdapter) SetTenantPlan(tenant, plan string) error {
+	return a.repo.SetTenantPlan(tenant, plan)
+}
+
+func (a *ratePlanRepoAdapter) DeleteTenantPlan(tenant string) error {
+	return a.repo.DeleteTenantPlan(tenant)
+}
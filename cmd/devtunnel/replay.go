@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/auditmos/devtunnel/dashboard"
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/urfave/cli/v2"
+)
+
+func bulkReplayFlags() []cli.Flag {
+	return append(requestFilterFlags(), []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "from-db",
+			Usage: "replay requests captured in the local database instead of a shared URL",
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Value: 1,
+			Usage: "requests in flight at once; 1 replays strictly in order",
+		},
+		&cli.BoolFlag{
+			Name:  "preserve-timing",
+			Usage: "keep the original gaps between requests",
+		},
+		&cli.DurationFlag{
+			Name:  "max-gap",
+			Usage: "with --preserve-timing, wait at most this long between requests",
+		},
+		&cli.BoolFlag{
+			Name:  "stop-on-failure",
+			Usage: "stop after the first request that errors or gets a 5xx",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the summary as JSON",
+		},
+	}...)
+}
+
+// requestFilterFlags pick captured requests from the local database, by ID
+// or by filter.
+func requestFilterFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "id",
+			Usage: "captured request ID (repeatable); overrides the filter flags",
+		},
+		&cli.StringFlag{
+			Name:  "tunnel",
+			Usage: "only requests captured by this tunnel session",
+		},
+		&cli.StringFlag{
+			Name:  "method",
+			Usage: "only requests with this method",
+		},
+		&cli.StringFlag{
+			Name:  "path",
+			Usage: "only requests whose URL starts with this prefix",
+		},
+		&cli.DurationFlag{
+			Name:  "since",
+			Usage: "only requests captured within this long ago",
+		},
+		&cli.BoolFlag{
+			Name:  "include-replays",
+			Usage: "also match earlier replays, not just original captures",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Value: dashboard.DefaultBulkReplayLimit,
+			Usage: "at most this many matching requests",
+		},
+	}
+}
+
+func requestFilterFromFlags(c *cli.Context) storage.RequestFilter {
+	filter := storage.RequestFilter{
+		TunnelID:       c.String("tunnel"),
+		Method:         c.String("method"),
+		URLPrefix:      c.String("path"),
+		IncludeReplays: c.Bool("include-replays"),
+		Limit:          c.Int("limit"),
+	}
+	if since := c.Duration("since"); since > 0 {
+		filter.Since = time.Now().Add(-since).UnixMilli()
+	}
+	return filter
+}
+
+func runBulkReplay(c *cli.Context) error {
+	opts := dashboard.BulkReplayOptions{
+		Concurrency:    c.Int("concurrency"),
+		PreserveTiming: c.Bool("preserve-timing"),
+		MaxGap:         c.Duration("max-gap"),
+		StopOnFailure:  c.Bool("stop-on-failure"),
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return fmt.Errorf("get db path: %w", err)
+	}
+	db, err := storage.OpenDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("open db: %w", err)
+	}
+	defer db.Close()
+
+	replayer := &dashboard.Replayer{
+		Repo:       storage.NewSQLiteRequestRepo(db),
+		LocalAddr:  fmt.Sprintf("localhost:%d", c.Int("port")),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	requests, err := replayer.Select(c.StringSlice("id"), requestFilterFromFlags(c))
+	if err != nil {
+		return fmt.Errorf("select requests: %w", err)
+	}
+	if len(requests) == 0 {
+		return fmt.Errorf("no captured requests match")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if !c.Bool("json") {
+		fmt.Printf("Replaying %d requests to %s\n", len(requests), replayer.LocalAddr)
+	}
+	summary := replayer.ReplayAll(ctx, requests, opts)
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(summary); err != nil {
+			return err
+		}
+	} else {
+		printBulkReplay(os.Stdout, &summary)
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d of %d replays failed", summary.Failed, summary.Total)
+	}
+	return nil
+}
+
+func printBulkReplay(out io.Writer, summary *dashboard.BulkReplaySummary) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tMETHOD\tURL\tORIGINAL\tREPLAY\tTIME\t")
+	for _, res := range summary.Results {
+		status, note := fmt.Sprint(res.Status), ""
+		switch {
+		case res.Skipped:
+			status, note = "-", "skipped"
+		case res.Error != "":
+			status, note = "-", res.Error
+		case res.Changed():
+			note = "changed"
+		}
+		timing := "-"
+		if !res.Skipped && res.Error == "" {
+			timing = fmt.Sprintf("%dms (was %dms)", res.DurationMs, res.OriginalDurationMs)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			res.ID, res.Method, res.URL, res.OriginalStatus, status, timing, note)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(out, "\n%d replayed, %d failed, %d changed, %d skipped in %dms\n",
+		summary.Replayed, summary.Failed, summary.Changed, summary.Skipped, summary.DurationMs)
+	changes := make([]string, 0, len(summary.StatusChanges))
+	for change := range summary.StatusChanges {
+		changes = append(changes, change)
+	}
+	sort.Strings(changes)
+	for _, change := range changes {
+		fmt.Fprintf(out, "  %s: %d\n", change, summary.StatusChanges[change])
+	}
+	if summary.Stopped {
+		fmt.Fprintln(out, "Stopped after the first failure")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/auditmos/devtunnel/tunnel"
+	"github.com/urfave/cli/v2"
+)
+
+func usageCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "usage",
+		Usage: "report traffic metered by the server",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "server",
+				Aliases: []string{"s"},
+				Value:   "localhost:8080",
+				Usage:   "upstream server address",
+			},
+			&cli.StringFlag{
+				Name:    "admin-token",
+				EnvVars: []string{"DEVTUNNEL_ADMIN_TOKEN"},
+				Usage:   "bearer token for /api/admin",
+			},
+			&cli.StringFlag{
+				Name:  "tenant",
+				Usage: "only this tenant (reserved subdomain, or ip:<addr>); also shows its quotas",
+			},
+			&cli.StringFlag{
+				Name:  "subdomain",
+				Usage: "only traffic to this subdomain",
+			},
+			&cli.DurationFlag{
+				Name:  "since",
+				Value: 24 * time.Hour,
+				Usage: "how far back to report",
+			},
+			&cli.StringFlag{
+				Name:  "group",
+				Value: "day",
+				Usage: "roll up by hour, day or month",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the raw API response",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			query := url.Values{}
+			query.Set("since", strconv.FormatInt(time.Now().Add(-c.Duration("since")).UnixMilli(), 10))
+			query.Set("group", c.String("group"))
+			if v := c.String("tenant"); v != "" {
+				query.Set("tenant", v)
+			}
+			if v := c.String("subdomain"); v != "" {
+				query.Set("subdomain", v)
+			}
+			return runUsage(c.String("server"), c.String("admin-token"), query, c.Bool("json"))
+		},
+	}
+}
+
+func runUsage(server, adminToken string, query url.Values, jsonOutput bool) error {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/api/admin/usage?%s", server, query.Encode()), nil)
+	if err != nil {
+		return fmt.Errorf("build usage request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("get usage: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("get usage failed: %s", body)
+	}
+	if jsonOutput {
+		_, err := io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+
+	var usage tunnel.APIUsageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		return fmt.Errorf("decode usage response: %w", err)
+	}
+	printUsage(os.Stdout, &usage)
+	return nil
+}
+
+func printUsage(out io.Writer, usage *tunnel.APIUsageResponse) {
+	layout := map[string]string{"hour": "2006-01-02 15:00", "day": "2006-01-02", "month": "2006-01"}[usage.Group]
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PERIOD (UTC)\tTENANT\tSUBDOMAIN\tREQUESTS\tIN\tOUT")
+	for _, rec := range usage.Usage {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n",
+			time.UnixMilli(rec.Hour).UTC().Format(layout), rec.Tenant, rec.Subdomain,
+			rec.Requests, formatByteCount(rec.BytesIn), formatByteCount(rec.BytesOut))
+	}
+	fmt.Fprintf(tw, "total\t\t\t%d\t%s\t%s\n", usage.Total.Requests, formatByteCount(usage.Total.BytesIn), formatByteCount(usage.Total.BytesOut))
+	tw.Flush()
+
+	if q := usage.Quota; q != nil {
+		fmt.Fprintf(out, "\nPlan %s\n", q.Plan.Name)
+		fmt.Fprintf(out, "  today:      %s requests, %s\n", quotaUsed(q.Today.Requests, q.Plan.DailyRequests, formatCount), quotaUsed(q.Today.Bytes(), q.Plan.DailyBytes, formatByteCount))
+		fmt.Fprintf(out, "  this month: %s requests, %s\n", quotaUsed(q.Month.Requests, q.Plan.MonthlyRequests, formatCount), quotaUsed(q.Month.Bytes(), q.Plan.MonthlyBytes, formatByteCount))
+	}
+}
+
+// quotaUsed shows usage against a quota, or alone when there is none.
+func quotaUsed(used, limit int64, format func(int64) string) string {
+	if limit == 0 {
+		return format(used)
+	}
+	return fmt.Sprintf("%s of %s", format(used), format(limit))
+}
+
+func formatCount(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func formatByteCount(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type usageRepoAdapter struct {
+	repo *storage.SQLiteUsageRepo
+}
+
+func (a *usageRepoAdapter) Add(records []*tunnel.UsageRecord) error {
+	stored := make([]*storage.UsageRecord, len(records))
+	for i, rec := range records {
+		stored[i] = &storage.UsageRecord{
+			Hour:      rec.Hour,
+			Tenant:    rec.Tenant,
+			Subdomain: rec.Subdomain,
+			Requests:  rec.Requests,
+			BytesIn:   rec.BytesIn,
+			BytesOut:  rec.BytesOut,
+		}
+	}
+	return a.repo.Add(stored)
+}
+
+func (a *usageRepoAdapter) List(filter tunnel.UsageFilter) ([]*tunnel.UsageRecord, error) {
+	records, err := a.repo.List(storage.UsageFilter{
+		Tenant:    filter.Tenant,
+		Subdomain: filter.Subdomain,
+		Since:     filter.Since,
+		Until:     filter.Until,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*tunnel.UsageRecord, len(records))
+	for i, rec := range records {
+		out[i] = &tunnel.UsageRecord{
+			Hour:      rec.Hour,
+			Tenant:    rec.Tenant,
+			Subdomain: rec.Subdomain,
+			UsageCounts: tunnel.UsageCounts{
+				Requests: rec.Requests,
+				BytesIn:  rec.BytesIn,
+				BytesOut: rec.BytesOut,
+			},
+		}
+	}
+	return out, nil
+}
+
+func (a *usageRepoAdapter) Totals(tenant string, since int64) (tunnel.UsageCounts, error) {
+	totals, err := a.repo.Totals(tenant, since)
+	if err != nil {
+		return tunnel.UsageCounts{}, err
+	}
+	return tunnel.UsageCounts{Requests: totals.Requests, BytesIn: totals.BytesIn, BytesOut: totals.BytesOut}, nil
+}
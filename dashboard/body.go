@@ -0,0 +1,484 @@
+package dashboard
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"github.com/auditmos/devtunnel/logging"
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/klauspost/compress/zstd"
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	// maxDecodedBytes bounds how far a compressed body is inflated, so a
+	// small capture can't expand without limit.
+	maxDecodedBytes = 16 << 20
+	// maxHexDumpBytes is how much of a binary body the hex dump shows.
+	maxHexDumpBytes = 4096
+	// maxFieldValueBytes is how much of a text form field is shown inline.
+	maxFieldValueBytes = 4096
+)
+
+// Body kinds.
+const (
+	BodyEmpty     = "empty"
+	BodyJSON      = "json"
+	BodyXML       = "xml"
+	BodyText      = "text"
+	BodyForm      = "form"
+	BodyMultipart = "multipart"
+	BodyImage     = "image"
+	BodyBinary    = "binary"
+)
+
+// DecodedBody is a captured body made readable: Content-Encoding undone,
+// then shown according to its content type. Text holds pretty-printed
+// JSON or XML, or plain text; Fields the fields of a form or the parts of
+// a multipart body; Hex a dump of the start of anything else. Error is set
+// when decoding failed part way and the rest is shown as far as it got.
+type DecodedBody struct {
+	Kind        string      `json:"kind"`
+	ContentType string      `json:"content_type,omitempty"`
+	Encoding    string      `json:"encoding,omitempty"`
+	RawSize     int         `json:"raw_size"`
+	Size        int         `json:"size"`
+	Text        string      `json:"text,omitempty"`
+	Fields      []BodyField `json:"fields,omitempty"`
+	Hex         string      `json:"hex,omitempty"`
+	Truncated   bool        `json:"truncated,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// BodyField is a form field or multipart part. File parts have a Filename
+// and are downloaded by Part, their index in the body; their Value is
+// left empty.
+type BodyField struct {
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int    `json:"size"`
+	Part        int    `json:"part"`
+}
+
+func (f BodyField) IsFile() bool { return f.Filename != "" }
+
+// DecodeBody decodes body according to headers, the headers it was sent
+// with.
+func DecodeBody(body []byte, headers map[string]string) DecodedBody {
+	d := DecodedBody{RawSize: len(body)}
+	if len(body) == 0 {
+		d.Kind = BodyEmpty
+		return d
+	}
+
+	data, encoding, truncated, err := decodeContent(body, headerValue(headers, "Content-Encoding"))
+	d.Encoding, d.Truncated, d.Size = encoding, truncated, len(data)
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	mediaType, params := storage.BodyContentType(data, headers)
+	d.ContentType = mediaType
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if fields, ok := parseFormFields(data); ok {
+			d.Kind, d.Fields = BodyForm, fields
+			return d
+		}
+	case mediaType == "multipart/form-data" || strings.HasPrefix(mediaType, "multipart/"):
+		if parts, err := multipartParts(data, params["boundary"]); err == nil {
+			d.Kind = BodyMultipart
+			for i, p := range parts {
+				d.Fields = append(d.Fields, p.field(i))
+			}
+			return d
+		} else if d.Error == "" {
+			d.Error = err.Error()
+		}
+	case strings.HasPrefix(mediaType, "image/"):
+		d.Kind = BodyImage
+		return d
+	case isJSONType(mediaType):
+		var buf bytes.Buffer
+		if json.Indent(&buf, data, "", "  ") == nil {
+			d.Kind, d.Text = BodyJSON, buf.String()
+			return d
+		}
+	case isXMLType(mediaType):
+		if pretty, err := indentXML(data); err == nil {
+			d.Kind, d.Text = BodyXML, pretty
+			return d
+		}
+	}
+
+	if utf8.Valid(data) {
+		d.Kind, d.Text = BodyText, string(data)
+		return d
+	}
+	d.Kind = BodyBinary
+	d.Hex = hex.Dump(data[:min(len(data), maxHexDumpBytes)])
+	return d
+}
+
+// storedBodyView decodes a stored body that was size bytes when captured,
+// marking it truncated when the store kept only part of it.
+func storedBodyView(body []byte, size int, headers map[string]string) DecodedBody {
+	d := DecodeBody(body, headers)
+	if len(body) > 0 && size > len(body) {
+		d.Truncated = true
+	}
+	return d
+}
+
+// DecodedBytes returns body with its Content-Encoding undone, or body
+// itself when that fails.
+func DecodedBytes(body []byte, headers map[string]string) []byte {
+	data, _, _, err := decodeContent(body, headerValue(headers, "Content-Encoding"))
+	if err != nil {
+		return body
+	}
+	return data
+}
+
+// decodeContent undoes each coding in a Content-Encoding list, last
+// applied first. It returns the codings it undid; on error, data is the
+// body as far as it was decoded.
+func decodeContent(body []byte, contentEncoding string) (data []byte, undone string, truncated bool, err error) {
+	var codings []string
+	for _, c := range strings.Split(contentEncoding, ",") {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" && c != "identity" {
+			codings = append(codings, c)
+		}
+	}
+
+	data = body
+	var done []string
+	for i := len(codings) - 1; i >= 0; i-- {
+		out, trunc, err := decodeCoding(data, codings[i])
+		if err != nil {
+			return data, strings.Join(done, ", "), truncated, fmt.Errorf("decode %s: %w", codings[i], err)
+		}
+		data, truncated = out, truncated || trunc
+		done = append([]string{codings[i]}, done...)
+	}
+	return data, strings.Join(done, ", "), truncated, nil
+}
+
+func decodeCoding(body []byte, coding string) ([]byte, bool, error) {
+	var r io.Reader
+	switch coding {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, false, err
+		}
+		defer zr.Close()
+		r = zr
+	case "deflate":
+		// deflate is meant to be zlib-wrapped, but some servers send raw
+		// deflate data
+		if zr, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			defer zr.Close()
+			r = zr
+		} else {
+			fr := flate.NewReader(bytes.NewReader(body))
+			defer fr.Close()
+			r = fr
+		}
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, false, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, false, fmt.Errorf("unsupported encoding")
+	}
+
+	out, err := io.ReadAll(io.LimitReader(r, maxDecodedBytes+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(out) > maxDecodedBytes {
+		return out[:maxDecodedBytes], true, nil
+	}
+	return out, false, nil
+}
+
+func isJSONType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func isXMLType(mediaType string) bool {
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// indentXML re-indents an XML document, dropping the whitespace between
+// elements. Names are written as they appear; encoding/xml's encoder would
+// rewrite namespace prefixes.
+func indentXML(data []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	var b strings.Builder
+	depth := 0
+	// openTag is a start tag still missing its ">", so an element that
+	// turns out empty can close as "/>"; inline is an element whose content
+	// so far is a single run of text, kept on the tag's line
+	openTag, inline := false, false
+	newline := func() {
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(strings.Repeat("  ", depth))
+	}
+	closeTag := func() {
+		if openTag {
+			b.WriteByte('>')
+			openTag = false
+		}
+	}
+
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			closeTag()
+			newline()
+			b.WriteString("<" + xmlName(t.Name))
+			for _, a := range t.Attr {
+				b.WriteString(" " + xmlName(a.Name) + `="`)
+				xml.EscapeText(&b, []byte(a.Value))
+				b.WriteByte('"')
+			}
+			openTag, inline = true, false
+			depth++
+		case xml.EndElement:
+			if depth--; depth < 0 {
+				return "", fmt.Errorf("unexpected </%s>", xmlName(t.Name))
+			}
+			switch {
+			case openTag:
+				b.WriteString("/>")
+				openTag = false
+			case inline:
+				b.WriteString("</" + xmlName(t.Name) + ">")
+			default:
+				newline()
+				b.WriteString("</" + xmlName(t.Name) + ">")
+			}
+			inline = false
+		case xml.CharData:
+			text := bytes.TrimSpace(t)
+			if len(text) == 0 {
+				continue
+			}
+			inline = openTag
+			closeTag()
+			if !inline {
+				newline()
+			}
+			xml.EscapeText(&b, text)
+		case xml.Comment:
+			closeTag()
+			newline()
+			b.WriteString("<!--" + string(t) + "-->")
+		case xml.ProcInst:
+			closeTag()
+			newline()
+			b.WriteString("<?" + t.Target + " " + string(t.Inst) + "?>")
+		case xml.Directive:
+			closeTag()
+			newline()
+			b.WriteString("<!" + string(t) + ">")
+		}
+	}
+	if depth != 0 {
+		return "", errors.New("unclosed element")
+	}
+	return b.String(), nil
+}
+
+func xmlName(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+// parseFormFields splits a urlencoded body into fields, keeping their
+// order; url.ParseQuery would lose it.
+func parseFormFields(data []byte) ([]BodyField, bool) {
+	if !utf8.Valid(data) {
+		return nil, false
+	}
+	var fields []BodyField
+	for i, pair := range strings.Split(strings.TrimSpace(string(data)), "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		var err error
+		if name, err = url.QueryUnescape(name); err != nil {
+			return nil, false
+		}
+		if value, err = url.QueryUnescape(value); err != nil {
+			return nil, false
+		}
+		fields = append(fields, BodyField{Name: name, Value: value, Size: len(value), Part: i})
+	}
+	return fields, true
+}
+
+type multipartPart struct {
+	name        string
+	filename    string
+	contentType string
+	data        []byte
+}
+
+func (p multipartPart) field(i int) BodyField {
+	f := BodyField{Name: p.name, Filename: p.filename, ContentType: p.contentType, Size: len(p.data), Part: i}
+	if !f.IsFile() {
+		if utf8.Valid(p.data) {
+			f.Value = string(p.data[:min(len(p.data), maxFieldValueBytes)])
+		} else {
+			// binary without a filename still needs a download link
+			f.Filename = p.name
+		}
+	}
+	return f
+}
+
+func multipartParts(data []byte, boundary string) ([]multipartPart, error) {
+	if boundary == "" {
+		return nil, errors.New("multipart body without boundary")
+	}
+	mr := multipart.NewReader(bytes.NewReader(data), boundary)
+	var parts []multipartPart
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read multipart: %w", err)
+		}
+		content, err := io.ReadAll(p)
+		if err != nil {
+			return nil, fmt.Errorf("read multipart: %w", err)
+		}
+		parts = append(parts, multipartPart{
+			name:        p.FormName(),
+			filename:    p.FileName(),
+			contentType: p.Header.Get("Content-Type"),
+			data:        content,
+		})
+	}
+}
+
+// handleBody serves a captured body decoded:
+// /api/body/<id>/request or /api/body/<id>/response, with ?part=N for one
+// part of a multipart body. Only images are shown inline; everything else
+// downloads, sandboxed, so captured HTML can't run as the dashboard.
+// ?view=1 returns the body as a DecodedBody instead, which the dashboard
+// renders when a request is expanded.
+func (s *Server) handleBody(w http.ResponseWriter, r *http.Request) {
+	traceID := ulid.Make().String()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, side, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/body/"), "/")
+	if side != "request" && side != "response" {
+		writeJSONError(w, "want /api/body/<id>/request or /api/body/<id>/response", http.StatusBadRequest)
+		return
+	}
+
+	req, err := s.repo.Get(id)
+	if err != nil {
+		s.logger.WithFields(logging.Fields{
+			"request_id": id,
+			"trace_id":   traceID,
+		}).WithError(err).Error("dashboard", "api", "Request failed")
+		writeJSONError(w, fmt.Sprintf("fetch request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if req == nil {
+		writeJSONError(w, "request not found", http.StatusNotFound)
+		return
+	}
+
+	body, size, headers := req.RequestBody, req.RequestBodySize, req.RequestHeaders
+	if side == "response" {
+		body, size, headers = req.ResponseBody, req.ResponseBodySize, req.ResponseHeaders
+	}
+	if r.URL.Query().Get("view") == "1" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(storedBodyView(body, size, headers))
+		return
+	}
+	data := DecodedBytes(body, headers)
+	mediaType, params := storage.BodyContentType(data, headers)
+	filename := fmt.Sprintf("%s-%s", id, side)
+
+	if v := r.URL.Query().Get("part"); v != "" {
+		n, err := strconv.Atoi(v)
+		parts, perr := multipartParts(data, params["boundary"])
+		if err != nil || perr != nil || n < 0 || n >= len(parts) {
+			writeJSONError(w, "part not found", http.StatusNotFound)
+			return
+		}
+		part := parts[n]
+		data = part.data
+		mediaType = part.contentType
+		if mediaType == "" {
+			mediaType = http.DetectContentType(data)
+		}
+		if part.filename != "" {
+			filename = part.filename
+		} else {
+			filename = part.name
+		}
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	disposition := "attachment"
+	if strings.HasPrefix(mediaType, "image/") && r.URL.Query().Get("download") != "1" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Write(data)
+}
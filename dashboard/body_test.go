@@ -0,0 +1,227 @@
+package dashboard
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf)
+		require.NoError(t, err)
+		w = zw
+	}
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDecodeBody_ContentEncodings(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate", "br", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			raw := compress(t, encoding, []byte(`{"a":1,"b":[true]}`))
+			d := DecodeBody(raw, map[string]string{
+				"Content-Type":     "application/json",
+				"Content-Encoding": encoding,
+			})
+
+			assert.Empty(t, d.Error)
+			assert.Equal(t, BodyJSON, d.Kind)
+			assert.Equal(t, encoding, d.Encoding)
+			assert.Equal(t, len(raw), d.RawSize)
+			assert.Equal(t, "{\n  \"a\": 1,\n  \"b\": [\n    true\n  ]\n}", d.Text)
+		})
+	}
+}
+
+func TestDecodeBody_StackedEncodings(t *testing.T) {
+	raw := compress(t, "br", compress(t, "gzip", []byte("hello")))
+
+	d := DecodeBody(raw, map[string]string{"content-encoding": "gzip, br"})
+
+	assert.Equal(t, "gzip, br", d.Encoding)
+	assert.Equal(t, BodyText, d.Kind)
+	assert.Equal(t, "hello", d.Text)
+}
+
+func TestDecodeBody_CorruptEncoding(t *testing.T) {
+	d := DecodeBody([]byte{0x1f, 0x8b, 0x00, 0xff}, map[string]string{"Content-Encoding": "gzip"})
+
+	assert.Contains(t, d.Error, "decode gzip")
+	assert.Equal(t, BodyBinary, d.Kind)
+	assert.NotEmpty(t, d.Hex)
+}
+
+func TestDecodeBody_XML(t *testing.T) {
+	d := DecodeBody([]byte(`<?xml version="1.0"?><a xmlns:x="urn:x"><x:b id="1">hi</x:b>  <c/></a>`),
+		map[string]string{"Content-Type": "application/soap+xml; charset=utf-8"})
+
+	assert.Equal(t, BodyXML, d.Kind)
+	assert.Equal(t, "application/soap+xml", d.ContentType)
+	assert.Equal(t, `<?xml version="1.0"?>
+<a xmlns:x="urn:x">
+  <x:b id="1">hi</x:b>
+  <c/>
+</a>`, d.Text)
+}
+
+func TestDecodeBody_Form(t *testing.T) {
+	d := DecodeBody([]byte("z=1&a=hello+world&tag=%E2%9C%93&empty="),
+		map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+
+	require.Equal(t, BodyForm, d.Kind)
+	var got []string
+	for _, f := range d.Fields {
+		got = append(got, f.Name+"="+f.Value)
+	}
+	assert.Equal(t, []string{"z=1", "a=hello world", "tag=✓", "empty="}, got)
+}
+
+func multipartBody(t *testing.T) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	require.NoError(t, mw.WriteField("title", "holiday"))
+	fw, err := mw.CreateFormFile("photo", "beach.png")
+	require.NoError(t, err)
+	fw.Write([]byte("\x89PNG\r\n\x1a\nnot really"))
+	require.NoError(t, mw.Close())
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+func TestDecodeBody_Multipart(t *testing.T) {
+	body, contentType := multipartBody(t)
+
+	d := DecodeBody(body, map[string]string{"Content-Type": contentType})
+
+	require.Equal(t, BodyMultipart, d.Kind)
+	require.Len(t, d.Fields, 2)
+	assert.Equal(t, BodyField{Name: "title", Value: "holiday", Size: 7, Part: 0}, d.Fields[0])
+	assert.True(t, d.Fields[1].IsFile())
+	assert.Equal(t, "beach.png", d.Fields[1].Filename)
+	assert.Equal(t, "application/octet-stream", d.Fields[1].ContentType)
+	assert.Equal(t, 1, d.Fields[1].Part)
+}
+
+func TestDecodeBody_ImageAndBinary(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	assert.Equal(t, BodyImage, DecodeBody(png, nil).Kind)
+
+	d := DecodeBody([]byte{0x00, 0x01, 0xfe, 0xff}, map[string]string{"Content-Type": "application/octet-stream"})
+	assert.Equal(t, BodyBinary, d.Kind)
+	assert.Contains(t, d.Hex, "00 01 fe ff")
+}
+
+func TestDecodeBody_SniffsJSON(t *testing.T) {
+	d := DecodeBody([]byte(`[1,2]`), nil)
+
+	assert.Equal(t, BodyJSON, d.Kind)
+	assert.Equal(t, "application/json", d.ContentType)
+}
+
+func TestDiff_ComparesDecodedBodies(t *testing.T) {
+	a := &storage.Request{ID: "a", ResponseHeaders: map[string]string{"Content-Encoding": "gzip"},
+		ResponseBody: compress(t, "gzip", []byte(`{"n":1}`))}
+	b := &storage.Request{ID: "b", ResponseBody: []byte(`{"n":2}`)}
+
+	d := Diff(a, b)
+
+	assert.Equal(t, "json", d.ResponseBody.Kind)
+	require.Len(t, d.ResponseBody.Changes, 1)
+	change := d.ResponseBody.Changes[0]
+	assert.Equal(t, "$.n", change.Path)
+	assert.Equal(t, "1", fmt.Sprint(change.A))
+	assert.Equal(t, "2", fmt.Sprint(change.B))
+}
+
+func TestBodyAPI(t *testing.T) {
+	body, contentType := multipartBody(t)
+	repo := newMockRepo()
+	repo.Save(&storage.Request{
+		ID:              "req-1",
+		RequestHeaders:  map[string]string{"Content-Type": contentType},
+		RequestBody:     body,
+		ResponseHeaders: map[string]string{"Content-Type": "text/html", "Content-Encoding": "gzip"},
+		ResponseBody:    compress(t, "gzip", []byte("<script>alert(1)</script>")),
+	})
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: repo})
+	require.NoError(t, err)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	t.Run("decoded and sandboxed", func(t *testing.T) {
+		rec := get("/api/body/req-1/response")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "<script>alert(1)</script>", rec.Body.String())
+		assert.Equal(t, "sandbox", rec.Header().Get("Content-Security-Policy"))
+		assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment"))
+	})
+
+	t.Run("multipart part", func(t *testing.T) {
+		rec := get("/api/body/req-1/request?part=1")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "\x89PNG\r\n\x1a\nnot really", rec.Body.String())
+		assert.Equal(t, "attachment; filename=beach.png", rec.Header().Get("Content-Disposition"))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/api/body/req-1/other").Code)
+		assert.Equal(t, http.StatusNotFound, get("/api/body/nope/request").Code)
+		assert.Equal(t, http.StatusNotFound, get("/api/body/req-1/request?part=9").Code)
+	})
+
+	t.Run("view", func(t *testing.T) {
+		rec := get("/api/body/req-1/request?view=1")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var view DecodedBody
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &view))
+		assert.Equal(t, BodyMultipart, view.Kind)
+		require.Len(t, view.Fields, 2)
+		assert.Equal(t, "beach.png", view.Fields[1].Filename)
+
+		rec = get("/api/body/req-1/response?view=1")
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &view))
+		assert.Equal(t, "<script>alert(1)</script>", view.Text)
+		assert.Equal(t, "gzip", view.Encoding)
+	})
+
+	t.Run("index leaves bodies to the detail panel", func(t *testing.T) {
+		rec := get("/")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `data-side="request"`)
+		assert.Contains(t, rec.Body.String(), `data-side="response"`)
+		assert.NotContains(t, rec.Body.String(), "alert(1)")
+	})
+}
@@ -0,0 +1,325 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	DefaultBulkReplayLimit = 100
+	MaxBulkReplayLimit     = 1000
+	maxBulkConcurrency     = 32
+)
+
+var errRequestNotFound = errors.New("request not found")
+
+// BulkReplayOptions controls a sequence replay. Concurrency 1, the default,
+// replays one request at a time in the order they arrived. PreserveTiming
+// starts each request at its original offset from the first, with each gap
+// capped at MaxGap when set. StopOnFailure dispatches nothing more after a
+// request fails: the local app did not answer, or answered with a 5xx.
+type BulkReplayOptions struct {
+	Concurrency    int
+	PreserveTiming bool
+	MaxGap         time.Duration
+	StopOnFailure  bool
+}
+
+// BulkReplayResult is one request of a bulk replay next to its original.
+// Skipped requests were not sent because the replay stopped first.
+type BulkReplayResult struct {
+	ID                 string `json:"id"`
+	ReplayID           string `json:"replay_id,omitempty"`
+	Method             string `json:"method"`
+	URL                string `json:"url"`
+	OriginalStatus     int    `json:"original_status"`
+	Status             int    `json:"status,omitempty"`
+	OriginalDurationMs int64  `json:"original_duration_ms"`
+	DurationMs         int64  `json:"duration_ms,omitempty"`
+	Error              string `json:"error,omitempty"`
+	Skipped            bool   `json:"skipped,omitempty"`
+}
+
+func (r BulkReplayResult) Failed() bool {
+	return !r.Skipped && (r.Error != "" || r.Status >= 500)
+}
+
+// Changed reports a replay answered with a different status than the
+// original was.
+func (r BulkReplayResult) Changed() bool {
+	return !r.Skipped && r.Error == "" && r.Status != r.OriginalStatus
+}
+
+// BulkReplaySummary reports a bulk replay. StatusChanges counts replays
+// whose status differs from the original's, keyed like "200 -> 500".
+type BulkReplaySummary struct {
+	Total         int                `json:"total"`
+	Replayed      int                `json:"replayed"`
+	Failed        int                `json:"failed"`
+	Skipped       int                `json:"skipped"`
+	Changed       int                `json:"changed"`
+	StatusChanges map[string]int     `json:"status_changes"`
+	Stopped       bool               `json:"stopped"`
+	DurationMs    int64              `json:"duration_ms"`
+	Results       []BulkReplayResult `json:"results"`
+}
+
+// BulkReplayRequest is the body of POST /api/bulk-replay. Requests are
+// named by IDs or matched by Filter.
+type BulkReplayRequest struct {
+	IDs            []string          `json:"ids,omitempty"`
+	Filter         *BulkReplayFilter `json:"filter,omitempty"`
+	Concurrency    int               `json:"concurrency,omitempty"`
+	PreserveTiming bool              `json:"preserve_timing,omitempty"`
+	MaxGapMs       int64             `json:"max_gap_ms,omitempty"`
+	StopOnFailure  bool              `json:"stop_on_failure,omitempty"`
+}
+
+type BulkReplayFilter struct {
+	TunnelID       string `json:"tunnel_id,omitempty"`
+	Method         string `json:"method,omitempty"`
+	URLPrefix      string `json:"url_prefix,omitempty"`
+	Since          int64  `json:"since,omitempty"`
+	Until          int64  `json:"until,omitempty"`
+	IncludeReplays bool   `json:"include_replays,omitempty"`
+	Limit          int    `json:"limit,omitempty"`
+}
+
+// Select loads the requests named by ids, or else those matching filter,
+// oldest first.
+func (p *Replayer) Select(ids []string, filter storage.RequestFilter) ([]*storage.Request, error) {
+	if len(ids) == 0 {
+		requests, err := p.Repo.Find(filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, req := range requests {
+			if err := p.Repo.LoadBodies(req); err != nil {
+				return nil, err
+			}
+		}
+		return requests, nil
+	}
+	requests := make([]*storage.Request, 0, len(ids))
+	for _, id := range ids {
+		req, err := p.Repo.Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("fetch request: %w", err)
+		}
+		if req == nil {
+			return nil, fmt.Errorf("%w: %s", errRequestNotFound, id)
+		}
+		requests = append(requests, req)
+	}
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].Timestamp < requests[j].Timestamp })
+	return requests, nil
+}
+
+// ReplayAll replays requests as a sequence, oldest first, and compares each
+// result with its original.
+func (p *Replayer) ReplayAll(ctx context.Context, requests []*storage.Request, opts BulkReplayOptions) BulkReplaySummary {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	requests = append([]*storage.Request(nil), requests...)
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].Timestamp < requests[j].Timestamp })
+
+	results := make([]BulkReplayResult, len(requests))
+	for i, req := range requests {
+		results[i] = BulkReplayResult{
+			ID:                 req.ID,
+			Method:             req.Method,
+			URL:                req.URL,
+			OriginalStatus:     req.StatusCode,
+			OriginalDurationMs: req.DurationMs,
+			Skipped:            true,
+		}
+	}
+
+	start := time.Now()
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	var stopped atomic.Bool
+	var offset time.Duration
+
+dispatch:
+	for i, req := range requests {
+		if opts.PreserveTiming && i > 0 {
+			gap := time.Duration(req.Timestamp-requests[i-1].Timestamp) * time.Millisecond
+			if opts.MaxGap > 0 && gap > opts.MaxGap {
+				gap = opts.MaxGap
+			}
+			offset += gap
+			if !sleepUntil(ctx, start.Add(offset)) {
+				break
+			}
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
+		// in order, the previous request has finished by now
+		if stopped.Load() {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, req *storage.Request) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			res := &results[i]
+			res.Skipped = false
+			replay, err := p.Replay(ctx, req, ReplayOverrides{})
+			if err != nil {
+				res.Error = err.Error()
+			} else {
+				res.ReplayID = replay.ID
+				res.Status = replay.StatusCode
+				res.DurationMs = replay.DurationMs
+			}
+			if opts.StopOnFailure && res.Failed() {
+				stopped.Store(true)
+			}
+		}(i, req)
+	}
+	wg.Wait()
+
+	summary := BulkReplaySummary{
+		Total:         len(results),
+		StatusChanges: map[string]int{},
+		Stopped:       stopped.Load(),
+		DurationMs:    time.Since(start).Milliseconds(),
+		Results:       results,
+	}
+	for _, res := range results {
+		switch {
+		case res.Skipped:
+			summary.Skipped++
+			continue
+		case res.Failed():
+			summary.Failed++
+		}
+		if res.Error == "" {
+			summary.Replayed++
+		}
+		if res.Changed() {
+			summary.Changed++
+			summary.StatusChanges[fmt.Sprintf("%d -> %d", res.OriginalStatus, res.Status)]++
+		}
+	}
+	return summary
+}
+
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// handleBulkReplay replays a sequence of captured requests and reports how
+// each went against its original. It answers once the last one is done.
+func (s *Server) handleBulkReplay(w http.ResponseWriter, r *http.Request) {
+	traceID := ulid.Make().String()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !allowWrite(w, r, "application/json") {
+		return
+	}
+
+	var req BulkReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case len(req.IDs) == 0 && req.Filter == nil:
+		writeJSONError(w, "ids or filter is required", http.StatusBadRequest)
+		return
+	case len(req.IDs) > MaxBulkReplayLimit:
+		writeJSONError(w, fmt.Sprintf("at most %d ids", MaxBulkReplayLimit), http.StatusBadRequest)
+		return
+	case req.Concurrency < 0 || req.Concurrency > maxBulkConcurrency:
+		writeJSONError(w, fmt.Sprintf("concurrency must be 1 to %d", maxBulkConcurrency), http.StatusBadRequest)
+		return
+	case req.MaxGapMs < 0:
+		writeJSONError(w, "max_gap_ms must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	var filter storage.RequestFilter
+	if f := req.Filter; f != nil {
+		filter = storage.RequestFilter{
+			TunnelID:       f.TunnelID,
+			Method:         f.Method,
+			URLPrefix:      f.URLPrefix,
+			Since:          f.Since,
+			Until:          f.Until,
+			IncludeReplays: f.IncludeReplays,
+			Limit:          f.Limit,
+		}
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultBulkReplayLimit
+	}
+	filter.Limit = min(filter.Limit, MaxBulkReplayLimit)
+
+	requests, err := s.replayer.Select(req.IDs, filter)
+	if errors.Is(err, errRequestNotFound) {
+		writeJSONError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.WithFields(logging.Fields{
+			"trace_id": traceID,
+		}).WithError(err).Error("dashboard", "api", "Request failed")
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	summary := s.replayer.ReplayAll(r.Context(), requests, BulkReplayOptions{
+		Concurrency:    req.Concurrency,
+		PreserveTiming: req.PreserveTiming,
+		MaxGap:         time.Duration(req.MaxGapMs) * time.Millisecond,
+		StopOnFailure:  req.StopOnFailure,
+	})
+
+	s.logger.WithFields(logging.Fields{
+		"total":       summary.Total,
+		"replayed":    summary.Replayed,
+		"failed":      summary.Failed,
+		"changed":     summary.Changed,
+		"skipped":     summary.Skipped,
+		"duration_ms": summary.DurationMs,
+		"trace_id":    traceID,
+	}).Info("dashboard", "replay", "Bulk replay finished")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
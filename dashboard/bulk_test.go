@@ -0,0 +1,250 @@
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReplayer(t *testing.T, handler http.HandlerFunc) (*Replayer, *mockRequestRepo) {
+	t.Helper()
+	local := httptest.NewServer(handler)
+	t.Cleanup(local.Close)
+	repo := newMockRepo()
+	return &Replayer{Repo: repo, LocalAddr: local.URL[7:], HTTPClient: local.Client()}, repo
+}
+
+func captured(id, url string, ts int64, status int) *storage.Request {
+	return &storage.Request{ID: id, Method: "GET", URL: url, Timestamp: ts, StatusCode: status, DurationMs: 5}
+}
+
+func TestReplayAll_InOrder(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	replayer, repo := newTestReplayer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/b" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	requests := []*storage.Request{
+		captured("3", "/c", 3000, 200),
+		captured("1", "/a", 1000, 200),
+		captured("2", "/b", 2000, 200),
+	}
+	summary := replayer.ReplayAll(context.Background(), requests, BulkReplayOptions{})
+
+	assert.Equal(t, []string{"/a", "/b", "/c"}, seen)
+	assert.Equal(t, 3, summary.Total)
+	assert.Equal(t, 3, summary.Replayed)
+	assert.Equal(t, 0, summary.Failed)
+	assert.Equal(t, 1, summary.Changed)
+	assert.Equal(t, map[string]int{"200 -> 404": 1}, summary.StatusChanges)
+	require.Len(t, summary.Results, 3)
+	assert.Equal(t, "2", summary.Results[1].ID)
+	assert.Equal(t, 404, summary.Results[1].Status)
+
+	saved, _ := repo.Get(summary.Results[0].ReplayID)
+	require.NotNil(t, saved)
+	assert.Equal(t, "1", saved.ReplayOf)
+}
+
+func TestReplayAll_Concurrent(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	release := make(chan struct{})
+	replayer, _ := newTestReplayer(t, func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+	})
+
+	var requests []*storage.Request
+	for i := range 6 {
+		requests = append(requests, captured(string(rune('a'+i)), "/", int64(i), 200))
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+	summary := replayer.ReplayAll(context.Background(), requests, BulkReplayOptions{Concurrency: 3})
+
+	assert.Equal(t, 6, summary.Replayed)
+	assert.Equal(t, int32(3), peak.Load())
+}
+
+func TestReplayAll_PreserveTiming(t *testing.T) {
+	var mu sync.Mutex
+	var at []time.Time
+	replayer, _ := newTestReplayer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		at = append(at, time.Now())
+		mu.Unlock()
+	})
+
+	requests := []*storage.Request{
+		captured("1", "/", 1000, 200),
+		captured("2", "/", 1150, 200),
+		captured("3", "/", 60000, 200),
+	}
+	summary := replayer.ReplayAll(context.Background(), requests, BulkReplayOptions{
+		PreserveTiming: true,
+		MaxGap:         50 * time.Millisecond,
+	})
+
+	require.Equal(t, 3, summary.Replayed)
+	require.Len(t, at, 3)
+	// both gaps are capped at 50ms
+	assert.GreaterOrEqual(t, at[1].Sub(at[0]), 40*time.Millisecond)
+	assert.GreaterOrEqual(t, at[2].Sub(at[0]), 90*time.Millisecond)
+	assert.Less(t, at[2].Sub(at[0]), time.Second)
+}
+
+func TestReplayAll_StopOnFailure(t *testing.T) {
+	replayer, _ := newTestReplayer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/boom" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	requests := []*storage.Request{
+		captured("1", "/ok", 1, 200),
+		captured("2", "/boom", 2, 200),
+		captured("3", "/ok", 3, 200),
+		captured("4", "/ok", 4, 200),
+	}
+	summary := replayer.ReplayAll(context.Background(), requests, BulkReplayOptions{StopOnFailure: true})
+
+	assert.True(t, summary.Stopped)
+	assert.Equal(t, 2, summary.Replayed)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, 2, summary.Skipped)
+	assert.True(t, summary.Results[2].Skipped)
+	assert.Equal(t, map[string]int{"200 -> 500": 1}, summary.StatusChanges)
+}
+
+func TestReplayAll_ConnectionError(t *testing.T) {
+	replayer := &Replayer{Repo: newMockRepo(), LocalAddr: "127.0.0.1:1", HTTPClient: http.DefaultClient}
+
+	summary := replayer.ReplayAll(context.Background(), []*storage.Request{captured("1", "/", 1, 200)}, BulkReplayOptions{})
+
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, 0, summary.Replayed)
+	assert.Equal(t, 0, summary.Changed)
+	assert.Contains(t, summary.Results[0].Error, "replay request")
+}
+
+func TestReplayAll_TruncatedBody(t *testing.T) {
+	var hits atomic.Int32
+	replayer, _ := newTestReplayer(t, func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	})
+	req := captured("1", "/upload", 1, 200)
+	req.Method = "POST"
+	req.RequestBody = []byte("part")
+	req.RequestBodySize = 100
+
+	summary := replayer.ReplayAll(context.Background(), []*storage.Request{req}, BulkReplayOptions{})
+
+	assert.Equal(t, 1, summary.Failed)
+	assert.Contains(t, summary.Results[0].Error, "truncated")
+	assert.Zero(t, hits.Load(), "a cut body is never sent")
+
+	body := `{"whole":true}`
+	replay, err := replayer.Replay(context.Background(), req, ReplayOverrides{Body: &body})
+	require.NoError(t, err, "a replacement body can be sent")
+	assert.Equal(t, []byte(body), replay.RequestBody)
+	assert.Equal(t, int32(1), hits.Load())
+}
+
+func TestBulkReplayAPI(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer local.Close()
+
+	repo := newMockRepo()
+	repo.Save(&storage.Request{ID: "a", Method: "POST", URL: "/hooks/1", Timestamp: 1, StatusCode: 200})
+	repo.Save(&storage.Request{ID: "b", Method: "POST", URL: "/hooks/2", Timestamp: 2, StatusCode: 201})
+	repo.Save(&storage.Request{ID: "c", Method: "GET", URL: "/health", Timestamp: 3, StatusCode: 200})
+	repo.Save(&storage.Request{ID: "d", Method: "POST", URL: "/hooks/1", Timestamp: 4, StatusCode: 200, ReplayOf: "a"})
+
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: repo, LocalAddr: local.URL[7:]})
+	require.NoError(t, err)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/bulk-replay", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.testHandler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("filter", func(t *testing.T) {
+		rec := post(`{"filter":{"method":"post","url_prefix":"/hooks/"}}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var summary BulkReplaySummary
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &summary))
+		assert.Equal(t, 2, summary.Total)
+		assert.Equal(t, "a", summary.Results[0].ID)
+		assert.Equal(t, "b", summary.Results[1].ID)
+		assert.Equal(t, 1, summary.Changed)
+		assert.Equal(t, map[string]int{"200 -> 201": 1}, summary.StatusChanges)
+	})
+
+	t.Run("ids", func(t *testing.T) {
+		rec := post(`{"ids":["c","a"],"concurrency":2}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var summary BulkReplaySummary
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &summary))
+		assert.Equal(t, 2, summary.Total)
+		assert.Equal(t, "a", summary.Results[0].ID)
+		assert.Equal(t, "c", summary.Results[1].ID)
+	})
+
+	t.Run("unknown id", func(t *testing.T) {
+		rec := post(`{"ids":["nope"]}`)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post(`{}`).Code)
+		assert.Equal(t, http.StatusBadRequest, post(`{"ids":["a"],"concurrency":100}`).Code)
+		assert.Equal(t, http.StatusBadRequest, post(`not json`).Code)
+	})
+
+	t.Run("cross-site", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/bulk-replay", bytes.NewBufferString(`{"ids":["a"]}`))
+		req.Header.Set("Content-Type", "text/plain")
+		rec := httptest.NewRecorder()
+		srv.testHandler().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+		req = httptest.NewRequest("POST", "/api/bulk-replay", bytes.NewBufferString(`{"ids":["a"]}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Origin", "https://evil.example")
+		rec = httptest.NewRecorder()
+		srv.testHandler().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+}
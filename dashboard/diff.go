@@ -0,0 +1,291 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/auditmos/devtunnel/storage"
+)
+
+// maxDiffLines bounds the line diff of text bodies, which is quadratic.
+// Longer bodies are only reported as changed.
+const maxDiffLines = 2000
+
+// ExchangeDiff compares two captured exchanges: what was sent, what came
+// back, and how long it took.
+type ExchangeDiff struct {
+	A               string         `json:"a"`
+	B               string         `json:"b"`
+	Method          ValueChange    `json:"method"`
+	URL             ValueChange    `json:"url"`
+	Status          StatusChange   `json:"status"`
+	Timing          TimingChange   `json:"timing"`
+	RequestHeaders  []HeaderChange `json:"request_headers"`
+	ResponseHeaders []HeaderChange `json:"response_headers"`
+	RequestBody     BodyDiff       `json:"request_body"`
+	ResponseBody    BodyDiff       `json:"response_body"`
+}
+
+type ValueChange struct {
+	A       string `json:"a"`
+	B       string `json:"b"`
+	Changed bool   `json:"changed"`
+}
+
+type StatusChange struct {
+	A       int  `json:"a"`
+	B       int  `json:"b"`
+	Changed bool `json:"changed"`
+}
+
+type TimingChange struct {
+	AMs     int64 `json:"a_ms"`
+	BMs     int64 `json:"b_ms"`
+	DeltaMs int64 `json:"delta_ms"`
+}
+
+// HeaderChange is a header added, removed or changed in B. Names compare
+// case-insensitively.
+type HeaderChange struct {
+	Name string `json:"name"`
+	Op   string `json:"op"`
+	A    string `json:"a,omitempty"`
+	B    string `json:"b,omitempty"`
+}
+
+// BodyDiff compares two bodies. JSON bodies are compared structurally,
+// with Changes keyed by JSON path; text bodies line by line; anything
+// else only by size and equality.
+type BodyDiff struct {
+	Kind    string       `json:"kind"`
+	Equal   bool         `json:"equal"`
+	ASize   int          `json:"a_size"`
+	BSize   int          `json:"b_size"`
+	Changes []JSONChange `json:"changes,omitempty"`
+	Lines   []LineChange `json:"lines,omitempty"`
+}
+
+type JSONChange struct {
+	Path string `json:"path"`
+	Op   string `json:"op"`
+	A    any    `json:"a,omitempty"`
+	B    any    `json:"b,omitempty"`
+}
+
+// LineChange is a line of a text diff: Op is "=" for context, "-" for a
+// line only in A and "+" for a line only in B.
+type LineChange struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Diff compares exchange a with b. Bodies are compared after undoing their
+// Content-Encoding.
+func Diff(a, b *storage.Request) ExchangeDiff {
+	return ExchangeDiff{
+		A:               a.ID,
+		B:               b.ID,
+		Method:          ValueChange{A: a.Method, B: b.Method, Changed: a.Method != b.Method},
+		URL:             ValueChange{A: a.URL, B: b.URL, Changed: a.URL != b.URL},
+		Status:          StatusChange{A: a.StatusCode, B: b.StatusCode, Changed: a.StatusCode != b.StatusCode},
+		Timing:          TimingChange{AMs: a.DurationMs, BMs: b.DurationMs, DeltaMs: b.DurationMs - a.DurationMs},
+		RequestHeaders:  diffHeaders(a.RequestHeaders, b.RequestHeaders),
+		ResponseHeaders: diffHeaders(a.ResponseHeaders, b.ResponseHeaders),
+		RequestBody:     diffBodies(DecodedBytes(a.RequestBody, a.RequestHeaders), DecodedBytes(b.RequestBody, b.RequestHeaders)),
+		ResponseBody:    diffBodies(DecodedBytes(a.ResponseBody, a.ResponseHeaders), DecodedBytes(b.ResponseBody, b.ResponseHeaders)),
+	}
+}
+
+func diffHeaders(a, b map[string]string) []HeaderChange {
+	canon := func(headers map[string]string) map[string]string {
+		out := make(map[string]string, len(headers))
+		for k, v := range headers {
+			out[http.CanonicalHeaderKey(k)] = v
+		}
+		return out
+	}
+	ca, cb := canon(a), canon(b)
+
+	changes := []HeaderChange{}
+	for name, va := range ca {
+		vb, ok := cb[name]
+		switch {
+		case !ok:
+			changes = append(changes, HeaderChange{Name: name, Op: "removed", A: va})
+		case va != vb:
+			changes = append(changes, HeaderChange{Name: name, Op: "changed", A: va, B: vb})
+		}
+	}
+	for name, vb := range cb {
+		if _, ok := ca[name]; !ok {
+			changes = append(changes, HeaderChange{Name: name, Op: "added", B: vb})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+func diffBodies(a, b []byte) BodyDiff {
+	d := BodyDiff{Equal: bytes.Equal(a, b), ASize: len(a), BSize: len(b)}
+
+	if va, vb, ok := decodeJSONBodies(a, b); ok {
+		d.Kind = "json"
+		d.Changes = diffJSON("$", va, vb, nil)
+		d.Equal = len(d.Changes) == 0
+		return d
+	}
+	if !utf8.Valid(a) || !utf8.Valid(b) {
+		d.Kind = "binary"
+		return d
+	}
+	d.Kind = "text"
+	if !d.Equal {
+		d.Lines = diffLines(string(a), string(b))
+	}
+	return d
+}
+
+// decodeJSONBodies decodes a and b when both are JSON. An empty body counts
+// as JSON null when the other one is JSON.
+func decodeJSONBodies(a, b []byte) (any, any, bool) {
+	if len(bytes.TrimSpace(a)) == 0 && len(bytes.TrimSpace(b)) == 0 {
+		return nil, nil, false
+	}
+	decode := func(body []byte) (any, bool) {
+		if len(bytes.TrimSpace(body)) == 0 {
+			return nil, true
+		}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil || dec.More() {
+			return nil, false
+		}
+		return v, true
+	}
+	va, okA := decode(a)
+	vb, okB := decode(b)
+	return va, vb, okA && okB
+}
+
+// diffJSON lists where b differs from a. Objects compare by key, arrays by
+// index; values of different types are a single change.
+func diffJSON(path string, a, b any, changes []JSONChange) []JSONChange {
+	switch ta := a.(type) {
+	case map[string]any:
+		tb, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(ta)+len(tb))
+		for k := range ta {
+			keys = append(keys, k)
+		}
+		for k := range tb {
+			if _, ok := ta[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			va, inA := ta[k]
+			vb, inB := tb[k]
+			child := jsonPath(path, k)
+			switch {
+			case !inB:
+				changes = append(changes, JSONChange{Path: child, Op: "removed", A: va})
+			case !inA:
+				changes = append(changes, JSONChange{Path: child, Op: "added", B: vb})
+			default:
+				changes = diffJSON(child, va, vb, changes)
+			}
+		}
+		return changes
+
+	case []any:
+		tb, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(ta), len(tb)); i++ {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(tb):
+				changes = append(changes, JSONChange{Path: child, Op: "removed", A: ta[i]})
+			case i >= len(ta):
+				changes = append(changes, JSONChange{Path: child, Op: "added", B: tb[i]})
+			default:
+				changes = diffJSON(child, ta[i], tb[i], changes)
+			}
+		}
+		return changes
+
+	default:
+		if a == b {
+			return changes
+		}
+	}
+	return append(changes, JSONChange{Path: path, Op: "changed", A: a, B: b})
+}
+
+func jsonPath(parent, key string) string {
+	for _, r := range key {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			quoted, _ := json.Marshal(key)
+			return parent + "[" + string(quoted) + "]"
+		}
+	}
+	return parent + "." + key
+}
+
+// diffLines is a longest-common-subsequence line diff.
+func diffLines(a, b string) []LineChange {
+	la, lb := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(la) > maxDiffLines || len(lb) > maxDiffLines {
+		return []LineChange{{Op: "-", Text: fmt.Sprintf("(%d lines)", len(la))}, {Op: "+", Text: fmt.Sprintf("(%d lines)", len(lb))}}
+	}
+
+	// lcs[i][j] is the common subsequence length of la[i:] and lb[j:]
+	lcs := make([][]int, len(la)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(lb)+1)
+	}
+	for i := len(la) - 1; i >= 0; i-- {
+		for j := len(lb) - 1; j >= 0; j-- {
+			if la[i] == lb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []LineChange
+	i, j := 0, 0
+	for i < len(la) && j < len(lb) {
+		switch {
+		case la[i] == lb[j]:
+			lines = append(lines, LineChange{Op: "=", Text: la[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, LineChange{Op: "-", Text: la[i]})
+			i++
+		default:
+			lines = append(lines, LineChange{Op: "+", Text: lb[j]})
+			j++
+		}
+	}
+	for ; i < len(la); i++ {
+		lines = append(lines, LineChange{Op: "-", Text: la[i]})
+	}
+	for ; j < len(lb); j++ {
+		lines = append(lines, LineChange{Op: "+", Text: lb[j]})
+	}
+	return lines
+}
@@ -0,0 +1,136 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffJSONBodies(t *testing.T) {
+	a := &storage.Request{
+		ID: "a", Method: "POST", URL: "/orders", StatusCode: 200, DurationMs: 40,
+		RequestHeaders:  map[string]string{"Content-Type": "application/json", "X-Old": "1"},
+		ResponseHeaders: map[string]string{"content-type": "application/json"},
+		RequestBody:     []byte(`{"qty":1,"items":["a","b"],"note":"x"}`),
+		ResponseBody:    []byte(`{"ok":true}`),
+	}
+	b := &storage.Request{
+		ID: "b", Method: "POST", URL: "/orders", StatusCode: 500, DurationMs: 25,
+		RequestHeaders:  map[string]string{"Content-Type": "application/json", "X-New": "2"},
+		ResponseHeaders: map[string]string{"Content-Type": "application/json"},
+		RequestBody:     []byte(`{"qty":2, "items":["a"], "note":"x", "user.id":7}`),
+		ResponseBody:    []byte(`{"ok": true}`),
+	}
+
+	d := Diff(a, b)
+	assert.False(t, d.Method.Changed)
+	assert.True(t, d.Status.Changed)
+	assert.Equal(t, int64(-15), d.Timing.DeltaMs)
+	assert.Equal(t, []HeaderChange{
+		{Name: "X-New", Op: "added", B: "2"},
+		{Name: "X-Old", Op: "removed", A: "1"},
+	}, d.RequestHeaders)
+	assert.Empty(t, d.ResponseHeaders, "header names compare case-insensitively")
+
+	assert.Equal(t, "json", d.RequestBody.Kind)
+	assert.False(t, d.RequestBody.Equal)
+	assert.Equal(t, []JSONChange{
+		{Path: "$.items[1]", Op: "removed", A: "b"},
+		{Path: "$.qty", Op: "changed", A: json.Number("1"), B: json.Number("2")},
+		{Path: `$["user.id"]`, Op: "added", B: json.Number("7")},
+	}, d.RequestBody.Changes)
+
+	assert.True(t, d.ResponseBody.Equal, "formatting differences are not changes")
+}
+
+func TestDiffTextAndBinaryBodies(t *testing.T) {
+	d := diffBodies([]byte("one\ntwo\nthree"), []byte("one\n2\nthree"))
+	assert.Equal(t, "text", d.Kind)
+	assert.Equal(t, []LineChange{
+		{Op: "=", Text: "one"},
+		{Op: "-", Text: "two"},
+		{Op: "+", Text: "2"},
+		{Op: "=", Text: "three"},
+	}, d.Lines)
+
+	d = diffBodies([]byte{0xff, 0x00}, []byte{0xff, 0x01})
+	assert.Equal(t, "binary", d.Kind)
+	assert.False(t, d.Equal)
+	assert.Empty(t, d.Lines)
+
+	d = diffBodies(nil, nil)
+	assert.True(t, d.Equal)
+}
+
+func lineageRepo() *mockRequestRepo {
+	repo := newMockRepo()
+	repo.requests["orig"] = &storage.Request{ID: "orig", Method: "POST", URL: "/hook", StatusCode: 200, Timestamp: 1, RequestHeaders: map[string]string{}, ResponseBody: []byte(`{"n":1}`)}
+	repo.requests["r1"] = &storage.Request{ID: "r1", Method: "POST", URL: "/hook", StatusCode: 500, Timestamp: 2, RequestHeaders: map[string]string{}, ResponseBody: []byte(`{"n":2}`), ResponseBodySize: 7, ReplayOf: "orig"}
+	repo.requests["r2"] = &storage.Request{ID: "r2", Method: "PUT", URL: "/hook", StatusCode: 200, Timestamp: 3, RequestHeaders: map[string]string{}, ReplayOf: "r1"}
+	repo.requests["other"] = &storage.Request{ID: "other", Method: "GET", URL: "/", Timestamp: 4, RequestHeaders: map[string]string{}}
+	return repo
+}
+
+func TestAPILineage(t *testing.T) {
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: lineageRepo()})
+	require.NoError(t, err)
+
+	// any member of a lineage shows the whole of it
+	for _, id := range []string{"orig", "r2"} {
+		rec := httptest.NewRecorder()
+		srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/lineage/"+id, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp LineageResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "orig", resp.Root.ID)
+		require.Len(t, resp.Replays, 2)
+		assert.Equal(t, "r1", resp.Replays[0].ID)
+		assert.Equal(t, "r2", resp.Replays[1].ID)
+		assert.Equal(t, "r1", resp.Replays[1].ReplayOf)
+		assert.Equal(t, `{"n":2}`, resp.Replays[0].ResponseBody)
+		assert.False(t, resp.Replays[0].ResponseBodyTruncated)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/lineage/missing", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/lineage/r1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/diff?a=orig&b=r2")
+}
+
+func TestAPIDiff(t *testing.T) {
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: lineageRepo()})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/diff?a=orig&b=r1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var d ExchangeDiff
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &d))
+	assert.Equal(t, StatusChange{A: 200, B: 500, Changed: true}, d.Status)
+	require.Len(t, d.ResponseBody.Changes, 1)
+	assert.Equal(t, "$.n", d.ResponseBody.Changes[0].Path)
+
+	rec = httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/diff?a=orig", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/diff?a=orig&b=missing", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/diff?a=orig&b=r1", nil))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "$.n")
+}
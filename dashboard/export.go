@@ -0,0 +1,686 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/auditmos/devtunnel/logging"
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/oklog/ulid/v2"
+)
+
+// Export formats.
+const (
+	FormatHAR     = "har"
+	FormatCurl    = "curl"
+	FormatHTTPie  = "httpie"
+	FormatPostman = "postman"
+	FormatGoTest  = "gotest"
+)
+
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// ExportOptions controls how captured requests are rendered. BaseURL is the
+// scheme and host the exported requests point at, since captures only keep
+// the path. Headers named by Scrubber's rules are masked; a nil Scrubber
+// exports them as stored.
+type ExportOptions struct {
+	Format   string
+	BaseURL  string
+	Scrubber *storage.Scrubber
+	// Name titles a Postman collection; Package names the Go test file's
+	// package. Both have defaults.
+	Name    string
+	Package string
+	Version string
+}
+
+type exportFormat struct {
+	contentType string
+	ext         string
+	write       func(w io.Writer, requests []*storage.Request, opts ExportOptions) error
+}
+
+var exportFormats = map[string]exportFormat{
+	FormatHAR:     {"application/json", ".har", writeHAR},
+	FormatCurl:    {"text/plain; charset=utf-8", ".sh", writeCurl},
+	FormatHTTPie:  {"text/plain; charset=utf-8", ".sh", writeHTTPie},
+	FormatPostman: {"application/json", ".postman_collection.json", writePostman},
+	FormatGoTest:  {"text/plain; charset=utf-8", "_test.go", writeGoTest},
+}
+
+// ExportFormats lists the formats Export accepts.
+func ExportFormats() []string {
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExportFilename is the file name an export of requests is saved as: the
+// request ID for one request, devtunnel otherwise.
+func ExportFilename(requests []*storage.Request, formatName string) string {
+	base := "devtunnel"
+	if len(requests) == 1 {
+		base = requests[0].ID
+	}
+	return base + exportFormats[formatName].ext
+}
+
+// Export renders requests in opts.Format to w.
+func Export(w io.Writer, requests []*storage.Request, opts ExportOptions) error {
+	f, ok := exportFormats[opts.Format]
+	if !ok {
+		return fmt.Errorf("unknown format %q (want one of %s)", opts.Format, strings.Join(ExportFormats(), ", "))
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = "http://localhost:3000"
+	}
+	opts.BaseURL = strings.TrimSuffix(opts.BaseURL, "/")
+	if opts.Scrubber != nil {
+		requests = scrubRequests(requests, opts.Scrubber)
+	}
+	return f.write(w, requests, opts)
+}
+
+func scrubRequests(requests []*storage.Request, scrubber *storage.Scrubber) []*storage.Request {
+	out := make([]*storage.Request, len(requests))
+	for i, req := range requests {
+		scrubbed := *req
+		scrubbed.RequestHeaders = scrubber.ScrubHeaders(req.RequestHeaders)
+		scrubbed.ResponseHeaders = scrubber.ScrubHeaders(req.ResponseHeaders)
+		out[i] = &scrubbed
+	}
+	return out
+}
+
+type exportHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// sortedHeaders orders headers by name so exports are stable.
+func sortedHeaders(headers map[string]string) []exportHeader {
+	out := make([]exportHeader, 0, len(headers))
+	for k, v := range headers {
+		out = append(out, exportHeader{Name: k, Value: v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// commandHeaders are the headers worth putting on a command line; the
+// client works out Host and Content-Length itself.
+func commandHeaders(headers map[string]string) []exportHeader {
+	var out []exportHeader
+	for _, h := range sortedHeaders(headers) {
+		switch http.CanonicalHeaderKey(h.Name) {
+		case "Host", "Content-Length":
+			continue
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+func headerValue(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// shellQuote single-quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func commandComment(req *storage.Request) string {
+	return fmt.Sprintf("# %s %s %s -> %d\n", req.ID, req.Method, req.URL, req.StatusCode)
+}
+
+// truncatedNote describes a body the store kept only part of, or is empty
+// when it kept all size bytes.
+func truncatedNote(body []byte, size int) string {
+	if size <= len(body) {
+		return ""
+	}
+	return fmt.Sprintf("truncated: stored %d of %d bytes", len(body), size)
+}
+
+func writeCurl(w io.Writer, requests []*storage.Request, opts ExportOptions) error {
+	var b strings.Builder
+	for i, req := range requests {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(commandComment(req))
+		b.WriteString("curl")
+		if req.Method != http.MethodGet || len(req.RequestBody) > 0 {
+			b.WriteString(" -X " + req.Method)
+		}
+		b.WriteString(" " + shellQuote(opts.BaseURL+req.URL))
+		for _, h := range commandHeaders(req.RequestHeaders) {
+			b.WriteString(" \\\n  -H " + shellQuote(h.Name+": "+h.Value))
+		}
+		switch {
+		case len(req.RequestBody) == 0:
+		case utf8.Valid(req.RequestBody):
+			b.WriteString(" \\\n  --data-raw " + shellQuote(string(req.RequestBody)))
+		default:
+			fmt.Fprintf(&b, "\n# binary request body (%d bytes) not included", len(req.RequestBody))
+		}
+		if note := truncatedNote(req.RequestBody, req.RequestBodySize); note != "" {
+			b.WriteString("\n# request body " + note)
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHTTPie(w io.Writer, requests []*storage.Request, opts ExportOptions) error {
+	var b strings.Builder
+	for i, req := range requests {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(commandComment(req))
+		b.WriteString("http")
+		binary := len(req.RequestBody) > 0 && !utf8.Valid(req.RequestBody)
+		if len(req.RequestBody) > 0 && !binary {
+			b.WriteString(" --raw " + shellQuote(string(req.RequestBody)))
+		}
+		b.WriteString(" " + req.Method + " " + shellQuote(opts.BaseURL+req.URL))
+		for _, h := range commandHeaders(req.RequestHeaders) {
+			item := h.Name + ":" + h.Value
+			if h.Value == "" {
+				item = h.Name + ";"
+			}
+			b.WriteString(" \\\n  " + shellQuote(item))
+		}
+		if binary {
+			fmt.Fprintf(&b, "\n# binary request body (%d bytes) not included", len(req.RequestBody))
+		}
+		if note := truncatedNote(req.RequestBody, req.RequestBodySize); note != "" {
+			b.WriteString("\n# request body " + note)
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// HAR 1.2, http://www.softwareishard.com/blog/har-12-spec/
+type harLog struct {
+	Log harBody `json:"log"`
+}
+
+type harBody struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int64       `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []any          `json:"cookies"`
+	Headers     []exportHeader `json:"headers"`
+	QueryString []exportHeader `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []any          `json:"cookies"`
+	Headers     []exportHeader `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	// Compression is the bytes Content-Encoding saved; Size and Text are
+	// the decoded body.
+	Compression int    `json:"compression,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    int64 `json:"send"`
+	Wait    int64 `json:"wait"`
+	Receive int64 `json:"receive"`
+}
+
+func writeHAR(w io.Writer, requests []*storage.Request, opts ExportOptions) error {
+	version := opts.Version
+	if version == "" {
+		version = "dev"
+	}
+	doc := harLog{Log: harBody{
+		Version: "1.2",
+		Creator: harCreator{Name: "devtunnel", Version: version},
+		Entries: make([]harEntry, len(requests)),
+	}}
+	for i, req := range requests {
+		doc.Log.Entries[i] = harEntryFor(req, opts.BaseURL)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func harEntryFor(req *storage.Request, baseURL string) harEntry {
+	fullURL := baseURL + req.URL
+	query := []exportHeader{}
+	if u, err := url.Parse(fullURL); err == nil {
+		for _, kv := range strings.Split(u.RawQuery, "&") {
+			if kv == "" {
+				continue
+			}
+			k, v, _ := strings.Cut(kv, "=")
+			k, _ = url.QueryUnescape(k)
+			v, _ = url.QueryUnescape(v)
+			query = append(query, exportHeader{Name: k, Value: v})
+		}
+	}
+
+	hr := harRequest{
+		Method:      req.Method,
+		URL:         fullURL,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []any{},
+		Headers:     sortedHeaders(req.RequestHeaders),
+		QueryString: query,
+		HeadersSize: -1,
+		BodySize:    max(req.RequestBodySize, len(req.RequestBody)),
+	}
+	if len(req.RequestBody) > 0 {
+		post := &harPostData{MimeType: headerValue(req.RequestHeaders, "Content-Type")}
+		var notes []string
+		if utf8.Valid(req.RequestBody) {
+			post.Text = string(req.RequestBody)
+		} else {
+			// postData has no encoding field
+			post.Text = base64.StdEncoding.EncodeToString(req.RequestBody)
+			notes = append(notes, "base64")
+		}
+		if note := truncatedNote(req.RequestBody, req.RequestBodySize); note != "" {
+			notes = append(notes, note)
+		}
+		post.Comment = strings.Join(notes, "; ")
+		hr.PostData = post
+	}
+
+	// HAR content is the body with its Content-Encoding undone
+	decoded := DecodedBytes(req.ResponseBody, req.ResponseHeaders)
+	content := harContent{
+		Size:     len(decoded),
+		MimeType: headerValue(req.ResponseHeaders, "Content-Type"),
+		Comment:  truncatedNote(req.ResponseBody, req.ResponseBodySize),
+	}
+	if !bytes.Equal(decoded, req.ResponseBody) {
+		content.Compression = len(decoded) - len(req.ResponseBody)
+	}
+	if utf8.Valid(decoded) {
+		content.Text = string(decoded)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(decoded)
+		content.Encoding = "base64"
+	}
+
+	entry := harEntry{
+		StartedDateTime: time.UnixMilli(req.Timestamp).UTC().Format("2006-01-02T15:04:05.000Z"),
+		Time:            req.DurationMs,
+		Request:         hr,
+		Response: harResponse{
+			Status:      req.StatusCode,
+			StatusText:  http.StatusText(req.StatusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []any{},
+			Headers:     sortedHeaders(req.ResponseHeaders),
+			Content:     content,
+			RedirectURL: headerValue(req.ResponseHeaders, "Location"),
+			HeadersSize: -1,
+			BodySize:    max(req.ResponseBodySize, len(req.ResponseBody)),
+		},
+		Timings: harTimings{Wait: req.DurationMs},
+		Comment: req.ID,
+	}
+	return entry
+}
+
+// Postman collection v2.1.
+type postmanCollection struct {
+	Info postmanInfo   `json:"info"`
+	Item []postmanItem `json:"item"`
+}
+
+type postmanInfo struct {
+	PostmanID string `json:"_postman_id"`
+	Name      string `json:"name"`
+	Schema    string `json:"schema"`
+}
+
+type postmanItem struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Request     postmanRequest    `json:"request"`
+	Response    []postmanResponse `json:"response"`
+}
+
+type postmanRequest struct {
+	Method string          `json:"method"`
+	Header []postmanHeader `json:"header"`
+	URL    string          `json:"url"`
+	Body   *postmanBody    `json:"body,omitempty"`
+}
+
+type postmanHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type postmanBody struct {
+	Mode string `json:"mode"`
+	Raw  string `json:"raw"`
+}
+
+type postmanResponse struct {
+	Name            string          `json:"name"`
+	OriginalRequest postmanRequest  `json:"originalRequest"`
+	Status          string          `json:"status"`
+	Code            int             `json:"code"`
+	Header          []postmanHeader `json:"header"`
+	Body            string          `json:"body"`
+}
+
+func postmanHeaders(headers []exportHeader) []postmanHeader {
+	out := make([]postmanHeader, len(headers))
+	for i, h := range headers {
+		out[i] = postmanHeader{Key: h.Name, Value: h.Value}
+	}
+	return out
+}
+
+func writePostman(w io.Writer, requests []*storage.Request, opts ExportOptions) error {
+	name := opts.Name
+	if name == "" {
+		name = "devtunnel"
+	}
+	coll := postmanCollection{
+		Info: postmanInfo{PostmanID: ulid.Make().String(), Name: name, Schema: postmanSchema},
+		Item: make([]postmanItem, len(requests)),
+	}
+	for i, req := range requests {
+		pr := postmanRequest{
+			Method: req.Method,
+			Header: postmanHeaders(commandHeaders(req.RequestHeaders)),
+			URL:    opts.BaseURL + req.URL,
+		}
+		if len(req.RequestBody) > 0 && utf8.Valid(req.RequestBody) {
+			pr.Body = &postmanBody{Mode: "raw", Raw: string(req.RequestBody)}
+		}
+		resp := postmanResponse{
+			Name:            "Captured " + req.ID,
+			OriginalRequest: pr,
+			Status:          http.StatusText(req.StatusCode),
+			Code:            req.StatusCode,
+			Header:          postmanHeaders(sortedHeaders(req.ResponseHeaders)),
+		}
+		if utf8.Valid(req.ResponseBody) {
+			resp.Body = string(req.ResponseBody)
+		}
+		var notes []string
+		if note := truncatedNote(req.RequestBody, req.RequestBodySize); note != "" {
+			notes = append(notes, "Request body "+note+".")
+		}
+		if note := truncatedNote(req.ResponseBody, req.ResponseBodySize); note != "" {
+			notes = append(notes, "Response body "+note+".")
+		}
+		coll.Item[i] = postmanItem{
+			Name:        req.Method + " " + req.URL,
+			Description: strings.Join(notes, " "),
+			Request:     pr,
+			Response:    []postmanResponse{resp},
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(coll)
+}
+
+// writeGoTest renders each request as a test that sends it through an
+// http.Handler with httptest and checks the captured status comes back.
+func writeGoTest(w io.Writer, requests []*storage.Request, opts ExportOptions) error {
+	pkg := opts.Package
+	if pkg == "" {
+		pkg = "main"
+	}
+
+	var b bytes.Buffer
+	usesStrings := false
+	for _, req := range requests {
+		body := "nil"
+		if len(req.RequestBody) > 0 {
+			body = "strings.NewReader(" + strconv.Quote(string(req.RequestBody)) + ")"
+			usesStrings = true
+		}
+		fmt.Fprintf(&b, "\n// %s %s, captured %s.\n", req.Method, req.URL,
+			time.UnixMilli(req.Timestamp).UTC().Format(time.RFC3339))
+		if note := truncatedNote(req.RequestBody, req.RequestBodySize); note != "" {
+			b.WriteString("// The request body was " + note + ".\n")
+		}
+		fmt.Fprintf(&b, "func TestCaptured_%s(t *testing.T) {\n", goIdent(req.ID))
+		fmt.Fprintf(&b, "req := httptest.NewRequest(%s, %s, %s)\n", strconv.Quote(req.Method), strconv.Quote(req.URL), body)
+		for _, h := range commandHeaders(req.RequestHeaders) {
+			fmt.Fprintf(&b, "req.Header.Set(%s, %s)\n", strconv.Quote(h.Name), strconv.Quote(h.Value))
+		}
+		b.WriteString("rec := httptest.NewRecorder()\n\n")
+		b.WriteString("capturedHandler.ServeHTTP(rec, req)\n\n")
+		fmt.Fprintf(&b, "if rec.Code != %d {\n", req.StatusCode)
+		fmt.Fprintf(&b, "t.Errorf(\"status = %%d, want %d\", rec.Code)\n}\n}\n", req.StatusCode)
+	}
+
+	// import only what the tests use, or the file will not compile
+	imports := "\t\"net/http\"\n"
+	if len(requests) > 0 {
+		imports += "\t\"net/http/httptest\"\n"
+	}
+	if usesStrings {
+		imports += "\t\"strings\"\n"
+	}
+	if len(requests) > 0 {
+		imports += "\t\"testing\"\n"
+	}
+
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "package %s\n\n", pkg)
+	fmt.Fprintf(&head, "import (\n%s)\n\n", imports)
+	head.WriteString("// capturedHandler is the handler the captured requests are sent to.\n")
+	head.WriteString("// Point it at your app's router.\n")
+	head.WriteString("var capturedHandler http.Handler = http.NotFoundHandler()\n")
+
+	src, err := format.Source(append(head.Bytes(), b.Bytes()...))
+	if err != nil {
+		return fmt.Errorf("format go test: %w", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// goIdent keeps the letters and digits of s, which is all a ULID has.
+func goIdent(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// handleExport renders one request, /api/export/<id>, or a filtered set,
+// /api/export?tunnel_id=...; ?format= picks the format (HAR by default).
+// Scrubbed headers stay masked unless ?unmasked=1.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	traceID := ulid.Make().String()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	formatName := q.Get("format")
+	if formatName == "" {
+		formatName = FormatHAR
+	}
+	f, ok := exportFormats[formatName]
+	if !ok {
+		writeJSONError(w, fmt.Sprintf("unknown format %q (want one of %s)", formatName, strings.Join(ExportFormats(), ", ")), http.StatusBadRequest)
+		return
+	}
+
+	var requests []*storage.Request
+	var err error
+	if id := strings.TrimPrefix(r.URL.Path, "/api/export/"); id != r.URL.Path && id != "" {
+		requests, err = s.replayer.Select([]string{id}, storage.RequestFilter{})
+	} else {
+		var filter storage.RequestFilter
+		filter, err = parseRequestFilter(q)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests, err = s.replayer.Select(nil, filter)
+	}
+	if errors.Is(err, errRequestNotFound) {
+		writeJSONError(w, "request not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.WithFields(logging.Fields{
+			"trace_id": traceID,
+		}).WithError(err).Error("dashboard", "api", "Request failed")
+		writeJSONError(w, fmt.Sprintf("fetch requests: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	opts := ExportOptions{
+		Format:  formatName,
+		BaseURL: q.Get("base"),
+		Name:    q.Get("name"),
+		Package: q.Get("package"),
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = "http://" + s.localAddr
+	}
+	if q.Get("unmasked") != "1" && s.scrubRuleRepo != nil {
+		opts.Scrubber, err = storage.NewScrubberWithRepo(s.scrubRuleRepo)
+		if err != nil {
+			s.logger.WithFields(logging.Fields{
+				"trace_id": traceID,
+			}).WithError(err).Error("dashboard", "api", "Request failed")
+			writeJSONError(w, fmt.Sprintf("load scrub rules: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Export(&buf, requests, opts); err != nil {
+		s.logger.WithFields(logging.Fields{
+			"format":   formatName,
+			"trace_id": traceID,
+		}).WithError(err).Error("dashboard", "api", "Request failed")
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", f.contentType)
+	if q.Get("download") != "0" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": ExportFilename(requests, formatName),
+		}))
+	}
+	w.Write(buf.Bytes())
+}
+
+// parseRequestFilter reads a RequestFilter from query parameters: tunnel_id,
+// method, url_prefix, since and until (unix ms), include_replays and limit.
+// The limit defaults to DefaultBulkReplayLimit and is capped at
+// MaxBulkReplayLimit.
+func parseRequestFilter(q url.Values) (storage.RequestFilter, error) {
+	filter := storage.RequestFilter{
+		TunnelID:       q.Get("tunnel_id"),
+		Method:         q.Get("method"),
+		URLPrefix:      q.Get("url_prefix"),
+		IncludeReplays: q.Get("include_replays") == "1" || q.Get("include_replays") == "true",
+		Limit:          DefaultBulkReplayLimit,
+	}
+	for name, dst := range map[string]*int64{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return filter, fmt.Errorf("invalid %s: want unix milliseconds", name)
+			}
+			*dst = n
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return filter, fmt.Errorf("invalid limit")
+		}
+		filter.Limit = min(n, MaxBulkReplayLimit)
+	}
+	return filter, nil
+}
@@ -0,0 +1,282 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memScrubRuleRepo struct {
+	patterns []string
+}
+
+func (m *memScrubRuleRepo) GetAll() ([]*storage.ScrubRule, error) {
+	rules := make([]*storage.ScrubRule, len(m.patterns))
+	for i, p := range m.patterns {
+		rules[i] = &storage.ScrubRule{ID: p, Pattern: p}
+	}
+	return rules, nil
+}
+
+func (m *memScrubRuleRepo) Create(pattern string) (*storage.ScrubRule, error) {
+	m.patterns = append(m.patterns, pattern)
+	return &storage.ScrubRule{ID: pattern, Pattern: pattern}, nil
+}
+
+func (m *memScrubRuleRepo) Delete(id string) error { return nil }
+
+func (m *memScrubRuleRepo) Seed() error { return nil }
+
+func exportRequest() *storage.Request {
+	return &storage.Request{
+		ID:        "01HEXPORT",
+		TunnelID:  "tun-1",
+		Timestamp: 1700000000000,
+		Method:    "POST",
+		URL:       "/hooks/stripe?attempt=2&x=a%20b",
+		RequestHeaders: map[string]string{
+			"Content-Type":   "application/json",
+			"Authorization":  "Bearer secret",
+			"Content-Length": "24",
+		},
+		RequestBody:     []byte(`{"msg":"it's here"}`),
+		StatusCode:      201,
+		ResponseHeaders: map[string]string{"Content-Type": "application/json"},
+		ResponseBody:    []byte(`{"ok":true}`),
+		DurationMs:      42,
+	}
+}
+
+func exportString(t *testing.T, format string, scrubber *storage.Scrubber) string {
+	t.Helper()
+	var buf bytes.Buffer
+	err := Export(&buf, []*storage.Request{exportRequest()}, ExportOptions{
+		Format:   format,
+		BaseURL:  "https://app.test/",
+		Scrubber: scrubber,
+	})
+	require.NoError(t, err)
+	return buf.String()
+}
+
+func TestExport_Curl(t *testing.T) {
+	out := exportString(t, FormatCurl, nil)
+
+	assert.Equal(t, `# 01HEXPORT POST /hooks/stripe?attempt=2&x=a%20b -> 201
+curl -X POST 'https://app.test/hooks/stripe?attempt=2&x=a%20b' \
+  -H 'Authorization: Bearer secret' \
+  -H 'Content-Type: application/json' \
+  --data-raw '{"msg":"it'\''s here"}'
+`, out)
+}
+
+func TestExport_HTTPie(t *testing.T) {
+	out := exportString(t, FormatHTTPie, nil)
+
+	assert.Contains(t, out, `http --raw '{"msg":"it'\''s here"}' POST 'https://app.test/hooks/stripe?attempt=2&x=a%20b'`)
+	assert.Contains(t, out, `'Content-Type:application/json'`)
+	assert.NotContains(t, out, "Content-Length")
+}
+
+func TestExport_HAR(t *testing.T) {
+	var doc harLog
+	require.NoError(t, json.Unmarshal([]byte(exportString(t, FormatHAR, nil)), &doc))
+
+	assert.Equal(t, "1.2", doc.Log.Version)
+	require.Len(t, doc.Log.Entries, 1)
+	entry := doc.Log.Entries[0]
+	assert.Equal(t, "2023-11-14T22:13:20.000Z", entry.StartedDateTime)
+	assert.Equal(t, int64(42), entry.Time)
+	assert.Equal(t, "https://app.test/hooks/stripe?attempt=2&x=a%20b", entry.Request.URL)
+	assert.Equal(t, []exportHeader{{"attempt", "2"}, {"x", "a b"}}, entry.Request.QueryString)
+	require.NotNil(t, entry.Request.PostData)
+	assert.Equal(t, "application/json", entry.Request.PostData.MimeType)
+	assert.Equal(t, 201, entry.Response.Status)
+	assert.Equal(t, "Created", entry.Response.StatusText)
+	assert.Equal(t, `{"ok":true}`, entry.Response.Content.Text)
+}
+
+func TestExport_HARBinaryResponse(t *testing.T) {
+	req := exportRequest()
+	req.ResponseBody = []byte{0xff, 0x00, 0xfe}
+
+	entry := harEntryFor(req, "http://x")
+
+	assert.Equal(t, "base64", entry.Response.Content.Encoding)
+	assert.Equal(t, "/wD+", entry.Response.Content.Text)
+}
+
+func TestExport_HARDecodesResponse(t *testing.T) {
+	body := []byte(`{"items":["a","a","a","a","a","a","a","a","a","a","a","a"]}`)
+	req := exportRequest()
+	req.ResponseHeaders = map[string]string{"Content-Type": "application/json", "Content-Encoding": "gzip"}
+	req.ResponseBody = compress(t, "gzip", body)
+
+	entry := harEntryFor(req, "http://x")
+
+	assert.Equal(t, string(body), entry.Response.Content.Text)
+	assert.Empty(t, entry.Response.Content.Encoding)
+	assert.Equal(t, len(body), entry.Response.Content.Size)
+	assert.Equal(t, len(body)-len(req.ResponseBody), entry.Response.Content.Compression)
+	assert.Equal(t, len(req.ResponseBody), entry.Response.BodySize)
+}
+
+func TestExport_TruncatedBodies(t *testing.T) {
+	req := exportRequest()
+	req.RequestBodySize = 100
+	req.ResponseBodySize = 50
+
+	entry := harEntryFor(req, "http://x")
+	assert.Equal(t, 100, entry.Request.BodySize)
+	assert.Equal(t, "truncated: stored 19 of 100 bytes", entry.Request.PostData.Comment)
+	assert.Equal(t, 50, entry.Response.BodySize)
+	assert.Equal(t, "truncated: stored 11 of 50 bytes", entry.Response.Content.Comment)
+
+	var out bytes.Buffer
+	require.NoError(t, Export(&out, []*storage.Request{req}, ExportOptions{Format: FormatCurl}))
+	assert.Contains(t, out.String(), "# request body truncated: stored 19 of 100 bytes")
+
+	out.Reset()
+	require.NoError(t, Export(&out, []*storage.Request{req}, ExportOptions{Format: FormatPostman}))
+	var coll postmanCollection
+	require.NoError(t, json.Unmarshal(out.Bytes(), &coll))
+	assert.Contains(t, coll.Item[0].Description, "Request body truncated")
+	assert.Contains(t, coll.Item[0].Description, "Response body truncated")
+}
+
+func TestExport_Postman(t *testing.T) {
+	var coll postmanCollection
+	require.NoError(t, json.Unmarshal([]byte(exportString(t, FormatPostman, nil)), &coll))
+
+	assert.Equal(t, postmanSchema, coll.Info.Schema)
+	require.Len(t, coll.Item, 1)
+	item := coll.Item[0]
+	assert.Equal(t, "POST /hooks/stripe?attempt=2&x=a%20b", item.Name)
+	assert.Equal(t, "https://app.test/hooks/stripe?attempt=2&x=a%20b", item.Request.URL)
+	require.NotNil(t, item.Request.Body)
+	assert.Equal(t, "raw", item.Request.Body.Mode)
+	require.Len(t, item.Response, 1)
+	assert.Equal(t, 201, item.Response[0].Code)
+}
+
+func TestExport_GoTest(t *testing.T) {
+	out := exportString(t, FormatGoTest, nil)
+
+	_, err := parser.ParseFile(token.NewFileSet(), "captured_test.go", out, 0)
+	require.NoError(t, err, out)
+	assert.Contains(t, out, "func TestCaptured_01HEXPORT(t *testing.T) {")
+	assert.Contains(t, out, `httptest.NewRequest("POST", "/hooks/stripe?attempt=2&x=a%20b", strings.NewReader("{\"msg\":\"it's here\"}"))`)
+	assert.Contains(t, out, "if rec.Code != 201 {")
+}
+
+// typeCheckGo compiles src as a package on its own, failing on anything
+// the go tool would reject, such as an unused import.
+func typeCheckGo(t *testing.T, src string) {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "captured_test.go", src, 0)
+	require.NoError(t, err, src)
+	conf := types.Config{Importer: importer.Default()}
+	_, err = conf.Check("captured", fset, []*ast.File{file}, nil)
+	require.NoError(t, err, src)
+}
+
+func TestExport_GoTestCompiles(t *testing.T) {
+	post := exportRequest()
+	get := exportRequest()
+	get.ID, get.Method, get.RequestBody = "01HEXPORTGET", "GET", nil
+
+	cases := map[string][]*storage.Request{
+		"get":      {get},
+		"post":     {post},
+		"get+post": {get, post},
+		"none":     nil,
+	}
+	for name, requests := range cases {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, Export(&buf, requests, ExportOptions{Format: FormatGoTest}))
+			typeCheckGo(t, buf.String())
+		})
+	}
+}
+
+func TestExport_MasksScrubbedHeaders(t *testing.T) {
+	scrubber, err := storage.NewScrubberWithRepo(&memScrubRuleRepo{patterns: []string{"authorization"}})
+	require.NoError(t, err)
+
+	for _, format := range ExportFormats() {
+		out := exportString(t, format, scrubber)
+		assert.NotContains(t, out, "Bearer secret", format)
+		assert.Contains(t, out, "***", format)
+	}
+}
+
+func TestExport_UnknownFormat(t *testing.T) {
+	err := Export(&bytes.Buffer{}, nil, ExportOptions{Format: "xml"})
+	assert.ErrorContains(t, err, `unknown format "xml"`)
+}
+
+func TestExportAPI(t *testing.T) {
+	repo := newMockRepo()
+	repo.Save(exportRequest())
+	other := exportRequest()
+	other.ID, other.TunnelID, other.Timestamp = "01HOTHER", "tun-2", 1700000001000
+	repo.Save(other)
+
+	srv, err := NewServer(ServerConfig{
+		Addr:          ":0",
+		Repo:          repo,
+		ScrubRuleRepo: &memScrubRuleRepo{patterns: []string{"authorization"}},
+		LocalAddr:     "localhost:4000",
+	})
+	require.NoError(t, err)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	t.Run("single", func(t *testing.T) {
+		rec := get("/api/export/01HEXPORT?format=curl")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, `attachment; filename=01HEXPORT.sh`, rec.Header().Get("Content-Disposition"))
+		assert.Contains(t, rec.Body.String(), "'http://localhost:4000/hooks/stripe")
+		assert.Contains(t, rec.Body.String(), "Authorization: ***")
+	})
+
+	t.Run("unmasked", func(t *testing.T) {
+		rec := get("/api/export/01HEXPORT?format=curl&unmasked=1")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Authorization: Bearer secret")
+	})
+
+	t.Run("tunnel session", func(t *testing.T) {
+		rec := get("/api/export?tunnel_id=tun-2")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+		var doc harLog
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+		require.Len(t, doc.Log.Entries, 1)
+		assert.Equal(t, "01HOTHER", doc.Log.Entries[0].Comment)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/api/export/nope").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/api/export/01HEXPORT?format=xml").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/api/export?since=yesterday").Code)
+	})
+}
@@ -0,0 +1,646 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/auditmos/devtunnel/logging"
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/oklog/ulid/v2"
+)
+
+// maxImportBytes caps an uploaded import file.
+const maxImportBytes = 32 << 20
+
+// ImportOptions controls Import. Format is FormatHAR or FormatCurl, or
+// empty to detect it. Imported requests are filed under TunnelID, a new
+// synthetic "import-..." ID when empty, and their headers scrubbed by
+// Scrubber when set.
+type ImportOptions struct {
+	Format   string
+	TunnelID string
+	Scrubber *storage.Scrubber
+}
+
+type ImportResult struct {
+	Format   string   `json:"format"`
+	TunnelID string   `json:"tunnel_id"`
+	IDs      []string `json:"ids"`
+}
+
+// Import parses a HAR file or a list of cURL commands and saves each
+// request into repo, ready to replay.
+func Import(repo storage.RequestRepo, data []byte, opts ImportOptions) (*ImportResult, error) {
+	format := opts.Format
+	if format == "" {
+		format = detectImportFormat(data)
+	}
+
+	var requests []*storage.Request
+	var err error
+	switch format {
+	case FormatHAR:
+		requests, err = parseHAR(data)
+	case FormatCurl:
+		requests, err = parseCurl(string(data))
+	default:
+		return nil, fmt.Errorf("unknown import format %q (want %s or %s)", format, FormatHAR, FormatCurl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("no requests found in %s input", format)
+	}
+
+	tunnelID := opts.TunnelID
+	if tunnelID == "" {
+		tunnelID = "import-" + ulid.Make().String()
+	}
+	result := &ImportResult{Format: format, TunnelID: tunnelID, IDs: make([]string, 0, len(requests))}
+	now := time.Now().UnixMilli()
+	for _, req := range requests {
+		req.ID = ulid.Make().String()
+		req.TunnelID = tunnelID
+		req.CreatedAt = now
+		if opts.Scrubber != nil {
+			req.RequestHeaders = opts.Scrubber.ScrubHeaders(req.RequestHeaders)
+			req.ResponseHeaders = opts.Scrubber.ScrubHeaders(req.ResponseHeaders)
+		}
+		if err := repo.Save(req); err != nil {
+			return result, fmt.Errorf("save request: %w", err)
+		}
+		result.IDs = append(result.IDs, req.ID)
+	}
+	return result, nil
+}
+
+func detectImportFormat(data []byte) string {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return FormatHAR
+	}
+	return FormatCurl
+}
+
+// addHeader records a header, folding repeats the way net/http joins them.
+func addHeader(headers map[string]string, name, value string) {
+	if prev, ok := headers[name]; ok {
+		sep := ", "
+		if strings.EqualFold(name, "Cookie") {
+			sep = "; "
+		}
+		value = prev + sep + value
+	}
+	headers[name] = value
+}
+
+// requestURI keeps the path and query of rawURL; captures don't store the
+// host.
+func requestURI(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("parse url %q: %w", rawURL, err)
+	}
+	if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	return u.RequestURI(), nil
+}
+
+// HAR as browsers write it: times are fractional milliseconds.
+type harImport struct {
+	Log struct {
+		Entries []struct {
+			StartedDateTime string  `json:"startedDateTime"`
+			Time            float64 `json:"time"`
+			Request         struct {
+				Method   string         `json:"method"`
+				URL      string         `json:"url"`
+				Headers  []exportHeader `json:"headers"`
+				PostData *struct {
+					MimeType string         `json:"mimeType"`
+					Text     string         `json:"text"`
+					Params   []exportHeader `json:"params"`
+					Comment  string         `json:"comment"`
+				} `json:"postData"`
+			} `json:"request"`
+			Response struct {
+				Status  int            `json:"status"`
+				Headers []exportHeader `json:"headers"`
+				Content struct {
+					Text     string `json:"text"`
+					Encoding string `json:"encoding"`
+				} `json:"content"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+func parseHAR(data []byte) ([]*storage.Request, error) {
+	var doc harImport
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse har: %w", err)
+	}
+
+	now := time.Now()
+	requests := make([]*storage.Request, 0, len(doc.Log.Entries))
+	for i, e := range doc.Log.Entries {
+		uri, err := requestURI(e.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("har entry %d: %w", i, err)
+		}
+		req := &storage.Request{
+			Timestamp:       now.UnixMilli(),
+			Method:          strings.ToUpper(e.Request.Method),
+			URL:             uri,
+			RequestHeaders:  harHeaders(e.Request.Headers),
+			StatusCode:      e.Response.Status,
+			ResponseHeaders: harHeaders(e.Response.Headers),
+			DurationMs:      int64(e.Time),
+		}
+		if req.Method == "" {
+			req.Method = http.MethodGet
+		}
+		if t, err := time.Parse(time.RFC3339Nano, e.StartedDateTime); err == nil {
+			req.Timestamp = t.UnixMilli()
+		}
+
+		if post := e.Request.PostData; post != nil {
+			switch {
+			case post.Text != "" && post.Comment == "base64":
+				req.RequestBody, err = base64.StdEncoding.DecodeString(post.Text)
+				if err != nil {
+					return nil, fmt.Errorf("har entry %d: decode request body: %w", i, err)
+				}
+			case post.Text != "":
+				req.RequestBody = []byte(post.Text)
+			case len(post.Params) > 0:
+				form := url.Values{}
+				for _, p := range post.Params {
+					form.Add(p.Name, p.Value)
+				}
+				req.RequestBody = []byte(form.Encode())
+			}
+		}
+
+		content := e.Response.Content
+		if content.Encoding == "base64" {
+			req.ResponseBody, err = base64.StdEncoding.DecodeString(content.Text)
+			if err != nil {
+				return nil, fmt.Errorf("har entry %d: decode response body: %w", i, err)
+			}
+		} else if content.Text != "" {
+			req.ResponseBody = []byte(content.Text)
+		}
+		// HAR content is already decoded, so the headers must not claim
+		// a Content-Encoding or the encoded length any more.
+		deleteHeader(req.ResponseHeaders, "Content-Encoding")
+		if headerValue(req.ResponseHeaders, "Content-Length") != "" {
+			deleteHeader(req.ResponseHeaders, "Content-Length")
+			req.ResponseHeaders["Content-Length"] = strconv.Itoa(len(req.ResponseBody))
+		}
+
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+// harHeaders drops HTTP/2 pseudo-headers such as :authority, which
+// browsers list alongside the real ones.
+func harHeaders(list []exportHeader) map[string]string {
+	headers := make(map[string]string, len(list))
+	for _, h := range list {
+		if strings.HasPrefix(h.Name, ":") {
+			continue
+		}
+		addHeader(headers, h.Name, h.Value)
+	}
+	return headers
+}
+
+// parseCurl reads one or more curl commands, as copied from a browser or
+// pasted into a ticket. Commands are separated by newlines; a trailing
+// backslash continues one onto the next line.
+func parseCurl(text string) ([]*storage.Request, error) {
+	commands, err := splitShellCommands(text)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	requests := make([]*storage.Request, 0, len(commands))
+	for i, args := range commands {
+		req, err := parseCurlArgs(args)
+		if err != nil {
+			return nil, fmt.Errorf("curl command %d: %w", i+1, err)
+		}
+		// keep the order they were listed in
+		req.Timestamp = now + int64(i)
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+// curlArgFlags are the curl options that take a value, so the value isn't
+// mistaken for the URL. Options not listed take none.
+var curlArgFlags = map[string]bool{
+	"-X": true, "--request": true,
+	"-H": true, "--header": true,
+	"-d": true, "--data": true, "--data-raw": true, "--data-binary": true, "--data-ascii": true, "--data-urlencode": true,
+	"--json": true, "-F": true, "--form": true,
+	"-u": true, "--user": true, "-A": true, "--user-agent": true,
+	"-e": true, "--referer": true, "-b": true, "--cookie": true,
+	"--url": true, "-o": true, "--output": true, "-x": true, "--proxy": true,
+	"-m": true, "--max-time": true, "--connect-timeout": true, "--retry": true,
+	"-w": true, "--write-out": true, "--cacert": true, "-E": true, "--cert": true, "--key": true,
+	"--resolve": true, "--connect-to": true, "-c": true, "--cookie-jar": true,
+}
+
+func parseCurlArgs(args []string) (*storage.Request, error) {
+	if len(args) == 0 || (args[0] != "curl" && !strings.HasSuffix(args[0], "/curl")) {
+		return nil, errors.New("not a curl command")
+	}
+
+	var (
+		method, rawURL string
+		headers        = map[string]string{}
+		data           []string
+		getData, head  bool
+	)
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			if rawURL == "" {
+				rawURL = arg
+			}
+			continue
+		}
+
+		flag, value, hasValue := arg, "", false
+		if strings.HasPrefix(arg, "--") {
+			if name, v, ok := strings.Cut(arg, "="); ok {
+				flag, value, hasValue = name, v, true
+			}
+		} else if len(arg) > 2 {
+			// -XPOST, or bundled switches like -sSL
+			if curlArgFlags[arg[:2]] {
+				flag, value, hasValue = arg[:2], arg[2:], true
+			} else {
+				for _, c := range arg[1:] {
+					switch c {
+					case 'G':
+						getData = true
+					case 'I':
+						head = true
+					}
+				}
+				continue
+			}
+		}
+		if curlArgFlags[flag] && !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s needs a value", flag)
+			}
+			i++
+			value = args[i]
+		}
+
+		switch flag {
+		case "-X", "--request":
+			method = strings.ToUpper(value)
+		case "-H", "--header":
+			name, v, ok := strings.Cut(value, ":")
+			if !ok {
+				// "Name;" sends an empty header
+				if name, ok = strings.CutSuffix(value, ";"); !ok {
+					return nil, fmt.Errorf("invalid header %q", value)
+				}
+			}
+			addHeader(headers, strings.TrimSpace(name), strings.TrimSpace(v))
+		case "-d", "--data", "--data-raw", "--data-binary", "--data-ascii", "--data-urlencode":
+			if strings.HasPrefix(value, "@") && flag != "--data-raw" {
+				return nil, fmt.Errorf("%s %s: reading the body from a file is not supported", flag, value)
+			}
+			if flag == "--data-urlencode" {
+				value = curlURLEncode(value)
+			}
+			data = append(data, value)
+		case "--json":
+			data = append(data, value)
+			setDefaultHeader(headers, "Content-Type", "application/json")
+			setDefaultHeader(headers, "Accept", "application/json")
+		case "-F", "--form":
+			return nil, errors.New("multipart form uploads (-F) are not supported")
+		case "-u", "--user":
+			addHeader(headers, "Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(value)))
+		case "-A", "--user-agent":
+			addHeader(headers, "User-Agent", value)
+		case "-e", "--referer":
+			addHeader(headers, "Referer", value)
+		case "-b", "--cookie":
+			if !strings.Contains(value, "=") {
+				return nil, fmt.Errorf("--cookie %s: reading cookies from a file is not supported", value)
+			}
+			addHeader(headers, "Cookie", value)
+		case "--url":
+			rawURL = value
+		case "-G", "--get":
+			getData = true
+		case "-I", "--head":
+			head = true
+		}
+	}
+	if rawURL == "" {
+		return nil, errors.New("no url")
+	}
+	if !strings.Contains(rawURL, "://") && !strings.HasPrefix(rawURL, "/") {
+		rawURL = "http://" + rawURL
+	}
+
+	body := strings.Join(data, "&")
+	if getData && body != "" {
+		sep := "?"
+		if strings.Contains(rawURL, "?") {
+			sep = "&"
+		}
+		rawURL += sep + body
+		body = ""
+	}
+	uri, err := requestURI(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case method != "":
+	case head:
+		method = http.MethodHead
+	case body != "":
+		method = http.MethodPost
+	default:
+		method = http.MethodGet
+	}
+	if body != "" {
+		setDefaultHeader(headers, "Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	req := &storage.Request{Method: method, URL: uri, RequestHeaders: headers}
+	if body != "" {
+		req.RequestBody = []byte(body)
+	}
+	return req, nil
+}
+
+func setDefaultHeader(headers map[string]string, name, value string) {
+	if headerValue(headers, name) == "" {
+		headers[name] = value
+	}
+}
+
+// curlURLEncode encodes a --data-urlencode value: "name=value" encodes the
+// value only.
+func curlURLEncode(value string) string {
+	if name, v, ok := strings.Cut(value, "="); ok {
+		return name + "=" + url.QueryEscape(v)
+	}
+	return url.QueryEscape(value)
+}
+
+// splitShellCommands splits text into commands of words, following the
+// POSIX shell quoting curl commands are written in, plus bash's $'...'.
+// An unquoted newline ends a command and # starts a comment.
+func splitShellCommands(text string) ([][]string, error) {
+	var (
+		commands [][]string
+		words    []string
+		word     strings.Builder
+		inWord   bool
+	)
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(words) > 0 {
+			commands = append(commands, words)
+			words = nil
+		}
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\':
+			switch {
+			case strings.HasPrefix(text[i+1:], "\r\n"):
+				i += 2
+			case i+1 < len(text) && text[i+1] == '\n':
+				i++
+			case i+1 < len(text):
+				word.WriteByte(text[i+1])
+				inWord = true
+				i++
+			}
+		case c == '\'':
+			end := strings.IndexByte(text[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated ' quote")
+			}
+			word.WriteString(text[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		case c == '$' && i+1 < len(text) && text[i+1] == '\'':
+			n, err := readANSIQuote(text[i+2:], &word)
+			if err != nil {
+				return nil, err
+			}
+			inWord = true
+			i += n + 1
+		case c == '"':
+			closed := false
+			for i++; i < len(text); i++ {
+				if text[i] == '"' {
+					closed = true
+					break
+				}
+				if text[i] == '\\' && i+1 < len(text) && strings.IndexByte("$`\"\\\n", text[i+1]) >= 0 {
+					i++
+					if text[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(text[i])
+			}
+			if !closed {
+				return nil, errors.New(`unterminated " quote`)
+			}
+			inWord = true
+		case c == '#' && !inWord:
+			for i+1 < len(text) && text[i+1] != '\n' {
+				i++
+			}
+		case c == '\n':
+			endCommand()
+		case c == ' ' || c == '\t' || c == '\r':
+			endWord()
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	endCommand()
+	return commands, nil
+}
+
+// readANSIQuote reads the body of a $'...' string up to its closing quote,
+// returning how many bytes it consumed including the quote.
+func readANSIQuote(s string, out *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'':
+			return i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return 0, errors.New("unterminated $' quote")
+			}
+			i++
+			switch e := s[i]; e {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			case '0':
+				out.WriteByte(0)
+			case 'x', 'u', 'U':
+				digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[e]
+				end := i + 1
+				for end < len(s) && end-i-1 < digits && isHexDigit(s[end]) {
+					end++
+				}
+				n, err := strconv.ParseUint(s[i+1:end], 16, 32)
+				if err != nil {
+					return 0, fmt.Errorf("invalid \\%c escape", e)
+				}
+				if e == 'x' {
+					out.WriteByte(byte(n))
+				} else {
+					out.WriteRune(rune(n))
+				}
+				i = end - 1
+			default:
+				// \\, \', \" and anything unknown stand for themselves
+				out.WriteByte(e)
+			}
+		default:
+			out.WriteByte(s[i])
+		}
+	}
+	return 0, errors.New("unterminated $' quote")
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// handleImport saves an uploaded HAR file or cURL commands as requests
+// under a new synthetic tunnel. The file is the request body, or the
+// "file" field of a multipart form sent with X-Requested-With; ?format=
+// skips detection.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	traceID := ulid.Make().String()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// a HAR file as JSON, or either format as an upload
+	if !allowWrite(w, r, "application/json", "multipart/form-data") {
+		return
+	}
+	upload := strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
+	// any site's HTML form can post multipart/form-data, but none can add
+	// a custom header without the browser asking us first
+	if upload && r.Header.Get("X-Requested-With") == "" {
+		writeJSONError(w, "uploads must set X-Requested-With", http.StatusForbidden)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var data []byte
+	var err error
+	if upload {
+		r.Body = body
+		file, _, ferr := r.FormFile("file")
+		if ferr != nil {
+			writeJSONError(w, fmt.Sprintf("read upload: %v", ferr), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, err = io.ReadAll(file)
+	} else {
+		data, err = io.ReadAll(body)
+	}
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("read upload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !utf8.Valid(data) {
+		writeJSONError(w, "import must be a HAR file or cURL commands", http.StatusBadRequest)
+		return
+	}
+
+	opts := ImportOptions{Format: r.URL.Query().Get("format")}
+	if s.scrubRuleRepo != nil {
+		opts.Scrubber, err = storage.NewScrubberWithRepo(s.scrubRuleRepo)
+		if err != nil {
+			s.logger.WithFields(logging.Fields{
+				"trace_id": traceID,
+			}).WithError(err).Error("dashboard", "api", "Request failed")
+			writeJSONError(w, fmt.Sprintf("load scrub rules: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result, err := Import(s.repo, data, opts)
+	if err != nil {
+		if result != nil {
+			// parsed fine, but the store failed part way
+			s.logger.WithFields(logging.Fields{
+				"tunnel_id": result.TunnelID,
+				"saved":     len(result.IDs),
+				"trace_id":  traceID,
+			}).WithError(err).Error("dashboard", "api", "Request failed")
+			writeJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.logger.WithFields(logging.Fields{
+		"format":    result.Format,
+		"tunnel_id": result.TunnelID,
+		"count":     len(result.IDs),
+		"trace_id":  traceID,
+	}).Info("dashboard", "import", "Requests imported")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,311 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const browserHAR = `{
+  "log": {
+    "version": "1.2",
+    "entries": [
+      {
+        "startedDateTime": "2024-03-01T10:00:00.123+01:00",
+        "time": 87.654,
+        "request": {
+          "method": "POST",
+          "url": "https://shop.example.com/api/cart?session=9",
+          "httpVersion": "h2",
+          "headers": [
+            {"name": ":authority", "value": "shop.example.com"},
+            {"name": "content-type", "value": "application/json"},
+            {"name": "authorization", "value": "Bearer abc"},
+            {"name": "accept", "value": "text/html"},
+            {"name": "accept", "value": "application/json"}
+          ],
+          "postData": {"mimeType": "application/json", "text": "{\"sku\":42}"}
+        },
+        "response": {
+          "status": 409,
+          "headers": [{"name": "content-type", "value": "application/json"}],
+          "content": {"size": 3, "mimeType": "application/json", "text": "e30=", "encoding": "base64"}
+        }
+      }
+    ]
+  }
+}`
+
+func TestParseHAR(t *testing.T) {
+	requests, err := parseHAR([]byte(browserHAR))
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+
+	req := requests[0]
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "/api/cart?session=9", req.URL)
+	assert.Equal(t, int64(1709283600123), req.Timestamp)
+	assert.Equal(t, int64(87), req.DurationMs)
+	assert.Equal(t, map[string]string{
+		"content-type":  "application/json",
+		"authorization": "Bearer abc",
+		"accept":        "text/html, application/json",
+	}, req.RequestHeaders)
+	assert.Equal(t, `{"sku":42}`, string(req.RequestBody))
+	assert.Equal(t, 409, req.StatusCode)
+	assert.Equal(t, "{}", string(req.ResponseBody))
+}
+
+func TestParseHAR_Invalid(t *testing.T) {
+	_, err := parseHAR([]byte(`{"log":`))
+	assert.ErrorContains(t, err, "parse har")
+
+	_, err = parseHAR([]byte(`{"log":{"entries":[{"request":{"url":"ftp://x/y"}}]}}`))
+	assert.ErrorContains(t, err, "har entry 0")
+}
+
+func TestParseCurl(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		method  string
+		url     string
+		headers map[string]string
+		body    string
+	}{
+		{
+			name:    "plain get",
+			command: `curl https://api.example.com/users?page=2`,
+			method:  "GET",
+			url:     "/users?page=2",
+			headers: map[string]string{},
+		},
+		{
+			name: "browser copy as curl",
+			command: `curl 'https://api.example.com/orders' \
+  -H 'accept: application/json' \
+  -H 'content-type: application/json' \
+  --data-raw '{"note":"it'\''s ok"}' \
+  --compressed`,
+			method:  "POST",
+			url:     "/orders",
+			headers: map[string]string{"accept": "application/json", "content-type": "application/json"},
+			body:    `{"note":"it's ok"}`,
+		},
+		{
+			name:    "ansi-c quoting and bundled flags",
+			command: `curl -sSL -XPUT "http://localhost:8080/items/1" -H "X-Trace: \"a\"" --data-binary $'line1\nline2\x21'`,
+			method:  "PUT",
+			url:     "/items/1",
+			headers: map[string]string{"X-Trace": `"a"`, "Content-Type": "application/x-www-form-urlencoded"},
+			body:    "line1\nline2!",
+		},
+		{
+			name:    "get with data and basic auth",
+			command: `curl -G example.com/search -d q=go --data-urlencode 'tag=a b' -u alice:pw`,
+			method:  "GET",
+			url:     "/search?q=go&tag=a+b",
+			headers: map[string]string{"Authorization": "Basic YWxpY2U6cHc="},
+		},
+		{
+			name:    "json flag",
+			command: `curl --json '{"a":1}' --url=https://x.test/hook`,
+			method:  "POST",
+			url:     "/hook",
+			headers: map[string]string{"Content-Type": "application/json", "Accept": "application/json"},
+			body:    `{"a":1}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, err := parseCurl(tt.command)
+			require.NoError(t, err)
+			require.Len(t, requests, 1)
+			req := requests[0]
+			assert.Equal(t, tt.method, req.Method)
+			assert.Equal(t, tt.url, req.URL)
+			assert.Equal(t, tt.headers, req.RequestHeaders)
+			assert.Equal(t, tt.body, string(req.RequestBody))
+		})
+	}
+}
+
+func TestParseCurl_Multiple(t *testing.T) {
+	requests, err := parseCurl("# from the ticket\ncurl https://a.test/one\n\ncurl https://a.test/two -X DELETE\n")
+	require.NoError(t, err)
+	require.Len(t, requests, 2)
+	assert.Equal(t, "/one", requests[0].URL)
+	assert.Equal(t, "DELETE", requests[1].Method)
+	assert.Less(t, requests[0].Timestamp, requests[1].Timestamp)
+}
+
+func TestParseCurl_Errors(t *testing.T) {
+	for command, want := range map[string]string{
+		`wget https://a.test`:               "not a curl command",
+		`curl -X POST`:                      "no url",
+		`curl https://a.test -d @body.json`: "not supported",
+		`curl 'https://a.test`:              "unterminated",
+		`curl https://a.test -H`:            "needs a value",
+	} {
+		_, err := parseCurl(command)
+		assert.ErrorContains(t, err, want, command)
+	}
+}
+
+func TestImport_ScrubsAndSaves(t *testing.T) {
+	repo := newMockRepo()
+	scrubber, err := storage.NewScrubberWithRepo(&memScrubRuleRepo{patterns: []string{"authorization"}})
+	require.NoError(t, err)
+
+	result, err := Import(repo, []byte(browserHAR), ImportOptions{Scrubber: scrubber})
+	require.NoError(t, err)
+
+	assert.Equal(t, FormatHAR, result.Format)
+	assert.True(t, strings.HasPrefix(result.TunnelID, "import-"))
+	require.Len(t, result.IDs, 1)
+	saved, _ := repo.Get(result.IDs[0])
+	require.NotNil(t, saved)
+	assert.Equal(t, result.TunnelID, saved.TunnelID)
+	assert.Equal(t, "***", saved.RequestHeaders["authorization"])
+	assert.NotZero(t, saved.CreatedAt)
+}
+
+func TestImport_ExportRoundTrip(t *testing.T) {
+	original := exportRequest()
+	for _, format := range []string{FormatHAR, FormatCurl} {
+		var buf bytes.Buffer
+		require.NoError(t, Export(&buf, []*storage.Request{original}, ExportOptions{Format: format}))
+
+		repo := newMockRepo()
+		result, err := Import(repo, buf.Bytes(), ImportOptions{})
+		require.NoError(t, err, format)
+		assert.Equal(t, format, result.Format)
+
+		got, _ := repo.Get(result.IDs[0])
+		assert.Equal(t, original.Method, got.Method, format)
+		assert.Equal(t, original.URL, got.URL, format)
+		assert.Equal(t, original.RequestBody, got.RequestBody, format)
+		assert.Equal(t, "Bearer secret", got.RequestHeaders["Authorization"], format)
+	}
+}
+
+func TestImport_ExportRoundTripGzipResponse(t *testing.T) {
+	body := []byte(`{"items":["a","a","a","a","a","a","a","a"]}`)
+	original := exportRequest()
+	original.ResponseBody = compress(t, "gzip", body)
+	original.ResponseHeaders = map[string]string{
+		"Content-Type":     "application/json",
+		"Content-Encoding": "gzip",
+		"Content-Length":   strconv.Itoa(len(original.ResponseBody)),
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(&buf, []*storage.Request{original}, ExportOptions{Format: FormatHAR}))
+	repo := newMockRepo()
+	result, err := Import(repo, buf.Bytes(), ImportOptions{})
+	require.NoError(t, err)
+
+	got, _ := repo.Get(result.IDs[0])
+	assert.Equal(t, body, got.ResponseBody)
+	assert.Empty(t, headerValue(got.ResponseHeaders, "Content-Encoding"))
+	assert.Equal(t, strconv.Itoa(len(body)), got.ResponseHeaders["Content-Length"])
+
+	view := DecodeBody(got.ResponseBody, got.ResponseHeaders)
+	assert.Empty(t, view.Error)
+	assert.Equal(t, DecodedBytes(original.ResponseBody, original.ResponseHeaders), DecodedBytes(got.ResponseBody, got.ResponseHeaders))
+}
+
+func TestImportAPI(t *testing.T) {
+	repo := newMockRepo()
+	srv, err := NewServer(ServerConfig{
+		Addr:          ":0",
+		Repo:          repo,
+		ScrubRuleRepo: &memScrubRuleRepo{patterns: []string{"authorization"}},
+	})
+	require.NoError(t, err)
+
+	post := func(body *bytes.Buffer, contentType string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/import", body)
+		req.Header.Set("Content-Type", contentType)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		srv.testHandler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	form := func(name, content string) (*bytes.Buffer, string) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, err := mw.CreateFormFile("file", name)
+		require.NoError(t, err)
+		fw.Write([]byte(content))
+		require.NoError(t, mw.Close())
+		return &body, mw.FormDataContentType()
+	}
+
+	upload := func(name, content string) *httptest.ResponseRecorder {
+		body, contentType := form(name, content)
+		return post(body, contentType, http.Header{"X-Requested-With": {"XMLHttpRequest"}})
+	}
+
+	t.Run("curl upload", func(t *testing.T) {
+		rec := upload("commands.txt", `curl https://a.test/hook -H 'Authorization: Bearer x' -d ok`)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+		var result ImportResult
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		assert.Equal(t, FormatCurl, result.Format)
+		saved, _ := repo.Get(result.IDs[0])
+		require.NotNil(t, saved)
+		assert.Equal(t, "***", saved.RequestHeaders["Authorization"])
+	})
+
+	t.Run("har upload", func(t *testing.T) {
+		rec := upload("session.har", browserHAR)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `"format":"har"`)
+	})
+
+	t.Run("raw har", func(t *testing.T) {
+		rec := post(bytes.NewBufferString(browserHAR), "application/json", nil)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `"format":"har"`)
+	})
+
+	t.Run("bad input", func(t *testing.T) {
+		rec := upload("commands.txt", `not a curl command`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("cross-site", func(t *testing.T) {
+		before := len(repo.requests)
+
+		rec := post(bytes.NewBufferString(`curl https://a.test/hook -d ok`), "text/plain", nil)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+		// what a cross-site <form enctype="multipart/form-data"> sends
+		body, contentType := form("commands.txt", `curl https://a.test/hook -d ok`)
+		rec = post(body, contentType, nil)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		req := httptest.NewRequest("POST", "/api/import", bytes.NewBufferString(browserHAR))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Sec-Fetch-Site", "cross-site")
+		rec = httptest.NewRecorder()
+		srv.testHandler().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		assert.Len(t, repo.requests, before, "nothing was imported")
+	})
+}
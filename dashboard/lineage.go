@@ -0,0 +1,202 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/auditmos/devtunnel/logging"
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/oklog/ulid/v2"
+)
+
+// maxLineageDepth stops the walk to a lineage's root on corrupt data.
+const maxLineageDepth = 100
+
+// LineageResponse is a captured request and every replay made from it,
+// oldest first.
+type LineageResponse struct {
+	Root    APIRequest   `json:"root"`
+	Replays []APIRequest `json:"replays"`
+}
+
+type LineageData struct {
+	Root    RequestView
+	Replays []RequestView
+	Current string
+}
+
+type DiffData struct {
+	A    RequestView
+	B    RequestView
+	Diff ExchangeDiff
+}
+
+// lineage finds the request id was replayed from, following replays of
+// replays back to the original, and lists all of its replays. A deleted
+// ancestor ends the walk early.
+func (s *Server) lineage(id string) (*storage.Request, []*storage.Request, error) {
+	root, err := s.repo.Get(id)
+	if err != nil || root == nil {
+		return nil, nil, err
+	}
+	for depth := 0; root.ReplayOf != "" && depth < maxLineageDepth; depth++ {
+		parent, err := s.repo.Get(root.ReplayOf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if parent == nil {
+			break
+		}
+		root = parent
+	}
+	replays, err := s.repo.ListReplays(root.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return root, replays, nil
+}
+
+func (s *Server) handleAPILineage(w http.ResponseWriter, r *http.Request) {
+	traceID := ulid.Make().String()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/lineage/")
+	root, replays, err := s.lineage(id)
+	if err != nil {
+		s.logger.WithFields(logging.Fields{
+			"request_id": id,
+			"trace_id":   traceID,
+		}).WithError(err).Error("dashboard", "api", "Request failed")
+		writeJSONError(w, fmt.Sprintf("fetch lineage: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if root == nil {
+		writeJSONError(w, "request not found", http.StatusNotFound)
+		return
+	}
+
+	// ListReplays leaves bodies out, and the API returns them
+	for _, req := range replays {
+		if err := s.repo.LoadBodies(req); err != nil {
+			s.logger.WithFields(logging.Fields{
+				"request_id": req.ID,
+				"trace_id":   traceID,
+			}).WithError(err).Error("dashboard", "api", "Request failed")
+			writeJSONError(w, fmt.Sprintf("load bodies: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resp := LineageResponse{Root: toAPIRequest(root, 0), Replays: make([]APIRequest, len(replays))}
+	for i, req := range replays {
+		resp.Replays[i] = toAPIRequest(req, 0)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleLineage(w http.ResponseWriter, r *http.Request) {
+	traceID := ulid.Make().String()
+
+	id := strings.TrimPrefix(r.URL.Path, "/lineage/")
+	root, replays, err := s.lineage(id)
+	if err != nil {
+		s.logger.WithFields(logging.Fields{
+			"request_id": id,
+			"trace_id":   traceID,
+		}).WithError(err).Error("dashboard", "api", "Request failed")
+		http.Error(w, "failed to load lineage", http.StatusInternalServerError)
+		return
+	}
+	if root == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := LineageData{
+		Root:    toRequestView(root),
+		Replays: make([]RequestView, len(replays)),
+		Current: id,
+	}
+	for i, req := range replays {
+		data.Replays[i] = toRequestView(req)
+	}
+	s.render(w, "lineage", data, traceID)
+}
+
+// loadPair fetches the exchanges named by ?a= and ?b=, writing the error
+// response when it cannot.
+func (s *Server) loadPair(w http.ResponseWriter, r *http.Request, traceID string) (*storage.Request, *storage.Request, bool) {
+	q := r.URL.Query()
+	ids := []string{q.Get("a"), q.Get("b")}
+	if ids[0] == "" || ids[1] == "" {
+		writeJSONError(w, "a and b are required", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	pair := make([]*storage.Request, 2)
+	for i, id := range ids {
+		req, err := s.repo.Get(id)
+		if err != nil {
+			s.logger.WithFields(logging.Fields{
+				"request_id": id,
+				"trace_id":   traceID,
+			}).WithError(err).Error("dashboard", "api", "Request failed")
+			writeJSONError(w, fmt.Sprintf("fetch request: %v", err), http.StatusInternalServerError)
+			return nil, nil, false
+		}
+		if req == nil {
+			writeJSONError(w, fmt.Sprintf("request %s not found", id), http.StatusNotFound)
+			return nil, nil, false
+		}
+		pair[i] = req
+	}
+	return pair[0], pair[1], true
+}
+
+// handleAPIDiff compares two exchanges: /api/diff?a=<id>&b=<id>.
+func (s *Server) handleAPIDiff(w http.ResponseWriter, r *http.Request) {
+	traceID := ulid.Make().String()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a, b, ok := s.loadPair(w, r, traceID)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Diff(a, b))
+}
+
+func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	traceID := ulid.Make().String()
+
+	a, b, ok := s.loadPair(w, r, traceID)
+	if !ok {
+		return
+	}
+	s.render(w, "diff", DiffData{
+		A:    toRequestView(a),
+		B:    toRequestView(b),
+		Diff: Diff(a, b),
+	}, traceID)
+}
+
+func (s *Server) render(w http.ResponseWriter, name string, data any, traceID string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.templates.ExecuteTemplate(w, name, data); err != nil {
+		s.logger.WithFields(logging.Fields{
+			"template": name,
+			"trace_id": traceID,
+		}).WithError(err).Error("dashboard", "template", "Render failed")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,29 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/auditmos/devtunnel/storage"
+)
+
+// APIMetricsResponse reports on the client's own machinery. RequestLog is
+// absent when requests are not being logged.
+type APIMetricsResponse struct {
+	RequestLog *storage.RequestLogStats `json:"request_log,omitempty"`
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resp APIMetricsResponse
+	if s.requestLog != nil {
+		stats := s.requestLog()
+		resp.RequestLog = &stats
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,42 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_RequestLog(t *testing.T) {
+	srv, err := NewServer(ServerConfig{
+		Addr: ":0",
+		Repo: newMockRepo(),
+		RequestLog: func() storage.RequestLogStats {
+			return storage.RequestLogStats{Written: 7, Dropped: 2}
+		},
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+
+	var resp APIMetricsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.NotNil(t, resp.RequestLog)
+	assert.Equal(t, int64(7), resp.RequestLog.Written)
+	assert.Equal(t, int64(2), resp.RequestLog.Dropped)
+}
+
+func TestMetrics_NoRequestLog(t *testing.T) {
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: newMockRepo()})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.JSONEq(t, `{}`, rec.Body.String())
+}
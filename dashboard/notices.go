@@ -0,0 +1,55 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const maxNotices = 50
+
+// Notice is a message from the tunnel server shown above the request list.
+type Notice struct {
+	Type      string `json:"type"`
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type APINoticesResponse struct {
+	Notices []Notice `json:"notices"`
+}
+
+// AddNotice records a server message, keeping only the most recent ones.
+func (s *Server) AddNotice(n Notice) {
+	if n.Timestamp == 0 {
+		n.Timestamp = time.Now().UnixMilli()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notices = append(s.notices, n)
+	if len(s.notices) > maxNotices {
+		s.notices = s.notices[len(s.notices)-maxNotices:]
+	}
+}
+
+// Notices returns recorded server messages, newest first.
+func (s *Server) Notices() []Notice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]Notice, len(s.notices))
+	for i, n := range s.notices {
+		result[len(s.notices)-1-i] = n
+	}
+	return result
+}
+
+func (s *Server) handleNotices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APINoticesResponse{Notices: s.Notices()})
+}
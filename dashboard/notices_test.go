@@ -0,0 +1,55 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotices_NewestFirst(t *testing.T) {
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: newMockRepo()})
+	require.NoError(t, err)
+
+	srv.AddNotice(Notice{Type: "notice", Message: "first"})
+	srv.AddNotice(Notice{Type: "shutdown", Message: "second"})
+
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/notices", nil))
+	assert.Equal(t, 200, rec.Code)
+
+	var resp APINoticesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Notices, 2)
+	assert.Equal(t, "second", resp.Notices[0].Message)
+	assert.NotZero(t, resp.Notices[0].Timestamp)
+}
+
+func TestNotices_Bounded(t *testing.T) {
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: newMockRepo()})
+	require.NoError(t, err)
+
+	for i := 0; i < maxNotices+10; i++ {
+		srv.AddNotice(Notice{Type: "notice", Message: fmt.Sprintf("n%d", i)})
+	}
+
+	notices := srv.Notices()
+	assert.Len(t, notices, maxNotices)
+	assert.Equal(t, fmt.Sprintf("n%d", maxNotices+9), notices[0].Message)
+}
+
+func TestNotices_RenderedOnIndex(t *testing.T) {
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: newMockRepo()})
+	require.NoError(t, err)
+
+	srv.AddNotice(Notice{Type: "rate_limit", Message: "rate limit exceeded"})
+
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.Contains(t, rec.Body.String(), "notice-rate_limit")
+	assert.Contains(t, rec.Body.String(), "rate limit exceeded")
+}
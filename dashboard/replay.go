@@ -0,0 +1,161 @@
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/auditmos/devtunnel/storage"
+	"github.com/oklog/ulid/v2"
+)
+
+// Replayer resends captured requests to the local app and saves each
+// result as a replay of the request it came from.
+type Replayer struct {
+	Repo       storage.RequestRepo
+	LocalAddr  string
+	HTTPClient *http.Client
+}
+
+// replaySendError is a replay the local app never answered.
+type replaySendError struct {
+	err error
+}
+
+func (e *replaySendError) Error() string { return "replay request: " + e.err.Error() }
+func (e *replaySendError) Unwrap() error { return e.err }
+
+// ErrBodyTruncated is returned by Replay for a request whose body the
+// store kept only part of, unless the replay supplies its own body.
+var ErrBodyTruncated = errors.New("request body was truncated when stored")
+
+// Replay sends original, edited by overrides, to the local app and saves
+// the exchange with ReplayOf pointing at original.
+func (p *Replayer) Replay(ctx context.Context, original *storage.Request, overrides ReplayOverrides) (*storage.Request, error) {
+	if overrides.Body == nil && original.RequestBodySize > len(original.RequestBody) {
+		return nil, fmt.Errorf("%w (kept %d of %d bytes)", ErrBodyTruncated, len(original.RequestBody), original.RequestBodySize)
+	}
+	replayed := overrides.apply(original)
+
+	start := time.Now()
+
+	url := fmt.Sprintf("http://%s%s", p.LocalAddr, replayed.URL)
+	req, err := http.NewRequestWithContext(ctx, replayed.Method, url, bytes.NewReader(replayed.RequestBody))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	for k, v := range replayed.RequestHeaders {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, &replaySendError{err: err}
+	}
+	defer resp.Body.Close()
+
+	duration := time.Since(start)
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	respHeaders := make(map[string]string)
+	for k := range resp.Header {
+		respHeaders[k] = resp.Header.Get(k)
+	}
+
+	newReq := &storage.Request{
+		ID:              ulid.Make().String(),
+		TunnelID:        original.TunnelID,
+		Timestamp:       time.Now().UnixMilli(),
+		Method:          replayed.Method,
+		URL:             replayed.URL,
+		RequestHeaders:  replayed.RequestHeaders,
+		RequestBody:     replayed.RequestBody,
+		StatusCode:      resp.StatusCode,
+		ResponseHeaders: respHeaders,
+		ResponseBody:    respBody,
+		DurationMs:      duration.Milliseconds(),
+		ReplayOf:        original.ID,
+	}
+	if err := p.Repo.Save(newReq); err != nil {
+		return nil, fmt.Errorf("save request: %w", err)
+	}
+	return newReq, nil
+}
+
+// ReplayOverrides edits a stored request before it is replayed. Zero
+// fields keep what was captured; URL is a path and query on the local app.
+// SetHeaders is applied after RemoveHeaders, so a header can be replaced
+// under a different case.
+type ReplayOverrides struct {
+	Method        string            `json:"method,omitempty"`
+	URL           string            `json:"url,omitempty"`
+	SetHeaders    map[string]string `json:"set_headers,omitempty"`
+	RemoveHeaders []string          `json:"remove_headers,omitempty"`
+	Body          *string           `json:"body,omitempty"`
+}
+
+func (o ReplayOverrides) empty() bool {
+	return o.Method == "" && o.URL == "" && len(o.SetHeaders) == 0 && len(o.RemoveHeaders) == 0 && o.Body == nil
+}
+
+func (o ReplayOverrides) validate() string {
+	switch {
+	case o.Method != "" && strings.ContainsAny(o.Method, " \t\r\n/"):
+		return "invalid method"
+	case o.URL != "" && !strings.HasPrefix(o.URL, "/"):
+		return "url must be a path starting with /"
+	}
+	for name := range o.SetHeaders {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			return "invalid header name"
+		}
+	}
+	return ""
+}
+
+// apply returns a copy of req with the overrides applied. Header names
+// match case-insensitively. Content-Length is dropped when the body
+// changes, since the client recomputes it.
+func (o ReplayOverrides) apply(req *storage.Request) *storage.Request {
+	out := *req
+	if o.Method != "" {
+		out.Method = strings.ToUpper(o.Method)
+	}
+	if o.URL != "" {
+		out.URL = o.URL
+	}
+
+	out.RequestHeaders = make(map[string]string, len(req.RequestHeaders)+len(o.SetHeaders))
+	for k, v := range req.RequestHeaders {
+		out.RequestHeaders[k] = v
+	}
+	if o.Body != nil {
+		out.RequestBody = []byte(*o.Body)
+		deleteHeader(out.RequestHeaders, "Content-Length")
+	}
+	for _, name := range o.RemoveHeaders {
+		deleteHeader(out.RequestHeaders, name)
+	}
+	for name, v := range o.SetHeaders {
+		deleteHeader(out.RequestHeaders, name)
+		out.RequestHeaders[http.CanonicalHeaderKey(name)] = v
+	}
+	return &out
+}
+
+func deleteHeader(headers map[string]string, name string) {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			delete(headers, k)
+		}
+	}
+}
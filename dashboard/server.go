@@ -3,19 +3,24 @@ package dashboard
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"embed"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/auditmos/devtunnel/crypto"
@@ -37,8 +42,13 @@ type Server struct {
 	listener      net.Listener
 	templates     *template.Template
 	httpClient    *http.Client
+	replayer      *Replayer
 	readyCallback func()
 	logger        logging.Logger
+	requestLog    func() storage.RequestLogStats
+
+	mu      sync.RWMutex
+	notices []Notice
 }
 
 type ServerConfig struct {
@@ -49,6 +59,9 @@ type ServerConfig struct {
 	LocalAddr     string
 	ServerAddr    string
 	Logger        logging.Logger
+	// RequestLog reports on the writer saving proxied requests, for
+	// /api/metrics.
+	RequestLog func() storage.RequestLogStats
 }
 
 func NewServer(cfg ServerConfig) (*Server, error) {
@@ -69,11 +82,14 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 		repo:          cfg.Repo,
 		scrubRuleRepo: cfg.ScrubRuleRepo,
 		logger:        logger,
+		requestLog:    cfg.RequestLog,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
 
+	s.replayer = &Replayer{Repo: cfg.Repo, LocalAddr: localAddr, HTTPClient: s.httpClient}
+
 	tmpl, err := loadTemplates(cfg.OverridesDir)
 	if err != nil {
 		return nil, fmt.Errorf("load templates: %w", err)
@@ -86,6 +102,17 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 func loadTemplates(overridesDir string) (*template.Template, error) {
 	funcMap := template.FuncMap{
 		"lower": strings.ToLower,
+		"json": func(v any) string {
+			b, _ := json.Marshal(v)
+			return string(b)
+		},
+		"dict": func(kv ...any) map[string]any {
+			m := make(map[string]any, len(kv)/2)
+			for i := 0; i+1 < len(kv); i += 2 {
+				m[fmt.Sprint(kv[i])] = kv[i+1]
+			}
+			return m
+		},
 	}
 
 	tmpl := template.New("").Funcs(funcMap)
@@ -142,10 +169,22 @@ func (s *Server) buildMux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleIndex)
 	mux.HandleFunc("/api/requests", s.handleAPIRequests)
+	mux.HandleFunc("/api/requests/", s.handleAPIRequest)
 	mux.HandleFunc("/api/replay/", s.handleReplay)
+	mux.HandleFunc("/api/bulk-replay", s.handleBulkReplay)
+	mux.HandleFunc("/api/export", s.handleExport)
+	mux.HandleFunc("/api/export/", s.handleExport)
+	mux.HandleFunc("/api/import", s.handleImport)
+	mux.HandleFunc("/api/body/", s.handleBody)
+	mux.HandleFunc("/api/lineage/", s.handleAPILineage)
+	mux.HandleFunc("/api/diff", s.handleAPIDiff)
+	mux.HandleFunc("/lineage/", s.handleLineage)
+	mux.HandleFunc("/diff", s.handleDiff)
 	mux.HandleFunc("/api/share/", s.handleShare)
 	mux.HandleFunc("/api/scrub-rules", s.handleScrubRules)
 	mux.HandleFunc("/api/scrub-rules/", s.handleScrubRuleByID)
+	mux.HandleFunc("/api/notices", s.handleNotices)
+	mux.HandleFunc("/api/metrics", s.handleMetrics)
 	return mux
 }
 
@@ -211,16 +250,21 @@ type RequestView struct {
 	StatusClass              string
 	DurationMs               int64
 	TimeAgo                  string
+	Delayed                  bool
+	ReceivedAgo              string
 	RequestHeaders           map[string]string
 	RequestHeadersFormatted  string
-	RequestBody              string
+	RequestBodySize          int
 	ResponseHeaders          map[string]string
 	ResponseHeadersFormatted string
-	ResponseBody             string
+	ResponseBodySize         int
+	ReplayOf                 string
+	Pinned                   bool
 }
 
 type IndexData struct {
 	Requests    []RequestView
+	Notices     []Notice
 	LastUpdated string
 }
 
@@ -242,6 +286,7 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// bodies are left out; the page fetches them when a request is expanded
 	views := make([]RequestView, len(requests))
 	for i, req := range requests {
 		views[i] = toRequestView(req)
@@ -249,6 +294,7 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 
 	data := IndexData{
 		Requests:    views,
+		Notices:     s.Notices(),
 		LastUpdated: time.Now().Format("15:04:05"),
 	}
 
@@ -277,8 +323,24 @@ func (s *Server) handleAPIRequests(w http.ResponseWriter, r *http.Request) {
 			limit = parsed
 		}
 	}
+	// max_body keeps only the start of each body; 0 keeps all of it
+	maxBody := 0
+	if maxStr := r.URL.Query().Get("max_body"); maxStr != "" {
+		if parsed, err := strconv.Atoi(maxStr); err == nil && parsed > 0 {
+			maxBody = parsed
+		}
+	}
+	// summary=1 leaves bodies out; /api/requests/<id> has them
+	summary := r.URL.Query().Get("summary") == "1"
 
 	requests, err := s.repo.ListAll(limit)
+	if err == nil && !summary {
+		for _, req := range requests {
+			if err = s.repo.LoadBodies(req); err != nil {
+				break
+			}
+		}
+	}
 	if err != nil {
 		s.logger.WithFields(logging.Fields{
 			"path":     r.URL.Path,
@@ -296,25 +358,109 @@ func (s *Server) handleAPIRequests(w http.ResponseWriter, r *http.Request) {
 
 	apiReqs := make([]APIRequest, len(requests))
 	for i, req := range requests {
-		apiReqs[i] = APIRequest{
-			ID:              req.ID,
-			TunnelID:        req.TunnelID,
-			Timestamp:       req.Timestamp,
-			Method:          req.Method,
-			URL:             req.URL,
-			RequestHeaders:  req.RequestHeaders,
-			RequestBody:     string(req.RequestBody),
-			StatusCode:      req.StatusCode,
-			ResponseHeaders: req.ResponseHeaders,
-			ResponseBody:    string(req.ResponseBody),
-			DurationMs:      req.DurationMs,
-		}
+		apiReqs[i] = toAPIRequest(req, maxBody)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(APIRequestsResponse{Requests: apiReqs})
 }
 
+// handleAPIRequest returns one request with its bodies:
+// /api/requests/<id>?max_body=N. POST and DELETE on /api/requests/<id>/pin
+// pin and unpin it.
+func (s *Server) handleAPIRequest(w http.ResponseWriter, r *http.Request) {
+	traceID := ulid.Make().String()
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/requests/")
+	if pinID, ok := strings.CutSuffix(id, "/pin"); ok {
+		s.handlePin(w, r, pinID, traceID)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxBody, _ := strconv.Atoi(r.URL.Query().Get("max_body"))
+
+	req, err := s.repo.Get(id)
+	if err != nil {
+		s.logger.WithFields(logging.Fields{
+			"request_id": id,
+			"trace_id":   traceID,
+		}).WithError(err).Error("dashboard", "api", "Request failed")
+		writeJSONError(w, fmt.Sprintf("fetch request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if req == nil {
+		writeJSONError(w, "request not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toAPIRequest(req, maxBody))
+}
+
+// handlePin pins a request, exempting it from retention, or unpins it.
+func (s *Server) handlePin(w http.ResponseWriter, r *http.Request, id, traceID string) {
+	var pinned bool
+	switch r.Method {
+	case http.MethodPost:
+		pinned = true
+	case http.MethodDelete:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := s.repo.SetPinned(id, pinned)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, "request not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.WithFields(logging.Fields{
+			"request_id": id,
+			"trace_id":   traceID,
+		}).WithError(err).Error("dashboard", "api", "Request failed")
+		writeJSONError(w, fmt.Sprintf("pin request: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"id": id, "pinned": pinned})
+}
+
+func toAPIRequest(req *storage.Request, maxBody int) APIRequest {
+	reqBody := storage.EncodeStoredBody(req.RequestBody, req.RequestBodySize, req.RequestHeaders, maxBody)
+	respBody := storage.EncodeStoredBody(req.ResponseBody, req.ResponseBodySize, req.ResponseHeaders, maxBody)
+	return APIRequest{
+		ID:                      req.ID,
+		TunnelID:                req.TunnelID,
+		Timestamp:               req.Timestamp,
+		Method:                  req.Method,
+		URL:                     req.URL,
+		RequestHeaders:          req.RequestHeaders,
+		RequestBody:             reqBody.Body,
+		RequestBodyEncoding:     reqBody.Encoding,
+		RequestBodyContentType:  reqBody.ContentType,
+		RequestBodySize:         reqBody.Size,
+		RequestBodyTruncated:    reqBody.Truncated,
+		StatusCode:              req.StatusCode,
+		ResponseHeaders:         req.ResponseHeaders,
+		ResponseBody:            respBody.Body,
+		ResponseBodyEncoding:    respBody.Encoding,
+		ResponseBodyContentType: respBody.ContentType,
+		ResponseBodySize:        respBody.Size,
+		ResponseBodyTruncated:   respBody.Truncated,
+		DurationMs:              req.DurationMs,
+		Delayed:                 req.ReceivedAt != 0,
+		ReceivedAt:              req.ReceivedAt,
+		ReplayOf:                req.ReplayOf,
+		Pinned:                  req.Pinned,
+	}
+}
+
 func toRequestView(req *storage.Request) RequestView {
 	return RequestView{
 		ID:                       req.ID,
@@ -324,12 +470,16 @@ func toRequestView(req *storage.Request) RequestView {
 		StatusClass:              statusClass(req.StatusCode),
 		DurationMs:               req.DurationMs,
 		TimeAgo:                  timeAgo(req.Timestamp),
+		Delayed:                  req.ReceivedAt != 0,
+		ReceivedAgo:              timeAgo(req.ReceivedAt),
 		RequestHeaders:           req.RequestHeaders,
 		RequestHeadersFormatted:  formatHeaders(req.RequestHeaders),
-		RequestBody:              string(req.RequestBody),
+		RequestBodySize:          max(req.RequestBodySize, len(req.RequestBody)),
 		ResponseHeaders:          req.ResponseHeaders,
 		ResponseHeadersFormatted: formatHeaders(req.ResponseHeaders),
-		ResponseBody:             string(req.ResponseBody),
+		ResponseBodySize:         max(req.ResponseBodySize, len(req.ResponseBody)),
+		ReplayOf:                 req.ReplayOf,
+		Pinned:                   req.Pinned,
 	}
 }
 
@@ -378,24 +528,47 @@ func formatHeaders(headers map[string]string) string {
 	return strings.TrimSuffix(sb.String(), "\n")
 }
 
+// ReplayResponse is the response a replay got. Body is encoded as in
+// APIRequest.
 type ReplayResponse struct {
-	StatusCode int               `json:"status_code"`
-	Headers    map[string]string `json:"headers"`
-	Body       string            `json:"body"`
+	ID              string            `json:"id"`
+	ReplayOf        string            `json:"replay_of"`
+	StatusCode      int               `json:"status_code"`
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	BodyEncoding    string            `json:"body_encoding"`
+	BodyContentType string            `json:"body_content_type,omitempty"`
+	BodySize        int               `json:"body_size"`
+	BodyTruncated   bool              `json:"body_truncated"`
 }
 
+// APIRequest is a captured exchange as the API returns it. Each body is
+// UTF-8 text, or base64 when its *_body_encoding says so; *_body_size is
+// the full length even when *_body_truncated.
 type APIRequest struct {
-	ID              string            `json:"id"`
-	TunnelID        string            `json:"tunnel_id"`
-	Timestamp       int64             `json:"timestamp"`
-	Method          string            `json:"method"`
-	URL             string            `json:"url"`
-	RequestHeaders  map[string]string `json:"request_headers"`
-	RequestBody     string            `json:"request_body"`
-	StatusCode      int               `json:"status_code"`
-	ResponseHeaders map[string]string `json:"response_headers"`
-	ResponseBody    string            `json:"response_body"`
-	DurationMs      int64             `json:"duration_ms"`
+	ID                      string            `json:"id"`
+	TunnelID                string            `json:"tunnel_id"`
+	Timestamp               int64             `json:"timestamp"`
+	Method                  string            `json:"method"`
+	URL                     string            `json:"url"`
+	RequestHeaders          map[string]string `json:"request_headers"`
+	RequestBody             string            `json:"request_body"`
+	RequestBodyEncoding     string            `json:"request_body_encoding"`
+	RequestBodyContentType  string            `json:"request_body_content_type,omitempty"`
+	RequestBodySize         int               `json:"request_body_size"`
+	RequestBodyTruncated    bool              `json:"request_body_truncated"`
+	StatusCode              int               `json:"status_code"`
+	ResponseHeaders         map[string]string `json:"response_headers"`
+	ResponseBody            string            `json:"response_body"`
+	ResponseBodyEncoding    string            `json:"response_body_encoding"`
+	ResponseBodyContentType string            `json:"response_body_content_type,omitempty"`
+	ResponseBodySize        int               `json:"response_body_size"`
+	ResponseBodyTruncated   bool              `json:"response_body_truncated"`
+	DurationMs              int64             `json:"duration_ms"`
+	Delayed                 bool              `json:"delayed,omitempty"`
+	ReceivedAt              int64             `json:"received_at,omitempty"`
+	ReplayOf                string            `json:"replay_of,omitempty"`
+	Pinned                  bool              `json:"pinned,omitempty"`
 }
 
 type APIRequestsResponse struct {
@@ -410,6 +583,10 @@ func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !allowWrite(w, r, "application/json") {
+		return
+	}
+
 	id := strings.TrimPrefix(r.URL.Path, "/api/replay/")
 	if id == "" {
 		writeJSONError(w, "missing request id", http.StatusBadRequest)
@@ -430,85 +607,61 @@ func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	start := time.Now()
-
-	url := fmt.Sprintf("http://%s%s", s.localAddr, storedReq.URL)
-	req, err := http.NewRequest(storedReq.Method, url, bytes.NewReader(storedReq.RequestBody))
-	if err != nil {
-		s.logger.WithFields(logging.Fields{
-			"request_id": id,
-			"trace_id":   traceID,
-		}).WithError(err).Error("dashboard", "api", "Request failed")
-		writeJSONError(w, fmt.Sprintf("create request: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	for k, v := range storedReq.RequestHeaders {
-		req.Header.Set(k, v)
+	var overrides ReplayOverrides
+	if body, _ := io.ReadAll(r.Body); len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &overrides); err != nil {
+			writeJSONError(w, "invalid json", http.StatusBadRequest)
+			return
+		}
 	}
-
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		s.logger.WithFields(logging.Fields{
-			"request_id": id,
-			"trace_id":   traceID,
-		}).WithError(err).Error("dashboard", "replay", "Replay failed")
-		writeJSONError(w, fmt.Sprintf("replay request: %v", err), http.StatusBadGateway)
+	if msg := overrides.validate(); msg != "" {
+		writeJSONError(w, msg, http.StatusBadRequest)
 		return
 	}
-	defer resp.Body.Close()
-
-	duration := time.Since(start)
-
-	respBody, err := io.ReadAll(resp.Body)
+	newReq, err := s.replayer.Replay(r.Context(), storedReq, overrides)
 	if err != nil {
+		if errors.Is(err, ErrBodyTruncated) {
+			writeJSONError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		var sendErr *replaySendError
+		if errors.As(err, &sendErr) {
+			s.logger.WithFields(logging.Fields{
+				"request_id": id,
+				"trace_id":   traceID,
+			}).WithError(err).Error("dashboard", "replay", "Replay failed")
+			writeJSONError(w, err.Error(), http.StatusBadGateway)
+			return
+		}
 		s.logger.WithFields(logging.Fields{
 			"request_id": id,
 			"trace_id":   traceID,
 		}).WithError(err).Error("dashboard", "api", "Request failed")
-		writeJSONError(w, fmt.Sprintf("read response: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	respHeaders := make(map[string]string)
-	for k := range resp.Header {
-		respHeaders[k] = resp.Header.Get(k)
-	}
-
-	newReq := &storage.Request{
-		ID:              ulid.Make().String(),
-		TunnelID:        storedReq.TunnelID,
-		Timestamp:       time.Now().UnixMilli(),
-		Method:          storedReq.Method,
-		URL:             storedReq.URL,
-		RequestHeaders:  storedReq.RequestHeaders,
-		RequestBody:     storedReq.RequestBody,
-		StatusCode:      resp.StatusCode,
-		ResponseHeaders: respHeaders,
-		ResponseBody:    respBody,
-		DurationMs:      duration.Milliseconds(),
-	}
-	if saveErr := s.repo.Save(newReq); saveErr != nil {
-		s.logger.WithFields(logging.Fields{
-			"request_id": id,
-			"trace_id":   traceID,
-		}).WithError(saveErr).Error("dashboard", "api", "Request failed")
-		writeJSONError(w, fmt.Sprintf("save request: %v", saveErr), http.StatusInternalServerError)
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	s.logger.WithFields(logging.Fields{
 		"request_id":  id,
-		"status_code": resp.StatusCode,
-		"duration_ms": duration.Milliseconds(),
+		"replay_id":   newReq.ID,
+		"edited":      !overrides.empty(),
+		"status_code": newReq.StatusCode,
+		"duration_ms": newReq.DurationMs,
 		"trace_id":    traceID,
 	}).Info("dashboard", "replay", "Request replayed")
 
+	body := storage.EncodeStoredBody(newReq.ResponseBody, newReq.ResponseBodySize, newReq.ResponseHeaders, 0)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ReplayResponse{
-		StatusCode: resp.StatusCode,
-		Headers:    respHeaders,
-		Body:       string(respBody),
+		ID:              newReq.ID,
+		ReplayOf:        storedReq.ID,
+		StatusCode:      newReq.StatusCode,
+		Headers:         newReq.ResponseHeaders,
+		Body:            body.Body,
+		BodyEncoding:    body.Encoding,
+		BodyContentType: body.ContentType,
+		BodySize:        body.Size,
+		BodyTruncated:   body.Truncated,
 	})
 }
 
@@ -518,15 +671,56 @@ func writeJSONError(w http.ResponseWriter, msg string, code int) {
 	json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
+// allowWrite rejects requests another site could have made the browser
+// send: any the browser marks as cross-site, or whose Origin is not the
+// dashboard's own, and any with a body that is not one of contentTypes.
+// Those should be types a plain HTML form cannot produce; a caller that
+// accepts multipart/form-data must also require a header a form cannot
+// set. Tools like curl send neither Sec-Fetch-Site nor Origin and pass.
+func allowWrite(w http.ResponseWriter, r *http.Request, contentTypes ...string) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		writeJSONError(w, "cross-site requests are not allowed", http.StatusForbidden)
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			writeJSONError(w, "cross-origin requests are not allowed", http.StatusForbidden)
+			return false
+		}
+	}
+	if r.ContentLength == 0 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	for _, ct := range contentTypes {
+		if mediaType == ct {
+			return true
+		}
+	}
+	writeJSONError(w, fmt.Sprintf("content type must be %s", strings.Join(contentTypes, " or ")), http.StatusUnsupportedMediaType)
+	return false
+}
+
+// ShareableRequest is what a share encrypts. Bodies are encoded as in
+// APIRequest, and never truncated.
 type ShareableRequest struct {
-	Method          string            `json:"method"`
-	URL             string            `json:"url"`
-	RequestHeaders  map[string]string `json:"request_headers"`
-	RequestBody     string            `json:"request_body"`
-	StatusCode      int               `json:"status_code"`
-	ResponseHeaders map[string]string `json:"response_headers"`
-	ResponseBody    string            `json:"response_body"`
-	DurationMs      int64             `json:"duration_ms"`
+	Method                  string            `json:"method"`
+	URL                     string            `json:"url"`
+	RequestHeaders          map[string]string `json:"request_headers"`
+	RequestBody             string            `json:"request_body"`
+	RequestBodyEncoding     string            `json:"request_body_encoding"`
+	RequestBodyContentType  string            `json:"request_body_content_type,omitempty"`
+	RequestBodySize         int               `json:"request_body_size"`
+	RequestBodyTruncated    bool              `json:"request_body_truncated"`
+	StatusCode              int               `json:"status_code"`
+	ResponseHeaders         map[string]string `json:"response_headers"`
+	ResponseBody            string            `json:"response_body"`
+	ResponseBodyEncoding    string            `json:"response_body_encoding"`
+	ResponseBodyContentType string            `json:"response_body_content_type,omitempty"`
+	ResponseBodySize        int               `json:"response_body_size"`
+	ResponseBodyTruncated   bool              `json:"response_body_truncated"`
+	DurationMs              int64             `json:"duration_ms"`
 }
 
 type ShareResponse struct {
@@ -566,15 +760,23 @@ func (s *Server) handleShare(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sharedReqBody := storage.EncodeStoredBody(storedReq.RequestBody, storedReq.RequestBodySize, storedReq.RequestHeaders, 0)
+	sharedRespBody := storage.EncodeStoredBody(storedReq.ResponseBody, storedReq.ResponseBodySize, storedReq.ResponseHeaders, 0)
 	shareable := ShareableRequest{
-		Method:          storedReq.Method,
-		URL:             storedReq.URL,
-		RequestHeaders:  storedReq.RequestHeaders,
-		RequestBody:     string(storedReq.RequestBody),
-		StatusCode:      storedReq.StatusCode,
-		ResponseHeaders: storedReq.ResponseHeaders,
-		ResponseBody:    string(storedReq.ResponseBody),
-		DurationMs:      storedReq.DurationMs,
+		Method:                  storedReq.Method,
+		URL:                     storedReq.URL,
+		RequestHeaders:          storedReq.RequestHeaders,
+		RequestBody:             sharedReqBody.Body,
+		RequestBodyEncoding:     sharedReqBody.Encoding,
+		RequestBodyContentType:  sharedReqBody.ContentType,
+		RequestBodySize:         sharedReqBody.Size,
+		StatusCode:              storedReq.StatusCode,
+		ResponseHeaders:         storedReq.ResponseHeaders,
+		ResponseBody:            sharedRespBody.Body,
+		ResponseBodyEncoding:    sharedRespBody.Encoding,
+		ResponseBodyContentType: sharedRespBody.ContentType,
+		ResponseBodySize:        sharedRespBody.Size,
+		DurationMs:              storedReq.DurationMs,
 	}
 
 	plaintext, err := json.Marshal(shareable)
@@ -3,10 +3,15 @@ package dashboard
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,6 +21,7 @@ import (
 )
 
 type mockRequestRepo struct {
+	mu       sync.Mutex
 	requests map[string]*storage.Request
 }
 
@@ -24,11 +30,15 @@ func newMockRepo() *mockRequestRepo {
 }
 
 func (m *mockRequestRepo) Save(req *storage.Request) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.requests[req.ID] = req
 	return nil
 }
 
 func (m *mockRequestRepo) Get(id string) (*storage.Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.requests[id], nil
 }
 
@@ -47,6 +57,66 @@ func (m *mockRequestRepo) ListAll(limit int) ([]*storage.Request, error) {
 	return result, nil
 }
 
+func (m *mockRequestRepo) ListReplays(id string) ([]*storage.Request, error) {
+	var result []*storage.Request
+	for _, r := range m.requests {
+		for parent := r.ReplayOf; parent != ""; parent = m.requests[parent].ReplayOf {
+			if parent == id {
+				// like the SQLite repo, listing leaves bodies to LoadBodies
+				summary := *r
+				summary.RequestBody, summary.ResponseBody = nil, nil
+				result = append(result, &summary)
+				break
+			}
+			if m.requests[parent] == nil {
+				break
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Timestamp < result[j].Timestamp })
+	return result, nil
+}
+
+func (m *mockRequestRepo) Find(filter storage.RequestFilter) ([]*storage.Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []*storage.Request
+	for _, r := range m.requests {
+		if (filter.TunnelID != "" && r.TunnelID != filter.TunnelID) ||
+			(filter.Method != "" && !strings.EqualFold(r.Method, filter.Method)) ||
+			!strings.HasPrefix(r.URL, filter.URLPrefix) ||
+			(!filter.IncludeReplays && r.ReplayOf != "") {
+			continue
+		}
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Timestamp < result[j].Timestamp })
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	return result, nil
+}
+
+func (m *mockRequestRepo) LoadBodies(req *storage.Request) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored := m.requests[req.ID]; stored != nil {
+		req.RequestBody, req.ResponseBody = stored.RequestBody, stored.ResponseBody
+	}
+	return nil
+}
+
+func (m *mockRequestRepo) SetPinned(id string, pinned bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	req := m.requests[id]
+	if req == nil {
+		return sql.ErrNoRows
+	}
+	req.Pinned = pinned
+	return nil
+}
+
 func (m *mockRequestRepo) Delete(id string) error {
 	delete(m.requests, id)
 	return nil
@@ -103,6 +173,56 @@ func TestReplay_Success(t *testing.T) {
 	assert.Equal(t, 200, resp.StatusCode)
 	assert.Equal(t, "ok", resp.Headers["X-Response"])
 	assert.Equal(t, `{"status":"received"}`, resp.Body)
+	assert.Equal(t, storage.BodyEncodingUTF8, resp.BodyEncoding)
+	assert.Equal(t, len(`{"status":"received"}`), resp.BodySize)
+}
+
+func TestReplay_BinaryResponse(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0x00, 0xff}
+	localServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}))
+	defer localServer.Close()
+
+	repo := newMockRepo()
+	repo.requests["req-001"] = &storage.Request{ID: "req-001", Method: "GET", URL: "/logo.png", Timestamp: time.Now().UnixMilli()}
+
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: repo, LocalAddr: localServer.URL[7:]})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/replay/req-001", nil))
+	require.Equal(t, 200, rec.Code, rec.Body.String())
+
+	var resp ReplayResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, storage.BodyEncodingBase64, resp.BodyEncoding)
+	assert.Equal(t, "image/png", resp.BodyContentType)
+	assert.Equal(t, len(png), resp.BodySize)
+	assert.False(t, resp.BodyTruncated)
+	decoded, err := base64.StdEncoding.DecodeString(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, png, decoded)
+}
+
+func TestReplay_TruncatedBody(t *testing.T) {
+	repo := newMockRepo()
+	repo.requests["req-001"] = &storage.Request{
+		ID:              "req-001",
+		Method:          "POST",
+		URL:             "/upload",
+		RequestBody:     []byte("part"),
+		RequestBodySize: 100,
+	}
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: repo, LocalAddr: "127.0.0.1:1"})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/replay/req-001", nil))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "truncated")
 }
 
 func TestReplay_RequestNotFound(t *testing.T) {
@@ -207,6 +327,122 @@ func TestReplay_SavesNewRequest(t *testing.T) {
 	assert.Equal(t, "/items", newReq.URL)
 	assert.Equal(t, 201, newReq.StatusCode)
 	assert.Equal(t, "tunnel-abc", newReq.TunnelID)
+	assert.Equal(t, "req-003", newReq.ReplayOf)
+}
+
+func TestReplay_WithOverrides(t *testing.T) {
+	localServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "PUT", r.Method)
+		assert.Equal(t, "/items/2?debug=1", r.URL.RequestURI())
+		assert.Equal(t, "yes", r.Header.Get("X-Debug"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Empty(t, r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, `{"qty":2}`, string(body))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer localServer.Close()
+
+	repo := newMockRepo()
+	repo.requests["req-004"] = &storage.Request{
+		ID:             "req-004",
+		Method:         "POST",
+		URL:            "/items",
+		RequestHeaders: map[string]string{"Content-Type": "application/json", "Authorization": "Bearer old", "Content-Length": "9"},
+		RequestBody:    []byte(`{"qty":1}`),
+		TunnelID:       "tunnel-abc",
+		Timestamp:      time.Now().UnixMilli(),
+	}
+
+	srv, err := NewServer(ServerConfig{
+		Addr:      ":0",
+		Repo:      repo,
+		LocalAddr: localServer.URL[7:],
+	})
+	require.NoError(t, err)
+
+	overrides := `{"method":"put","url":"/items/2?debug=1","set_headers":{"x-debug":"yes"},"remove_headers":["authorization"],"body":"{\"qty\":2}"}`
+	req := httptest.NewRequest("POST", "/api/replay/req-004", bytes.NewBufferString(overrides))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, req)
+	require.Equal(t, 200, rec.Code, rec.Body.String())
+
+	var resp ReplayResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "req-004", resp.ReplayOf)
+
+	saved := repo.requests[resp.ID]
+	require.NotNil(t, saved)
+	assert.Equal(t, "req-004", saved.ReplayOf)
+	assert.Equal(t, "PUT", saved.Method)
+	assert.Equal(t, "/items/2?debug=1", saved.URL)
+	assert.Equal(t, map[string]string{"Content-Type": "application/json", "X-Debug": "yes"}, saved.RequestHeaders)
+	assert.Equal(t, `{"qty":2}`, string(saved.RequestBody))
+
+	original := repo.requests["req-004"]
+	assert.Equal(t, "Bearer old", original.RequestHeaders["Authorization"], "the original is left as captured")
+}
+
+func TestReplay_InvalidOverrides(t *testing.T) {
+	repo := newMockRepo()
+	repo.requests["req-005"] = &storage.Request{ID: "req-005", Method: "GET", URL: "/", RequestHeaders: map[string]string{}}
+
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: repo})
+	require.NoError(t, err)
+
+	for _, body := range []string{`{"url":"http://evil.example/"}`, `{"method":"GET /x"}`, `not json`} {
+		req := httptest.NewRequest("POST", "/api/replay/req-005", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.testHandler().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+	assert.Len(t, repo.requests, 1)
+}
+
+func TestReplay_RejectsCrossSite(t *testing.T) {
+	repo := newMockRepo()
+	repo.requests["req-006"] = &storage.Request{ID: "req-006", Method: "GET", URL: "/", RequestHeaders: map[string]string{}}
+
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: repo})
+	require.NoError(t, err)
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+		body    string
+		want    int
+	}{
+		{"form post", map[string]string{"Content-Type": "text/plain"}, `{"url":"/admin"}`, http.StatusUnsupportedMediaType},
+		{"no content type", nil, `{"url":"/admin"}`, http.StatusUnsupportedMediaType},
+		{"cross-site fetch", map[string]string{"Content-Type": "application/json", "Sec-Fetch-Site": "cross-site"}, `{}`, http.StatusForbidden},
+		{"foreign origin", map[string]string{"Content-Type": "application/json", "Origin": "https://evil.example"}, `{}`, http.StatusForbidden},
+		{"null origin", map[string]string{"Origin": "null"}, ``, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/replay/req-006", strings.NewReader(tc.body))
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			srv.testHandler().ServeHTTP(rec, req)
+			assert.Equal(t, tc.want, rec.Code, rec.Body.String())
+		})
+	}
+	assert.Len(t, repo.requests, 1, "nothing was replayed")
+
+	// the dashboard's own page passes
+	req := httptest.NewRequest("POST", "/api/replay/req-006", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	req.Header.Set("Origin", "http://"+req.Host)
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, req)
+	assert.NotEqual(t, http.StatusForbidden, rec.Code)
+	assert.NotEqual(t, http.StatusUnsupportedMediaType, rec.Code)
 }
 
 func TestAPIRequests_ReturnsJSON(t *testing.T) {
@@ -314,6 +550,108 @@ func TestAPIRequests_LimitParam(t *testing.T) {
 	assert.Len(t, resp.Requests, 2)
 }
 
+func TestAPIRequests_BodyEncodings(t *testing.T) {
+	repo := newMockRepo()
+	repo.requests["bin"] = &storage.Request{
+		ID:              "bin",
+		Method:          "POST",
+		URL:             "/upload",
+		RequestHeaders:  map[string]string{"Content-Type": "application/octet-stream"},
+		RequestBody:     []byte{0x00, 0xfe, 0xff, 0x10},
+		StatusCode:      200,
+		ResponseHeaders: map[string]string{"Content-Type": "text/plain"},
+		ResponseBody:    []byte("stored 4 bytes"),
+		Timestamp:       time.Now().UnixMilli(),
+	}
+
+	srv, err := NewServer(ServerConfig{
+		Addr: ":0",
+		Repo: repo,
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/requests?max_body=6", nil))
+	require.Equal(t, 200, rec.Code)
+
+	var resp APIRequestsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Requests, 1)
+	r := resp.Requests[0]
+
+	assert.Equal(t, "base64", r.RequestBodyEncoding)
+	assert.Equal(t, "AP7/EA==", r.RequestBody)
+	assert.Equal(t, "application/octet-stream", r.RequestBodyContentType)
+	assert.Equal(t, 4, r.RequestBodySize)
+	assert.False(t, r.RequestBodyTruncated)
+
+	assert.Equal(t, "utf8", r.ResponseBodyEncoding)
+	assert.Equal(t, "stored", r.ResponseBody)
+	assert.Equal(t, 14, r.ResponseBodySize)
+	assert.True(t, r.ResponseBodyTruncated)
+}
+
+func TestAPIRequest_Detail(t *testing.T) {
+	repo := newMockRepo()
+	repo.requests["req-1"] = &storage.Request{
+		ID:              "req-1",
+		Method:          "POST",
+		URL:             "/upload",
+		RequestHeaders:  map[string]string{"Content-Type": "text/plain"},
+		RequestBody:     []byte("first 5"),
+		RequestBodySize: 1000,
+		Timestamp:       time.Now().UnixMilli(),
+	}
+
+	srv, err := NewServer(ServerConfig{
+		Addr: ":0",
+		Repo: repo,
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/requests/req-1", nil))
+	require.Equal(t, 200, rec.Code)
+
+	var r APIRequest
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &r))
+	assert.Equal(t, "first 5", r.RequestBody)
+	assert.Equal(t, 1000, r.RequestBodySize)
+	assert.True(t, r.RequestBodyTruncated, "stored body was cut short")
+
+	rec = httptest.NewRecorder()
+	srv.testHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/requests/nope", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAPIRequest_Pin(t *testing.T) {
+	repo := newMockRepo()
+	repo.requests["req-1"] = &storage.Request{ID: "req-1", Method: "GET", URL: "/", Timestamp: time.Now().UnixMilli()}
+
+	srv, err := NewServer(ServerConfig{
+		Addr: ":0",
+		Repo: repo,
+	})
+	require.NoError(t, err)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.testHandler().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	rec := do("POST", "/api/requests/req-1/pin")
+	require.Equal(t, 200, rec.Code)
+	assert.True(t, repo.requests["req-1"].Pinned)
+	assert.Contains(t, do("GET", "/").Body.String(), "Unpin")
+
+	require.Equal(t, 200, do("DELETE", "/api/requests/req-1/pin").Code)
+	assert.False(t, repo.requests["req-1"].Pinned)
+
+	assert.Equal(t, http.StatusNotFound, do("POST", "/api/requests/nope/pin").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, do("GET", "/api/requests/req-1/pin").Code)
+}
+
 func TestDashboardReadySignal(t *testing.T) {
 	repo := newMockRepo()
 
@@ -574,3 +912,33 @@ func TestScrubRulesNotConfigured(t *testing.T) {
 	json.Unmarshal(rec.Body.Bytes(), &resp)
 	assert.Contains(t, resp["error"], "not configured")
 }
+
+func TestDelayedRequestMarked(t *testing.T) {
+	repo := newMockRepo()
+	now := time.Now()
+	repo.requests["req-late"] = &storage.Request{
+		ID:             "req-late",
+		Method:         "POST",
+		URL:            "/webhook",
+		RequestHeaders: map[string]string{},
+		StatusCode:     200,
+		Timestamp:      now.UnixMilli(),
+		ReceivedAt:     now.Add(-2 * time.Hour).UnixMilli(),
+	}
+
+	srv, err := NewServer(ServerConfig{Addr: ":0", Repo: repo})
+	require.NoError(t, err)
+	handler := srv.testHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/requests", nil))
+	var resp APIRequestsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Requests, 1)
+	assert.True(t, resp.Requests[0].Delayed)
+	assert.Equal(t, now.Add(-2*time.Hour).UnixMilli(), resp.Requests[0].ReceivedAt)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Contains(t, rec.Body.String(), "delayed · received 2h ago")
+}
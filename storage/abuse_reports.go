@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const abuseReportsSchema = `
+CREATE TABLE IF NOT EXISTS abuse_reports (
+    id          TEXT PRIMARY KEY,
+    subdomain   TEXT NOT NULL,
+    url         TEXT NOT NULL,
+    reason      TEXT NOT NULL,
+    remote_addr TEXT,
+    user_agent  TEXT,
+    created_at  INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_abuse_reports_subdomain ON abuse_reports(subdomain);
+`
+
+// AbuseReport is a visitor's report that a tunnel hosts abusive content.
+type AbuseReport struct {
+	ID         string
+	Subdomain  string
+	URL        string
+	Reason     string
+	RemoteAddr string
+	UserAgent  string
+	CreatedAt  int64
+}
+
+type AbuseReportRepo interface {
+	Save(report *AbuseReport) error
+	List(subdomain string, limit int) ([]*AbuseReport, error)
+}
+
+type SQLiteAbuseReportRepo struct {
+	db *sql.DB
+}
+
+func InitAbuseReportsSchema(db *sql.DB) error {
+	_, err := db.Exec(abuseReportsSchema)
+	if err != nil {
+		return fmt.Errorf("init abuse_reports schema: %w", err)
+	}
+	return nil
+}
+
+func NewSQLiteAbuseReportRepo(db *sql.DB) *SQLiteAbuseReportRepo {
+	return &SQLiteAbuseReportRepo{db: db}
+}
+
+func (r *SQLiteAbuseReportRepo) Save(report *AbuseReport) error {
+	if report.ID == "" {
+		report.ID = ulid.Make().String()
+	}
+	if report.CreatedAt == 0 {
+		report.CreatedAt = time.Now().UnixMilli()
+	}
+
+	_, err := r.db.Exec(`
+		INSERT INTO abuse_reports (id, subdomain, url, reason, remote_addr, user_agent, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, report.ID, report.Subdomain, report.URL, report.Reason, report.RemoteAddr, report.UserAgent, report.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert abuse report: %w", err)
+	}
+	return nil
+}
+
+// List returns the newest reports first, for one subdomain or for all when
+// subdomain is empty.
+func (r *SQLiteAbuseReportRepo) List(subdomain string, limit int) ([]*AbuseReport, error) {
+	rows, err := r.db.Query(`
+		SELECT id, subdomain, url, reason, remote_addr, user_agent, created_at
+		FROM abuse_reports WHERE ? = '' OR subdomain = ? ORDER BY id DESC LIMIT ?
+	`, subdomain, subdomain, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query abuse reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []*AbuseReport
+	for rows.Next() {
+		report := &AbuseReport{}
+		var remoteAddr, userAgent sql.NullString
+		if err := rows.Scan(&report.ID, &report.Subdomain, &report.URL, &report.Reason, &remoteAddr, &userAgent, &report.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan abuse report: %w", err)
+		}
+		report.RemoteAddr = remoteAddr.String
+		report.UserAgent = userAgent.String
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
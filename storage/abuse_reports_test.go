@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAbuseReportRepo(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, InitAbuseReportsSchema(db))
+
+	repo := NewSQLiteAbuseReportRepo(db)
+	first := &AbuseReport{Subdomain: "phish", URL: "/login", Reason: "fake bank login", RemoteAddr: "10.0.0.1:5000", UserAgent: "Firefox"}
+	require.NoError(t, repo.Save(first))
+	require.NoError(t, repo.Save(&AbuseReport{Subdomain: "other", URL: "/", Reason: "spam"}))
+	require.NoError(t, repo.Save(&AbuseReport{Subdomain: "phish", URL: "/pay", Reason: "card form"}))
+	assert.NotEmpty(t, first.ID)
+	assert.NotZero(t, first.CreatedAt)
+
+	all, err := repo.List("", 10)
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	phish, err := repo.List("phish", 10)
+	require.NoError(t, err)
+	require.Len(t, phish, 2)
+	assert.Equal(t, "/pay", phish[0].URL)
+	assert.Equal(t, "fake bank login", phish[1].Reason)
+	assert.Equal(t, "Firefox", phish[1].UserAgent)
+
+	limited, err := repo.List("", 1)
+	require.NoError(t, err)
+	assert.Len(t, limited, 1)
+}
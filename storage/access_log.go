@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const accessLogSchema = `
+CREATE TABLE IF NOT EXISTS access_log (
+    id         TEXT PRIMARY KEY,
+    created_at INTEGER NOT NULL,
+    subdomain  TEXT NOT NULL,
+    method     TEXT NOT NULL,
+    path       TEXT NOT NULL,
+    status     INTEGER NOT NULL,
+    bytes_in   INTEGER NOT NULL DEFAULT 0,
+    bytes_out  INTEGER NOT NULL DEFAULT 0,
+    latency_ms INTEGER NOT NULL DEFAULT 0,
+    remote_ip  TEXT,
+    user_agent TEXT,
+    trace_id   TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_log_subdomain ON access_log(subdomain, created_at);
+CREATE INDEX IF NOT EXISTS idx_access_log_created_at ON access_log(created_at);
+`
+
+// AccessLogEntry is one request the server handled for a tunnel. Bodies
+// are never stored.
+type AccessLogEntry struct {
+	ID        string
+	CreatedAt int64
+	Subdomain string
+	Method    string
+	Path      string
+	Status    int
+	BytesIn   int64
+	BytesOut  int64
+	LatencyMs int64
+	RemoteIP  string
+	UserAgent string
+	TraceID   string
+}
+
+// AccessLogFilter narrows access log queries; zero fields match everything.
+// Path matches as a prefix and StatusClass as the hundreds digit, so 5
+// selects 5xx. Before is an entry ID to page back from.
+type AccessLogFilter struct {
+	Subdomain   string
+	Method      string
+	Path        string
+	Status      int
+	StatusClass int
+	RemoteIP    string
+	TraceID     string
+	Since       int64
+	Until       int64
+	Before      string
+	Limit       int
+}
+
+type AccessLogRepo interface {
+	SaveBatch(entries []*AccessLogEntry) error
+	List(filter AccessLogFilter) ([]*AccessLogEntry, error)
+	Prune(olderThan time.Time) (int64, error)
+	PruneToCount(max int) (int64, error)
+}
+
+type SQLiteAccessLogRepo struct {
+	db *sql.DB
+}
+
+func InitAccessLogSchema(db *sql.DB) error {
+	_, err := db.Exec(accessLogSchema)
+	if err != nil {
+		return fmt.Errorf("init access_log schema: %w", err)
+	}
+	return nil
+}
+
+func NewSQLiteAccessLogRepo(db *sql.DB) *SQLiteAccessLogRepo {
+	return &SQLiteAccessLogRepo{db: db}
+}
+
+// SaveBatch inserts entries in one transaction.
+func (r *SQLiteAccessLogRepo) SaveBatch(entries []*AccessLogEntry) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO access_log (id, created_at, subdomain, method, path, status,
+			bytes_in, bytes_out, latency_ms, remote_ip, user_agent, trace_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("prepare access log insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		if e.ID == "" {
+			e.ID = ulid.Make().String()
+		}
+		if e.CreatedAt == 0 {
+			e.CreatedAt = time.Now().UnixMilli()
+		}
+		_, err := stmt.Exec(e.ID, e.CreatedAt, e.Subdomain, e.Method, e.Path, e.Status,
+			e.BytesIn, e.BytesOut, e.LatencyMs, e.RemoteIP, e.UserAgent, e.TraceID)
+		if err != nil {
+			return fmt.Errorf("insert access log entry: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// List returns matching entries, newest first.
+func (r *SQLiteAccessLogRepo) List(filter AccessLogFilter) ([]*AccessLogEntry, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		conds = append(conds, cond)
+		args = append(args, arg)
+	}
+	if filter.Subdomain != "" {
+		add("subdomain = ?", filter.Subdomain)
+	}
+	if filter.Method != "" {
+		add("method = ?", strings.ToUpper(filter.Method))
+	}
+	if filter.Path != "" {
+		add("substr(path, 1, ?) = ?", len(filter.Path))
+		args = append(args, filter.Path)
+	}
+	if filter.Status != 0 {
+		add("status = ?", filter.Status)
+	}
+	if filter.StatusClass != 0 {
+		add("status / 100 = ?", filter.StatusClass)
+	}
+	if filter.RemoteIP != "" {
+		add("remote_ip = ?", filter.RemoteIP)
+	}
+	if filter.TraceID != "" {
+		add("trace_id = ?", filter.TraceID)
+	}
+	if filter.Since > 0 {
+		add("created_at >= ?", filter.Since)
+	}
+	if filter.Until > 0 {
+		add("created_at < ?", filter.Until)
+	}
+	if filter.Before != "" {
+		add("id < ?", filter.Before)
+	}
+
+	query := `SELECT id, created_at, subdomain, method, path, status, bytes_in, bytes_out,
+		latency_ms, remote_ip, user_agent, trace_id FROM access_log`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query access_log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*AccessLogEntry
+	for rows.Next() {
+		e := &AccessLogEntry{}
+		var remoteIP, userAgent, traceID sql.NullString
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.Subdomain, &e.Method, &e.Path, &e.Status,
+			&e.BytesIn, &e.BytesOut, &e.LatencyMs, &remoteIP, &userAgent, &traceID)
+		if err != nil {
+			return nil, fmt.Errorf("scan access_log: %w", err)
+		}
+		e.RemoteIP, e.UserAgent, e.TraceID = remoteIP.String, userAgent.String, traceID.String
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (r *SQLiteAccessLogRepo) Prune(olderThan time.Time) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM access_log WHERE created_at < ?", olderThan.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("prune access_log: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// PruneToCount deletes the oldest entries beyond the newest max.
+func (r *SQLiteAccessLogRepo) PruneToCount(max int) (int64, error) {
+	res, err := r.db.Exec(`
+		DELETE FROM access_log WHERE id < (
+			SELECT id FROM access_log ORDER BY id DESC LIMIT 1 OFFSET ?
+		)
+	`, max-1)
+	if err != nil {
+		return 0, fmt.Errorf("prune access_log: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLogRepoFilters(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, InitAccessLogSchema(db))
+
+	repo := NewSQLiteAccessLogRepo(db)
+	entries := []*AccessLogEntry{
+		{CreatedAt: 1000, Subdomain: "api", Method: "GET", Path: "/users/1", Status: 200, RemoteIP: "192.0.2.1", TraceID: "t1"},
+		{CreatedAt: 2000, Subdomain: "api", Method: "POST", Path: "/users", Status: 201, BytesIn: 42},
+		{CreatedAt: 3000, Subdomain: "api", Method: "GET", Path: "/health", Status: 503},
+		{CreatedAt: 4000, Subdomain: "web", Method: "GET", Path: "/users", Status: 404},
+	}
+	require.NoError(t, repo.SaveBatch(entries))
+	for _, e := range entries {
+		assert.NotEmpty(t, e.ID)
+	}
+
+	all, err := repo.List(AccessLogFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 4)
+	assert.Equal(t, "/users", all[0].Path, "newest first")
+	assert.Equal(t, "192.0.2.1", all[3].RemoteIP)
+
+	for name, tc := range map[string]struct {
+		filter AccessLogFilter
+		want   int
+	}{
+		"subdomain":    {AccessLogFilter{Subdomain: "api"}, 3},
+		"method":       {AccessLogFilter{Method: "post"}, 1},
+		"path prefix":  {AccessLogFilter{Path: "/users"}, 3},
+		"status":       {AccessLogFilter{Status: 404}, 1},
+		"status class": {AccessLogFilter{StatusClass: 2}, 2},
+		"remote ip":    {AccessLogFilter{RemoteIP: "192.0.2.1"}, 1},
+		"trace id":     {AccessLogFilter{TraceID: "t1"}, 1},
+		"time range":   {AccessLogFilter{Since: 2000, Until: 4000}, 2},
+		"before":       {AccessLogFilter{Before: all[1].ID}, 2},
+		"limit":        {AccessLogFilter{Limit: 1}, 1},
+	} {
+		got, err := repo.List(tc.filter)
+		require.NoError(t, err, name)
+		assert.Len(t, got, tc.want, name)
+	}
+}
+
+func TestAccessLogRepoPrune(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, InitAccessLogSchema(db))
+
+	repo := NewSQLiteAccessLogRepo(db)
+	now := time.Now()
+	var entries []*AccessLogEntry
+	for i := 0; i < 5; i++ {
+		entries = append(entries, &AccessLogEntry{
+			CreatedAt: now.Add(time.Duration(i-4) * time.Hour).UnixMilli(),
+			Subdomain: "api", Method: "GET", Path: fmt.Sprintf("/%d", i), Status: 200,
+		})
+		require.NoError(t, repo.SaveBatch(entries[i:]))
+	}
+
+	removed, err := repo.Prune(now.Add(-150 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+
+	removed, err = repo.PruneToCount(2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), removed)
+
+	left, err := repo.List(AccessLogFilter{})
+	require.NoError(t, err)
+	require.Len(t, left, 2)
+	assert.Equal(t, "/4", left[0].Path)
+	assert.Equal(t, "/3", left[1].Path)
+
+	removed, err = repo.PruneToCount(10)
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
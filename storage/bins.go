@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const binsSchema = `
+CREATE TABLE IF NOT EXISTS bins (
+    subdomain        TEXT PRIMARY KEY,
+    response_status  INTEGER NOT NULL DEFAULT 200,
+    response_headers TEXT NOT NULL,
+    response_body    BLOB,
+    created_at       INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS bin_requests (
+    id          TEXT PRIMARY KEY,
+    subdomain   TEXT NOT NULL,
+    method      TEXT NOT NULL,
+    url         TEXT NOT NULL,
+    headers     TEXT NOT NULL,
+    body        BLOB,
+    remote_addr TEXT,
+    received_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_bin_requests_subdomain ON bin_requests(subdomain, id);
+`
+
+// Bin is a server-hosted subdomain that captures requests and answers them
+// with a canned response. OwnsReservation is set when the bin reserved its
+// subdomain itself, so deleting the bin releases it.
+type Bin struct {
+	Subdomain       string
+	ResponseStatus  int
+	ResponseHeaders map[string]string
+	ResponseBody    []byte
+	CreatedAt       int64
+	OwnsReservation bool
+}
+
+// BinRequest is one request captured by a bin. IDs are ULIDs, so ordering
+// by ID is arrival order.
+type BinRequest struct {
+	ID         string
+	Subdomain  string
+	Method     string
+	URL        string
+	Headers    map[string]string
+	Body       []byte
+	RemoteAddr string
+	ReceivedAt int64
+}
+
+type BinRepo interface {
+	Save(bin *Bin) error
+	Get(subdomain string) (*Bin, error)
+	Delete(subdomain string) error
+	SaveRequest(req *BinRequest) error
+	ListRequests(subdomain, after string, limit int) ([]*BinRequest, error)
+	TrimRequests(subdomain string, keep int) error
+	ListCreatedBefore(before int64) ([]*Bin, error)
+}
+
+type SQLiteBinRepo struct {
+	db *sql.DB
+}
+
+func InitBinsSchema(db *sql.DB) error {
+	_, err := db.Exec(binsSchema)
+	if err != nil {
+		return fmt.Errorf("init bins schema: %w", err)
+	}
+	if err := addColumn(db, "bins", "owns_reservation", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return fmt.Errorf("migrate bins: %w", err)
+	}
+	return nil
+}
+
+func NewSQLiteBinRepo(db *sql.DB) *SQLiteBinRepo {
+	return &SQLiteBinRepo{db: db}
+}
+
+func (r *SQLiteBinRepo) Save(bin *Bin) error {
+	if bin.CreatedAt == 0 {
+		bin.CreatedAt = time.Now().UnixMilli()
+	}
+	if bin.ResponseStatus == 0 {
+		bin.ResponseStatus = 200
+	}
+
+	headers, err := json.Marshal(bin.ResponseHeaders)
+	if err != nil {
+		return fmt.Errorf("marshal bin headers: %w", err)
+	}
+
+	_, err = r.db.Exec(`
+		INSERT OR REPLACE INTO bins (subdomain, response_status, response_headers, response_body, created_at, owns_reservation)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, bin.Subdomain, bin.ResponseStatus, headers, bin.ResponseBody, bin.CreatedAt, bin.OwnsReservation)
+	if err != nil {
+		return fmt.Errorf("insert bin: %w", err)
+	}
+	return nil
+}
+
+const binColumns = "subdomain, response_status, response_headers, response_body, created_at, owns_reservation"
+
+func scanBin(row rowScanner) (*Bin, error) {
+	bin := &Bin{}
+	var headers []byte
+	if err := row.Scan(&bin.Subdomain, &bin.ResponseStatus, &headers, &bin.ResponseBody, &bin.CreatedAt, &bin.OwnsReservation); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(headers, &bin.ResponseHeaders); err != nil {
+		return nil, fmt.Errorf("unmarshal bin headers: %w", err)
+	}
+	return bin, nil
+}
+
+func (r *SQLiteBinRepo) Get(subdomain string) (*Bin, error) {
+	bin, err := scanBin(r.db.QueryRow("SELECT "+binColumns+" FROM bins WHERE subdomain = ?", subdomain))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan bin: %w", err)
+	}
+	return bin, nil
+}
+
+// ListCreatedBefore returns the bins created before the given unix ms.
+func (r *SQLiteBinRepo) ListCreatedBefore(before int64) ([]*Bin, error) {
+	rows, err := r.db.Query("SELECT "+binColumns+" FROM bins WHERE created_at < ?", before)
+	if err != nil {
+		return nil, fmt.Errorf("query bins: %w", err)
+	}
+	defer rows.Close()
+
+	var bins []*Bin
+	for rows.Next() {
+		bin, err := scanBin(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan bin: %w", err)
+		}
+		bins = append(bins, bin)
+	}
+	return bins, rows.Err()
+}
+
+// Delete removes the bin and everything it captured.
+func (r *SQLiteBinRepo) Delete(subdomain string) error {
+	if _, err := r.db.Exec("DELETE FROM bin_requests WHERE subdomain = ?", subdomain); err != nil {
+		return fmt.Errorf("delete bin requests: %w", err)
+	}
+	if _, err := r.db.Exec("DELETE FROM bins WHERE subdomain = ?", subdomain); err != nil {
+		return fmt.Errorf("delete bin: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteBinRepo) SaveRequest(req *BinRequest) error {
+	if req.ID == "" {
+		req.ID = ulid.Make().String()
+	}
+	if req.ReceivedAt == 0 {
+		req.ReceivedAt = time.Now().UnixMilli()
+	}
+
+	headers, err := json.Marshal(req.Headers)
+	if err != nil {
+		return fmt.Errorf("marshal bin request headers: %w", err)
+	}
+
+	_, err = r.db.Exec(`
+		INSERT INTO bin_requests (id, subdomain, method, url, headers, body, remote_addr, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, req.ID, req.Subdomain, req.Method, req.URL, headers, req.Body, req.RemoteAddr, req.ReceivedAt)
+	if err != nil {
+		return fmt.Errorf("insert bin request: %w", err)
+	}
+	return nil
+}
+
+// ListRequests returns up to limit captures for subdomain in arrival order,
+// starting after the capture with ID after (all captures when empty).
+func (r *SQLiteBinRepo) ListRequests(subdomain, after string, limit int) ([]*BinRequest, error) {
+	rows, err := r.db.Query(`
+		SELECT id, subdomain, method, url, headers, body, remote_addr, received_at
+		FROM bin_requests WHERE subdomain = ? AND id > ? ORDER BY id LIMIT ?
+	`, subdomain, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query bin requests: %w", err)
+	}
+	defer rows.Close()
+
+	var reqs []*BinRequest
+	for rows.Next() {
+		req := &BinRequest{}
+		var headers []byte
+		var remoteAddr sql.NullString
+		if err := rows.Scan(&req.ID, &req.Subdomain, &req.Method, &req.URL, &headers, &req.Body, &remoteAddr, &req.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("scan bin request: %w", err)
+		}
+		req.RemoteAddr = remoteAddr.String
+		if err := json.Unmarshal(headers, &req.Headers); err != nil {
+			return nil, fmt.Errorf("unmarshal bin request headers: %w", err)
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, rows.Err()
+}
+
+// TrimRequests deletes all but the newest keep captures for subdomain.
+func (r *SQLiteBinRepo) TrimRequests(subdomain string, keep int) error {
+	_, err := r.db.Exec(`
+		DELETE FROM bin_requests WHERE subdomain = ? AND id NOT IN (
+			SELECT id FROM bin_requests WHERE subdomain = ? ORDER BY id DESC LIMIT ?
+		)
+	`, subdomain, subdomain, keep)
+	if err != nil {
+		return fmt.Errorf("trim bin requests: %w", err)
+	}
+	return nil
+}
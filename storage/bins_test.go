@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinRepo(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, InitBinsSchema(db))
+
+	repo := NewSQLiteBinRepo(db)
+	require.NoError(t, repo.Save(&Bin{Subdomain: "hooks", ResponseHeaders: map[string]string{"Content-Type": "application/json"}, ResponseBody: []byte(`{"ok":true}`)}))
+
+	bin, err := repo.Get("hooks")
+	require.NoError(t, err)
+	require.NotNil(t, bin)
+	assert.Equal(t, 200, bin.ResponseStatus)
+	assert.Equal(t, "application/json", bin.ResponseHeaders["Content-Type"])
+	assert.Equal(t, `{"ok":true}`, string(bin.ResponseBody))
+
+	missing, err := repo.Get("other")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	var ids []string
+	for _, path := range []string{"/a", "/b", "/c"} {
+		req := &BinRequest{Subdomain: "hooks", Method: "POST", URL: path, Headers: map[string]string{"X-Path": path}, Body: []byte(path)}
+		require.NoError(t, repo.SaveRequest(req))
+		ids = append(ids, req.ID)
+	}
+
+	reqs, err := repo.ListRequests("hooks", "", 10)
+	require.NoError(t, err)
+	require.Len(t, reqs, 3)
+	assert.Equal(t, "/a", reqs[0].URL)
+	assert.Equal(t, "/a", reqs[0].Headers["X-Path"])
+
+	reqs, err = repo.ListRequests("hooks", ids[0], 1)
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "/b", reqs[0].URL)
+
+	require.NoError(t, repo.TrimRequests("hooks", 2))
+	reqs, err = repo.ListRequests("hooks", "", 10)
+	require.NoError(t, err)
+	require.Len(t, reqs, 2)
+	assert.Equal(t, "/b", reqs[0].URL)
+
+	require.NoError(t, repo.Delete("hooks"))
+	bin, err = repo.Get("hooks")
+	require.NoError(t, err)
+	assert.Nil(t, bin)
+	reqs, err = repo.ListRequests("hooks", "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, reqs)
+}
+
+func TestBinRepo_ListCreatedBefore(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, InitBinsSchema(db))
+
+	repo := NewSQLiteBinRepo(db)
+	require.NoError(t, repo.Save(&Bin{Subdomain: "old", CreatedAt: 1000, OwnsReservation: true}))
+	require.NoError(t, repo.Save(&Bin{Subdomain: "new", CreatedAt: 5000}))
+
+	bins, err := repo.ListCreatedBefore(2000)
+	require.NoError(t, err)
+	require.Len(t, bins, 1)
+	assert.Equal(t, "old", bins[0].Subdomain)
+	assert.True(t, bins[0].OwnsReservation)
+
+	bin, err := repo.Get("new")
+	require.NoError(t, err)
+	assert.False(t, bin.OwnsReservation)
+}
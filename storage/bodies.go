@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// DefaultMaxStoredBody is how much of each body SQLiteRequestRepo keeps
+// unless SetMaxBodySize says otherwise.
+const DefaultMaxStoredBody = 10 << 20
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+const insertBodySQL = `
+	INSERT OR IGNORE INTO bodies (hash, data, size, created_at)
+	VALUES (?, ?, ?, ?)
+`
+
+// bodyHash is the key a body is stored under.
+func bodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// putBody stores body in the bodies table with insert, prepared from
+// insertBodySQL, once however many requests share it, and returns its
+// hash. Empty bodies are not stored and have no hash.
+func putBody(insert *sql.Stmt, body []byte) (string, error) {
+	if len(body) == 0 {
+		return "", nil
+	}
+	hash := bodyHash(body)
+	_, err := insert.Exec(hash, body, len(body), time.Now().UnixMilli())
+	if err != nil {
+		return "", fmt.Errorf("insert body: %w", err)
+	}
+	return hash, nil
+}
+
+// pruneBodies deletes bodies no request refers to any more.
+func pruneBodies(db execer) error {
+	_, err := db.Exec(`
+		DELETE FROM bodies WHERE hash NOT IN (
+			SELECT request_body_hash FROM requests WHERE request_body_hash IS NOT NULL
+			UNION
+			SELECT response_body_hash FROM requests WHERE response_body_hash IS NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("prune bodies: %w", err)
+	}
+	return nil
+}
+
+// migrateInlineBodies moves bodies stored in the requests table, as they
+// were before the bodies table existed, into it.
+func migrateInlineBodies(db *sql.DB) error {
+	inline, err := hasColumn(db, "requests", "request_body")
+	if err != nil || !inline {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	insertBody, err := tx.Prepare(insertBodySQL)
+	if err != nil {
+		return fmt.Errorf("prepare body insert: %w", err)
+	}
+	defer insertBody.Close()
+
+	type inlineRow struct {
+		rowid             int64
+		reqBody, respBody []byte
+	}
+	var last int64
+	for {
+		rows, err := tx.Query(`
+			SELECT rowid, request_body, response_body FROM requests
+			WHERE rowid > ? ORDER BY rowid LIMIT 100
+		`, last)
+		if err != nil {
+			return fmt.Errorf("query inline bodies: %w", err)
+		}
+		var batch []inlineRow
+		for rows.Next() {
+			var row inlineRow
+			if err := rows.Scan(&row.rowid, &row.reqBody, &row.respBody); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan inline bodies: %w", err)
+			}
+			batch = append(batch, row)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("query inline bodies: %w", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, row := range batch {
+			reqHash, err := putBody(insertBody, row.reqBody)
+			if err != nil {
+				return err
+			}
+			respHash, err := putBody(insertBody, row.respBody)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`
+				UPDATE requests SET request_body_hash = ?, request_body_size = ?, response_body_hash = ?, response_body_size = ?
+				WHERE rowid = ?
+			`, nullString(reqHash), len(row.reqBody), nullString(respHash), len(row.respBody), row.rowid)
+			if err != nil {
+				return fmt.Errorf("update request bodies: %w", err)
+			}
+			last = row.rowid
+		}
+	}
+
+	for _, column := range []string{"request_body", "response_body"} {
+		if _, err := tx.Exec("ALTER TABLE requests DROP COLUMN " + column); err != nil {
+			return fmt.Errorf("drop column requests.%s: %w", column, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countBodies(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var n int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM bodies").Scan(&n))
+	return n
+}
+
+func TestRequestRepo_DeduplicatesBodies(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	payload := []byte(`{"event":"push"}`)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		req := &Request{
+			TunnelID:       "tunnel-123",
+			Timestamp:      time.Now().UnixMilli() + int64(i),
+			Method:         "POST",
+			URL:            "/hook",
+			RequestHeaders: map[string]string{},
+			RequestBody:    payload,
+			ResponseBody:   []byte("ok"),
+		}
+		require.NoError(t, repo.Save(req))
+		ids = append(ids, req.ID)
+	}
+
+	assert.Equal(t, 2, countBodies(t, db))
+
+	require.NoError(t, repo.Delete(ids[0]))
+	assert.Equal(t, 2, countBodies(t, db), "bodies still used by other requests are kept")
+
+	_, err = repo.Prune(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, countBodies(t, db))
+}
+
+func TestRequestRepo_ListsSummaries(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	req := &Request{
+		TunnelID:       "tunnel-123",
+		Timestamp:      time.Now().UnixMilli(),
+		Method:         "POST",
+		URL:            "/upload",
+		RequestHeaders: map[string]string{},
+		RequestBody:    []byte("hello"),
+	}
+	require.NoError(t, repo.Save(req))
+
+	requests, err := repo.ListAll(10)
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	summary := requests[0]
+	assert.Nil(t, summary.RequestBody)
+	assert.Equal(t, 5, summary.RequestBodySize)
+	assert.Equal(t, bodyHash([]byte("hello")), summary.RequestBodyHash)
+	assert.Empty(t, summary.ResponseBodyHash)
+
+	require.NoError(t, repo.LoadBodies(summary))
+	assert.Equal(t, []byte("hello"), summary.RequestBody)
+	assert.Nil(t, summary.ResponseBody)
+}
+
+func TestRequestRepo_MaxBodySize(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	repo.SetMaxBodySize(4)
+	req := &Request{
+		TunnelID:       "tunnel-123",
+		Timestamp:      time.Now().UnixMilli(),
+		Method:         "POST",
+		URL:            "/upload",
+		RequestHeaders: map[string]string{},
+		RequestBody:    []byte("0123456789"),
+	}
+	require.NoError(t, repo.Save(req))
+
+	got, err := repo.Get(req.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("0123"), got.RequestBody)
+	assert.Equal(t, 10, got.RequestBodySize)
+}
+
+func TestOpenDB_MigratesInlineBodies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.db")
+	legacy, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = legacy.Exec(`
+		CREATE TABLE requests (
+			id TEXT PRIMARY KEY, tunnel_id TEXT NOT NULL, timestamp INTEGER NOT NULL,
+			method TEXT NOT NULL, url TEXT NOT NULL, request_headers TEXT NOT NULL,
+			request_body BLOB, status_code INTEGER, response_headers TEXT,
+			response_body BLOB, duration_ms INTEGER, created_at INTEGER NOT NULL
+		);
+		INSERT INTO requests VALUES ('a', 't', 1, 'POST', '/x', '{}', 'same', 200, '{}', 'same', 5, 1);
+		INSERT INTO requests VALUES ('b', 't', 2, 'GET', '/y', '{}', NULL, 204, '{}', '', 5, 2);
+	`)
+	require.NoError(t, err)
+	require.NoError(t, legacy.Close())
+
+	db, err := OpenDB(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	inline, err := hasColumn(db, "requests", "request_body")
+	require.NoError(t, err)
+	assert.False(t, inline)
+	assert.Equal(t, 1, countBodies(t, db))
+
+	repo := NewSQLiteRequestRepo(db)
+	a, err := repo.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("same"), a.RequestBody)
+	assert.Equal(t, []byte("same"), a.ResponseBody)
+	assert.Equal(t, 4, a.ResponseBodySize)
+
+	b, err := repo.Get("b")
+	require.NoError(t, err)
+	assert.Empty(t, b.RequestBody)
+	assert.Empty(t, b.ResponseBody)
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Body encodings used where bodies are written as JSON strings.
+const (
+	BodyEncodingUTF8   = "utf8"
+	BodyEncodingBase64 = "base64"
+)
+
+// EncodedBody is a body ready to go into JSON. UTF-8 text is kept as is,
+// so text bodies read the same as before; anything else is base64. Size is
+// the length of the whole body, and Truncated is set when Body holds only
+// its start.
+type EncodedBody struct {
+	Body        string
+	Encoding    string
+	ContentType string
+	Size        int
+	Truncated   bool
+}
+
+// EncodeBody encodes body, sent with headers, keeping at most max bytes of
+// it; max <= 0 keeps all of it. Text is cut on a character boundary.
+func EncodeBody(body []byte, headers map[string]string, max int) EncodedBody {
+	e := EncodedBody{Encoding: BodyEncodingUTF8, Size: len(body)}
+	if len(body) == 0 {
+		return e
+	}
+	e.ContentType, _ = BodyContentType(body, headers)
+
+	text := utf8.Valid(body)
+	if max > 0 && len(body) > max {
+		body, e.Truncated = body[:max], true
+		if text {
+			for len(body) > 0 && !utf8.Valid(body) {
+				body = body[:len(body)-1]
+			}
+		}
+	}
+	if text {
+		e.Body = string(body)
+	} else {
+		e.Body = base64.StdEncoding.EncodeToString(body)
+		e.Encoding = BodyEncodingBase64
+	}
+	return e
+}
+
+// DecodeBodyString reverses EncodeBody. An empty encoding is UTF-8, which
+// is what bodies were before they carried one.
+func DecodeBodyString(body, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", BodyEncodingUTF8:
+		return []byte(body), nil
+	case BodyEncodingBase64:
+		out, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, fmt.Errorf("decode base64 body: %w", err)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unknown body encoding %q", encoding)
+	}
+}
+
+// BodyContentType is the media type of body: its Content-Type header, or
+// sniffed from the body when that is missing or invalid.
+func BodyContentType(body []byte, headers map[string]string) (string, map[string]string) {
+	for k, v := range headers {
+		if strings.EqualFold(k, "Content-Type") && v != "" {
+			if mediaType, params, err := mime.ParseMediaType(v); err == nil {
+				return mediaType, params
+			}
+		}
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return "application/json", nil
+	}
+	mediaType, params, _ := mime.ParseMediaType(http.DetectContentType(body))
+	return mediaType, params
+}
+
+// EncodeStoredBody is EncodeBody for a body of a stored Request, which was
+// size bytes when captured. Size and Truncated cover the whole body even
+// when the store kept only part of it, or it was not loaded.
+func EncodeStoredBody(body []byte, size int, headers map[string]string, max int) EncodedBody {
+	e := EncodeBody(body, headers, max)
+	if size > e.Size {
+		e.Size, e.Truncated = size, true
+	}
+	return e
+}
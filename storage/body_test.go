@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeBody_Text(t *testing.T) {
+	e := EncodeBody([]byte(`{"a":"ü"}`), map[string]string{"content-type": "application/json; charset=utf-8"}, 0)
+
+	assert.Equal(t, EncodedBody{
+		Body:        `{"a":"ü"}`,
+		Encoding:    BodyEncodingUTF8,
+		ContentType: "application/json",
+		Size:        10,
+	}, e)
+}
+
+func TestEncodeBody_Binary(t *testing.T) {
+	body := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0x00, 0xff}
+
+	e := EncodeBody(body, nil, 0)
+
+	assert.Equal(t, BodyEncodingBase64, e.Encoding)
+	assert.Equal(t, "image/png", e.ContentType)
+	assert.Equal(t, len(body), e.Size)
+	decoded, err := DecodeBodyString(e.Body, e.Encoding)
+	require.NoError(t, err)
+	assert.Equal(t, body, decoded)
+}
+
+func TestEncodeBody_Truncates(t *testing.T) {
+	// "ü" is two bytes; cutting at 3 would split it
+	e := EncodeBody([]byte("abüc"), map[string]string{"Content-Type": "text/plain"}, 3)
+	assert.Equal(t, "ab", e.Body)
+	assert.Equal(t, BodyEncodingUTF8, e.Encoding)
+	assert.Equal(t, 5, e.Size)
+	assert.True(t, e.Truncated)
+
+	e = EncodeBody([]byte{0x00, 0xfe, 0xff, 0x01}, nil, 2)
+	assert.Equal(t, "AP4=", e.Body)
+	assert.True(t, e.Truncated)
+
+	e = EncodeBody([]byte("short"), nil, 100)
+	assert.False(t, e.Truncated)
+}
+
+func TestEncodeBody_Empty(t *testing.T) {
+	assert.Equal(t, EncodedBody{Encoding: BodyEncodingUTF8}, EncodeBody(nil, nil, 0))
+}
+
+func TestDecodeBodyString(t *testing.T) {
+	out, err := DecodeBodyString("plain", "")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("plain"), out)
+
+	_, err = DecodeBodyString("!!", BodyEncodingBase64)
+	assert.ErrorContains(t, err, "decode base64 body")
+
+	_, err = DecodeBodyString("x", "hex")
+	assert.ErrorContains(t, err, "unknown body encoding")
+}
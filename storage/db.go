@@ -26,10 +26,12 @@ CREATE TABLE IF NOT EXISTS requests (
     method          TEXT NOT NULL,
     url             TEXT NOT NULL,
     request_headers TEXT NOT NULL,
-    request_body    BLOB,
+    request_body_hash TEXT,
+    request_body_size INTEGER NOT NULL DEFAULT 0,
     status_code     INTEGER,
     response_headers TEXT,
-    response_body   BLOB,
+    response_body_hash TEXT,
+    response_body_size INTEGER NOT NULL DEFAULT 0,
     duration_ms     INTEGER,
     created_at      INTEGER NOT NULL
 );
@@ -37,6 +39,13 @@ CREATE TABLE IF NOT EXISTS requests (
 CREATE INDEX IF NOT EXISTS idx_requests_tunnel ON requests(tunnel_id);
 CREATE INDEX IF NOT EXISTS idx_requests_timestamp ON requests(timestamp DESC);
 
+CREATE TABLE IF NOT EXISTS bodies (
+    hash       TEXT PRIMARY KEY,
+    data       BLOB NOT NULL,
+    size       INTEGER NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
 CREATE TABLE IF NOT EXISTS scrub_rules (
     id         TEXT PRIMARY KEY,
     pattern    TEXT NOT NULL UNIQUE,
@@ -46,9 +55,18 @@ CREATE TABLE IF NOT EXISTS scrub_rules (
 CREATE INDEX IF NOT EXISTS idx_scrub_rules_pattern ON scrub_rules(pattern);
 `
 
+// connPragmas are set on every connection: WAL lets readers carry on while
+// a write is in progress, and busy_timeout makes writers from other
+// connections or processes wait their turn rather than fail with
+// SQLITE_BUSY. Switching to WAL writes the file header, after which
+// auto_vacuum can no longer change without a VACUUM, so it comes first;
+// incremental vacuum only takes effect on a new database, and Vacuum
+// converts older ones.
+const connPragmas = "?_pragma=busy_timeout(5000)&_pragma=auto_vacuum(INCREMENTAL)&_pragma=journal_mode(WAL)"
+
 // OpenDB opens client database with tunnels and requests tables
 func OpenDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", path)
+	db, err := sql.Open("sqlite", path+connPragmas)
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
@@ -58,12 +76,92 @@ func OpenDB(path string) (*sql.DB, error) {
 		return nil, fmt.Errorf("init schema: %w", err)
 	}
 
+	if err := migrateClientSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return db, nil
 }
 
+// migrateClientSchema adds columns introduced after a database was created.
+func migrateClientSchema(db *sql.DB) error {
+	if err := addColumn(db, "requests", "received_at", "INTEGER"); err != nil {
+		return fmt.Errorf("migrate requests: %w", err)
+	}
+	if err := addColumn(db, "requests", "replay_of", "TEXT"); err != nil {
+		return fmt.Errorf("migrate requests: %w", err)
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_requests_replay_of ON requests(replay_of)"); err != nil {
+		return fmt.Errorf("migrate requests: %w", err)
+	}
+	if err := addColumn(db, "requests", "pinned", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("migrate requests: %w", err)
+	}
+	for _, c := range []struct{ column, decl string }{
+		{"request_body_hash", "TEXT"},
+		{"request_body_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"response_body_hash", "TEXT"},
+		{"response_body_size", "INTEGER NOT NULL DEFAULT 0"},
+	} {
+		if err := addColumn(db, "requests", c.column, c.decl); err != nil {
+			return fmt.Errorf("migrate requests: %w", err)
+		}
+	}
+	if err := migrateInlineBodies(db); err != nil {
+		return fmt.Errorf("migrate requests: %w", err)
+	}
+	for _, column := range []string{"request_body_hash", "response_body_hash"} {
+		if _, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_requests_%s ON requests(%s)", column, column)); err != nil {
+			return fmt.Errorf("migrate requests: %w", err)
+		}
+	}
+	return nil
+}
+
+// addColumn adds column to table unless it already exists.
+func addColumn(db *sql.DB, table, column, decl string) error {
+	exists, err := hasColumn(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl)); err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// hasColumn reports whether table has column.
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("table info %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			name, ctype  string
+			notNull, pk  int
+			defaultValue sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultValue, &pk); err != nil {
+			return false, fmt.Errorf("scan table info %s: %w", table, err)
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("table info %s: %w", table, err)
+	}
+	return false, nil
+}
+
 // OpenServerDB opens server database (blobs only, no tunnels/requests)
 func OpenServerDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", path)
+	db, err := sql.Open("sqlite", path+connPragmas)
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
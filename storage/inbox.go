@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const inboxSchema = `
+CREATE TABLE IF NOT EXISTS inbox (
+    id          TEXT PRIMARY KEY,
+    subdomain   TEXT NOT NULL,
+    method      TEXT NOT NULL,
+    url         TEXT NOT NULL,
+    headers     TEXT NOT NULL,
+    body        BLOB,
+    trace_id    TEXT,
+    received_at INTEGER NOT NULL,
+    expires_at  INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_inbox_subdomain ON inbox(subdomain, id);
+CREATE INDEX IF NOT EXISTS idx_inbox_expires ON inbox(expires_at);
+`
+
+// InboxItem is a request the server held because no client was connected.
+// IDs are ULIDs, so ordering by ID is arrival order.
+type InboxItem struct {
+	ID         string
+	Subdomain  string
+	Method     string
+	URL        string
+	Headers    map[string]string
+	Body       []byte
+	TraceID    string
+	ReceivedAt int64
+	ExpiresAt  int64
+}
+
+// InboxStats summarizes the unexpired items held for one subdomain.
+type InboxStats struct {
+	Subdomain string
+	Count     int
+	Bytes     int64
+}
+
+type InboxRepo interface {
+	Save(item *InboxItem) error
+	List(subdomain string) ([]*InboxItem, error)
+	Delete(id string) error
+	DeleteBySubdomain(subdomain string) error
+	Stats(subdomain string) (*InboxStats, error)
+	ListStats() ([]*InboxStats, error)
+	Prune() (int64, error)
+}
+
+type SQLiteInboxRepo struct {
+	db *sql.DB
+}
+
+func InitInboxSchema(db *sql.DB) error {
+	_, err := db.Exec(inboxSchema)
+	if err != nil {
+		return fmt.Errorf("init inbox schema: %w", err)
+	}
+	return nil
+}
+
+func NewSQLiteInboxRepo(db *sql.DB) *SQLiteInboxRepo {
+	return &SQLiteInboxRepo{db: db}
+}
+
+func (r *SQLiteInboxRepo) Save(item *InboxItem) error {
+	if item.ID == "" {
+		item.ID = ulid.Make().String()
+	}
+	if item.ReceivedAt == 0 {
+		item.ReceivedAt = time.Now().UnixMilli()
+	}
+	if item.ExpiresAt == 0 {
+		item.ExpiresAt = time.Now().Add(24 * time.Hour).UnixMilli()
+	}
+
+	headers, err := json.Marshal(item.Headers)
+	if err != nil {
+		return fmt.Errorf("marshal inbox headers: %w", err)
+	}
+
+	_, err = r.db.Exec(`
+		INSERT INTO inbox (id, subdomain, method, url, headers, body, trace_id, received_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, item.ID, item.Subdomain, item.Method, item.URL, headers, item.Body, item.TraceID, item.ReceivedAt, item.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("insert inbox item: %w", err)
+	}
+	return nil
+}
+
+// List returns the unexpired items for subdomain in arrival order.
+func (r *SQLiteInboxRepo) List(subdomain string) ([]*InboxItem, error) {
+	rows, err := r.db.Query(`
+		SELECT id, subdomain, method, url, headers, body, trace_id, received_at, expires_at
+		FROM inbox WHERE subdomain = ? AND expires_at > ? ORDER BY id
+	`, subdomain, time.Now().UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("query inbox: %w", err)
+	}
+	defer rows.Close()
+
+	var items []*InboxItem
+	for rows.Next() {
+		item := &InboxItem{}
+		var headers []byte
+		var traceID sql.NullString
+		if err := rows.Scan(&item.ID, &item.Subdomain, &item.Method, &item.URL, &headers, &item.Body, &traceID, &item.ReceivedAt, &item.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan inbox item: %w", err)
+		}
+		item.TraceID = traceID.String
+		if err := json.Unmarshal(headers, &item.Headers); err != nil {
+			return nil, fmt.Errorf("unmarshal inbox headers: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (r *SQLiteInboxRepo) Delete(id string) error {
+	_, err := r.db.Exec("DELETE FROM inbox WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("delete inbox item: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteInboxRepo) DeleteBySubdomain(subdomain string) error {
+	_, err := r.db.Exec("DELETE FROM inbox WHERE subdomain = ?", subdomain)
+	if err != nil {
+		return fmt.Errorf("delete inbox items: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteInboxRepo) Stats(subdomain string) (*InboxStats, error) {
+	stats := &InboxStats{Subdomain: subdomain}
+	err := r.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(LENGTH(body)), 0)
+		FROM inbox WHERE subdomain = ? AND expires_at > ?
+	`, subdomain, time.Now().UnixMilli()).Scan(&stats.Count, &stats.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("inbox stats: %w", err)
+	}
+	return stats, nil
+}
+
+func (r *SQLiteInboxRepo) ListStats() ([]*InboxStats, error) {
+	rows, err := r.db.Query(`
+		SELECT subdomain, COUNT(*), COALESCE(SUM(LENGTH(body)), 0)
+		FROM inbox WHERE expires_at > ? GROUP BY subdomain ORDER BY subdomain
+	`, time.Now().UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("query inbox stats: %w", err)
+	}
+	defer rows.Close()
+
+	var all []*InboxStats
+	for rows.Next() {
+		stats := &InboxStats{}
+		if err := rows.Scan(&stats.Subdomain, &stats.Count, &stats.Bytes); err != nil {
+			return nil, fmt.Errorf("scan inbox stats: %w", err)
+		}
+		all = append(all, stats)
+	}
+	return all, rows.Err()
+}
+
+func (r *SQLiteInboxRepo) Prune() (int64, error) {
+	res, err := r.db.Exec("DELETE FROM inbox WHERE expires_at <= ?", time.Now().UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("prune inbox: %w", err)
+	}
+	return res.RowsAffected()
+}
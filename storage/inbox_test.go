@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReservationRepo(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, InitReservationsSchema(db))
+
+	repo := NewSQLiteReservationRepo(db)
+	require.NoError(t, repo.Save(&Reservation{Subdomain: "hooks", TokenHash: "abc", Inbox: true}))
+
+	res, err := repo.Get("hooks")
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, "abc", res.TokenHash)
+	assert.True(t, res.Inbox)
+	assert.Equal(t, 202, res.InboxStatus)
+	assert.False(t, res.SkipWarning)
+
+	require.NoError(t, repo.Save(&Reservation{Subdomain: "trusted", TokenHash: "def", SkipWarning: true}))
+	trusted, err := repo.Get("trusted")
+	require.NoError(t, err)
+	assert.True(t, trusted.SkipWarning)
+	require.NoError(t, repo.Delete("trusted"))
+
+	missing, err := repo.Get("other")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	all, err := repo.List()
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+
+	require.NoError(t, repo.Delete("hooks"))
+	res, err = repo.Get("hooks")
+	require.NoError(t, err)
+	assert.Nil(t, res)
+}
+
+func TestInboxRepo(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, InitInboxSchema(db))
+
+	repo := NewSQLiteInboxRepo(db)
+	first := &InboxItem{Subdomain: "hooks", Method: "POST", URL: "/a", Headers: map[string]string{"X-Event": "1"}, Body: []byte("one")}
+	second := &InboxItem{Subdomain: "hooks", Method: "POST", URL: "/b", Headers: map[string]string{}, Body: []byte("second")}
+	expired := &InboxItem{Subdomain: "hooks", Method: "POST", URL: "/old", Headers: map[string]string{}, ExpiresAt: time.Now().Add(-time.Minute).UnixMilli()}
+	require.NoError(t, repo.Save(first))
+	require.NoError(t, repo.Save(second))
+	require.NoError(t, repo.Save(expired))
+
+	items, err := repo.List("hooks")
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "/a", items[0].URL)
+	assert.Equal(t, "1", items[0].Headers["X-Event"])
+	assert.Equal(t, "/b", items[1].URL)
+
+	stats, err := repo.Stats("hooks")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Count)
+	assert.Equal(t, int64(len("one")+len("second")), stats.Bytes)
+
+	pruned, err := repo.Prune()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pruned)
+
+	require.NoError(t, repo.Delete(first.ID))
+	all, err := repo.ListStats()
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, 1, all[0].Count)
+
+	require.NoError(t, repo.DeleteBySubdomain("hooks"))
+	items, err = repo.List("hooks")
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestOpenDBMigratesRequestsTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+
+	// a database created before received_at existed
+	old, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = old.Exec(`CREATE TABLE requests (
+		id TEXT PRIMARY KEY, tunnel_id TEXT NOT NULL, timestamp INTEGER NOT NULL,
+		method TEXT NOT NULL, url TEXT NOT NULL, request_headers TEXT NOT NULL,
+		request_body BLOB, status_code INTEGER, response_headers TEXT,
+		response_body BLOB, duration_ms INTEGER, created_at INTEGER NOT NULL)`)
+	require.NoError(t, err)
+	_, err = old.Exec(`INSERT INTO requests VALUES ('r1', 't1', 1, 'GET', '/', '{}', NULL, 200, '{}', NULL, 5, 1)`)
+	require.NoError(t, err)
+	old.Close()
+
+	db, err := OpenDB(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	req, err := repo.Get("r1")
+	require.NoError(t, err)
+	require.NotNil(t, req)
+	assert.Zero(t, req.ReceivedAt)
+
+	require.NoError(t, repo.Save(&Request{TunnelID: "t1", Timestamp: 2, Method: "POST", URL: "/late", RequestHeaders: map[string]string{}, ReceivedAt: 1}))
+	all, err := repo.ListAll(10)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, int64(1), all[0].ReceivedAt)
+
+	// reopening does not try to add the column again
+	require.NoError(t, migrateClientSchema(db))
+}
+
+func TestInitReservationsSchemaMigrates(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// a table created before skip_warning existed
+	_, err = db.Exec(`CREATE TABLE reservations (
+		subdomain TEXT PRIMARY KEY, token_hash TEXT NOT NULL, inbox INTEGER NOT NULL DEFAULT 0,
+		inbox_status INTEGER NOT NULL DEFAULT 202, created_at INTEGER NOT NULL)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO reservations VALUES ('hooks', 'abc', 1, 202, 1)`)
+	require.NoError(t, err)
+
+	require.NoError(t, InitReservationsSchema(db))
+	require.NoError(t, InitReservationsSchema(db))
+
+	res, err := NewSQLiteReservationRepo(db).Get("hooks")
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.False(t, res.SkipWarning)
+}
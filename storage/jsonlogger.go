@@ -8,27 +8,44 @@ import (
 	"github.com/auditmos/devtunnel/tunnel"
 )
 
+// JSONLogEntry is one JSONL line. Bodies are encoded with EncodeBody, so
+// binary payloads come out as base64 rather than mangled text.
 type JSONLogEntry struct {
-	Timestamp       int64             `json:"timestamp"`
-	Method          string            `json:"method"`
-	URL             string            `json:"url"`
-	RequestHeaders  map[string]string `json:"request_headers"`
-	RequestBody     string            `json:"request_body"`
-	StatusCode      int               `json:"status_code"`
-	ResponseHeaders map[string]string `json:"response_headers"`
-	ResponseBody    string            `json:"response_body"`
-	DurationMs      int64             `json:"duration_ms"`
+	Timestamp               int64             `json:"timestamp"`
+	Method                  string            `json:"method"`
+	URL                     string            `json:"url"`
+	RequestHeaders          map[string]string `json:"request_headers"`
+	RequestBody             string            `json:"request_body"`
+	RequestBodyEncoding     string            `json:"request_body_encoding"`
+	RequestBodyContentType  string            `json:"request_body_content_type,omitempty"`
+	RequestBodySize         int               `json:"request_body_size"`
+	RequestBodyTruncated    bool              `json:"request_body_truncated"`
+	StatusCode              int               `json:"status_code"`
+	ResponseHeaders         map[string]string `json:"response_headers"`
+	ResponseBody            string            `json:"response_body"`
+	ResponseBodyEncoding    string            `json:"response_body_encoding"`
+	ResponseBodyContentType string            `json:"response_body_content_type,omitempty"`
+	ResponseBodySize        int               `json:"response_body_size"`
+	ResponseBodyTruncated   bool              `json:"response_body_truncated"`
+	DurationMs              int64             `json:"duration_ms"`
 }
 
 type JSONLogger struct {
 	w        io.Writer
 	scrubber *Scrubber
+	maxBody  int
 }
 
 func NewJSONLogger(w io.Writer, scrubber *Scrubber) *JSONLogger {
 	return &JSONLogger{w: w, scrubber: scrubber}
 }
 
+// SetMaxBody keeps at most n bytes of each logged body; n <= 0 logs whole
+// bodies.
+func (l *JSONLogger) SetMaxBody(n int) {
+	l.maxBody = n
+}
+
 func (l *JSONLogger) Log(input *tunnel.RequestLog) error {
 	reqHeaders := input.RequestHeaders
 	respHeaders := input.ResponseHeaders
@@ -37,16 +54,30 @@ func (l *JSONLogger) Log(input *tunnel.RequestLog) error {
 		respHeaders = l.scrubber.ScrubHeaders(respHeaders)
 	}
 
+	reqBody := EncodeBody(input.RequestBody, input.RequestHeaders, l.maxBody)
+	respBody := EncodeBody(input.ResponseBody, input.ResponseHeaders, l.maxBody)
+	timestamp := time.Now().UnixMilli()
+	if input.CapturedAt != 0 {
+		timestamp = input.CapturedAt
+	}
 	entry := JSONLogEntry{
-		Timestamp:       time.Now().UnixMilli(),
-		Method:          input.Method,
-		URL:             input.URL,
-		RequestHeaders:  reqHeaders,
-		RequestBody:     string(input.RequestBody),
-		StatusCode:      input.StatusCode,
-		ResponseHeaders: respHeaders,
-		ResponseBody:    string(input.ResponseBody),
-		DurationMs:      input.DurationMs,
+		Timestamp:               timestamp,
+		Method:                  input.Method,
+		URL:                     input.URL,
+		RequestHeaders:          reqHeaders,
+		RequestBody:             reqBody.Body,
+		RequestBodyEncoding:     reqBody.Encoding,
+		RequestBodyContentType:  reqBody.ContentType,
+		RequestBodySize:         reqBody.Size,
+		RequestBodyTruncated:    reqBody.Truncated,
+		StatusCode:              input.StatusCode,
+		ResponseHeaders:         respHeaders,
+		ResponseBody:            respBody.Body,
+		ResponseBodyEncoding:    respBody.Encoding,
+		ResponseBodyContentType: respBody.ContentType,
+		ResponseBodySize:        respBody.Size,
+		ResponseBodyTruncated:   respBody.Truncated,
+		DurationMs:              input.DurationMs,
 	}
 
 	enc := json.NewEncoder(l.w)
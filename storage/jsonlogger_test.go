@@ -89,3 +89,28 @@ func TestMultiLogger_Log(t *testing.T) {
 	assert.NotEmpty(t, buf1.Bytes())
 	assert.NotEmpty(t, buf2.Bytes())
 }
+
+func TestJSONLogger_BinaryBody(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, nil)
+	logger.SetMaxBody(4)
+
+	err := logger.Log(&tunnel.RequestLog{
+		Method:       "PUT",
+		URL:          "/upload",
+		RequestBody:  []byte{0x00, 0xfe, 0xff, 0x10, 0x20},
+		StatusCode:   200,
+		ResponseBody: []byte("ok"),
+	})
+	require.NoError(t, err)
+
+	var entry JSONLogEntry
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, BodyEncodingBase64, entry.RequestBodyEncoding)
+	assert.Equal(t, "AP7/EA==", entry.RequestBody)
+	assert.Equal(t, 5, entry.RequestBodySize)
+	assert.True(t, entry.RequestBodyTruncated)
+	assert.Equal(t, BodyEncodingUTF8, entry.ResponseBodyEncoding)
+	assert.Equal(t, "ok", entry.ResponseBody)
+	assert.False(t, entry.ResponseBodyTruncated)
+}
@@ -1,38 +1,38 @@
 package storage
 
 import (
+	"sync/atomic"
 	"time"
 
+	"github.com/auditmos/devtunnel/logging"
 	"github.com/auditmos/devtunnel/tunnel"
 	"github.com/oklog/ulid/v2"
 )
 
-type DBLogger struct {
-	repo     *SQLiteRequestRepo
-	tunnelID string
-	scrubber *Scrubber
-}
-
-func NewDBLogger(repo *SQLiteRequestRepo, tunnelID string, scrubber *Scrubber) *DBLogger {
-	return &DBLogger{repo: repo, tunnelID: tunnelID, scrubber: scrubber}
-}
-
-func (l *DBLogger) SetTunnelID(id string) {
-	l.tunnelID = id
-}
+const (
+	requestLogQueueSize     = 1024
+	requestLogBatchSize     = 100
+	requestLogFlushInterval = 250 * time.Millisecond
+)
 
-func (l *DBLogger) Log(input *tunnel.RequestLog) error {
+// newRequest turns a proxied exchange seen at the given time into a
+// Request, scrubbing its headers when scrubber is set. Requests pulled
+// from a bin are dated when the bin captured them.
+func newRequest(input *tunnel.RequestLog, tunnelID string, scrubber *Scrubber, at time.Time) *Request {
+	if input.CapturedAt != 0 {
+		at = time.UnixMilli(input.CapturedAt)
+	}
 	reqHeaders := input.RequestHeaders
 	respHeaders := input.ResponseHeaders
-	if l.scrubber != nil {
-		reqHeaders = l.scrubber.ScrubHeaders(reqHeaders)
-		respHeaders = l.scrubber.ScrubHeaders(respHeaders)
+	if scrubber != nil {
+		reqHeaders = scrubber.ScrubHeaders(reqHeaders)
+		respHeaders = scrubber.ScrubHeaders(respHeaders)
 	}
 
-	req := &Request{
+	return &Request{
 		ID:              ulid.Make().String(),
-		TunnelID:        l.tunnelID,
-		Timestamp:       time.Now().UnixMilli(),
+		TunnelID:        tunnelID,
+		Timestamp:       at.UnixMilli(),
 		Method:          input.Method,
 		URL:             input.URL,
 		RequestHeaders:  reqHeaders,
@@ -42,6 +42,146 @@ func (l *DBLogger) Log(input *tunnel.RequestLog) error {
 		ResponseBody:    input.ResponseBody,
 		DurationMs:      input.DurationMs,
 		CreatedAt:       time.Now().UnixMilli(),
+		ReceivedAt:      input.ReceivedAt,
+	}
+}
+
+// RequestLogStats reports on an AsyncDBLogger since it started. Queued is
+// how many requests are waiting to be written right now.
+type RequestLogStats struct {
+	Queued  int   `json:"queued"`
+	Written int64 `json:"written"`
+	Dropped int64 `json:"dropped"`
+	Failed  int64 `json:"failed"`
+}
+
+// AsyncDBLogger saves requests to the request repo in batched transactions
+// off the proxy path. When the queue is full requests are dropped rather
+// than holding up the response. Bodies are cut to the repo's size cap
+// before queueing, so the queue holds at most that much per request.
+type AsyncDBLogger struct {
+	repo     *SQLiteRequestRepo
+	scrubber *Scrubber
+	logger   logging.Logger
+	tunnelID atomic.Value
+	queue    chan *Request
+	written  atomic.Int64
+	dropped  atomic.Int64
+	failed   atomic.Int64
+	reported int64
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewAsyncDBLogger starts a writer for repo; Close flushes and stops it.
+func NewAsyncDBLogger(repo *SQLiteRequestRepo, tunnelID string, scrubber *Scrubber, logger logging.Logger) *AsyncDBLogger {
+	l := &AsyncDBLogger{
+		repo:     repo,
+		scrubber: scrubber,
+		logger:   logger,
+		queue:    make(chan *Request, requestLogQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	l.tunnelID.Store(tunnelID)
+	go l.run()
+	return l
+}
+
+func (l *AsyncDBLogger) SetTunnelID(id string) {
+	l.tunnelID.Store(id)
+}
+
+// Log queues input to be saved and never blocks. Requests logged once the
+// queue is full, or after Close, count as dropped.
+func (l *AsyncDBLogger) Log(input *tunnel.RequestLog) error {
+	select {
+	case <-l.stop:
+		l.dropped.Add(1)
+		return nil
+	default:
+	}
+	req := newRequest(input, l.tunnelID.Load().(string), l.scrubber, time.Now())
+	l.repo.trimBodies(req)
+	select {
+	case l.queue <- req:
+	default:
+		l.dropped.Add(1)
+	}
+	return nil
+}
+
+// Stats reports how many requests were written, dropped and failed.
+func (l *AsyncDBLogger) Stats() RequestLogStats {
+	return RequestLogStats{
+		Queued:  len(l.queue),
+		Written: l.written.Load(),
+		Dropped: l.dropped.Load(),
+		Failed:  l.failed.Load(),
+	}
+}
+
+// Close writes out what is queued and stops the writer.
+func (l *AsyncDBLogger) Close() {
+	close(l.stop)
+	<-l.done
+}
+
+func (l *AsyncDBLogger) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(requestLogFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Request, 0, requestLogBatchSize)
+	for {
+		select {
+		case req := <-l.queue:
+			batch = append(batch, req)
+			if len(batch) >= requestLogBatchSize {
+				batch = l.write(batch)
+			}
+		case <-ticker.C:
+			batch = l.write(batch)
+		case <-l.stop:
+			// proxied requests may still be logging, so drain without
+			// closing the queue
+			for {
+				select {
+				case req := <-l.queue:
+					batch = append(batch, req)
+					if len(batch) >= requestLogBatchSize {
+						batch = l.write(batch)
+					}
+				default:
+					l.write(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *AsyncDBLogger) write(batch []*Request) []*Request {
+	if dropped := l.dropped.Load(); dropped > l.reported {
+		l.logger.WithFields(logging.Fields{"dropped": dropped - l.reported, "total": dropped}).Warn("client", "request_log", "Request log entries dropped")
+		l.reported = dropped
+	}
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := l.repo.SaveBatch(batch); err == nil {
+		l.written.Add(int64(len(batch)))
+		return batch[:0]
+	}
+	// one bad request should not cost the rest of its batch
+	for _, req := range batch {
+		if err := l.repo.Save(req); err != nil {
+			l.failed.Add(1)
+			l.logger.WithError(err).WithFields(logging.Fields{"method": req.Method, "url": req.URL}).Error("client", "request_log", "Write request log failed")
+			continue
+		}
+		l.written.Add(1)
 	}
-	return l.repo.Save(req)
+	return batch[:0]
 }
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+	"github.com/auditmos/devtunnel/tunnel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestRepo_SaveBatch(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+	repo := NewSQLiteRequestRepo(db)
+
+	batch := []*Request{
+		{TunnelID: "t1", Timestamp: 1, Method: "GET", URL: "/a", ResponseBody: []byte("same")},
+		{TunnelID: "t1", Timestamp: 2, Method: "GET", URL: "/b", ResponseBody: []byte("same")},
+	}
+	require.NoError(t, repo.SaveBatch(batch))
+	assert.NotEmpty(t, batch[0].ID)
+	assert.Equal(t, batch[0].ResponseBodyHash, batch[1].ResponseBodyHash)
+
+	// a duplicate ID fails the whole batch
+	err = repo.SaveBatch([]*Request{
+		{TunnelID: "t1", Timestamp: 3, Method: "GET", URL: "/c"},
+		{ID: batch[0].ID, TunnelID: "t1", Timestamp: 4, Method: "GET", URL: "/d"},
+	})
+	require.Error(t, err)
+
+	requests, err := repo.ListAll(10)
+	require.NoError(t, err)
+	assert.Len(t, requests, 2)
+}
+
+func TestAsyncDBLogger_WritesOnClose(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	scrubRuleRepo := NewSQLiteScrubRuleRepo(db)
+	require.NoError(t, scrubRuleRepo.Seed())
+	scrubber, err := NewScrubberWithRepo(scrubRuleRepo)
+	require.NoError(t, err)
+
+	logger := NewAsyncDBLogger(repo, "tunnel-123", scrubber, logging.NopLogger{})
+	for i := range requestLogBatchSize + 5 {
+		require.NoError(t, logger.Log(&tunnel.RequestLog{
+			Method:         "POST",
+			URL:            fmt.Sprintf("/hook/%d", i),
+			RequestHeaders: map[string]string{"Authorization": "Bearer secret-token"},
+			RequestBody:    []byte(`{"event":"test"}`),
+			StatusCode:     200,
+		}))
+	}
+	logger.Close()
+
+	stats := logger.Stats()
+	assert.Equal(t, int64(requestLogBatchSize+5), stats.Written)
+	assert.Zero(t, stats.Dropped)
+	assert.Zero(t, stats.Failed)
+	assert.Zero(t, stats.Queued)
+
+	requests, err := repo.ListAll(1000)
+	require.NoError(t, err)
+	require.Len(t, requests, requestLogBatchSize+5)
+	assert.Equal(t, "tunnel-123", requests[0].TunnelID)
+	assert.Equal(t, "***", requests[0].RequestHeaders["Authorization"])
+}
+
+func TestAsyncDBLogger_FlushesWhileRunning(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	logger := NewAsyncDBLogger(repo, "tunnel-123", nil, logging.NopLogger{})
+	defer logger.Close()
+
+	require.NoError(t, logger.Log(&tunnel.RequestLog{Method: "GET", URL: "/", StatusCode: 200}))
+	assert.Eventually(t, func() bool {
+		return logger.Stats().Written == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestAsyncDBLogger_CapsBodiesBeforeQueueing(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	repo.SetMaxBodySize(4)
+
+	req := &Request{RequestBody: []byte("0123456789"), ResponseBody: []byte("ok")}
+	repo.trimBodies(req)
+	assert.Equal(t, "0123", string(req.RequestBody))
+	assert.Less(t, cap(req.RequestBody), 10, "the rest of the body is not kept")
+	assert.Equal(t, 10, req.RequestBodySize)
+	assert.Equal(t, "ok", string(req.ResponseBody))
+
+	logger := NewAsyncDBLogger(repo, "tunnel-123", nil, logging.NopLogger{})
+	require.NoError(t, logger.Log(&tunnel.RequestLog{Method: "GET", URL: "/download", StatusCode: 200, ResponseBody: []byte("0123456789")}))
+	logger.Close()
+
+	requests, err := repo.ListAll(10)
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	require.NoError(t, repo.LoadBodies(requests[0]))
+	assert.Equal(t, "0123", string(requests[0].ResponseBody))
+	assert.Equal(t, 10, requests[0].ResponseBodySize)
+}
+
+func TestAsyncDBLogger_DatesBinCapturesWhenCaptured(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	logger := NewAsyncDBLogger(repo, "tunnel-123", nil, logging.NopLogger{})
+	capturedAt := time.Now().Add(-time.Hour).UnixMilli()
+	require.NoError(t, logger.Log(&tunnel.RequestLog{Method: "POST", URL: "/hook", StatusCode: 200, CapturedAt: capturedAt}))
+	logger.Close()
+
+	requests, err := repo.ListAll(10)
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	assert.Equal(t, capturedAt, requests[0].Timestamp)
+	assert.Zero(t, requests[0].ReceivedAt, "not shown as a delayed delivery")
+}
+
+func TestAsyncDBLogger_DropsAfterClose(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	logger := NewAsyncDBLogger(NewSQLiteRequestRepo(db), "tunnel-123", nil, logging.NopLogger{})
+	logger.Close()
+
+	require.NoError(t, logger.Log(&tunnel.RequestLog{Method: "GET", URL: "/"}))
+	assert.Equal(t, int64(1), logger.Stats().Dropped)
+	assert.Zero(t, logger.Stats().Written)
+}
+
+func TestOpenDB_SharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.db")
+	db1, err := OpenDB(path)
+	require.NoError(t, err)
+	defer db1.Close()
+	db2, err := OpenDB(path)
+	require.NoError(t, err)
+	defer db2.Close()
+
+	var mode string
+	require.NoError(t, db1.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	assert.Equal(t, "wal", mode)
+
+	// two clients writing to one database wait on each other rather than
+	// failing with SQLITE_BUSY
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for _, repo := range []*SQLiteRequestRepo{NewSQLiteRequestRepo(db1), NewSQLiteRequestRepo(db2)} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				errs <- repo.Save(&Request{TunnelID: "t", Timestamp: int64(i), Method: "GET", URL: "/"})
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	stats, err := NewSQLiteRequestRepo(db1).Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), stats.Requests)
+}
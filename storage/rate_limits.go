@@ -61,3 +61,190 @@ func (r *SQLiteRateLimitRepo) Get() (*RateLimits, error) {
 	}
 	return limits, nil
 }
+
+const ratePlansSchema = `
+CREATE TABLE IF NOT EXISTS rate_plans (
+    name             TEXT PRIMARY KEY,
+    requests_per_min INTEGER NOT NULL,
+    burst            INTEGER NOT NULL,
+    max_conns        INTEGER NOT NULL,
+    daily_requests   INTEGER NOT NULL DEFAULT 0,
+    monthly_requests INTEGER NOT NULL DEFAULT 0,
+    daily_bytes      INTEGER NOT NULL DEFAULT 0,
+    monthly_bytes    INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS tenant_plans (
+    tenant TEXT PRIMARY KEY,
+    plan   TEXT NOT NULL
+);
+`
+
+// RatePlan is a named set of limits. Burst is how many requests may be
+// made at once. The quotas cap traffic per UTC day and calendar month;
+// zero, like a MaxConns of zero, is unlimited.
+type RatePlan struct {
+	Name            string
+	RequestsPerMin  int
+	Burst           int
+	MaxConns        int
+	DailyRequests   int64
+	MonthlyRequests int64
+	DailyBytes      int64
+	MonthlyBytes    int64
+}
+
+// TenantPlan puts one tenant on a plan other than the default.
+type TenantPlan struct {
+	Tenant string
+	Plan   string
+}
+
+type RatePlanRepo interface {
+	ListPlans() ([]*RatePlan, error)
+	SavePlan(plan *RatePlan) error
+	DeletePlan(name string) error
+	ListTenantPlans() ([]*TenantPlan, error)
+	SetTenantPlan(tenant, plan string) error
+	DeleteTenantPlan(tenant string) error
+}
+
+type SQLiteRatePlanRepo struct {
+	db *sql.DB
+}
+
+func InitRatePlansSchema(db *sql.DB) error {
+	_, err := db.Exec(ratePlansSchema)
+	if err != nil {
+		return fmt.Errorf("init rate_plans schema: %w", err)
+	}
+	for _, column := range []string{"daily_requests", "monthly_requests", "daily_bytes", "monthly_bytes"} {
+		if err := addColumn(db, "rate_plans", column, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return fmt.Errorf("migrate rate_plans: %w", err)
+		}
+	}
+	return nil
+}
+
+// SeedRatePlans creates the default plan from the rate_limits row, with a
+// burst of a full minute's requests, and a per-IP plan for visitors.
+func SeedRatePlans(db *sql.DB) error {
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO rate_plans (name, requests_per_min, burst, max_conns)
+		SELECT 'default', requests_per_min, requests_per_min, max_concurrent_conns
+		FROM rate_limits WHERE id = 1
+	`)
+	if err != nil {
+		return fmt.Errorf("seed default rate plan: %w", err)
+	}
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO rate_plans (name, requests_per_min, burst, max_conns)
+		VALUES ('per-ip', 600, 600, 0)
+	`)
+	if err != nil {
+		return fmt.Errorf("seed per-ip rate plan: %w", err)
+	}
+	return nil
+}
+
+func NewSQLiteRatePlanRepo(db *sql.DB) *SQLiteRatePlanRepo {
+	return &SQLiteRatePlanRepo{db: db}
+}
+
+func (r *SQLiteRatePlanRepo) ListPlans() ([]*RatePlan, error) {
+	rows, err := r.db.Query(`
+		SELECT name, requests_per_min, burst, max_conns,
+			daily_requests, monthly_requests, daily_bytes, monthly_bytes
+		FROM rate_plans ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query rate_plans: %w", err)
+	}
+	defer rows.Close()
+
+	var plans []*RatePlan
+	for rows.Next() {
+		plan := &RatePlan{}
+		err := rows.Scan(&plan.Name, &plan.RequestsPerMin, &plan.Burst, &plan.MaxConns,
+			&plan.DailyRequests, &plan.MonthlyRequests, &plan.DailyBytes, &plan.MonthlyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("scan rate_plans: %w", err)
+		}
+		plans = append(plans, plan)
+	}
+	return plans, rows.Err()
+}
+
+func (r *SQLiteRatePlanRepo) SavePlan(plan *RatePlan) error {
+	_, err := r.db.Exec(`
+		INSERT INTO rate_plans (name, requests_per_min, burst, max_conns,
+			daily_requests, monthly_requests, daily_bytes, monthly_bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			requests_per_min = excluded.requests_per_min,
+			burst = excluded.burst,
+			max_conns = excluded.max_conns,
+			daily_requests = excluded.daily_requests,
+			monthly_requests = excluded.monthly_requests,
+			daily_bytes = excluded.daily_bytes,
+			monthly_bytes = excluded.monthly_bytes
+	`, plan.Name, plan.RequestsPerMin, plan.Burst, plan.MaxConns,
+		plan.DailyRequests, plan.MonthlyRequests, plan.DailyBytes, plan.MonthlyBytes)
+	if err != nil {
+		return fmt.Errorf("save rate plan: %w", err)
+	}
+	return nil
+}
+
+// DeletePlan removes a plan and moves its tenants back to the default.
+func (r *SQLiteRatePlanRepo) DeletePlan(name string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM tenant_plans WHERE plan = ?", name); err != nil {
+		return fmt.Errorf("delete tenant plans: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM rate_plans WHERE name = ?", name); err != nil {
+		return fmt.Errorf("delete rate plan: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (r *SQLiteRatePlanRepo) ListTenantPlans() ([]*TenantPlan, error) {
+	rows, err := r.db.Query("SELECT tenant, plan FROM tenant_plans ORDER BY tenant")
+	if err != nil {
+		return nil, fmt.Errorf("query tenant_plans: %w", err)
+	}
+	defer rows.Close()
+
+	var overrides []*TenantPlan
+	for rows.Next() {
+		tp := &TenantPlan{}
+		if err := rows.Scan(&tp.Tenant, &tp.Plan); err != nil {
+			return nil, fmt.Errorf("scan tenant_plans: %w", err)
+		}
+		overrides = append(overrides, tp)
+	}
+	return overrides, rows.Err()
+}
+
+func (r *SQLiteRatePlanRepo) SetTenantPlan(tenant, plan string) error {
+	_, err := r.db.Exec(`
+		INSERT INTO tenant_plans (tenant, plan) VALUES (?, ?)
+		ON CONFLICT(tenant) DO UPDATE SET plan = excluded.plan
+	`, tenant, plan)
+	if err != nil {
+		return fmt.Errorf("set tenant plan: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteRatePlanRepo) DeleteTenantPlan(tenant string) error {
+	if _, err := r.db.Exec("DELETE FROM tenant_plans WHERE tenant = ?", tenant); err != nil {
+		return fmt.Errorf("delete tenant plan: %w", err)
+	}
+	return nil
+}
@@ -71,3 +71,57 @@ func TestRateLimitRepoGetNotSeeded(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not seeded")
 }
+
+func TestSeedRatePlansFromRateLimits(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, InitRateLimitsSchema(db))
+	require.NoError(t, InitRatePlansSchema(db))
+	_, err = db.Exec("INSERT INTO rate_limits (id, requests_per_min, max_concurrent_conns) VALUES (1, 120, 3)")
+	require.NoError(t, err)
+
+	require.NoError(t, SeedRatePlans(db))
+	require.NoError(t, SeedRatePlans(db))
+
+	repo := NewSQLiteRatePlanRepo(db)
+	plans, err := repo.ListPlans()
+	require.NoError(t, err)
+	require.Len(t, plans, 2)
+	assert.Equal(t, &RatePlan{Name: "default", RequestsPerMin: 120, Burst: 120, MaxConns: 3}, plans[0])
+	assert.Equal(t, "per-ip", plans[1].Name)
+}
+
+func TestRatePlanRepo(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, InitRatePlansSchema(db))
+
+	repo := NewSQLiteRatePlanRepo(db)
+	require.NoError(t, repo.SavePlan(&RatePlan{Name: "pro", RequestsPerMin: 600, Burst: 100, MaxConns: 20}))
+	require.NoError(t, repo.SavePlan(&RatePlan{Name: "pro", RequestsPerMin: 1200, Burst: 200, MaxConns: 20}))
+	require.NoError(t, repo.SetTenantPlan("acme", "pro"))
+	require.NoError(t, repo.SetTenantPlan("ip:192.0.2.1", "pro"))
+
+	plans, err := repo.ListPlans()
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	assert.Equal(t, 1200, plans[0].RequestsPerMin)
+
+	overrides, err := repo.ListTenantPlans()
+	require.NoError(t, err)
+	assert.Len(t, overrides, 2)
+
+	require.NoError(t, repo.DeleteTenantPlan("acme"))
+	overrides, err = repo.ListTenantPlans()
+	require.NoError(t, err)
+	require.Len(t, overrides, 1)
+	assert.Equal(t, "ip:192.0.2.1", overrides[0].Tenant)
+
+	require.NoError(t, repo.DeletePlan("pro"))
+	overrides, err = repo.ListTenantPlans()
+	require.NoError(t, err)
+	assert.Empty(t, overrides, "deleting a plan moves its tenants back to the default")
+}
@@ -1,28 +1,63 @@
 package storage
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/oklog/ulid/v2"
 	_ "modernc.org/sqlite"
 )
 
+// Request is a captured exchange. Bodies live in the bodies table, keyed
+// by the SHA-256 of their contents, so the List and Find methods return
+// them empty; Get and LoadBodies fill them in. The sizes are the length of
+// each body as captured, which is more than len(body) when the stored copy
+// was cut to the repo's size cap.
 type Request struct {
-	ID              string
-	TunnelID        string
-	Timestamp       int64
-	Method          string
-	URL             string
-	RequestHeaders  map[string]string
-	RequestBody     []byte
-	StatusCode      int
-	ResponseHeaders map[string]string
-	ResponseBody    []byte
-	DurationMs      int64
-	CreatedAt       int64
+	ID               string
+	TunnelID         string
+	Timestamp        int64
+	Method           string
+	URL              string
+	RequestHeaders   map[string]string
+	RequestBody      []byte
+	RequestBodyHash  string
+	RequestBodySize  int
+	StatusCode       int
+	ResponseHeaders  map[string]string
+	ResponseBody     []byte
+	ResponseBodyHash string
+	ResponseBodySize int
+	DurationMs       int64
+	CreatedAt        int64
+	// ReceivedAt is when the server first received a request it held in
+	// the offline inbox; zero for requests delivered live.
+	ReceivedAt int64
+	// ReplayOf is the ID of the request this one replayed, possibly
+	// edited; empty for captured traffic.
+	ReplayOf string
+	// Pinned requests are never removed by Prune or the retention
+	// policies.
+	Pinned bool
+}
+
+const requestColumns = `id, tunnel_id, timestamp, method, url, request_headers, request_body_hash, request_body_size, status_code, response_headers, response_body_hash, response_body_size, duration_ms, created_at, received_at, replay_of, pinned`
+
+// RequestFilter narrows Find; zero fields match everything. URLPrefix
+// matches the start of the path and query, and Until is exclusive. Replays
+// are left out unless IncludeReplays is set.
+type RequestFilter struct {
+	TunnelID       string
+	Method         string
+	URLPrefix      string
+	Since          int64
+	Until          int64
+	IncludeReplays bool
+	Limit          int
 }
 
 type RequestRepo interface {
@@ -30,19 +65,89 @@ type RequestRepo interface {
 	Get(id string) (*Request, error)
 	List(tunnelID string, limit int) ([]*Request, error)
 	ListAll(limit int) ([]*Request, error)
+	ListReplays(id string) ([]*Request, error)
+	Find(filter RequestFilter) ([]*Request, error)
+	LoadBodies(req *Request) error
+	SetPinned(id string, pinned bool) error
 	Delete(id string) error
 	Prune(olderThan time.Time) (int64, error)
 }
 
 type SQLiteRequestRepo struct {
-	db *sql.DB
+	db      *sql.DB
+	maxBody int
 }
 
 func NewSQLiteRequestRepo(db *sql.DB) *SQLiteRequestRepo {
-	return &SQLiteRequestRepo{db: db}
+	return &SQLiteRequestRepo{db: db, maxBody: DefaultMaxStoredBody}
+}
+
+// SetMaxBodySize caps how many bytes of each body Save keeps; n <= 0 keeps
+// whole bodies.
+func (r *SQLiteRequestRepo) SetMaxBodySize(n int) {
+	r.maxBody = n
+}
+
+// capBody is the part of body Save stores.
+func (r *SQLiteRequestRepo) capBody(body []byte) []byte {
+	if r.maxBody > 0 && len(body) > r.maxBody {
+		return body[:r.maxBody]
+	}
+	return body
+}
+
+// trimBodies records req's full body sizes and swaps its bodies for copies
+// of the part Save stores, so a request waiting to be saved does not hold
+// on to the rest.
+func (r *SQLiteRequestRepo) trimBodies(req *Request) {
+	req.RequestBodySize = max(req.RequestBodySize, len(req.RequestBody))
+	req.ResponseBodySize = max(req.ResponseBodySize, len(req.ResponseBody))
+	if capped := r.capBody(req.RequestBody); len(capped) < len(req.RequestBody) {
+		req.RequestBody = bytes.Clone(capped)
+	}
+	if capped := r.capBody(req.ResponseBody); len(capped) < len(req.ResponseBody) {
+		req.ResponseBody = bytes.Clone(capped)
+	}
 }
 
 func (r *SQLiteRequestRepo) Save(req *Request) error {
+	return r.SaveBatch([]*Request{req})
+}
+
+// SaveBatch saves requests in one transaction; if any fails, none are
+// saved.
+func (r *SQLiteRequestRepo) SaveBatch(requests []*Request) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	insertBody, err := tx.Prepare(insertBodySQL)
+	if err != nil {
+		return fmt.Errorf("prepare body insert: %w", err)
+	}
+	defer insertBody.Close()
+	insertRequest, err := tx.Prepare(`
+		INSERT INTO requests (` + requestColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("prepare request insert: %w", err)
+	}
+	defer insertRequest.Close()
+
+	for _, req := range requests {
+		if err := r.insert(insertBody, insertRequest, req); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// insert saves req with the statements SaveBatch prepared, filling in its
+// ID, CreatedAt, body sizes and hashes.
+func (r *SQLiteRequestRepo) insert(insertBody, insertRequest *sql.Stmt, req *Request) error {
 	if req.ID == "" {
 		req.ID = ulid.Make().String()
 	}
@@ -60,10 +165,26 @@ func (r *SQLiteRequestRepo) Save(req *Request) error {
 		return fmt.Errorf("marshal response headers: %w", err)
 	}
 
-	_, err = r.db.Exec(`
-		INSERT INTO requests (id, tunnel_id, timestamp, method, url, request_headers, request_body, status_code, response_headers, response_body, duration_ms, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, req.ID, req.TunnelID, req.Timestamp, req.Method, req.URL, reqHeaders, req.RequestBody, req.StatusCode, respHeaders, req.ResponseBody, req.DurationMs, req.CreatedAt)
+	var receivedAt sql.NullInt64
+	if req.ReceivedAt != 0 {
+		receivedAt = sql.NullInt64{Int64: req.ReceivedAt, Valid: true}
+	}
+	var replayOf sql.NullString
+	if req.ReplayOf != "" {
+		replayOf = sql.NullString{String: req.ReplayOf, Valid: true}
+	}
+
+	req.RequestBodySize = max(req.RequestBodySize, len(req.RequestBody))
+	req.ResponseBodySize = max(req.ResponseBodySize, len(req.ResponseBody))
+
+	if req.RequestBodyHash, err = putBody(insertBody, r.capBody(req.RequestBody)); err != nil {
+		return err
+	}
+	if req.ResponseBodyHash, err = putBody(insertBody, r.capBody(req.ResponseBody)); err != nil {
+		return err
+	}
+
+	_, err = insertRequest.Exec(req.ID, req.TunnelID, req.Timestamp, req.Method, req.URL, reqHeaders, nullString(req.RequestBodyHash), req.RequestBodySize, req.StatusCode, respHeaders, nullString(req.ResponseBodyHash), req.ResponseBodySize, req.DurationMs, req.CreatedAt, receivedAt, replayOf, req.Pinned)
 	if err != nil {
 		return fmt.Errorf("insert request: %w", err)
 	}
@@ -72,35 +193,47 @@ func (r *SQLiteRequestRepo) Save(req *Request) error {
 
 func (r *SQLiteRequestRepo) Get(id string) (*Request, error) {
 	row := r.db.QueryRow(`
-		SELECT id, tunnel_id, timestamp, method, url, request_headers, request_body, status_code, response_headers, response_body, duration_ms, created_at
+		SELECT `+requestColumns+`
 		FROM requests WHERE id = ?
 	`, id)
 
-	req := &Request{}
-	var reqHeaders, respHeaders []byte
-	err := row.Scan(&req.ID, &req.TunnelID, &req.Timestamp, &req.Method, &req.URL, &reqHeaders, &req.RequestBody, &req.StatusCode, &respHeaders, &req.ResponseBody, &req.DurationMs, &req.CreatedAt)
+	req, err := scanRequest(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("scan request: %w", err)
+		return nil, err
 	}
-
-	if err := json.Unmarshal(reqHeaders, &req.RequestHeaders); err != nil {
-		return nil, fmt.Errorf("unmarshal request headers: %w", err)
+	if err := r.LoadBodies(req); err != nil {
+		return nil, err
 	}
-	if len(respHeaders) > 0 {
-		if err := json.Unmarshal(respHeaders, &req.ResponseHeaders); err != nil {
-			return nil, fmt.Errorf("unmarshal response headers: %w", err)
+	return req, nil
+}
+
+// LoadBodies fills in the bodies of a request returned by List, ListAll,
+// ListReplays or Find.
+func (r *SQLiteRequestRepo) LoadBodies(req *Request) error {
+	for _, b := range []struct {
+		hash string
+		body *[]byte
+	}{
+		{req.RequestBodyHash, &req.RequestBody},
+		{req.ResponseBodyHash, &req.ResponseBody},
+	} {
+		if b.hash == "" || *b.body != nil {
+			continue
+		}
+		err := r.db.QueryRow("SELECT data FROM bodies WHERE hash = ?", b.hash).Scan(b.body)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("load body: %w", err)
 		}
 	}
-
-	return req, nil
+	return nil
 }
 
 func (r *SQLiteRequestRepo) List(tunnelID string, limit int) ([]*Request, error) {
 	rows, err := r.db.Query(`
-		SELECT id, tunnel_id, timestamp, method, url, request_headers, request_body, status_code, response_headers, response_body, duration_ms, created_at
+		SELECT `+requestColumns+`
 		FROM requests WHERE tunnel_id = ? ORDER BY timestamp DESC LIMIT ?
 	`, tunnelID, limit)
 	if err != nil {
@@ -113,7 +246,7 @@ func (r *SQLiteRequestRepo) List(tunnelID string, limit int) ([]*Request, error)
 
 func (r *SQLiteRequestRepo) ListAll(limit int) ([]*Request, error) {
 	rows, err := r.db.Query(`
-		SELECT id, tunnel_id, timestamp, method, url, request_headers, request_body, status_code, response_headers, response_body, duration_ms, created_at
+		SELECT `+requestColumns+`
 		FROM requests ORDER BY timestamp DESC LIMIT ?
 	`, limit)
 	if err != nil {
@@ -124,42 +257,175 @@ func (r *SQLiteRequestRepo) ListAll(limit int) ([]*Request, error) {
 	return r.scanRows(rows)
 }
 
+// ListReplays returns every request replayed from id, directly or from one
+// of its replays, oldest first.
+func (r *SQLiteRequestRepo) ListReplays(id string) ([]*Request, error) {
+	rows, err := r.db.Query(`
+		WITH RECURSIVE lineage(id) AS (
+			SELECT id FROM requests WHERE replay_of = ?
+			UNION
+			SELECT r.id FROM requests r JOIN lineage l ON r.replay_of = l.id
+		)
+		SELECT `+requestColumns+`
+		FROM requests WHERE id IN (SELECT id FROM lineage) ORDER BY timestamp, id
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query replays: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanRows(rows)
+}
+
+// Find returns matching requests oldest first, the order they arrived in.
+func (r *SQLiteRequestRepo) Find(filter RequestFilter) ([]*Request, error) {
+	var conds []string
+	var args []any
+	if filter.TunnelID != "" {
+		conds = append(conds, "tunnel_id = ?")
+		args = append(args, filter.TunnelID)
+	}
+	if filter.Method != "" {
+		conds = append(conds, "method = ?")
+		args = append(args, strings.ToUpper(filter.Method))
+	}
+	if filter.URLPrefix != "" {
+		conds = append(conds, "substr(url, 1, ?) = ?")
+		args = append(args, len(filter.URLPrefix), filter.URLPrefix)
+	}
+	if filter.Since > 0 {
+		conds = append(conds, "timestamp >= ?")
+		args = append(args, filter.Since)
+	}
+	if filter.Until > 0 {
+		conds = append(conds, "timestamp < ?")
+		args = append(args, filter.Until)
+	}
+	if !filter.IncludeReplays {
+		conds = append(conds, "replay_of IS NULL")
+	}
+
+	query := `SELECT ` + requestColumns + ` FROM requests`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY timestamp, id"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query requests: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanRows(rows)
+}
+
 func (r *SQLiteRequestRepo) scanRows(rows *sql.Rows) ([]*Request, error) {
 	var requests []*Request
 	for rows.Next() {
-		req := &Request{}
-		var reqHeaders, respHeaders []byte
-		err := rows.Scan(&req.ID, &req.TunnelID, &req.Timestamp, &req.Method, &req.URL, &reqHeaders, &req.RequestBody, &req.StatusCode, &respHeaders, &req.ResponseBody, &req.DurationMs, &req.CreatedAt)
+		req, err := scanRequest(rows)
 		if err != nil {
-			return nil, fmt.Errorf("scan request: %w", err)
+			return nil, err
 		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
 
-		if err := json.Unmarshal(reqHeaders, &req.RequestHeaders); err != nil {
-			return nil, fmt.Errorf("unmarshal request headers: %w", err)
-		}
-		if len(respHeaders) > 0 {
-			if err := json.Unmarshal(respHeaders, &req.ResponseHeaders); err != nil {
-				return nil, fmt.Errorf("unmarshal response headers: %w", err)
-			}
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanRequest reads one row selected with requestColumns. sql.ErrNoRows is
+// returned unwrapped.
+func scanRequest(row rowScanner) (*Request, error) {
+	req := &Request{}
+	var reqHeaders, respHeaders []byte
+	var reqBodyHash, respBodyHash sql.NullString
+	var receivedAt sql.NullInt64
+	var replayOf sql.NullString
+	err := row.Scan(&req.ID, &req.TunnelID, &req.Timestamp, &req.Method, &req.URL, &reqHeaders, &reqBodyHash, &req.RequestBodySize, &req.StatusCode, &respHeaders, &respBodyHash, &req.ResponseBodySize, &req.DurationMs, &req.CreatedAt, &receivedAt, &replayOf, &req.Pinned)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan request: %w", err)
+	}
+	req.RequestBodyHash = reqBodyHash.String
+	req.ResponseBodyHash = respBodyHash.String
+	req.ReceivedAt = receivedAt.Int64
+	req.ReplayOf = replayOf.String
+
+	if err := json.Unmarshal(reqHeaders, &req.RequestHeaders); err != nil {
+		return nil, fmt.Errorf("unmarshal request headers: %w", err)
+	}
+	if len(respHeaders) > 0 {
+		if err := json.Unmarshal(respHeaders, &req.ResponseHeaders); err != nil {
+			return nil, fmt.Errorf("unmarshal response headers: %w", err)
 		}
+	}
+	return req, nil
+}
 
-		requests = append(requests, req)
+// SetPinned pins or unpins a request. It returns sql.ErrNoRows when there
+// is no request id.
+func (r *SQLiteRequestRepo) SetPinned(id string, pinned bool) error {
+	res, err := r.db.Exec("UPDATE requests SET pinned = ? WHERE id = ?", pinned, id)
+	if err != nil {
+		return fmt.Errorf("pin request: %w", err)
 	}
-	return requests, rows.Err()
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
+// Delete removes a request, and its bodies unless another request shares
+// them.
 func (r *SQLiteRequestRepo) Delete(id string) error {
-	_, err := r.db.Exec("DELETE FROM requests WHERE id = ?", id)
+	tx, err := r.db.Begin()
 	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM requests WHERE id = ?", id); err != nil {
 		return fmt.Errorf("delete request: %w", err)
 	}
-	return nil
+	if err := pruneBodies(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
+// Prune removes unpinned requests older than olderThan, and the bodies
+// only they used.
 func (r *SQLiteRequestRepo) Prune(olderThan time.Time) (int64, error) {
-	res, err := r.db.Exec("DELETE FROM requests WHERE timestamp < ?", olderThan.UnixMilli())
+	return r.deleteWhere("pinned = 0 AND timestamp < ?", olderThan.UnixMilli())
+}
+
+// deleteWhere removes the requests matching cond, and the bodies only they
+// used.
+func (r *SQLiteRequestRepo) deleteWhere(cond string, args ...any) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM requests WHERE "+cond, args...)
 	if err != nil {
 		return 0, fmt.Errorf("prune requests: %w", err)
 	}
+	if err := pruneBodies(tx); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit prune: %w", err)
+	}
 	return res.RowsAffected()
 }
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const reservationsSchema = `
+CREATE TABLE IF NOT EXISTS reservations (
+    subdomain    TEXT PRIMARY KEY,
+    token_hash   TEXT NOT NULL,
+    inbox        INTEGER NOT NULL DEFAULT 0,
+    inbox_status INTEGER NOT NULL DEFAULT 202,
+    skip_warning INTEGER NOT NULL DEFAULT 0,
+    created_at   INTEGER NOT NULL
+);
+`
+
+type Reservation struct {
+	Subdomain   string
+	TokenHash   string
+	Inbox       bool
+	InboxStatus int
+	SkipWarning bool
+	CreatedAt   int64
+}
+
+type ReservationRepo interface {
+	Save(res *Reservation) error
+	Get(subdomain string) (*Reservation, error)
+	List() ([]*Reservation, error)
+	Delete(subdomain string) error
+}
+
+type SQLiteReservationRepo struct {
+	db *sql.DB
+}
+
+func InitReservationsSchema(db *sql.DB) error {
+	_, err := db.Exec(reservationsSchema)
+	if err != nil {
+		return fmt.Errorf("init reservations schema: %w", err)
+	}
+	if err := addColumn(db, "reservations", "skip_warning", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("migrate reservations: %w", err)
+	}
+	return nil
+}
+
+func NewSQLiteReservationRepo(db *sql.DB) *SQLiteReservationRepo {
+	return &SQLiteReservationRepo{db: db}
+}
+
+// Save inserts res, replacing any reservation for the same subdomain.
+func (r *SQLiteReservationRepo) Save(res *Reservation) error {
+	if res.CreatedAt == 0 {
+		res.CreatedAt = time.Now().UnixMilli()
+	}
+	if res.InboxStatus == 0 {
+		res.InboxStatus = 202
+	}
+
+	_, err := r.db.Exec(`
+		INSERT OR REPLACE INTO reservations (subdomain, token_hash, inbox, inbox_status, skip_warning, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, res.Subdomain, res.TokenHash, res.Inbox, res.InboxStatus, res.SkipWarning, res.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert reservation: %w", err)
+	}
+	return nil
+}
+
+func (r *SQLiteReservationRepo) Get(subdomain string) (*Reservation, error) {
+	row := r.db.QueryRow(`
+		SELECT subdomain, token_hash, inbox, inbox_status, skip_warning, created_at
+		FROM reservations WHERE subdomain = ?
+	`, subdomain)
+
+	res := &Reservation{}
+	err := row.Scan(&res.Subdomain, &res.TokenHash, &res.Inbox, &res.InboxStatus, &res.SkipWarning, &res.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan reservation: %w", err)
+	}
+	return res, nil
+}
+
+func (r *SQLiteReservationRepo) List() ([]*Reservation, error) {
+	rows, err := r.db.Query(`
+		SELECT subdomain, token_hash, inbox, inbox_status, skip_warning, created_at
+		FROM reservations ORDER BY subdomain
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query reservations: %w", err)
+	}
+	defer rows.Close()
+
+	var reservations []*Reservation
+	for rows.Next() {
+		res := &Reservation{}
+		if err := rows.Scan(&res.Subdomain, &res.TokenHash, &res.Inbox, &res.InboxStatus, &res.SkipWarning, &res.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan reservation: %w", err)
+		}
+		reservations = append(reservations, res)
+	}
+	return reservations, rows.Err()
+}
+
+func (r *SQLiteReservationRepo) Delete(subdomain string) error {
+	_, err := r.db.Exec("DELETE FROM reservations WHERE subdomain = ?", subdomain)
+	if err != nil {
+		return fmt.Errorf("delete reservation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// maxPruneBatch caps how many requests PruneToSize removes between size
+// checks; smaller databases go in steps of a twentieth of their requests.
+const maxPruneBatch = 500
+
+// RequestRetention bounds the client database by the age of requests, the
+// number kept per tunnel and the database size. Zero or negative fields
+// leave that bound off. Pinned requests are exempt from all of them.
+type RequestRetention struct {
+	MaxAge       time.Duration
+	MaxPerTunnel int
+	MaxSize      int64
+}
+
+// PruneStats counts the requests each retention policy removed.
+type PruneStats struct {
+	Expired   int64 `json:"expired"`
+	OverCount int64 `json:"over_count"`
+	OverSize  int64 `json:"over_size"`
+}
+
+// Total is the number of requests removed.
+func (s PruneStats) Total() int64 {
+	return s.Expired + s.OverCount + s.OverSize
+}
+
+// ApplyRetention prunes by age, then by count per tunnel, then by size,
+// stopping at the first error.
+func (r *SQLiteRequestRepo) ApplyRetention(policy RequestRetention, now time.Time) (PruneStats, error) {
+	var stats PruneStats
+	var err error
+	if policy.MaxAge > 0 {
+		if stats.Expired, err = r.Prune(now.Add(-policy.MaxAge)); err != nil {
+			return stats, err
+		}
+	}
+	if policy.MaxPerTunnel > 0 {
+		if stats.OverCount, err = r.PruneToCount(policy.MaxPerTunnel); err != nil {
+			return stats, err
+		}
+	}
+	if policy.MaxSize > 0 {
+		if stats.OverSize, err = r.PruneToSize(policy.MaxSize); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// PruneToCount keeps the newest max unpinned requests of each tunnel and
+// removes the rest.
+func (r *SQLiteRequestRepo) PruneToCount(max int) (int64, error) {
+	return r.deleteWhere(`id IN (
+		SELECT id FROM (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY tunnel_id ORDER BY timestamp DESC, id DESC) AS n
+			FROM requests WHERE pinned = 0
+		) WHERE n > ?
+	)`, max)
+}
+
+// PruneToSize removes the oldest unpinned requests until the pages in use
+// take no more than maxBytes. The file itself only shrinks once the freed
+// pages are vacuumed.
+func (r *SQLiteRequestRepo) PruneToSize(maxBytes int64) (int64, error) {
+	var unpinned int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM requests WHERE pinned = 0").Scan(&unpinned); err != nil {
+		return 0, fmt.Errorf("count requests: %w", err)
+	}
+	batch := min(max(unpinned/20, 1), maxPruneBatch)
+
+	var removed int64
+	for {
+		stats, err := Stats(r.db)
+		if err != nil {
+			return removed, err
+		}
+		if stats.SizeBytes-stats.FreeBytes <= maxBytes {
+			return removed, nil
+		}
+		n, err := r.deleteWhere(`id IN (
+			SELECT id FROM requests WHERE pinned = 0 ORDER BY timestamp, id LIMIT ?
+		)`, batch)
+		removed += n
+		if err != nil || n == 0 {
+			return removed, err
+		}
+	}
+}
+
+// RequestStats summarises the captured traffic in the client database.
+// BodyBytes counts each distinct body once, however many requests share
+// it.
+type RequestStats struct {
+	Requests  int64 `json:"requests"`
+	Pinned    int64 `json:"pinned"`
+	Tunnels   int64 `json:"tunnels"`
+	Bodies    int64 `json:"bodies"`
+	BodyBytes int64 `json:"body_bytes"`
+	Oldest    int64 `json:"oldest,omitempty"`
+	Newest    int64 `json:"newest,omitempty"`
+}
+
+// Stats counts the requests and bodies stored.
+func (r *SQLiteRequestRepo) Stats() (*RequestStats, error) {
+	stats := &RequestStats{}
+	err := r.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(pinned), 0), COUNT(DISTINCT tunnel_id),
+			COALESCE(MIN(timestamp), 0), COALESCE(MAX(timestamp), 0)
+		FROM requests
+	`).Scan(&stats.Requests, &stats.Pinned, &stats.Tunnels, &stats.Oldest, &stats.Newest)
+	if err != nil {
+		return nil, fmt.Errorf("request stats: %w", err)
+	}
+	err = r.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(size), 0) FROM bodies").Scan(&stats.Bodies, &stats.BodyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("body stats: %w", err)
+	}
+	return stats, nil
+}
+
+// DBStats describes a database file: its size, the part of it free pages
+// take up until they are vacuumed, and the rows in each table.
+type DBStats struct {
+	SizeBytes  int64            `json:"size_bytes"`
+	FreeBytes  int64            `json:"free_bytes"`
+	AutoVacuum string           `json:"auto_vacuum"`
+	Tables     map[string]int64 `json:"tables"`
+}
+
+// Stats reports on db, whichever schema it holds.
+func Stats(db *sql.DB) (*DBStats, error) {
+	var pageSize, pages, free, autoVacuum int64
+	for _, p := range []struct {
+		pragma string
+		dest   *int64
+	}{
+		{"page_size", &pageSize},
+		{"page_count", &pages},
+		{"freelist_count", &free},
+		{"auto_vacuum", &autoVacuum},
+	} {
+		if err := db.QueryRow("PRAGMA " + p.pragma).Scan(p.dest); err != nil {
+			return nil, fmt.Errorf("pragma %s: %w", p.pragma, err)
+		}
+	}
+	stats := &DBStats{
+		SizeBytes:  pages * pageSize,
+		FreeBytes:  free * pageSize,
+		AutoVacuum: [...]string{"none", "full", "incremental"}[autoVacuum%3],
+		Tables:     make(map[string]int64),
+	}
+
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("list tables: %w", err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	for _, name := range tables {
+		var n int64
+		if err := db.QueryRow(`SELECT COUNT(*) FROM "` + name + `"`).Scan(&n); err != nil {
+			return nil, fmt.Errorf("count %s: %w", name, err)
+		}
+		stats.Tables[name] = n
+	}
+	return stats, nil
+}
+
+// Vacuum rebuilds db, returning free pages to the filesystem. It also
+// switches databases made before incremental vacuum to it, so that
+// IncrementalVacuum works on them from then on.
+func Vacuum(db *sql.DB) error {
+	// auto_vacuum only changes with the VACUUM that follows it on the same
+	// connection
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(context.Background(), "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return fmt.Errorf("set auto_vacuum: %w", err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "VACUUM"); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	return nil
+}
+
+// IncrementalVacuum returns free pages to the filesystem without
+// rebuilding the database. It does nothing unless the database uses
+// incremental vacuum.
+func IncrementalVacuum(db *sql.DB) error {
+	if _, err := db.Exec("PRAGMA incremental_vacuum"); err != nil {
+		return fmt.Errorf("incremental vacuum: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func saveAt(t *testing.T, repo *SQLiteRequestRepo, tunnelID string, ts time.Time, body []byte) *Request {
+	t.Helper()
+	req := &Request{
+		TunnelID:       tunnelID,
+		Timestamp:      ts.UnixMilli(),
+		Method:         "POST",
+		URL:            "/hook",
+		RequestHeaders: map[string]string{},
+		RequestBody:    body,
+	}
+	require.NoError(t, repo.Save(req))
+	return req
+}
+
+func TestRequestRepo_PruneKeepsPinned(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	old := time.Now().Add(-48 * time.Hour)
+	pinned := saveAt(t, repo, "t1", old, nil)
+	unpinned := saveAt(t, repo, "t1", old, nil)
+	require.NoError(t, repo.SetPinned(pinned.ID, true))
+
+	n, err := repo.Prune(time.Now().Add(-24 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	got, err := repo.Get(pinned.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.True(t, got.Pinned)
+	got, err = repo.Get(unpinned.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestRequestRepo_SetPinnedNotFound(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.ErrorIs(t, NewSQLiteRequestRepo(db).SetPinned("nope", true), sql.ErrNoRows)
+}
+
+func TestRequestRepo_PruneToCount(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	start := time.Now().Add(-time.Hour)
+	var t1 []*Request
+	for i := 0; i < 4; i++ {
+		t1 = append(t1, saveAt(t, repo, "t1", start.Add(time.Duration(i)*time.Minute), nil))
+	}
+	saveAt(t, repo, "t2", start, nil)
+	require.NoError(t, repo.SetPinned(t1[0].ID, true))
+
+	n, err := repo.PruneToCount(2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	left, err := repo.List("t1", 10)
+	require.NoError(t, err)
+	var ids []string
+	for _, req := range left {
+		ids = append(ids, req.ID)
+	}
+	assert.ElementsMatch(t, []string{t1[0].ID, t1[2].ID, t1[3].ID}, ids)
+
+	left, err = repo.List("t2", 10)
+	require.NoError(t, err)
+	assert.Len(t, left, 1)
+}
+
+func TestRequestRepo_PruneToSize(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	start := time.Now().Add(-time.Hour)
+	var reqs []*Request
+	for i := 0; i < 40; i++ {
+		body := []byte(fmt.Sprintf("%d:%0*d", i, 8000, 0))
+		reqs = append(reqs, saveAt(t, repo, "t1", start.Add(time.Duration(i)*time.Second), body))
+	}
+	require.NoError(t, repo.SetPinned(reqs[0].ID, true))
+
+	before, err := Stats(db)
+	require.NoError(t, err)
+	limit := (before.SizeBytes - before.FreeBytes) / 2
+
+	n, err := repo.PruneToSize(limit)
+	require.NoError(t, err)
+	assert.Positive(t, n)
+
+	after, err := Stats(db)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, after.SizeBytes-after.FreeBytes, limit)
+
+	got, err := repo.Get(reqs[0].ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got, "pinned request survives")
+	got, err = repo.Get(reqs[39].ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got, "newest request survives")
+}
+
+func TestRequestRepo_ApplyRetention(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	now := time.Now()
+	saveAt(t, repo, "t1", now.Add(-72*time.Hour), nil)
+	for i := 0; i < 3; i++ {
+		saveAt(t, repo, "t1", now.Add(-time.Duration(i)*time.Minute), nil)
+	}
+
+	stats, err := repo.ApplyRetention(RequestRetention{MaxAge: 24 * time.Hour, MaxPerTunnel: 2, MaxSize: -1}, now)
+	require.NoError(t, err)
+	assert.Equal(t, PruneStats{Expired: 1, OverCount: 1}, stats)
+	assert.Equal(t, int64(2), stats.Total())
+}
+
+func TestStatsAndVacuum(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	req := saveAt(t, repo, "t1", time.Now(), []byte("payload"))
+	require.NoError(t, repo.SetPinned(req.ID, true))
+
+	stats, err := Stats(db)
+	require.NoError(t, err)
+	assert.Positive(t, stats.SizeBytes)
+	assert.Equal(t, int64(1), stats.Tables["requests"])
+	assert.Equal(t, int64(1), stats.Tables["bodies"])
+
+	requests, err := repo.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), requests.Requests)
+	assert.Equal(t, int64(1), requests.Pinned)
+	assert.Equal(t, int64(7), requests.BodyBytes)
+
+	require.NoError(t, Vacuum(db))
+	require.NoError(t, IncrementalVacuum(db))
+	stats, err = Stats(db)
+	require.NoError(t, err)
+	assert.Equal(t, "incremental", stats.AutoVacuum)
+}
@@ -1,9 +1,11 @@
 package storage
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/auditmos/devtunnel/logging"
 	"github.com/auditmos/devtunnel/tunnel"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -60,6 +62,78 @@ func TestRequestRepo_SaveAndGet(t *testing.T) {
 	assert.Equal(t, req.DurationMs, got.DurationMs)
 }
 
+func TestRequestRepo_ReplayOf(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	original := &Request{TunnelID: "t1", Timestamp: 1, Method: "POST", URL: "/hook", RequestHeaders: map[string]string{}}
+	require.NoError(t, repo.Save(original))
+	replay := &Request{TunnelID: "t1", Timestamp: 2, Method: "PUT", URL: "/hook", RequestHeaders: map[string]string{}, ReplayOf: original.ID}
+	require.NoError(t, repo.Save(replay))
+
+	got, err := repo.Get(replay.ID)
+	require.NoError(t, err)
+	assert.Equal(t, original.ID, got.ReplayOf)
+
+	got, err = repo.Get(original.ID)
+	require.NoError(t, err)
+	assert.Empty(t, got.ReplayOf)
+
+	// a replay of the replay belongs to the same lineage
+	again := &Request{TunnelID: "t1", Timestamp: 3, Method: "PUT", URL: "/hook", RequestHeaders: map[string]string{}, ReplayOf: replay.ID}
+	require.NoError(t, repo.Save(again))
+	require.NoError(t, repo.Save(&Request{TunnelID: "t1", Timestamp: 4, Method: "GET", URL: "/other", RequestHeaders: map[string]string{}}))
+
+	replays, err := repo.ListReplays(original.ID)
+	require.NoError(t, err)
+	require.Len(t, replays, 2)
+	assert.Equal(t, replay.ID, replays[0].ID)
+	assert.Equal(t, again.ID, replays[1].ID)
+
+	replays, err = repo.ListReplays(again.ID)
+	require.NoError(t, err)
+	assert.Empty(t, replays)
+}
+
+func TestRequestRepo_Find(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewSQLiteRequestRepo(db)
+	for i, url := range []string{"/hooks/created", "/hooks/updated", "/health", "/hooks/paid"} {
+		require.NoError(t, repo.Save(&Request{ID: fmt.Sprintf("r%d", i), TunnelID: "t1", Timestamp: int64(100 * (i + 1)), Method: "POST", URL: url, RequestHeaders: map[string]string{}}))
+	}
+	require.NoError(t, repo.Save(&Request{ID: "replay", TunnelID: "t1", Timestamp: 500, Method: "POST", URL: "/hooks/created", RequestHeaders: map[string]string{}, ReplayOf: "r0"}))
+	require.NoError(t, repo.Save(&Request{ID: "other", TunnelID: "t2", Timestamp: 600, Method: "GET", URL: "/hooks/x", RequestHeaders: map[string]string{}}))
+
+	ids := func(reqs []*Request) []string {
+		var out []string
+		for _, r := range reqs {
+			out = append(out, r.ID)
+		}
+		return out
+	}
+
+	found, err := repo.Find(RequestFilter{TunnelID: "t1", URLPrefix: "/hooks/"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r0", "r1", "r3"}, ids(found), "oldest first, replays left out")
+
+	found, err = repo.Find(RequestFilter{URLPrefix: "/hooks/", IncludeReplays: true, Since: 200, Until: 600})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r1", "r3", "replay"}, ids(found))
+
+	found, err = repo.Find(RequestFilter{Method: "get"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other"}, ids(found))
+
+	found, err = repo.Find(RequestFilter{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r0", "r1"}, ids(found))
+}
+
 func TestRequestRepo_GetNotFound(t *testing.T) {
 	db, err := OpenMemoryDB()
 	require.NoError(t, err)
@@ -236,7 +310,7 @@ func TestDBFileCreation(t *testing.T) {
 	assert.Equal(t, req.ID, got.ID)
 }
 
-func TestDBLogger_SafeModeScrubsHeaders(t *testing.T) {
+func TestAsyncDBLogger_SafeModeScrubsHeaders(t *testing.T) {
 	db, err := OpenMemoryDB()
 	require.NoError(t, err)
 	defer db.Close()
@@ -246,7 +320,7 @@ func TestDBLogger_SafeModeScrubsHeaders(t *testing.T) {
 	require.NoError(t, scrubRuleRepo.Seed())
 	scrubber, err := NewScrubberWithRepo(scrubRuleRepo)
 	require.NoError(t, err)
-	logger := NewDBLogger(repo, "tunnel-123", scrubber)
+	logger := NewAsyncDBLogger(repo, "tunnel-123", scrubber, logging.NopLogger{})
 
 	reqLog := &tunnel.RequestLog{
 		Method:          "POST",
@@ -261,6 +335,7 @@ func TestDBLogger_SafeModeScrubsHeaders(t *testing.T) {
 
 	err = logger.Log(reqLog)
 	require.NoError(t, err)
+	logger.Close()
 
 	requests, err := repo.ListAll(1)
 	require.NoError(t, err)
@@ -273,13 +348,13 @@ func TestDBLogger_SafeModeScrubsHeaders(t *testing.T) {
 	assert.Equal(t, "application/json", saved.ResponseHeaders["Content-Type"])
 }
 
-func TestDBLogger_NoSafeMode_PreservesHeaders(t *testing.T) {
+func TestAsyncDBLogger_NoSafeMode_PreservesHeaders(t *testing.T) {
 	db, err := OpenMemoryDB()
 	require.NoError(t, err)
 	defer db.Close()
 
 	repo := NewSQLiteRequestRepo(db)
-	logger := NewDBLogger(repo, "tunnel-123", nil)
+	logger := NewAsyncDBLogger(repo, "tunnel-123", nil, logging.NopLogger{})
 
 	reqLog := &tunnel.RequestLog{
 		Method:          "POST",
@@ -294,6 +369,7 @@ func TestDBLogger_NoSafeMode_PreservesHeaders(t *testing.T) {
 
 	err = logger.Log(reqLog)
 	require.NoError(t, err)
+	logger.Close()
 
 	requests, err := repo.ListAll(1)
 	require.NoError(t, err)
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const usageSchema = `
+CREATE TABLE IF NOT EXISTS usage_hourly (
+    hour      INTEGER NOT NULL,
+    tenant    TEXT NOT NULL,
+    subdomain TEXT NOT NULL,
+    requests  INTEGER NOT NULL DEFAULT 0,
+    bytes_in  INTEGER NOT NULL DEFAULT 0,
+    bytes_out INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (hour, tenant, subdomain)
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_hourly_tenant ON usage_hourly(tenant, hour);
+`
+
+// UsageRecord is the traffic one tenant sent through one subdomain during
+// the hour starting at Hour (unix milliseconds).
+type UsageRecord struct {
+	Hour      int64
+	Tenant    string
+	Subdomain string
+	Requests  int64
+	BytesIn   int64
+	BytesOut  int64
+}
+
+// UsageFilter narrows usage queries; empty fields match everything and
+// Until is exclusive.
+type UsageFilter struct {
+	Tenant    string
+	Subdomain string
+	Since     int64
+	Until     int64
+}
+
+type UsageRepo interface {
+	Add(records []*UsageRecord) error
+	List(filter UsageFilter) ([]*UsageRecord, error)
+	Totals(tenant string, since int64) (*UsageRecord, error)
+}
+
+type SQLiteUsageRepo struct {
+	db *sql.DB
+}
+
+func InitUsageSchema(db *sql.DB) error {
+	_, err := db.Exec(usageSchema)
+	if err != nil {
+		return fmt.Errorf("init usage schema: %w", err)
+	}
+	return nil
+}
+
+func NewSQLiteUsageRepo(db *sql.DB) *SQLiteUsageRepo {
+	return &SQLiteUsageRepo{db: db}
+}
+
+// Add adds each record's counts to its hourly row.
+func (r *SQLiteUsageRepo) Add(records []*UsageRecord) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, rec := range records {
+		_, err := tx.Exec(`
+			INSERT INTO usage_hourly (hour, tenant, subdomain, requests, bytes_in, bytes_out)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(hour, tenant, subdomain) DO UPDATE SET
+				requests = requests + excluded.requests,
+				bytes_in = bytes_in + excluded.bytes_in,
+				bytes_out = bytes_out + excluded.bytes_out
+		`, rec.Hour, rec.Tenant, rec.Subdomain, rec.Requests, rec.BytesIn, rec.BytesOut)
+		if err != nil {
+			return fmt.Errorf("add usage: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *SQLiteUsageRepo) List(filter UsageFilter) ([]*UsageRecord, error) {
+	where, args := usageWhere(filter)
+	rows, err := r.db.Query(`
+		SELECT hour, tenant, subdomain, requests, bytes_in, bytes_out
+		FROM usage_hourly`+where+`
+		ORDER BY hour, tenant, subdomain
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query usage: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*UsageRecord
+	for rows.Next() {
+		rec := &UsageRecord{}
+		if err := rows.Scan(&rec.Hour, &rec.Tenant, &rec.Subdomain, &rec.Requests, &rec.BytesIn, &rec.BytesOut); err != nil {
+			return nil, fmt.Errorf("scan usage: %w", err)
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// Totals sums a tenant's usage in the hours starting at or after since.
+func (r *SQLiteUsageRepo) Totals(tenant string, since int64) (*UsageRecord, error) {
+	totals := &UsageRecord{Hour: since, Tenant: tenant}
+	err := r.db.QueryRow(`
+		SELECT COALESCE(SUM(requests), 0), COALESCE(SUM(bytes_in), 0), COALESCE(SUM(bytes_out), 0)
+		FROM usage_hourly WHERE tenant = ? AND hour >= ?
+	`, tenant, since).Scan(&totals.Requests, &totals.BytesIn, &totals.BytesOut)
+	if err != nil {
+		return nil, fmt.Errorf("sum usage: %w", err)
+	}
+	return totals, nil
+}
+
+func usageWhere(filter UsageFilter) (string, []any) {
+	var conds []string
+	var args []any
+	if filter.Tenant != "" {
+		conds = append(conds, "tenant = ?")
+		args = append(args, filter.Tenant)
+	}
+	if filter.Subdomain != "" {
+		conds = append(conds, "subdomain = ?")
+		args = append(args, filter.Subdomain)
+	}
+	if filter.Since > 0 {
+		conds = append(conds, "hour >= ?")
+		args = append(args, filter.Since)
+	}
+	if filter.Until > 0 {
+		conds = append(conds, "hour < ?")
+		args = append(args, filter.Until)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageRepoAddsToHourlyRows(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, InitUsageSchema(db))
+
+	repo := NewSQLiteUsageRepo(db)
+	hour := int64(3_600_000)
+	require.NoError(t, repo.Add([]*UsageRecord{
+		{Hour: hour, Tenant: "acme", Subdomain: "api", Requests: 1, BytesIn: 10, BytesOut: 100},
+		{Hour: hour, Tenant: "ip:192.0.2.1", Subdomain: "tmp", Requests: 1},
+	}))
+	require.NoError(t, repo.Add([]*UsageRecord{
+		{Hour: hour, Tenant: "acme", Subdomain: "api", Requests: 2, BytesIn: 20, BytesOut: 200},
+		{Hour: 2 * hour, Tenant: "acme", Subdomain: "web", Requests: 1, BytesOut: 5},
+	}))
+
+	records, err := repo.List(UsageFilter{Tenant: "acme"})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, &UsageRecord{Hour: hour, Tenant: "acme", Subdomain: "api", Requests: 3, BytesIn: 30, BytesOut: 300}, records[0])
+
+	records, err = repo.List(UsageFilter{Subdomain: "web", Since: 2 * hour, Until: 3 * hour})
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	totals, err := repo.Totals("acme", hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), totals.Requests)
+	assert.Equal(t, int64(305), totals.BytesOut)
+
+	totals, err = repo.Totals("acme", 2*hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), totals.Requests)
+}
+
+func TestInitRatePlansSchemaMigratesQuotas(t *testing.T) {
+	db, err := OpenMemoryDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE rate_plans (name TEXT PRIMARY KEY, requests_per_min INTEGER NOT NULL, burst INTEGER NOT NULL, max_conns INTEGER NOT NULL)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO rate_plans VALUES ('pro', 600, 600, 10)`)
+	require.NoError(t, err)
+	require.NoError(t, InitRatePlansSchema(db))
+
+	repo := NewSQLiteRatePlanRepo(db)
+	require.NoError(t, repo.SavePlan(&RatePlan{Name: "metered", RequestsPerMin: 60, Burst: 60, MonthlyBytes: 1 << 30}))
+	plans, err := repo.ListPlans()
+	require.NoError(t, err)
+	require.Len(t, plans, 2)
+	assert.Equal(t, int64(1<<30), plans[0].MonthlyBytes)
+	assert.Zero(t, plans[1].DailyRequests)
+}
@@ -0,0 +1,457 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+const (
+	accessLogQueueSize     = 1024
+	accessLogBatchSize     = 100
+	accessLogFlushInterval = time.Second
+	accessLogPruneInterval = time.Hour
+	defaultAccessLogLimit  = 100
+	maxAccessLogLimit      = 1000
+)
+
+// AccessLogEntry is one request the server handled for a subdomain. Path
+// carries no query string and bodies are never kept, only their sizes.
+type AccessLogEntry struct {
+	ID        string `json:"id"`
+	CreatedAt int64  `json:"created_at"`
+	Subdomain string `json:"subdomain"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	BytesIn   int64  `json:"bytes_in"`
+	BytesOut  int64  `json:"bytes_out"`
+	LatencyMs int64  `json:"latency_ms"`
+	RemoteIP  string `json:"remote_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+}
+
+// AccessLogFilter narrows access log queries; zero fields match everything.
+// Path matches as a prefix, StatusClass by the hundreds digit (5 is 5xx),
+// and Before is an entry ID to page back from.
+type AccessLogFilter struct {
+	Subdomain   string
+	Method      string
+	Path        string
+	Status      int
+	StatusClass int
+	RemoteIP    string
+	TraceID     string
+	Since       int64
+	Until       int64
+	Before      string
+	Limit       int
+}
+
+// AccessLogRepo stores the access log, newest first.
+type AccessLogRepo interface {
+	SaveBatch(entries []*AccessLogEntry) error
+	List(filter AccessLogFilter) ([]*AccessLogEntry, error)
+	Prune(olderThan time.Time) (int64, error)
+	PruneToCount(max int) (int64, error)
+}
+
+// AccessLogRetention bounds the access log by age and row count. Zero
+// fields take the defaults and negative ones disable that bound.
+type AccessLogRetention struct {
+	MaxAge  time.Duration
+	MaxRows int
+}
+
+var defaultAccessLogRetention = AccessLogRetention{
+	MaxAge:  7 * 24 * time.Hour,
+	MaxRows: 1_000_000,
+}
+
+func (r AccessLogRetention) withDefaults() AccessLogRetention {
+	r.MaxAge = durationOrDefault(r.MaxAge, defaultAccessLogRetention.MaxAge)
+	switch {
+	case r.MaxRows == 0:
+		r.MaxRows = defaultAccessLogRetention.MaxRows
+	case r.MaxRows < 0:
+		r.MaxRows = 0
+	}
+	return r
+}
+
+// APIAccessLogResponse is a page of the access log. Next is the before
+// cursor for the following page, empty once a page comes back short.
+// Dropped counts entries lost since startup because writes fell behind.
+type APIAccessLogResponse struct {
+	Entries []*AccessLogEntry `json:"entries"`
+	Next    string            `json:"next,omitempty"`
+	Dropped int64             `json:"dropped"`
+}
+
+// accessLogger writes entries to the repo in batches off the request path.
+// When the queue is full entries are dropped rather than slowing proxying.
+type accessLogger struct {
+	repo      AccessLogRepo
+	retention AccessLogRetention
+	logger    logging.Logger
+	queue     chan *AccessLogEntry
+	dropped   atomic.Int64
+	reported  int64
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newAccessLogger(repo AccessLogRepo, retention AccessLogRetention, logger logging.Logger) *accessLogger {
+	return &accessLogger{
+		repo:      repo,
+		retention: retention.withDefaults(),
+		logger:    logger,
+		queue:     make(chan *AccessLogEntry, accessLogQueueSize),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (l *accessLogger) log(e *AccessLogEntry) {
+	select {
+	case l.queue <- e:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+func (l *accessLogger) run() {
+	defer close(l.done)
+
+	flushTicker := time.NewTicker(accessLogFlushInterval)
+	defer flushTicker.Stop()
+	pruneTicker := time.NewTicker(accessLogPruneInterval)
+	defer pruneTicker.Stop()
+
+	l.prune(time.Now())
+	batch := make([]*AccessLogEntry, 0, accessLogBatchSize)
+	for {
+		select {
+		case e := <-l.queue:
+			batch = append(batch, e)
+			if len(batch) >= accessLogBatchSize {
+				batch = l.write(batch)
+			}
+		case <-flushTicker.C:
+			batch = l.write(batch)
+		case <-pruneTicker.C:
+			l.prune(time.Now())
+		case <-l.stop:
+			// requests may still be queueing entries, so drain without
+			// closing the queue
+			for {
+				select {
+				case e := <-l.queue:
+					batch = append(batch, e)
+					if len(batch) >= accessLogBatchSize {
+						batch = l.write(batch)
+					}
+				default:
+					l.write(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// close writes out what is queued and stops the writer.
+func (l *accessLogger) close() {
+	close(l.stop)
+	<-l.done
+}
+
+func (l *accessLogger) write(batch []*AccessLogEntry) []*AccessLogEntry {
+	if dropped := l.dropped.Load(); dropped > l.reported {
+		l.logger.WithFields(logging.Fields{"dropped": dropped - l.reported, "total": dropped}).Warn("server", "access_log", "Access log entries dropped")
+		l.reported = dropped
+	}
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := l.repo.SaveBatch(batch); err != nil {
+		l.logger.WithError(err).WithFields(logging.Fields{"entries": len(batch)}).Error("server", "access_log", "Write access log failed")
+	}
+	return batch[:0]
+}
+
+func (l *accessLogger) prune(now time.Time) {
+	var removed int64
+	if l.retention.MaxAge > 0 {
+		n, err := l.repo.Prune(now.Add(-l.retention.MaxAge))
+		if err != nil {
+			l.logger.WithError(err).Error("server", "access_log", "Prune access log failed")
+			return
+		}
+		removed += n
+	}
+	if l.retention.MaxRows > 0 {
+		n, err := l.repo.PruneToCount(l.retention.MaxRows)
+		if err != nil {
+			l.logger.WithError(err).Error("server", "access_log", "Prune access log failed")
+			return
+		}
+		removed += n
+	}
+	if removed > 0 {
+		l.logger.WithFields(logging.Fields{"removed": removed}).Info("server", "access_log", "Access log pruned")
+	}
+}
+
+// accessRecorder remembers the status and size of a response.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// withAccessLog records requests to next in the access log. target names
+// the subdomain and path a request is for; requests without a subdomain
+// are not logged.
+func (s *Server) withAccessLog(target func(r *http.Request) (subdomain, path string), next http.HandlerFunc) http.HandlerFunc {
+	if s.accessLog == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		subdomain, path := target(r)
+		if subdomain == "" {
+			next(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w}
+		var body *countingBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &countingBody{ReadCloser: r.Body}
+			r.Body = body
+		}
+		next(rec, r)
+
+		e := &AccessLogEntry{
+			CreatedAt: start.UnixMilli(),
+			Subdomain: subdomain,
+			Method:    r.Method,
+			Path:      path,
+			Status:    rec.status,
+			BytesOut:  rec.bytes,
+			LatencyMs: time.Since(start).Milliseconds(),
+			RemoteIP:  s.clientIP(r),
+			UserAgent: r.UserAgent(),
+			TraceID:   w.Header().Get("X-Trace-ID"),
+		}
+		if e.Status == 0 {
+			e.Status = http.StatusOK
+		}
+		if body != nil {
+			e.BytesIn = body.n
+		}
+		s.accessLog.log(e)
+	}
+}
+
+func (s *Server) hostTarget(r *http.Request) (string, string) {
+	return s.extractSubdomainFromHost(r.Host), r.URL.Path
+}
+
+func proxyPathTarget(r *http.Request) (string, string) {
+	subdomain, path, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/proxy/"), "/")
+	return subdomain, "/" + path
+}
+
+func (s *Server) stopAccessLog() {
+	if s.accessLog != nil {
+		s.accessLog.close()
+	}
+}
+
+// handleAdminAccessLog queries the access log, newest first. ?subdomain=,
+// ?method=, ?status= (404 or 4xx), ?path= (a prefix), ?remote_ip= and
+// ?trace_id= narrow it; ?since= and ?until= take unix milliseconds or
+// RFC 3339. Pages hold ?limit= entries and ?before= continues from a
+// page's next cursor. ?format=jsonl exports every match as JSON lines.
+func (s *Server) handleAdminAccessLog(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.accessLog == nil {
+		writeServerJSONError(w, "access log not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	filter, msg := parseAccessLogFilter(r.URL.Query())
+	if msg != "" {
+		writeServerJSONError(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "jsonl" {
+		s.exportAccessLog(w, filter)
+		return
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultAccessLogLimit
+	}
+	filter.Limit = min(filter.Limit, maxAccessLogLimit)
+
+	entries, err := s.accessLog.repo.List(filter)
+	if err != nil {
+		s.logger.WithError(err).Error("server", "access_log", "List access log failed")
+		writeServerJSONError(w, "list access log failed", http.StatusInternalServerError)
+		return
+	}
+	resp := APIAccessLogResponse{Entries: entries, Dropped: s.accessLog.dropped.Load()}
+	if resp.Entries == nil {
+		resp.Entries = []*AccessLogEntry{}
+	}
+	if len(entries) == filter.Limit {
+		resp.Next = entries[len(entries)-1].ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// exportAccessLog streams every entry matching filter a page at a time.
+// A limit caps the export; without one it has none.
+func (s *Server) exportAccessLog(w http.ResponseWriter, filter AccessLogFilter) {
+	remaining := -1
+	if filter.Limit > 0 {
+		remaining = filter.Limit
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="access-log.jsonl"`)
+	enc := json.NewEncoder(w)
+	filter.Limit = maxAccessLogLimit
+	for remaining != 0 {
+		if remaining > 0 && remaining < filter.Limit {
+			filter.Limit = remaining
+		}
+		entries, err := s.accessLog.repo.List(filter)
+		if err != nil {
+			// the status line is gone, so all that is left is to stop
+			s.logger.WithError(err).Error("server", "access_log", "Export access log failed")
+			return
+		}
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		}
+		if len(entries) < filter.Limit {
+			return
+		}
+		if remaining > 0 {
+			remaining -= len(entries)
+		}
+		filter.Before = entries[len(entries)-1].ID
+	}
+}
+
+func parseAccessLogFilter(q map[string][]string) (AccessLogFilter, string) {
+	get := func(name string) string {
+		if v := q[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	filter := AccessLogFilter{
+		Subdomain: get("subdomain"),
+		Method:    strings.ToUpper(get("method")),
+		Path:      get("path"),
+		RemoteIP:  get("remote_ip"),
+		TraceID:   get("trace_id"),
+		Before:    get("before"),
+	}
+
+	if v := get("status"); v != "" {
+		switch {
+		case len(v) == 3 && strings.HasSuffix(strings.ToLower(v), "xx") && v[0] >= '1' && v[0] <= '5':
+			filter.StatusClass = int(v[0] - '0')
+		default:
+			status, err := strconv.Atoi(v)
+			if err != nil || status < 100 || status > 599 {
+				return filter, "invalid status"
+			}
+			filter.Status = status
+		}
+	}
+
+	for name, dst := range map[string]*int64{"since": &filter.Since, "until": &filter.Until} {
+		v := get(name)
+		if v == "" {
+			continue
+		}
+		ms, err := parseAccessLogTime(v)
+		if err != nil {
+			return filter, fmt.Sprintf("invalid %s", name)
+		}
+		*dst = ms
+	}
+
+	if v := get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return filter, "invalid limit"
+		}
+		filter.Limit = limit
+	}
+	return filter, ""
+}
+
+// parseAccessLogTime reads unix milliseconds or an RFC 3339 timestamp.
+func parseAccessLogTime(v string) (int64, error) {
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixMilli(), nil
+}
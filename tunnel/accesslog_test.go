@@ -0,0 +1,224 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memAccessLogRepo struct {
+	mu      sync.Mutex
+	entries []*AccessLogEntry
+}
+
+func (m *memAccessLogRepo) SaveBatch(entries []*AccessLogEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range entries {
+		if e.ID == "" {
+			e.ID = ulid.Make().String()
+		}
+		copied := *e
+		m.entries = append(m.entries, &copied)
+	}
+	return nil
+}
+
+func (m *memAccessLogRepo) List(filter AccessLogFilter) ([]*AccessLogEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*AccessLogEntry
+	for _, e := range m.entries {
+		switch {
+		case filter.Subdomain != "" && e.Subdomain != filter.Subdomain,
+			filter.Method != "" && e.Method != filter.Method,
+			filter.Path != "" && !strings.HasPrefix(e.Path, filter.Path),
+			filter.Status != 0 && e.Status != filter.Status,
+			filter.StatusClass != 0 && e.Status/100 != filter.StatusClass,
+			filter.TraceID != "" && e.TraceID != filter.TraceID,
+			filter.Before != "" && e.ID >= filter.Before:
+			continue
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	if filter.Limit > 0 && len(out) > filter.Limit {
+		out = out[:filter.Limit]
+	}
+	return out, nil
+}
+
+func (m *memAccessLogRepo) Prune(olderThan time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var kept []*AccessLogEntry
+	for _, e := range m.entries {
+		if e.CreatedAt >= olderThan.UnixMilli() {
+			kept = append(kept, e)
+		}
+	}
+	removed := int64(len(m.entries) - len(kept))
+	m.entries = kept
+	return removed, nil
+}
+
+func (m *memAccessLogRepo) PruneToCount(max int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.entries) <= max {
+		return 0, nil
+	}
+	removed := int64(len(m.entries) - max)
+	m.entries = m.entries[len(m.entries)-max:]
+	return removed, nil
+}
+
+func (m *memAccessLogRepo) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+func TestAccessLogRetentionWithDefaults(t *testing.T) {
+	assert.Equal(t, defaultAccessLogRetention, AccessLogRetention{}.withDefaults())
+
+	r := AccessLogRetention{MaxAge: -1, MaxRows: -1}.withDefaults()
+	assert.Zero(t, r.MaxAge)
+	assert.Zero(t, r.MaxRows)
+}
+
+func startAccessLogServer(t *testing.T, ctx context.Context) (*Server, *memAccessLogRepo) {
+	repo := &memAccessLogRepo{}
+	srv := startTestTunnel(t, ctx, ServerConfig{
+		Reservations: newMemReservationRepo(),
+		AdminToken:   "admin-secret",
+		AccessLog:    repo,
+	}, ClientConfig{Subdomain: "logged"}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		io.Copy(w, r.Body)
+	})
+	return srv, repo
+}
+
+func TestAccessLogRecordsProxiedRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, repo := startAccessLogServer(t, ctx)
+
+	req, _ := http.NewRequest("POST", "http://"+srv.Addr()+"/echo?token=secret", strings.NewReader("hello"))
+	req.Host = "logged.test.local"
+	req.Header.Set("User-Agent", "access-test")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	traceID := resp.Header.Get("X-Trace-ID")
+
+	resp, err = http.Get("http://" + srv.Addr() + "/proxy/logged/missing")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Eventually(t, func() bool { return repo.count() == 2 }, 3*time.Second, 20*time.Millisecond)
+
+	resp = adminRequest(t, srv, "GET", "/api/admin/access-log?status=2xx", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var page APIAccessLogResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Entries, 1)
+	e := page.Entries[0]
+	assert.Equal(t, "logged", e.Subdomain)
+	assert.Equal(t, "POST", e.Method)
+	assert.Equal(t, "/echo", e.Path, "query strings are not logged")
+	assert.Equal(t, http.StatusOK, e.Status)
+	assert.Equal(t, int64(5), e.BytesIn)
+	assert.Equal(t, int64(5), e.BytesOut)
+	assert.Equal(t, "127.0.0.1", e.RemoteIP)
+	assert.Equal(t, "access-test", e.UserAgent)
+	assert.Equal(t, traceID, e.TraceID)
+	assert.Empty(t, page.Next)
+
+	resp = adminRequest(t, srv, "GET", "/api/admin/access-log?status=404", "")
+	page = APIAccessLogResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "/missing", page.Entries[0].Path)
+}
+
+func TestAccessLogQueryPagesAndExports(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, repo := startAccessLogServer(t, ctx)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, repo.SaveBatch([]*AccessLogEntry{{CreatedAt: time.Now().UnixMilli(), Subdomain: "logged", Method: "GET", Path: "/", Status: 200}}))
+	}
+
+	resp := adminRequest(t, srv, "GET", "/api/admin/access-log?limit=3", "")
+	var page APIAccessLogResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Entries, 3)
+	require.NotEmpty(t, page.Next)
+
+	resp = adminRequest(t, srv, "GET", "/api/admin/access-log?limit=3&before="+page.Next, "")
+	page = APIAccessLogResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	assert.Len(t, page.Entries, 2)
+	assert.Empty(t, page.Next)
+
+	resp = adminRequest(t, srv, "GET", "/api/admin/access-log?format=jsonl", "")
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	lines := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var e AccessLogEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		lines++
+	}
+	assert.Equal(t, 5, lines)
+
+	resp = adminRequest(t, srv, "GET", "/api/admin/access-log?status=abc", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, _ := http.NewRequest("GET", "http://"+srv.Addr()+"/api/admin/access-log", nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestParseAccessLogFilter(t *testing.T) {
+	filter, msg := parseAccessLogFilter(map[string][]string{
+		"status": {"5xx"},
+		"method": {"get"},
+		"since":  {"2026-01-02T03:04:05Z"},
+		"until":  {"1767323045000"},
+	})
+	require.Empty(t, msg)
+	assert.Equal(t, 5, filter.StatusClass)
+	assert.Equal(t, "GET", filter.Method)
+	assert.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).UnixMilli(), filter.Since)
+	assert.Equal(t, int64(1767323045000), filter.Until)
+
+	for _, q := range []map[string][]string{
+		{"status": {"6xx"}},
+		{"since": {"yesterday"}},
+		{"limit": {"0"}},
+	} {
+		_, msg := parseAccessLogFilter(q)
+		assert.NotEmpty(t, msg, q)
+	}
+}
@@ -0,0 +1,319 @@
+package tunnel
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+// requireAdmin checks the bearer token on admin endpoints, writing an error
+// response when it is missing or wrong.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		writeServerJSONError(w, "admin api disabled", http.StatusNotFound)
+		return false
+	}
+	if !s.adminTokenValid(r) {
+		writeServerJSONError(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if s.reservations == nil {
+		writeServerJSONError(w, "reservations not configured", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// adminTokenValid reports whether r bears the admin token. It is always
+// false when no admin token is configured.
+func (s *Server) adminTokenValid(r *http.Request) bool {
+	if s.adminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+type CreateReservationRequest struct {
+	Subdomain   string `json:"subdomain"`
+	Inbox       bool   `json:"inbox"`
+	InboxStatus int    `json:"inbox_status,omitempty"`
+	SkipWarning bool   `json:"skip_warning"`
+}
+
+// UpdateReservationRequest changes a reservation without rotating its token.
+type UpdateReservationRequest struct {
+	SkipWarning *bool `json:"skip_warning"`
+}
+
+// APIReservation describes a reservation. Token is only set in the response
+// that created it; the server keeps a hash.
+type APIReservation struct {
+	Subdomain   string `json:"subdomain"`
+	Token       string `json:"token,omitempty"`
+	Inbox       bool   `json:"inbox"`
+	InboxStatus int    `json:"inbox_status"`
+	SkipWarning bool   `json:"skip_warning"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+type APIReservationsResponse struct {
+	Reservations []APIReservation `json:"reservations"`
+}
+
+func (s *Server) handleAdminReservations(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		reservations, err := s.reservations.List()
+		if err != nil {
+			writeServerJSONError(w, "list reservations failed", http.StatusInternalServerError)
+			return
+		}
+		resp := APIReservationsResponse{Reservations: []APIReservation{}}
+		for _, res := range reservations {
+			resp.Reservations = append(resp.Reservations, APIReservation{
+				Subdomain:   res.Subdomain,
+				Inbox:       res.Inbox,
+				InboxStatus: res.InboxStatus,
+				SkipWarning: res.SkipWarning,
+				CreatedAt:   res.CreatedAt,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case http.MethodPost:
+		var req CreateReservationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeServerJSONError(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if !isValidSubdomain(req.Subdomain) {
+			writeServerJSONError(w, "invalid subdomain", http.StatusBadRequest)
+			return
+		}
+		if req.InboxStatus == 0 {
+			req.InboxStatus = http.StatusAccepted
+		}
+		if req.InboxStatus < 200 || req.InboxStatus > 599 {
+			writeServerJSONError(w, "invalid inbox_status", http.StatusBadRequest)
+			return
+		}
+
+		token := generateReservationToken()
+		res := &Reservation{
+			Subdomain:   req.Subdomain,
+			TokenHash:   HashToken(token),
+			Inbox:       req.Inbox,
+			InboxStatus: req.InboxStatus,
+			SkipWarning: req.SkipWarning,
+			CreatedAt:   time.Now().UnixMilli(),
+		}
+		if err := s.reservations.Save(res); err != nil {
+			writeServerJSONError(w, "save reservation failed", http.StatusInternalServerError)
+			return
+		}
+
+		s.logger.WithFields(logging.Fields{"subdomain": res.Subdomain, "inbox": res.Inbox}).Info("server", "admin", "Subdomain reserved")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(APIReservation{
+			Subdomain:   res.Subdomain,
+			Token:       token,
+			Inbox:       res.Inbox,
+			InboxStatus: res.InboxStatus,
+			SkipWarning: res.SkipWarning,
+			CreatedAt:   res.CreatedAt,
+		})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleAdminReservationByName(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	subdomain := strings.TrimPrefix(r.URL.Path, "/api/admin/reservations/")
+	if r.Method == http.MethodPatch {
+		s.updateReservation(w, r, subdomain)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.reservations.Delete(subdomain); err != nil {
+		writeServerJSONError(w, "delete reservation failed", http.StatusInternalServerError)
+		return
+	}
+	if s.inbox != nil {
+		s.inbox.DeleteBySubdomain(subdomain)
+	}
+
+	s.logger.WithFields(logging.Fields{"subdomain": subdomain}).Info("server", "admin", "Reservation deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) updateReservation(w http.ResponseWriter, r *http.Request, subdomain string) {
+	var req UpdateReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeServerJSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.reservations.Get(subdomain)
+	if err != nil {
+		writeServerJSONError(w, "get reservation failed", http.StatusInternalServerError)
+		return
+	}
+	if res == nil {
+		writeServerJSONError(w, "reservation not found", http.StatusNotFound)
+		return
+	}
+	if req.SkipWarning != nil {
+		res.SkipWarning = *req.SkipWarning
+	}
+	if err := s.reservations.Save(res); err != nil {
+		writeServerJSONError(w, "save reservation failed", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.WithFields(logging.Fields{"subdomain": subdomain, "skip_warning": res.SkipWarning}).Info("server", "admin", "Reservation updated")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIReservation{
+		Subdomain:   res.Subdomain,
+		Inbox:       res.Inbox,
+		InboxStatus: res.InboxStatus,
+		SkipWarning: res.SkipWarning,
+		CreatedAt:   res.CreatedAt,
+	})
+}
+
+type APIInboxItem struct {
+	ID         string `json:"id"`
+	Subdomain  string `json:"subdomain"`
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	Size       int    `json:"size"`
+	ReceivedAt int64  `json:"received_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+type APIInboxResponse struct {
+	Subdomains []InboxStats   `json:"subdomains"`
+	Items      []APIInboxItem `json:"items,omitempty"`
+	Limits     APIInboxLimits `json:"limits"`
+}
+
+type APIInboxLimits struct {
+	MaxItems     int   `json:"max_items"`
+	MaxBytes     int64 `json:"max_bytes"`
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	TTLSeconds   int64 `json:"ttl_seconds"`
+}
+
+// handleAdminInbox lists queued requests per subdomain. With ?subdomain=
+// it also lists that subdomain's items, without bodies.
+func (s *Server) handleAdminInbox(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.inbox == nil {
+		writeServerJSONError(w, "inbox not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	stats, err := s.inbox.ListStats()
+	if err != nil {
+		writeServerJSONError(w, "list inbox failed", http.StatusInternalServerError)
+		return
+	}
+	resp := APIInboxResponse{
+		Subdomains: []InboxStats{},
+		Limits: APIInboxLimits{
+			MaxItems:     s.inboxLimits.MaxItems,
+			MaxBytes:     s.inboxLimits.MaxBytes,
+			MaxBodyBytes: s.inboxLimits.MaxBodyBytes,
+			TTLSeconds:   int64(s.inboxLimits.TTL / time.Second),
+		},
+	}
+	for _, st := range stats {
+		resp.Subdomains = append(resp.Subdomains, *st)
+	}
+
+	if subdomain := r.URL.Query().Get("subdomain"); subdomain != "" {
+		items, err := s.inbox.List(subdomain)
+		if err != nil {
+			writeServerJSONError(w, "list inbox failed", http.StatusInternalServerError)
+			return
+		}
+		resp.Items = []APIInboxItem{}
+		for _, item := range items {
+			resp.Items = append(resp.Items, APIInboxItem{
+				ID:         item.ID,
+				Subdomain:  item.Subdomain,
+				Method:     item.Method,
+				URL:        item.URL,
+				Size:       len(item.Body),
+				ReceivedAt: item.ReceivedAt,
+				ExpiresAt:  item.ExpiresAt,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleAdminInboxItem(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.inbox == nil {
+		writeServerJSONError(w, "inbox not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/admin/inbox/")
+	if err := s.inbox.Delete(id); err != nil {
+		writeServerJSONError(w, "delete inbox item failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isValidSubdomain accepts a single DNS label of lowercase letters, digits
+// and inner hyphens.
+func isValidSubdomain(name string) bool {
+	if name == "" || len(name) > 63 || name[0] == '-' || name[len(name)-1] == '-' {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
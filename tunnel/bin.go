@@ -0,0 +1,487 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+// maxBinRequests is how many captures a bin keeps; older ones are dropped.
+const maxBinRequests = 500
+
+// binPageSize bounds one page of the bin requests API.
+const binPageSize = 100
+
+const (
+	defaultBinTTL    = 7 * 24 * time.Hour
+	binPruneInterval = time.Hour
+)
+
+// Bin is a subdomain hosted by the server itself: requests are captured and
+// answered with a canned response until a client attaches with the bin's
+// reservation token. OwnsReservation is set when creating the bin reserved
+// the subdomain, so deleting or expiring the bin releases it.
+type Bin struct {
+	Subdomain       string
+	ResponseStatus  int
+	ResponseHeaders map[string]string
+	ResponseBody    []byte
+	CreatedAt       int64
+	OwnsReservation bool
+}
+
+type BinRequest struct {
+	ID         string            `json:"id"`
+	Subdomain  string            `json:"subdomain"`
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers"`
+	Body       []byte            `json:"body,omitempty"`
+	RemoteAddr string            `json:"remote_addr,omitempty"`
+	ReceivedAt int64             `json:"received_at"`
+}
+
+type BinRepo interface {
+	Save(bin *Bin) error
+	Get(subdomain string) (*Bin, error)
+	Delete(subdomain string) error
+	SaveRequest(req *BinRequest) error
+	ListRequests(subdomain, after string, limit int) ([]*BinRequest, error)
+	TrimRequests(subdomain string, keep int) error
+	ListCreatedBefore(before int64) ([]*Bin, error)
+}
+
+type CreateBinRequest struct {
+	Subdomain string            `json:"subdomain,omitempty"`
+	Status    int               `json:"status,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      string            `json:"body,omitempty"`
+}
+
+// CreateBinResponse carries the bin's token, which is only shown once. Bins
+// made on an existing reservation keep its token, so none is returned.
+type CreateBinResponse struct {
+	Subdomain string `json:"subdomain"`
+	PublicURL string `json:"public_url"`
+	Token     string `json:"token,omitempty"`
+	ViewURL   string `json:"view_url"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type APIBin struct {
+	Subdomain string            `json:"subdomain"`
+	Status    int               `json:"status"`
+	Headers   map[string]string `json:"headers"`
+	Body      string            `json:"body,omitempty"`
+	CreatedAt int64             `json:"created_at"`
+}
+
+type BinRequestsResponse struct {
+	Bin      APIBin       `json:"bin"`
+	Requests []BinRequest `json:"requests"`
+}
+
+// holdOffline answers a request for a subdomain with no connected client
+// from its bin or offline inbox. It reports whether a response was written.
+func (s *Server) holdOffline(w http.ResponseWriter, r *http.Request, subdomain, targetPath string) bool {
+	if s.captureBin(w, r, subdomain, targetPath) {
+		return true
+	}
+	return s.queueInbox(w, r, subdomain, targetPath)
+}
+
+// captureBin records r when subdomain is a bin and writes the bin's canned
+// response. It reports whether a response was written.
+func (s *Server) captureBin(w http.ResponseWriter, r *http.Request, subdomain, targetPath string) bool {
+	if s.bins == nil {
+		return false
+	}
+	bin, err := s.bins.Get(subdomain)
+	if err != nil || bin == nil || s.binExpired(bin, time.Now()) {
+		return false
+	}
+
+	logger := s.logger.WithFields(logging.Fields{"subdomain": subdomain, "method": r.Method, "path": targetPath})
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.inboxLimits.MaxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeServerJSONError(w, "request too large for bin", http.StatusRequestEntityTooLarge)
+			return true
+		}
+		writeServerJSONError(w, "read body failed", http.StatusBadRequest)
+		return true
+	}
+
+	headers := make(map[string]string)
+	for k, v := range r.Header {
+		if len(v) > 0 {
+			headers[k] = v[0]
+		}
+	}
+	captured := &BinRequest{
+		Subdomain:  subdomain,
+		Method:     r.Method,
+		URL:        targetPath,
+		Headers:    headers,
+		Body:       body,
+		RemoteAddr: r.RemoteAddr,
+		ReceivedAt: time.Now().UnixMilli(),
+	}
+	if err := s.bins.SaveRequest(captured); err != nil {
+		logger.WithError(err).Error("server", "bin", "Bin capture failed")
+	} else {
+		s.bins.TrimRequests(subdomain, maxBinRequests)
+		logger.WithFields(logging.Fields{"id": captured.ID, "bytes": len(body)}).Debug("server", "bin", "Request captured")
+	}
+
+	for k, v := range bin.ResponseHeaders {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(bin.ResponseStatus)
+	w.Write(bin.ResponseBody)
+	return true
+}
+
+// handleCreateBin registers a bin. The admin token may create one on any
+// free subdomain, reserving it and returning a new token that guards the
+// captures and lets a client attach later. A reservation's token may turn
+// its own subdomain into a bin. Either way the bin expires after binTTL.
+func (s *Server) handleCreateBin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.bins == nil || s.reservations == nil {
+		writeServerJSONError(w, "bins not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if !s.allowVisitor(w, r) {
+		return
+	}
+
+	var req CreateBinRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil && err != io.EOF {
+		writeServerJSONError(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.Status == 0 {
+		req.Status = http.StatusOK
+	}
+	if req.Status < 200 || req.Status > 599 {
+		writeServerJSONError(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+
+	subdomain := req.Subdomain
+	if subdomain != "" && !isValidSubdomain(subdomain) {
+		writeServerJSONError(w, "invalid subdomain", http.StatusBadRequest)
+		return
+	}
+
+	// a reservation's own token makes its subdomain a bin; anything else
+	// needs the admin token
+	var token string
+	reserved := subdomain != "" && s.reservationTokenValid(r, subdomain)
+	if !reserved {
+		if !s.adminTokenValid(r) {
+			writeServerJSONError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if subdomain == "" {
+			subdomain = generateSubdomain()
+		}
+		if existing, err := s.reservations.Get(subdomain); err != nil || existing != nil || !s.isSubdomainAvailable(subdomain) {
+			writeServerJSONError(w, "subdomain not available", http.StatusConflict)
+			return
+		}
+	}
+
+	// remaking a bin on its own reservation must still release it later
+	ownsReservation := !reserved
+	if reserved {
+		if existing, err := s.bins.Get(subdomain); err == nil && existing != nil {
+			ownsReservation = existing.OwnsReservation
+		}
+	}
+
+	now := time.Now()
+	if !reserved {
+		token = generateReservationToken()
+		if err := s.reservations.Save(&Reservation{
+			Subdomain:   subdomain,
+			TokenHash:   HashToken(token),
+			InboxStatus: http.StatusAccepted,
+			CreatedAt:   now.UnixMilli(),
+		}); err != nil {
+			writeServerJSONError(w, "save reservation failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.Headers == nil {
+		req.Headers = map[string]string{"Content-Type": "text/plain; charset=utf-8"}
+	}
+	if err := s.bins.Save(&Bin{
+		Subdomain:       subdomain,
+		ResponseStatus:  req.Status,
+		ResponseHeaders: req.Headers,
+		ResponseBody:    []byte(req.Body),
+		CreatedAt:       now.UnixMilli(),
+		OwnsReservation: ownsReservation,
+	}); err != nil {
+		if !reserved {
+			s.reservations.Delete(subdomain)
+		}
+		writeServerJSONError(w, "save bin failed", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.WithFields(logging.Fields{"subdomain": subdomain, "status": req.Status}).Info("server", "bin", "Bin created")
+
+	viewURL := fmt.Sprintf("http://%s/bins/%s", r.Host, subdomain)
+	if token != "" {
+		viewURL += "#" + token
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateBinResponse{
+		Subdomain: subdomain,
+		PublicURL: s.publicURL(subdomain, false),
+		Token:     token,
+		ViewURL:   viewURL,
+		ExpiresAt: now.Add(s.binTTL).UnixMilli(),
+	})
+}
+
+// binExpired reports whether bin has outlived binTTL; expired bins stop
+// capturing at once, before pruneBins gets to them.
+func (s *Server) binExpired(bin *Bin, now time.Time) bool {
+	return now.Sub(time.UnixMilli(bin.CreatedAt)) >= s.binTTL
+}
+
+// pruneBins deletes expired bins with their captures, releasing the
+// subdomains they reserved.
+func (s *Server) pruneBins(now time.Time) {
+	expired, err := s.bins.ListCreatedBefore(now.Add(-s.binTTL).UnixMilli() + 1)
+	if err != nil {
+		s.logger.WithError(err).Error("server", "bin", "List expired bins failed")
+		return
+	}
+	for _, bin := range expired {
+		if err := s.deleteBin(bin); err != nil {
+			s.logger.WithError(err).WithFields(logging.Fields{"subdomain": bin.Subdomain}).Error("server", "bin", "Delete expired bin failed")
+			continue
+		}
+		s.logger.WithFields(logging.Fields{"subdomain": bin.Subdomain}).Info("server", "bin", "Bin expired")
+	}
+}
+
+func (s *Server) pruneBinsLoop(ctx context.Context) {
+	ticker := time.NewTicker(binPruneInterval)
+	defer ticker.Stop()
+	for {
+		s.pruneBins(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deleteBin removes bin and its captures, and its reservation when the bin
+// made it.
+func (s *Server) deleteBin(bin *Bin) error {
+	if err := s.bins.Delete(bin.Subdomain); err != nil {
+		return err
+	}
+	if bin.OwnsReservation {
+		return s.reservations.Delete(bin.Subdomain)
+	}
+	return nil
+}
+
+// handleBinByName serves /api/bins/{subdomain}/requests (GET) and
+// /api/bins/{subdomain} (DELETE) to bearers of the bin's token.
+func (s *Server) handleBinByName(w http.ResponseWriter, r *http.Request) {
+	if s.bins == nil || s.reservations == nil {
+		writeServerJSONError(w, "bins not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/bins/")
+	subdomain, sub, _ := strings.Cut(rest, "/")
+
+	bin, err := s.bins.Get(subdomain)
+	if err != nil {
+		writeServerJSONError(w, "get bin failed", http.StatusInternalServerError)
+		return
+	}
+	if bin == nil {
+		writeServerJSONError(w, "bin not found", http.StatusNotFound)
+		return
+	}
+	if !s.reservationTokenValid(r, subdomain) {
+		writeServerJSONError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case sub == "requests" && r.Method == http.MethodGet:
+		s.listBinRequests(w, r, bin)
+	case sub == "" && r.Method == http.MethodDelete:
+		if err := s.deleteBin(bin); err != nil {
+			writeServerJSONError(w, "delete bin failed", http.StatusInternalServerError)
+			return
+		}
+		s.logger.WithFields(logging.Fields{"subdomain": subdomain}).Info("server", "bin", "Bin deleted")
+		w.WriteHeader(http.StatusNoContent)
+	case sub == "" || sub == "requests":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) listBinRequests(w http.ResponseWriter, r *http.Request, bin *Bin) {
+	limit := binPageSize
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v < limit {
+		limit = v
+	}
+
+	reqs, err := s.bins.ListRequests(bin.Subdomain, r.URL.Query().Get("after"), limit)
+	if err != nil {
+		writeServerJSONError(w, "list bin requests failed", http.StatusInternalServerError)
+		return
+	}
+
+	resp := BinRequestsResponse{
+		Bin: APIBin{
+			Subdomain: bin.Subdomain,
+			Status:    bin.ResponseStatus,
+			Headers:   bin.ResponseHeaders,
+			Body:      string(bin.ResponseBody),
+			CreatedAt: bin.CreatedAt,
+		},
+		Requests: []BinRequest{},
+	}
+	for _, req := range reqs {
+		resp.Requests = append(resp.Requests, *req)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// reservationTokenValid reports whether r carries the reservation token of
+// subdomain as a bearer token.
+func (s *Server) reservationTokenValid(r *http.Request, subdomain string) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return false
+	}
+	res, err := s.reservations.Get(subdomain)
+	if err != nil || res == nil {
+		return false
+	}
+	return tokenMatches(res.TokenHash, HashToken(token))
+}
+
+type BinViewData struct {
+	Subdomain string
+	PublicURL string
+}
+
+// handleBinView renders the bin page. The token stays in the URL fragment
+// and the page fetches captures with it, so it never reaches server logs.
+func (s *Server) handleBinView(w http.ResponseWriter, r *http.Request) {
+	subdomain := strings.TrimPrefix(r.URL.Path, "/bins/")
+	if subdomain == "" {
+		http.Error(w, "missing bin", http.StatusBadRequest)
+		return
+	}
+
+	if s.templates == nil {
+		http.Error(w, "templates not loaded", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := BinViewData{Subdomain: subdomain, PublicURL: s.publicURL(subdomain, false)}
+	if err := s.templates.ExecuteTemplate(w, "bin.html", data); err != nil {
+		s.logger.WithError(err).WithFields(logging.Fields{"template": "bin.html"}).Error("server", "template", "Render failed")
+		http.Error(w, "template error", http.StatusInternalServerError)
+	}
+}
+
+// PullBin copies the captures of the bin this client attached to into its
+// request logger, picking up after the last capture pulled earlier. It
+// returns how many captures were logged.
+func (c *Client) PullBin(ctx context.Context) (int, error) {
+	if c.subdomain == "" || c.authToken == "" {
+		return 0, fmt.Errorf("pull bin: subdomain and token required")
+	}
+
+	c.binMu.Lock()
+	defer c.binMu.Unlock()
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	pulled := 0
+	for {
+		q := url.Values{"after": {c.binCursor}, "limit": {strconv.Itoa(binPageSize)}}
+		u := url.URL{Scheme: "http", Host: c.serverAddr, Path: "/api/bins/" + c.subdomain + "/requests", RawQuery: q.Encode()}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return pulled, fmt.Errorf("create bin request: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return pulled, fmt.Errorf("fetch bin: %w", err)
+		}
+		var page BinRequestsResponse
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return pulled, fmt.Errorf("fetch bin: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return pulled, fmt.Errorf("decode bin: %w", err)
+		}
+
+		for _, captured := range page.Requests {
+			if c.logger != nil {
+				if err := c.logger.Log(&RequestLog{
+					Method:          captured.Method,
+					URL:             captured.URL,
+					RequestHeaders:  captured.Headers,
+					RequestBody:     captured.Body,
+					StatusCode:      page.Bin.Status,
+					ResponseHeaders: page.Bin.Headers,
+					ResponseBody:    []byte(page.Bin.Body),
+					CapturedAt:      captured.ReceivedAt,
+				}); err != nil {
+					return pulled, fmt.Errorf("log bin request: %w", err)
+				}
+			}
+			c.binCursor = captured.ID
+			pulled++
+		}
+		if len(page.Requests) < binPageSize {
+			return pulled, nil
+		}
+	}
+}
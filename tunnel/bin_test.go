@@ -0,0 +1,343 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memBinRepo struct {
+	mu       sync.Mutex
+	bins     map[string]*Bin
+	requests map[string]*BinRequest
+}
+
+func newMemBinRepo() *memBinRepo {
+	return &memBinRepo{bins: make(map[string]*Bin), requests: make(map[string]*BinRequest)}
+}
+
+func (m *memBinRepo) Save(bin *Bin) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bins[bin.Subdomain] = bin
+	return nil
+}
+
+func (m *memBinRepo) Get(subdomain string) (*Bin, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bins[subdomain], nil
+}
+
+func (m *memBinRepo) Delete(subdomain string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bins, subdomain)
+	for id, req := range m.requests {
+		if req.Subdomain == subdomain {
+			delete(m.requests, id)
+		}
+	}
+	return nil
+}
+
+func (m *memBinRepo) SaveRequest(req *BinRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if req.ID == "" {
+		req.ID = ulid.Make().String()
+	}
+	m.requests[req.ID] = req
+	return nil
+}
+
+func (m *memBinRepo) ListRequests(subdomain, after string, limit int) ([]*BinRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*BinRequest
+	for _, req := range m.requests {
+		if req.Subdomain == subdomain && req.ID > after {
+			out = append(out, req)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (m *memBinRepo) TrimRequests(subdomain string, keep int) error {
+	all, _ := m.ListRequests(subdomain, "", len(m.requests))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i < len(all)-keep; i++ {
+		delete(m.requests, all[i].ID)
+	}
+	return nil
+}
+
+func (m *memBinRepo) ListCreatedBefore(before int64) ([]*Bin, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*Bin
+	for _, bin := range m.bins {
+		if bin.CreatedAt < before {
+			out = append(out, bin)
+		}
+	}
+	return out, nil
+}
+
+const binAdminToken = "admin-secret"
+
+func startBinServer(t *testing.T, ctx context.Context) (*Server, *memBinRepo) {
+	bins := newMemBinRepo()
+	srv := startTestServer(t, ctx, ServerConfig{
+		AdminToken:   binAdminToken,
+		Reservations: newMemReservationRepo(),
+		Bins:         bins,
+	})
+	return srv, bins
+}
+
+func createBin(t *testing.T, srv *Server, req CreateBinRequest) (int, CreateBinResponse) {
+	return createBinWithToken(t, srv, binAdminToken, req)
+}
+
+func createBinWithToken(t *testing.T, srv *Server, token string, req CreateBinRequest) (int, CreateBinResponse) {
+	payload, _ := json.Marshal(req)
+	httpReq, _ := http.NewRequest("POST", "http://"+srv.Addr()+"/api/bins", bytes.NewReader(payload))
+	httpReq.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var created CreateBinResponse
+	json.NewDecoder(resp.Body).Decode(&created)
+	return resp.StatusCode, created
+}
+
+func sendToSubdomain(t *testing.T, srv *Server, subdomain, method, path, body string) (*http.Response, string) {
+	req, _ := http.NewRequest(method, "http://"+srv.Addr()+path, strings.NewReader(body))
+	req.Host = subdomain + ".test.local"
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	return resp, string(respBody)
+}
+
+func TestBinCapturesRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _ := startBinServer(t, ctx)
+
+	status, created := createBin(t, srv, CreateBinRequest{
+		Subdomain: "stripe",
+		Status:    http.StatusAccepted,
+		Headers:   map[string]string{"Content-Type": "application/json"},
+		Body:      `{"received":true}`,
+	})
+	require.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, "http://stripe.test.local", created.PublicURL)
+	assert.NotEmpty(t, created.Token)
+	assert.Contains(t, created.ViewURL, "/bins/stripe#"+created.Token)
+
+	status, _ = createBin(t, srv, CreateBinRequest{Subdomain: "stripe"})
+	assert.Equal(t, http.StatusConflict, status)
+
+	resp, body := sendToSubdomain(t, srv, "stripe", "POST", "/webhook?x=1", `{"type":"charge.succeeded"}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `{"received":true}`, body)
+
+	listURL := "http://" + srv.Addr() + "/api/bins/stripe/requests"
+	resp, err := http.Get(listURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, _ := http.NewRequest("GET", listURL, nil)
+	req.Header.Set("Authorization", "Bearer "+created.Token)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var page BinRequestsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Requests, 1)
+	assert.Equal(t, "POST", page.Requests[0].Method)
+	assert.Equal(t, "/webhook?x=1", page.Requests[0].URL)
+	assert.Equal(t, `{"type":"charge.succeeded"}`, string(page.Requests[0].Body))
+	assert.Equal(t, http.StatusAccepted, page.Bin.Status)
+
+	resp, err = http.Get("http://" + srv.Addr() + "/bins/stripe")
+	require.NoError(t, err)
+	view, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(view), "http://stripe.test.local")
+}
+
+func TestBinRejectsClientWithoutToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _ := startBinServer(t, ctx)
+	status, _ := createBin(t, srv, CreateBinRequest{Subdomain: "hooks"})
+	require.Equal(t, http.StatusCreated, status)
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "hooks"})
+	client.SetReconnect(false)
+	err := client.Connect(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reserved")
+}
+
+func TestClientAttachesToBin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	localServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("from local"))
+	}))
+	defer localServer.Close()
+
+	srv, _ := startBinServer(t, ctx)
+	status, created := createBin(t, srv, CreateBinRequest{Subdomain: "hooks", Body: "canned"})
+	require.Equal(t, http.StatusCreated, status)
+
+	sendToSubdomain(t, srv, "hooks", "POST", "/one", "1")
+	sendToSubdomain(t, srv, "hooks", "POST", "/two", "2")
+
+	logged := make(chan *RequestLog, 10)
+	client := NewClient(ClientConfig{
+		ServerAddr: srv.Addr(),
+		LocalPort:  strings.Split(localServer.Listener.Addr().String(), ":")[1],
+		Subdomain:  "hooks",
+		AuthToken:  created.Token,
+	})
+	client.SetLogger(chanRequestLogger(logged))
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	n, err := client.PullBin(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	for _, want := range []string{"/one", "/two"} {
+		l := <-logged
+		assert.Equal(t, want, l.URL)
+		assert.Equal(t, http.StatusOK, l.StatusCode)
+		assert.Equal(t, "canned", string(l.ResponseBody))
+		assert.NotZero(t, l.CapturedAt)
+		assert.Zero(t, l.ReceivedAt, "bin captures are not inbox deliveries")
+	}
+
+	n, err = client.PullBin(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n, "second pull starts after the last capture")
+
+	// while attached, traffic goes to the client instead of the bin
+	resp, body := sendToSubdomain(t, srv, "hooks", "GET", "/live", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "from local", body)
+}
+
+func TestBinCreateRequiresToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, bins := startBinServer(t, ctx)
+
+	status, _ := createBinWithToken(t, srv, "", CreateBinRequest{Subdomain: "phish"})
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = createBinWithToken(t, srv, "wrong", CreateBinRequest{Subdomain: "phish"})
+	assert.Equal(t, http.StatusUnauthorized, status)
+	bin, _ := bins.Get("phish")
+	assert.Nil(t, bin)
+}
+
+func TestBinCreateOnOwnReservation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, bins := startBinServer(t, ctx)
+	require.NoError(t, srv.reservations.Save(&Reservation{Subdomain: "mine", TokenHash: HashToken("res-token")}))
+
+	status, _ := createBinWithToken(t, srv, "res-token", CreateBinRequest{Subdomain: "other"})
+	assert.Equal(t, http.StatusUnauthorized, status, "a reservation token only covers its own subdomain")
+
+	status, created := createBinWithToken(t, srv, "res-token", CreateBinRequest{Subdomain: "mine", Body: "canned"})
+	require.Equal(t, http.StatusCreated, status)
+	assert.Empty(t, created.Token, "the reservation keeps its token")
+	assert.NotZero(t, created.ExpiresAt)
+
+	_, body := sendToSubdomain(t, srv, "mine", "POST", "/hook", "x")
+	assert.Equal(t, "canned", body)
+
+	// deleting the bin keeps the reservation it was made on
+	bin, _ := bins.Get("mine")
+	require.NoError(t, srv.deleteBin(bin))
+	res, err := srv.reservations.Get("mine")
+	require.NoError(t, err)
+	assert.NotNil(t, res)
+}
+
+func TestBinCreateRateLimited(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _ := startBinServer(t, ctx)
+	srv.rateLimiter.SetPlans([]RatePlan{{Name: PlanPerIP, RequestsPerMin: 1, Burst: 1}}, nil)
+
+	status, _ := createBin(t, srv, CreateBinRequest{})
+	require.Equal(t, http.StatusCreated, status)
+	status, _ = createBin(t, srv, CreateBinRequest{})
+	assert.Equal(t, http.StatusTooManyRequests, status)
+}
+
+func TestBinsExpire(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, bins := startBinServer(t, ctx)
+	status, _ := createBin(t, srv, CreateBinRequest{Subdomain: "old", Body: "canned"})
+	require.Equal(t, http.StatusCreated, status)
+	sendToSubdomain(t, srv, "old", "POST", "/hook", "x")
+
+	bin, _ := bins.Get("old")
+	aged := *bin
+	aged.CreatedAt = time.Now().Add(-srv.binTTL).UnixMilli()
+	require.NoError(t, bins.Save(&aged))
+
+	// an expired bin stops answering before it is pruned
+	resp, _ := sendToSubdomain(t, srv, "old", "GET", "/", "")
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+
+	srv.pruneBins(time.Now())
+	bin, _ = bins.Get("old")
+	assert.Nil(t, bin)
+	reqs, _ := bins.ListRequests("old", "", 10)
+	assert.Empty(t, reqs)
+	res, err := srv.reservations.Get("old")
+	require.NoError(t, err)
+	assert.Nil(t, res, "the subdomain is released")
+}
@@ -1,14 +1,19 @@
 package tunnel
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/auditmos/devtunnel/logging"
@@ -25,6 +30,12 @@ type RequestLog struct {
 	ResponseHeaders map[string]string
 	ResponseBody    []byte
 	DurationMs      int64
+	// ReceivedAt is set for requests the server held in its offline inbox:
+	// when the server first received them, in unix ms.
+	ReceivedAt int64
+	// CapturedAt is set for requests pulled from a request bin: when the
+	// bin captured them, in unix ms. They are logged as of that time.
+	CapturedAt int64
 }
 
 type RequestLogger interface {
@@ -35,6 +46,13 @@ type Client struct {
 	serverAddr string
 	localPort  string
 	subdomain  string
+	authToken  string
+	pool       string
+
+	passthrough bool
+	certFile    string
+	keyFile     string
+	tlsConfig   *tls.Config
 
 	mu        sync.RWMutex
 	session   *yamux.Session
@@ -46,8 +64,31 @@ type Client struct {
 	maxBackoff   time.Duration
 	onConnected  func(publicURL string)
 	onDisconnect func(err error)
+	onControl    func(msg ControlMessage)
 	logger       RequestLogger
 	log          logging.Logger
+
+	control      *controlChannel
+	retryAfter   time.Duration
+	reconnecting atomic.Bool
+	version      string
+	capabilities []string
+
+	noCompression bool
+	compression   string
+	stats         CompressionStats
+
+	resumeToken string
+
+	binMu     sync.Mutex
+	binCursor string
+
+	maxBodyBytes int64
+
+	healthPath     string
+	healthInterval time.Duration
+	healthProbe    chan struct{}
+	upstreamDown   atomic.Bool
 }
 
 type ClientConfig struct {
@@ -55,6 +96,33 @@ type ClientConfig struct {
 	LocalPort  string
 	Subdomain  string
 	Logger     logging.Logger
+
+	// AuthToken claims a subdomain reserved on the server.
+	AuthToken string
+	// Pool shares the reserved subdomain with other clients instead of
+	// taking it over, naming how the server picks between them.
+	Pool string
+
+	// TLSPassthrough asks the server to route raw TLS connections to this
+	// client. With TLSCertFile/TLSKeyFile set, TLS is terminated locally and
+	// plain HTTP is forwarded; otherwise bytes go to a local TLS server.
+	TLSPassthrough bool
+	TLSCertFile    string
+	TLSKeyFile     string
+
+	// DisableCompression keeps frame bodies uncompressed even when the
+	// server offers compression.
+	DisableCompression bool
+
+	// MaxBodyBytes asks the server to refuse request bodies over this size
+	// with 413, when lower than the server's own limit.
+	MaxBodyBytes int64
+
+	// HealthPath is probed with GET to check the local app; empty means a
+	// TCP dial to LocalPort. HealthInterval defaults to 10s and a negative
+	// value disables health checks.
+	HealthPath     string
+	HealthInterval time.Duration
 }
 
 func NewClient(cfg ClientConfig) *Client {
@@ -62,13 +130,31 @@ func NewClient(cfg ClientConfig) *Client {
 	if logger == nil {
 		logger = logging.NopLogger{}
 	}
+	healthInterval := cfg.HealthInterval
+	if healthInterval == 0 {
+		healthInterval = defaultHealthInterval
+	}
+	healthPath := cfg.HealthPath
+	if healthPath != "" && !strings.HasPrefix(healthPath, "/") {
+		healthPath = "/" + healthPath
+	}
 	return &Client{
-		serverAddr: cfg.ServerAddr,
-		localPort:  cfg.LocalPort,
-		subdomain:  cfg.Subdomain,
-		reconnect:  true,
-		maxBackoff: 60 * time.Second,
-		log:        logger,
+		serverAddr:     cfg.ServerAddr,
+		localPort:      cfg.LocalPort,
+		subdomain:      cfg.Subdomain,
+		authToken:      cfg.AuthToken,
+		pool:           cfg.Pool,
+		passthrough:    cfg.TLSPassthrough,
+		certFile:       cfg.TLSCertFile,
+		keyFile:        cfg.TLSKeyFile,
+		noCompression:  cfg.DisableCompression,
+		maxBodyBytes:   cfg.MaxBodyBytes,
+		healthPath:     healthPath,
+		healthInterval: healthInterval,
+		healthProbe:    make(chan struct{}, 1),
+		reconnect:      true,
+		maxBackoff:     60 * time.Second,
+		log:            logger,
 	}
 }
 
@@ -80,11 +166,24 @@ func (c *Client) OnDisconnect(fn func(err error)) {
 	c.onDisconnect = fn
 }
 
+// OnControl registers a callback for control messages from the server.
+// Pings are answered internally and not passed on.
+func (c *Client) OnControl(fn func(msg ControlMessage)) {
+	c.onControl = fn
+}
+
 func (c *Client) SetLogger(l RequestLogger) {
 	c.logger = l
 }
 
 func (c *Client) Connect(ctx context.Context) error {
+	if c.certFile != "" || c.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			return fmt.Errorf("load tls certificate: %w", err)
+		}
+		c.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
 	return c.connectWithBackoff(ctx)
 }
 
@@ -105,15 +204,17 @@ func (c *Client) connectWithBackoff(ctx context.Context) error {
 			return err
 		}
 
+		wait := fullJitter(backoff)
+		c.log.WithError(err).WithFields(logging.Fields{
+			"retry_in": wait.String(),
+		}).Error("client", "connect", "Connection failed")
+
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(backoff):
+		case <-time.After(wait):
 		}
 
-		c.log.WithError(err).WithFields(logging.Fields{
-			"retry_in": backoff.String(),
-		}).Error("client", "connect", "Connection failed")
 		backoff *= 2
 		if backoff > c.maxBackoff {
 			backoff = c.maxBackoff
@@ -156,8 +257,17 @@ func (c *Client) connect(ctx context.Context) error {
 	}
 
 	req := HandshakeRequest{
-		Version:   "1.0",
-		Subdomain: c.subdomain,
+		Version:      ProtocolVersion,
+		Versions:     SupportedVersions,
+		Capabilities: c.offeredCapabilities(),
+		Compression:  compressionPreference,
+		AuthToken:    c.authToken,
+		Subdomain:    c.subdomain,
+		ResumeToken:  c.resumeToken,
+		Passthrough:  c.passthrough,
+		Control:      true,
+		Pool:         c.pool,
+		MaxBodyBytes: c.maxBodyBytes,
 	}
 
 	enc := json.NewEncoder(stream)
@@ -174,61 +284,136 @@ func (c *Client) connect(ctx context.Context) error {
 		session.Close()
 		return fmt.Errorf("decode handshake response: %w", err)
 	}
-	stream.Close()
 
 	if !resp.Success {
+		stream.Close()
 		session.Close()
 		return fmt.Errorf("handshake failed: %s", resp.Error)
 	}
 
+	// servers that predate negotiation leave Version empty and speak 1.0
+	version, caps := resp.Version, resp.Capabilities
+	if version == "" {
+		version = "1.0"
+		if resp.Control {
+			caps = []string{CapControl}
+		}
+	}
+	if _, ok := negotiateVersion(SupportedVersions, []string{version}); !ok {
+		stream.Close()
+		session.Close()
+		return fmt.Errorf("server chose unsupported protocol version %s; upgrade devtunnel to connect", version)
+	}
+	caps = intersectCapabilities(caps, c.offeredCapabilities())
+	var compression string
+	if hasCapability(caps, CapCompression) {
+		compression = negotiateCompression(compressionPreference, []string{resp.Compression})
+	}
+
+	var control *controlChannel
+	if hasCapability(caps, CapControl) {
+		control = newControlChannel(stream, dec)
+	} else {
+		stream.Close()
+	}
+
+	if c.pool != "" && !hasCapability(caps, CapPool) {
+		c.log.WithFields(logging.Fields{"subdomain": resp.Subdomain}).Warn("client", "connect", "Server does not support pools; this client took over the subdomain")
+	}
+	if c.resumeToken != "" && !resp.Resumed {
+		c.log.WithFields(logging.Fields{"previous": c.subdomain, "subdomain": resp.Subdomain}).Warn("client", "connect", "Session could not be resumed")
+	}
+
 	c.mu.Lock()
 	c.conn = conn
 	c.session = session
 	c.publicURL = resp.PublicURL
 	c.subdomain = resp.Subdomain
+	c.resumeToken = resp.ResumeToken
 	c.connected = true
+	c.control = control
+	c.retryAfter = 0
+	c.version = version
+	c.capabilities = caps
+	c.compression = compression
 	c.mu.Unlock()
 
 	c.log.WithFields(logging.Fields{
-		"public_url": resp.PublicURL,
-		"subdomain":  resp.Subdomain,
+		"public_url":   resp.PublicURL,
+		"subdomain":    resp.Subdomain,
+		"version":      version,
+		"capabilities": strings.Join(caps, ","),
+		"compression":  compression,
+		"resumed":      resp.Resumed,
 	}).Info("client", "connect", "Connected")
 
 	if c.onConnected != nil {
 		c.onConnected(resp.PublicURL)
 	}
 
-	go c.handleRequests(ctx)
-	go c.monitorConnection(ctx)
+	go c.handleRequests(ctx, session)
+	go c.monitorConnection(ctx, session)
+	if control != nil {
+		go c.readControl(ctx, control)
+		if c.healthInterval > 0 && !c.passthrough {
+			go c.watchUpstream(ctx, control)
+		}
+	}
 
 	return nil
 }
 
-func (c *Client) monitorConnection(ctx context.Context) {
-	c.mu.RLock()
-	session := c.session
-	c.mu.RUnlock()
-
+func (c *Client) monitorConnection(ctx context.Context, session *yamux.Session) {
 	select {
 	case <-session.CloseChan():
 		c.mu.Lock()
-		c.connected = false
+		current := c.session == session
+		if current {
+			c.connected = false
+		}
 		c.mu.Unlock()
 
+		// an early reconnect already replaced this session
+		if !current {
+			return
+		}
+
 		if c.onDisconnect != nil {
 			c.onDisconnect(fmt.Errorf("connection closed"))
 		}
 
 		if c.reconnect {
 			c.log.Warn("client", "disconnect", "Connection lost")
-			c.log.Info("client", "reconnect", "Reconnecting")
-			c.connectWithBackoff(ctx)
+			c.reconnectAfterHint(ctx, "Reconnecting")
 		}
 	case <-ctx.Done():
 		return
 	}
 }
 
+// reconnectAfterHint waits for any retry hint from the server, then runs the
+// backoff loop unless another reconnect is already in progress.
+func (c *Client) reconnectAfterHint(ctx context.Context, msg string) {
+	if !c.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.reconnecting.Store(false)
+
+	c.mu.RLock()
+	retryAfter := c.retryAfter
+	c.mu.RUnlock()
+	if retryAfter > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryAfter):
+		}
+	}
+
+	c.log.Info("client", "reconnect", msg)
+	c.connectWithBackoff(ctx)
+}
+
 func (c *Client) IsConnected() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -247,6 +432,53 @@ func (c *Client) Session() *yamux.Session {
 	return c.session
 }
 
+// Latency returns the round trip last measured by the server's pings.
+func (c *Client) Latency() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.control == nil {
+		return 0
+	}
+	return time.Duration(c.control.latencyMs.Load()) * time.Millisecond
+}
+
+// offeredCapabilities lists what this client can use if the server agrees.
+func (c *Client) offeredCapabilities() []string {
+	caps := []string{CapControl}
+	if !c.noCompression {
+		caps = append(caps, CapCompression)
+	}
+	if c.pool != "" {
+		caps = append(caps, CapPool)
+	}
+	return caps
+}
+
+// CompressionStats returns frame byte counters for this client.
+func (c *Client) CompressionStats() CompressionSnapshot {
+	c.mu.RLock()
+	encoding := c.compression
+	c.mu.RUnlock()
+
+	snap := c.stats.Snapshot()
+	snap.Encoding = encoding
+	return snap
+}
+
+// ProtocolVersion returns the protocol version negotiated with the server.
+func (c *Client) ProtocolVersion() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
+}
+
+// HasCapability reports whether the server agreed to the named capability.
+func (c *Client) HasCapability(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return hasCapability(c.capabilities, name)
+}
+
 func (c *Client) SetReconnect(v bool) {
 	c.reconnect = v
 }
@@ -267,11 +499,52 @@ func (c *Client) Close() error {
 	return nil
 }
 
-func (c *Client) handleRequests(ctx context.Context) {
-	c.mu.RLock()
-	session := c.session
-	c.mu.RUnlock()
+func (c *Client) readControl(ctx context.Context, control *controlChannel) {
+	defer control.close()
+	for {
+		var msg ControlMessage
+		if err := control.dec.Decode(&msg); err != nil {
+			return
+		}
 
+		logger := c.log.WithFields(logging.Fields{"type": msg.Type})
+		switch msg.Type {
+		case ControlPing:
+			control.latencyMs.Store(msg.LatencyMs)
+			if err := control.send(ControlMessage{Type: ControlPong, Timestamp: msg.Timestamp}); err != nil {
+				logger.WithError(err).Warn("client", "control", "Pong failed")
+			}
+			continue
+		case ControlShutdown, ControlReconnect, ControlClose:
+			if msg.RetryAfterMs > 0 {
+				c.mu.Lock()
+				c.retryAfter = time.Duration(msg.RetryAfterMs) * time.Millisecond
+				c.mu.Unlock()
+			}
+			logger.WithFields(logging.Fields{"retry_after_ms": msg.RetryAfterMs}).Warn("client", "control", msg.Message)
+			// open a new session right away; the old one finishes in-flight streams
+			if msg.Type == ControlReconnect && c.reconnect {
+				go c.reconnectAfterHint(ctx, "Reconnecting on server request")
+			}
+		case ControlRateLimit:
+			logger.WithFields(logging.Fields{"retry_after_ms": msg.RetryAfterMs}).Warn("client", "control", msg.Message)
+		case ControlConfig:
+			fields := logging.Fields{}
+			for k, v := range msg.Config {
+				fields[k] = v
+			}
+			logger.WithFields(fields).Info("client", "control", "Server config received")
+		default:
+			logger.Info("client", "control", msg.Message)
+		}
+
+		if c.onControl != nil {
+			c.onControl(msg)
+		}
+	}
+}
+
+func (c *Client) handleRequests(ctx context.Context, session *yamux.Session) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -298,6 +571,13 @@ func (c *Client) handleStream(stream io.ReadWriteCloser) {
 		return
 	}
 
+	if req.Kind == StreamTLS {
+		// the encoder's trailing newline precedes the raw TLS bytes
+		rest, _ := io.ReadAll(dec.Buffered())
+		c.handlePassthrough(stream, bytes.NewReader(bytes.TrimLeft(rest, "\n")), &req)
+		return
+	}
+
 	logger := c.log.WithTraceID(req.TraceID).WithFields(logging.Fields{
 		"method":     req.Method,
 		"url":        req.URL,
@@ -306,11 +586,26 @@ func (c *Client) handleStream(stream io.ReadWriteCloser) {
 
 	start := time.Now()
 
+	c.mu.RLock()
+	compression := c.compression
+	c.mu.RUnlock()
+
+	reqBody, err := decompressBody(req.Encoding, req.Body, c.maxBodyBytes)
+	if err != nil {
+		logger.WithError(err).Error("client", "forward", "Failed to decompress request")
+		c.sendError(stream, req.ID, http.StatusBadGateway, ErrorTunnelFailed)
+		return
+	}
+	if compression != "" {
+		c.stats.add(len(reqBody), len(req.Body))
+	}
+	req.Body = reqBody
+
 	localURL := fmt.Sprintf("http://127.0.0.1:%s%s", c.localPort, req.URL)
 	httpReq, err := http.NewRequest(req.Method, localURL, bytes.NewReader(req.Body))
 	if err != nil {
 		logger.WithError(err).Error("client", "forward", "Failed to create request")
-		c.sendError(stream, req.ID, http.StatusBadGateway)
+		c.sendError(stream, req.ID, http.StatusBadGateway, ErrorTunnelFailed)
 		return
 	}
 
@@ -321,11 +616,21 @@ func (c *Client) handleStream(stream io.ReadWriteCloser) {
 		httpReq.Header.Set("X-Trace-ID", req.TraceID)
 	}
 
-	httpClient := &http.Client{Timeout: 30 * time.Second}
+	timeout := defaultUpstreamTimeout
+	if req.TimeoutMs > 0 {
+		timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+	httpClient := &http.Client{Timeout: timeout}
 	resp, err := httpClient.Do(httpReq)
 	if err != nil {
+		if isTimeout(err) {
+			logger.WithError(err).WithFields(logging.Fields{"limit": "upstream_timeout", "max": timeout.String()}).Warn("client", "forward", "Local app timed out")
+			c.sendError(stream, req.ID, http.StatusGatewayTimeout, ErrorUpstreamTimeout)
+			return
+		}
 		logger.WithError(err).Error("client", "forward", "Failed to forward request")
-		c.sendError(stream, req.ID, http.StatusBadGateway)
+		c.sendError(stream, req.ID, http.StatusBadGateway, ErrorUpstreamDown)
+		c.checkUpstreamSoon()
 		return
 	}
 	defer resp.Body.Close()
@@ -333,10 +638,15 @@ func (c *Client) handleStream(stream io.ReadWriteCloser) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		logger.WithError(err).Error("client", "forward", "Failed to read response")
-		c.sendError(stream, req.ID, http.StatusBadGateway)
+		c.sendError(stream, req.ID, http.StatusBadGateway, ErrorUpstreamDown)
+		c.checkUpstreamSoon()
 		return
 	}
 
+	// Only this client may mark a response as a tunnel error; an app
+	// setting the header must not get the server's error pages.
+	resp.Header.Del(HeaderTunnelError)
+
 	headers := make(map[string]string)
 	for k, v := range resp.Header {
 		if len(v) > 0 {
@@ -361,17 +671,24 @@ func (c *Client) handleStream(stream io.ReadWriteCloser) {
 			ResponseHeaders: headers,
 			ResponseBody:    body,
 			DurationMs:      durationMs,
+			ReceivedAt:      req.ReceivedAt,
 		}
 		if err := c.logger.Log(reqLog); err != nil {
 			logger.WithError(err).Error("client", "forward", "Failed to log request")
 		}
 	}
 
+	wireBody, encoding := compressBody(compression, body, headers)
+	if compression != "" {
+		c.stats.add(len(body), len(wireBody))
+	}
+
 	respFrame := ResponseFrame{
 		ID:         req.ID,
 		StatusCode: resp.StatusCode,
 		Headers:    headers,
-		Body:       body,
+		Body:       wireBody,
+		Encoding:   encoding,
 	}
 
 	enc := json.NewEncoder(stream)
@@ -380,13 +697,155 @@ func (c *Client) handleStream(stream io.ReadWriteCloser) {
 	}
 }
 
-func (c *Client) sendError(stream io.Writer, id string, status int) {
+// sendError answers a request the client could not forward. The server
+// renders its error page for reason instead of passing the body on.
+func (c *Client) sendError(stream io.Writer, id string, status int, reason string) {
 	respFrame := ResponseFrame{
 		ID:         id,
 		StatusCode: status,
-		Headers:    map[string]string{},
-		Body:       []byte("tunnel error"),
+		Headers:    map[string]string{HeaderTunnelError: reason},
+		Body:       []byte("tunnel error: " + reason),
 	}
 	enc := json.NewEncoder(stream)
 	enc.Encode(&respFrame)
 }
+
+// handlePassthrough serves a raw TLS connection relayed by the server, either
+// by terminating it with the configured certificate or by splicing it to the
+// local TLS server untouched.
+func (c *Client) handlePassthrough(stream io.ReadWriteCloser, buffered io.Reader, req *RequestFrame) {
+	logger := c.log.WithTraceID(req.TraceID).WithFields(logging.Fields{
+		"server_name": req.URL,
+		"request_id":  req.ID,
+	})
+
+	conn, ok := stream.(net.Conn)
+	if !ok {
+		logger.Error("client", "passthrough", "Stream is not a connection")
+		return
+	}
+	conn = &prefixConn{r: io.MultiReader(buffered, conn), Conn: conn}
+
+	if c.tlsConfig == nil {
+		local, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", c.localPort), 10*time.Second)
+		if err != nil {
+			logger.WithError(err).Error("client", "passthrough", "Failed to dial local TLS server")
+			return
+		}
+		bytesIn, bytesOut := splice(conn, local)
+		logger.WithFields(logging.Fields{
+			"bytes_in":  bytesIn,
+			"bytes_out": bytesOut,
+		}).Info("client", "passthrough", "TLS connection forwarded")
+		return
+	}
+
+	tlsConn := tls.Server(conn, c.tlsConfig)
+	defer tlsConn.Close()
+	if err := tlsConn.Handshake(); err != nil {
+		logger.WithError(err).Error("client", "passthrough", "TLS handshake failed")
+		return
+	}
+
+	br := bufio.NewReader(tlsConn)
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	for {
+		httpReq, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		if !c.forwardDecrypted(httpClient, tlsConn, httpReq, logger) {
+			return
+		}
+	}
+}
+
+// forwardDecrypted sends one request read from a locally terminated TLS
+// connection to the local app and writes the response back. It reports
+// whether the connection can serve another request.
+func (c *Client) forwardDecrypted(httpClient *http.Client, w io.Writer, r *http.Request, logger logging.Logger) bool {
+	start := time.Now()
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		logger.WithError(err).Error("client", "passthrough", "Failed to read request")
+		return false
+	}
+
+	localURL := fmt.Sprintf("http://127.0.0.1:%s%s", c.localPort, r.URL.RequestURI())
+	httpReq, err := http.NewRequest(r.Method, localURL, bytes.NewReader(body))
+	if err != nil {
+		logger.WithError(err).Error("client", "passthrough", "Failed to create request")
+		return false
+	}
+	httpReq.Header = r.Header.Clone()
+	httpReq.Host = r.Host
+
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		logger.WithError(err).Error("client", "passthrough", "Failed to forward request")
+		errResp := &http.Response{
+			StatusCode: http.StatusBadGateway,
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       io.NopCloser(bytes.NewReader([]byte("tunnel error"))),
+			Close:      true,
+		}
+		errResp.ContentLength = int64(len("tunnel error"))
+		errResp.Write(w)
+		return false
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.WithError(err).Error("client", "passthrough", "Failed to read response")
+		return false
+	}
+
+	durationMs := time.Since(start).Milliseconds()
+	logger.WithFields(logging.Fields{
+		"method":      r.Method,
+		"url":         r.URL.RequestURI(),
+		"status_code": resp.StatusCode,
+		"duration_ms": durationMs,
+	}).Info("client", "passthrough", "Request forwarded")
+
+	if c.logger != nil {
+		reqLog := &RequestLog{
+			Method:          r.Method,
+			URL:             r.URL.RequestURI(),
+			RequestHeaders:  firstHeaderValues(r.Header),
+			RequestBody:     body,
+			StatusCode:      resp.StatusCode,
+			ResponseHeaders: firstHeaderValues(resp.Header),
+			ResponseBody:    respBody,
+			DurationMs:      durationMs,
+		}
+		if err := c.logger.Log(reqLog); err != nil {
+			logger.WithError(err).Error("client", "passthrough", "Failed to log request")
+		}
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	resp.ContentLength = int64(len(respBody))
+	resp.TransferEncoding = nil
+	resp.Close = r.Close
+	if err := resp.Write(w); err != nil {
+		logger.WithError(err).Error("client", "passthrough", "Failed to write response")
+		return false
+	}
+	return !r.Close
+}
+
+func firstHeaderValues(h http.Header) map[string]string {
+	headers := make(map[string]string, len(h))
+	for k, v := range h {
+		if len(v) > 0 {
+			headers[k] = v[0]
+		}
+	}
+	return headers
+}
@@ -0,0 +1,259 @@
+package tunnel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Frame body encodings carried in RequestFrame.Encoding and
+// ResponseFrame.Encoding. An empty encoding means the body is sent as-is.
+const (
+	EncodingZstd = "zstd"
+	EncodingGzip = "gzip"
+)
+
+// compressionPreference lists the encodings this build supports, best first.
+var compressionPreference = []string{EncodingZstd, EncodingGzip}
+
+// compressMinSize is the smallest body worth compressing; below it the frame
+// overhead outweighs the savings.
+const compressMinSize = 1024
+
+// incompressibleTypes are content types that are already compressed.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/pdf",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// negotiateCompression picks the first of supported that the peer offered.
+func negotiateCompression(supported, offered []string) string {
+	common := intersectCapabilities(supported, offered)
+	if len(common) == 0 {
+		return ""
+	}
+	return common[0]
+}
+
+// shouldCompress reports whether a body with these headers is worth
+// compressing.
+func shouldCompress(body []byte, headers map[string]string) bool {
+	if len(body) < compressMinSize {
+		return false
+	}
+	for k, v := range headers {
+		switch strings.ToLower(k) {
+		case "content-encoding":
+			if v != "" && v != "identity" {
+				return false
+			}
+		case "content-type":
+			ct := strings.ToLower(v)
+			for _, skip := range incompressibleTypes {
+				if strings.HasPrefix(ct, skip) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// compressBody encodes body with encoding when it is worth it. It returns
+// the body to put on the wire and the encoding actually used, which is empty
+// when the body is sent as-is.
+func compressBody(encoding string, body []byte, headers map[string]string) ([]byte, string) {
+	if encoding == "" || !shouldCompress(body, headers) {
+		return body, ""
+	}
+
+	var out []byte
+	switch encoding {
+	case EncodingZstd:
+		out = zstdEncoder.EncodeAll(body, make([]byte, 0, len(body)/2))
+	case EncodingGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		zw.Close()
+		out = buf.Bytes()
+	default:
+		return body, ""
+	}
+
+	if len(out) >= len(body) {
+		return body, ""
+	}
+	return out, encoding
+}
+
+// errFrameBodyTooLarge is returned by decompressBody when a body inflates
+// past its limit.
+var errFrameBodyTooLarge = errors.New("decompressed body too large")
+
+// decompressBody reverses compressBody. Bodies that inflate to more than
+// max bytes fail with errFrameBodyTooLarge, so a small frame cannot
+// exhaust memory; max of zero or less means no limit.
+func decompressBody(encoding string, body []byte, max int64) ([]byte, error) {
+	switch encoding {
+	case "":
+		return body, nil
+	case EncodingZstd:
+		if max <= 0 {
+			out, err := zstdDecoder.DecodeAll(body, nil)
+			if err != nil {
+				return nil, fmt.Errorf("zstd decode: %w", err)
+			}
+			return out, nil
+		}
+		zr, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(max)))
+		if err != nil {
+			return nil, fmt.Errorf("zstd decode: %w", err)
+		}
+		defer zr.Close()
+		out, err := readLimited(zr, max)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+			err = errFrameBodyTooLarge
+		}
+		if err != nil {
+			return nil, fmt.Errorf("zstd decode: %w", err)
+		}
+		return out, nil
+	case EncodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("gzip decode: %w", err)
+		}
+		defer zr.Close()
+		out, err := readLimited(zr, max)
+		if err != nil {
+			return nil, fmt.Errorf("gzip decode: %w", err)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unknown frame encoding %q", encoding)
+	}
+}
+
+// readLimited reads r to the end, failing with errFrameBodyTooLarge past
+// max bytes when max is positive.
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	if max <= 0 {
+		return io.ReadAll(r)
+	}
+	out, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > max {
+		return nil, errFrameBodyTooLarge
+	}
+	return out, nil
+}
+
+// CompressionStats counts frame body bytes before and after compression.
+type CompressionStats struct {
+	rawBytes  atomic.Int64
+	wireBytes atomic.Int64
+}
+
+func (s *CompressionStats) add(raw, wire int) {
+	s.rawBytes.Add(int64(raw))
+	s.wireBytes.Add(int64(wire))
+}
+
+// Snapshot returns the current counters.
+func (s *CompressionStats) Snapshot() CompressionSnapshot {
+	snap := CompressionSnapshot{
+		RawBytes:  s.rawBytes.Load(),
+		WireBytes: s.wireBytes.Load(),
+	}
+	if snap.WireBytes > 0 {
+		snap.Ratio = float64(snap.RawBytes) / float64(snap.WireBytes)
+	}
+	return snap
+}
+
+// CompressionSnapshot is a point-in-time view of CompressionStats. Ratio is
+// raw over wire bytes, so 1 means no savings.
+type CompressionSnapshot struct {
+	Encoding  string  `json:"encoding,omitempty"`
+	RawBytes  int64   `json:"raw_bytes"`
+	WireBytes int64   `json:"wire_bytes"`
+	Ratio     float64 `json:"ratio"`
+}
+
+func (s *Server) recordCompression(sess *Session, raw, wire int) {
+	if sess.Compression == "" {
+		return
+	}
+	sess.compression.add(raw, wire)
+	s.compression.add(raw, wire)
+}
+
+// CompressionStats returns frame byte counters across every session that
+// negotiated compression.
+func (s *Server) CompressionStats() CompressionSnapshot {
+	return s.compression.Snapshot()
+}
+
+// CompressionStats returns this session's frame byte counters.
+func (sess *Session) CompressionStats() CompressionSnapshot {
+	snap := sess.compression.Snapshot()
+	snap.Encoding = sess.Compression
+	return snap
+}
+
+type CompressionStatsResponse struct {
+	Total    CompressionSnapshot            `json:"total"`
+	Sessions map[string]CompressionSnapshot `json:"sessions"`
+}
+
+// handleAdminCompression reports compression per subdomain. It names every
+// connected tunnel, so it is for admins only.
+func (s *Server) handleAdminCompression(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := CompressionStatsResponse{
+		Total:    s.CompressionStats(),
+		Sessions: map[string]CompressionSnapshot{},
+	}
+	s.mu.RLock()
+	for subdomain, sess := range s.sessions {
+		if sess.Compression != "" {
+			resp.Sessions[subdomain] = sess.CompressionStats()
+		}
+	}
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
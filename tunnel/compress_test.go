@@ -0,0 +1,164 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressBodyRoundTrip(t *testing.T) {
+	body := []byte(strings.Repeat(`{"id":1,"name":"devtunnel"},`, 200))
+
+	for _, encoding := range []string{EncodingZstd, EncodingGzip} {
+		t.Run(encoding, func(t *testing.T) {
+			wire, used := compressBody(encoding, body, map[string]string{"Content-Type": "application/json"})
+			assert.Equal(t, encoding, used)
+			assert.Less(t, len(wire), len(body))
+
+			out, err := decompressBody(used, wire, int64(len(body)))
+			require.NoError(t, err)
+			assert.Equal(t, body, out)
+		})
+	}
+}
+
+func TestCompressBodySkips(t *testing.T) {
+	large := []byte(strings.Repeat("a", 4096))
+
+	_, used := compressBody(EncodingZstd, []byte("small"), nil)
+	assert.Empty(t, used, "below threshold")
+
+	_, used = compressBody(EncodingZstd, large, map[string]string{"Content-Type": "image/png"})
+	assert.Empty(t, used, "already compressed type")
+
+	_, used = compressBody(EncodingZstd, large, map[string]string{"Content-Encoding": "br"})
+	assert.Empty(t, used, "already content-encoded")
+
+	_, used = compressBody("", large, nil)
+	assert.Empty(t, used, "compression not negotiated")
+
+	random := make([]byte, 4096)
+	rand.Read(random)
+	wire, used := compressBody(EncodingGzip, random, nil)
+	assert.Empty(t, used, "no savings")
+	assert.Equal(t, random, wire)
+}
+
+func TestDecompressBodyUnknownEncoding(t *testing.T) {
+	_, err := decompressBody("lz4", []byte("x"), 0)
+	assert.Error(t, err)
+}
+
+func TestDecompressBodyLimit(t *testing.T) {
+	body := make([]byte, 8<<20)
+
+	for _, encoding := range []string{EncodingZstd, EncodingGzip} {
+		t.Run(encoding, func(t *testing.T) {
+			wire, used := compressBody(encoding, body, nil)
+			require.Equal(t, encoding, used)
+			require.Less(t, len(wire), 64<<10, "zeros compress to a small frame")
+
+			_, err := decompressBody(used, wire, 1<<20)
+			assert.ErrorIs(t, err, errFrameBodyTooLarge)
+
+			out, err := decompressBody(used, wire, int64(len(body)))
+			require.NoError(t, err)
+			assert.Len(t, out, len(body))
+		})
+	}
+}
+
+func TestCompressedTunnelRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payload := strings.Repeat(`{"event":"order.created","amount":1200},`, 100)
+	localServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(reqBody)
+	}))
+	defer localServer.Close()
+
+	srv := startTestServer(t, ctx, ServerConfig{AdminToken: "admin-secret", Reservations: newMemReservationRepo()})
+
+	logged := make(chan *RequestLog, 1)
+	client := NewClient(ClientConfig{
+		ServerAddr: srv.Addr(),
+		LocalPort:  strings.Split(localServer.Listener.Addr().String(), ":")[1],
+		Subdomain:  "zip",
+	})
+	client.SetLogger(chanRequestLogger(logged))
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	sess := srv.GetSession("zip")
+	require.NotNil(t, sess)
+	assert.Equal(t, EncodingZstd, sess.Compression)
+
+	req, _ := http.NewRequest("POST", "http://"+srv.Addr()+"/hook", strings.NewReader(payload))
+	req.Host = "zip.test.local"
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, payload, string(body))
+
+	select {
+	case l := <-logged:
+		// the dashboard sees logical bodies, not wire bytes
+		assert.Equal(t, payload, string(l.RequestBody))
+		assert.Equal(t, payload, string(l.ResponseBody))
+	case <-time.After(time.Second):
+		t.Fatal("request was not logged")
+	}
+
+	stats := srv.CompressionStats()
+	assert.Equal(t, int64(2*len(payload)), stats.RawBytes)
+	assert.Greater(t, stats.Ratio, 1.0)
+	assert.Greater(t, client.CompressionStats().Ratio, 1.0)
+
+	statsURL := "http://" + srv.Addr() + "/api/admin/compression"
+	statsResp, err := http.Get(statsURL)
+	require.NoError(t, err)
+	statsResp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, statsResp.StatusCode)
+
+	statsReq, _ := http.NewRequest("GET", statsURL, nil)
+	statsReq.Header.Set("Authorization", "Bearer admin-secret")
+	statsResp, err = http.DefaultClient.Do(statsReq)
+	require.NoError(t, err)
+	defer statsResp.Body.Close()
+	var apiStats CompressionStatsResponse
+	require.NoError(t, json.NewDecoder(statsResp.Body).Decode(&apiStats))
+	assert.Equal(t, EncodingZstd, apiStats.Sessions["zip"].Encoding)
+	assert.Equal(t, stats.WireBytes, apiStats.Total.WireBytes)
+}
+
+func TestCompressionDisabledByClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "plain", DisableCompression: true})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	sess := srv.GetSession("plain")
+	require.NotNil(t, sess)
+	assert.Empty(t, sess.Compression)
+	assert.False(t, client.HasCapability(CapCompression))
+}
@@ -0,0 +1,180 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+const rateLimitWarnInterval = 10 * time.Second
+
+// controlChannel is the long-lived stream kept open after the handshake.
+type controlChannel struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	enc    *json.Encoder
+	dec    *json.Decoder
+	closed chan struct{}
+	once   sync.Once
+
+	latencyMs     atomic.Int64
+	lastPongAt    atomic.Int64
+	lastRateLimit atomic.Int64
+}
+
+func newControlChannel(conn net.Conn, dec *json.Decoder) *controlChannel {
+	return &controlChannel{
+		conn:   conn,
+		enc:    json.NewEncoder(conn),
+		dec:    dec,
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *controlChannel) send(msg ControlMessage) error {
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().UnixMilli()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	defer c.conn.SetWriteDeadline(time.Time{})
+	return c.enc.Encode(&msg)
+}
+
+func (c *controlChannel) close() {
+	c.once.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// Send delivers a control message to the client behind this session.
+func (sess *Session) Send(msg ControlMessage) error {
+	if sess.control == nil {
+		return fmt.Errorf("control channel not negotiated")
+	}
+	return sess.control.send(msg)
+}
+
+// Latency returns the last measured control channel round trip.
+func (sess *Session) Latency() time.Duration {
+	if sess.control == nil {
+		return 0
+	}
+	return time.Duration(sess.control.latencyMs.Load()) * time.Millisecond
+}
+
+// Notify sends a control message to the client connected on subdomain.
+func (s *Server) Notify(subdomain string, msg ControlMessage) error {
+	sess := s.GetSession(subdomain)
+	if sess == nil {
+		return fmt.Errorf("tunnel not found")
+	}
+	return sess.Send(msg)
+}
+
+// Broadcast sends a control message to every connected client.
+func (s *Server) Broadcast(msg ControlMessage) {
+	sessions := s.allSessions()
+
+	for _, sess := range sessions {
+		if err := sess.Send(msg); err != nil && sess.control != nil {
+			s.logger.WithError(err).WithFields(logging.Fields{"subdomain": sess.Subdomain, "type": msg.Type}).Warn("server", "control", "Control send failed")
+		}
+	}
+}
+
+// CloseSession tells the client why it is being disconnected, then closes
+// its session.
+func (s *Server) CloseSession(subdomain, reason string) error {
+	sess := s.GetSession(subdomain)
+	if sess == nil {
+		return fmt.Errorf("tunnel not found")
+	}
+	sess.Send(ControlMessage{Type: ControlClose, Message: reason})
+	s.logger.WithFields(logging.Fields{"subdomain": subdomain, "reason": reason}).Info("server", "control", "Session closed by server")
+	return sess.Session.Close()
+}
+
+func (s *Server) startControl(sess *Session) {
+	limits := map[string]string{}
+	if s.rateLimiter != nil {
+		plan := s.rateLimiter.Plan(sess.Tenant)
+		limits["requests_per_min"] = strconv.Itoa(plan.RequestsPerMin)
+		limits["max_concurrent_conns"] = strconv.Itoa(plan.MaxConns)
+		limits["burst"] = strconv.Itoa(plan.Burst)
+		limits["rate_plan"] = plan.Name
+	}
+	s.limitsConfig(sess, limits)
+	sess.Send(ControlMessage{Type: ControlConfig, Config: limits})
+
+	go s.readControl(sess)
+	go s.pingControl(sess)
+}
+
+func (s *Server) readControl(sess *Session) {
+	defer sess.control.close()
+	for {
+		var msg ControlMessage
+		if err := sess.control.dec.Decode(&msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case ControlPong:
+			now := time.Now().UnixMilli()
+			latency := now - msg.Timestamp
+			sess.control.latencyMs.Store(latency)
+			sess.control.lastPongAt.Store(now)
+			s.logger.WithFields(logging.Fields{"subdomain": sess.Subdomain, "latency_ms": latency}).Debug("server", "control", "Pong received")
+		case ControlHealth:
+			sess.SetHealthy(msg.Status == UpstreamUp)
+			s.logger.WithFields(logging.Fields{"subdomain": sess.Subdomain, "member": sess.ID, "upstream": msg.Status}).Info("server", "control", "Upstream health changed")
+		default:
+			s.logger.WithFields(logging.Fields{"subdomain": sess.Subdomain, "type": msg.Type}).Debug("server", "control", "Control message received")
+		}
+	}
+}
+
+func (s *Server) pingControl(sess *Session) {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sess.control.closed:
+			return
+		case <-ticker.C:
+			msg := ControlMessage{Type: ControlPing, LatencyMs: sess.control.latencyMs.Load()}
+			if err := sess.Send(msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// warnRateLimited tells the client its tunnel is being throttled and why,
+// at most once per rateLimitWarnInterval.
+func (s *Server) warnRateLimited(subdomain, message string, retryAfter int) {
+	sess := s.GetSession(subdomain)
+	if sess == nil || sess.control == nil {
+		return
+	}
+	now := time.Now().UnixMilli()
+	last := sess.control.lastRateLimit.Load()
+	if now-last < rateLimitWarnInterval.Milliseconds() || !sess.control.lastRateLimit.CompareAndSwap(last, now) {
+		return
+	}
+	sess.Send(ControlMessage{
+		Type:         ControlRateLimit,
+		Message:      message,
+		RetryAfterMs: int64(retryAfter) * 1000,
+	})
+}
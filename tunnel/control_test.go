@@ -0,0 +1,171 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func connectControlClient(t *testing.T, ctx context.Context, srv *Server, subdomain string) (*Client, chan ControlMessage) {
+	msgs := make(chan ControlMessage, 10)
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: subdomain})
+	client.SetReconnect(false)
+	client.OnControl(func(msg ControlMessage) { msgs <- msg })
+	require.NoError(t, client.Connect(ctx))
+	return client, msgs
+}
+
+func waitControl(t *testing.T, msgs chan ControlMessage, msgType string) ControlMessage {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-msgs:
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no %s control message received", msgType)
+		}
+	}
+}
+
+func TestControlConfigOnConnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{RequestsPerMin: 42})
+
+	client, msgs := connectControlClient(t, ctx, srv, "cfg")
+	defer client.Close()
+
+	msg := waitControl(t, msgs, ControlConfig)
+	assert.Equal(t, "42", msg.Config["requests_per_min"])
+}
+
+func TestControlNotify(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	client, msgs := connectControlClient(t, ctx, srv, "notify")
+	defer client.Close()
+
+	require.NoError(t, srv.Notify("notify", ControlMessage{Type: ControlNotice, Message: "maintenance at 22:00"}))
+	msg := waitControl(t, msgs, ControlNotice)
+	assert.Equal(t, "maintenance at 22:00", msg.Message)
+	assert.NotZero(t, msg.Timestamp)
+
+	assert.Error(t, srv.Notify("missing", ControlMessage{Type: ControlNotice}))
+}
+
+func TestControlPingMeasuresLatency(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{PingInterval: 20 * time.Millisecond})
+
+	client, _ := connectControlClient(t, ctx, srv, "ping")
+	defer client.Close()
+
+	sess := srv.GetSession("ping")
+	require.NotNil(t, sess)
+	require.Eventually(t, func() bool {
+		return sess.control.lastPongAt.Load() > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, sess.Latency(), time.Duration(0))
+}
+
+func TestControlCloseSessionSendsReason(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	client, msgs := connectControlClient(t, ctx, srv, "kick")
+	defer client.Close()
+
+	require.NoError(t, srv.CloseSession("kick", "token revoked"))
+	msg := waitControl(t, msgs, ControlClose)
+	assert.Equal(t, "token revoked", msg.Message)
+
+	require.Eventually(t, func() bool { return !client.IsConnected() }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestControlRateLimitWarning(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{RequestsPerMin: 1})
+
+	client, msgs := connectControlClient(t, ctx, srv, "busy")
+	defer client.Close()
+
+	srv.rateLimiter.AllowRequest(srv.GetSession("busy").Tenant)
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		srv.handleProxy(w, httptest.NewRequest(http.MethodGet, "/proxy/busy/", nil))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	}
+
+	msg := waitControl(t, msgs, ControlRateLimit)
+	assert.Greater(t, msg.RetryAfterMs, int64(0))
+
+	select {
+	case extra := <-msgs:
+		assert.NotEqual(t, ControlRateLimit, extra.Type, "warnings should be throttled")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestControlMessagesLoggedByClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	var mu sync.Mutex
+	var buf bytes.Buffer
+	logger := logging.NewLogger(logging.LoggerConfig{
+		Output:    &lockedWriter{mu: &mu, w: &buf},
+		Formatter: &logging.JSONFormatter{},
+		Level:     logging.DEBUG,
+	})
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "logged", Logger: logger})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	require.NoError(t, srv.Notify("logged", ControlMessage{Type: ControlShutdown, Message: "going down", RetryAfterMs: 500}))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Contains(buf.String(), "going down")
+	}, 2*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	assert.Contains(t, buf.String(), `"retry_after_ms":500`)
+	mu.Unlock()
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  *bytes.Buffer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,129 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainWaitsForInflightRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	localServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+	defer localServer.Close()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	client := NewClient(ClientConfig{
+		ServerAddr: srv.Addr(),
+		LocalPort:  strings.Split(localServer.Listener.Addr().String(), ":")[1],
+		Subdomain:  "slow",
+	})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://"+srv.Addr()+"/work", nil)
+		req.Host = "slow.test.local"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		results <- result{body: string(body)}
+	}()
+
+	require.Eventually(t, func() bool { return srv.inflight.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer drainCancel()
+	srv.Drain(drainCtx)
+
+	assert.True(t, srv.Draining())
+	assert.Equal(t, int64(0), srv.inflight.Load())
+
+	r := <-results
+	require.NoError(t, r.err)
+	assert.Equal(t, "done", r.body)
+}
+
+func TestDrainRejectsNewHandshakes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	srv.draining.Store(true)
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000"})
+	client.SetReconnect(false)
+	err := client.Connect(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server draining")
+}
+
+func TestDrainSendsReconnectHint(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	hints := make(chan ControlMessage, 4)
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000"})
+	client.SetReconnect(false)
+	client.OnControl(func(msg ControlMessage) {
+		if msg.Type == ControlReconnect {
+			hints <- msg
+		}
+	})
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Second)
+	defer drainCancel()
+	srv.Drain(drainCtx)
+
+	select {
+	case msg := <-hints:
+		assert.Equal(t, int64(1000), msg.RetryAfterMs)
+	case <-time.After(time.Second):
+		t.Fatal("reconnect hint not received")
+	}
+}
+
+func TestListenReusePort(t *testing.T) {
+	if !reusePortSupported {
+		t.Skip("SO_REUSEPORT not supported on this platform")
+	}
+
+	srv := NewServer(ServerConfig{Addr: "127.0.0.1:0", Domain: "test.local", ReusePort: true})
+
+	first, err := srv.listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := srv.listen(first.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+
+	assert.Equal(t, first.Addr().String(), second.Addr().String())
+}
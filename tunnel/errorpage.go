@@ -0,0 +1,138 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+// HeaderTunnelError marks a response frame the client produced itself
+// instead of its local app, naming one of the Error* reasons.
+const HeaderTunnelError = "X-Devtunnel-Error"
+
+// Reasons reported on error pages and in their JSON variant.
+const (
+	ErrorTunnelOffline      = "tunnel_offline"
+	ErrorTunnelReconnecting = "tunnel_reconnecting"
+	ErrorUpstreamDown       = "upstream_down"
+	ErrorUpstreamTimeout    = "upstream_timeout"
+	ErrorBodyTooLarge       = "body_too_large"
+	ErrorTunnelFailed       = "tunnel_error"
+)
+
+var errorMessages = map[string]struct{ title, message string }{
+	ErrorTunnelOffline: {
+		"Tunnel offline",
+		"No devtunnel client is connected for this address. If you own it, start the client again.",
+	},
+	ErrorTunnelReconnecting: {
+		"Tunnel reconnecting",
+		"The devtunnel client dropped and is expected back shortly. Try again in a moment.",
+	},
+	ErrorUpstreamDown: {
+		"Local app not responding",
+		"The tunnel is connected, but the app behind it is not running or refused the connection.",
+	},
+	ErrorUpstreamTimeout: {
+		"Local app timed out",
+		"The tunnel is connected, but the app behind it took too long to answer.",
+	},
+	ErrorBodyTooLarge: {
+		"Request too large",
+		"The request body is larger than this tunnel accepts.",
+	},
+	ErrorTunnelFailed: {
+		"Tunnel error",
+		"The request could not be carried through the tunnel.",
+	},
+}
+
+// ErrorPageData is rendered by error.html, or encoded as JSON for clients
+// that do not ask for HTML.
+type ErrorPageData struct {
+	Status     int    `json:"status"`
+	Reason     string `json:"reason"`
+	Title      string `json:"-"`
+	Message    string `json:"error"`
+	Subdomain  string `json:"subdomain,omitempty"`
+	TraceID    string `json:"trace_id,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// loadServerTemplates parses the embedded templates, then any *.html files
+// in overridesDir, which replace embedded templates of the same name.
+func loadServerTemplates(overridesDir string, logger logging.Logger) *template.Template {
+	tmpl, err := template.ParseFS(serverTemplates, "templates/*.html")
+	if err != nil {
+		logger.WithError(err).Error("server", "template", "Parse embedded templates failed")
+		return nil
+	}
+	if overridesDir == "" {
+		return tmpl
+	}
+	if info, err := os.Stat(overridesDir); err != nil || !info.IsDir() {
+		return tmpl
+	}
+	matches, _ := filepath.Glob(filepath.Join(overridesDir, "*.html"))
+	if len(matches) == 0 {
+		return tmpl
+	}
+
+	overridden, err := tmpl.Clone()
+	if err == nil {
+		_, err = overridden.ParseFiles(matches...)
+	}
+	if err != nil {
+		logger.WithError(err).WithFields(logging.Fields{"dir": overridesDir}).Warn("server", "template", "Template overrides ignored")
+		return tmpl
+	}
+	logger.WithFields(logging.Fields{"dir": overridesDir, "count": len(matches)}).Info("server", "template", "Template overrides loaded")
+	return overridden
+}
+
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// writeErrorPage answers a request the tunnel could not serve: a branded
+// page for browsers and JSON for everyone else.
+func (s *Server) writeErrorPage(w http.ResponseWriter, r *http.Request, status int, reason, subdomain, traceID string) {
+	text, ok := errorMessages[reason]
+	if !ok {
+		text = errorMessages[ErrorTunnelFailed]
+	}
+	data := ErrorPageData{
+		Status:    status,
+		Reason:    reason,
+		Title:     text.title,
+		Message:   text.message,
+		Subdomain: subdomain,
+		TraceID:   traceID,
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err == nil {
+		data.RetryAfter = retry
+	}
+	if traceID != "" {
+		w.Header().Set("X-Trace-ID", traceID)
+	}
+	w.Header().Set(HeaderTunnelError, reason)
+
+	if wantsHTML(r) && s.templates != nil && s.templates.Lookup("error.html") != nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		if err := s.templates.ExecuteTemplate(w, "error.html", data); err != nil {
+			s.logger.WithError(err).WithFields(logging.Fields{"template": "error.html"}).Error("server", "template", "Render failed")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,160 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getWithAccept(t *testing.T, srv *Server, subdomain, accept string) (*http.Response, string) {
+	req, _ := http.NewRequest("GET", "http://"+srv.Addr()+"/", nil)
+	req.Host = subdomain + ".test.local"
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+// freePort returns a local port with nothing listening on it.
+func freePort(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := strings.Split(ln.Addr().String(), ":")[1]
+	ln.Close()
+	return port
+}
+
+func TestErrorPageTunnelOffline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	resp, body := getWithAccept(t, srv, "ghost", "text/html,application/xhtml+xml")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+	assert.Equal(t, ErrorTunnelOffline, resp.Header.Get(HeaderTunnelError))
+	assert.Contains(t, body, "Tunnel offline")
+	assert.Contains(t, body, "ghost")
+
+	resp, body = getWithAccept(t, srv, "ghost", "application/json")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var data ErrorPageData
+	require.NoError(t, json.Unmarshal([]byte(body), &data))
+	assert.Equal(t, ErrorTunnelOffline, data.Reason)
+	assert.Equal(t, "ghost", data.Subdomain)
+	assert.Equal(t, http.StatusBadGateway, data.Status)
+}
+
+func TestErrorPageUpstreamDown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	port := freePort(t)
+	client := NewClient(ClientConfig{
+		ServerAddr:     srv.Addr(),
+		LocalPort:      port,
+		Subdomain:      "napping",
+		HealthInterval: 50 * time.Millisecond,
+	})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	sess := srv.GetSession("napping")
+	require.NotNil(t, sess)
+	require.Eventually(t, func() bool { return !sess.Healthy() }, 2*time.Second, 20*time.Millisecond)
+	assert.False(t, client.UpstreamHealthy())
+
+	resp, body := getWithAccept(t, srv, "napping", "text/html")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, ErrorUpstreamDown, resp.Header.Get(HeaderTunnelError))
+	assert.NotEmpty(t, resp.Header.Get("X-Trace-ID"))
+	assert.Contains(t, body, "Local app not responding")
+
+	resp, body = getWithAccept(t, srv, "napping", "")
+	var data ErrorPageData
+	require.NoError(t, json.Unmarshal([]byte(body), &data))
+	assert.Equal(t, ErrorUpstreamDown, data.Reason)
+	assert.Equal(t, resp.Header.Get("X-Trace-ID"), data.TraceID)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:"+port)
+	require.NoError(t, err)
+	defer ln.Close()
+	require.Eventually(t, sess.Healthy, 2*time.Second, 20*time.Millisecond)
+	assert.True(t, client.UpstreamHealthy())
+}
+
+func TestErrorPageNotForgedByLocalApp(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderTunnelError, ErrorTunnelOffline)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("app maintenance"))
+	}))
+	defer local.Close()
+
+	client := NewClient(ClientConfig{
+		ServerAddr: srv.Addr(),
+		LocalPort:  strings.Split(local.Listener.Addr().String(), ":")[1],
+		Subdomain:  "forger",
+	})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	resp, body := getWithAccept(t, srv, "forger", "text/html")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(HeaderTunnelError))
+	assert.Equal(t, "app maintenance", body)
+}
+
+func TestErrorPageOverrides(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	custom := `<h1>Acme tunnels: {{.Title}} ({{.Reason}})</h1>`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "error.html"), []byte(custom), 0644))
+
+	srv := startTestServer(t, ctx, ServerConfig{OverridesDir: dir})
+
+	resp, body := getWithAccept(t, srv, "ghost", "text/html")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, "<h1>Acme tunnels: Tunnel offline (tunnel_offline)</h1>", body)
+
+	// templates that are not overridden keep working
+	assert.NotNil(t, srv.templates.Lookup("shared.html"))
+}
+
+func TestErrorPageBadOverrideFallsBack(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "error.html"), []byte(`{{.Broken`), 0644))
+
+	tmpl := loadServerTemplates(dir, logging.NopLogger{})
+	require.NotNil(t, tmpl)
+	assert.NotNil(t, tmpl.Lookup("error.html"))
+}
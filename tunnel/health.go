@@ -0,0 +1,100 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+const (
+	defaultHealthInterval = 10 * time.Second
+	healthProbeTimeout    = 2 * time.Second
+)
+
+// probeUpstream checks the local app once: a TCP dial when no health path
+// is set, otherwise a GET that must not answer with a 5xx.
+func (c *Client) probeUpstream(ctx context.Context) error {
+	addr := net.JoinHostPort("127.0.0.1", c.localPort)
+	if c.healthPath == "" {
+		conn, err := net.DialTimeout("tcp", addr, healthProbeTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+c.healthPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("health check returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// UpstreamHealthy reports the result of the last health check of the
+// local app. It is true until a check fails.
+func (c *Client) UpstreamHealthy() bool {
+	return !c.upstreamDown.Load()
+}
+
+// checkUpstreamSoon asks the health loop for an immediate probe, used when
+// forwarding a request fails.
+func (c *Client) checkUpstreamSoon() {
+	select {
+	case c.healthProbe <- struct{}{}:
+	default:
+	}
+}
+
+// watchUpstream probes the local app every healthInterval and reports it to
+// the server over control whenever it goes up or down. The first result is
+// always reported so the server starts from the client's view.
+func (c *Client) watchUpstream(ctx context.Context, control *controlChannel) {
+	ticker := time.NewTicker(c.healthInterval)
+	defer ticker.Stop()
+
+	reported := ""
+	for {
+		status := UpstreamUp
+		err := c.probeUpstream(ctx)
+		if err != nil {
+			status = UpstreamDown
+		}
+		c.upstreamDown.Store(status == UpstreamDown)
+
+		if status != reported {
+			logger := c.log.WithFields(logging.Fields{"local_port": c.localPort, "health_path": c.healthPath})
+			if status == UpstreamDown {
+				logger.WithError(err).Warn("client", "health", "Local app is down")
+			} else if reported != "" {
+				logger.Info("client", "health", "Local app is back up")
+			}
+			if err := control.send(ControlMessage{Type: ControlHealth, Status: status}); err != nil {
+				return
+			}
+			reported = status
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-control.closed:
+			return
+		case <-ticker.C:
+		case <-c.healthProbe:
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+// Headers added to requests delivered from the offline inbox. Like every
+// header under tunnelHeaderPrefix, they are removed from visitors' requests.
+const (
+	HeaderDelayed    = "X-Devtunnel-Delayed"
+	HeaderReceivedAt = "X-Devtunnel-Received-At"
+)
+
+// tunnelHeaderPrefix is the canonical prefix of the tunnel's own headers.
+const tunnelHeaderPrefix = "X-Devtunnel-"
+
+// Reservation pins a subdomain to whoever holds its token. With Inbox set,
+// requests arriving while no client is connected are queued and answered
+// with InboxStatus. SkipWarning exempts the subdomain from the browser
+// interstitial.
+type Reservation struct {
+	Subdomain   string
+	TokenHash   string
+	Inbox       bool
+	InboxStatus int
+	SkipWarning bool
+	CreatedAt   int64
+}
+
+type ReservationRepo interface {
+	Save(res *Reservation) error
+	Get(subdomain string) (*Reservation, error)
+	List() ([]*Reservation, error)
+	Delete(subdomain string) error
+}
+
+type InboxItem struct {
+	ID         string
+	Subdomain  string
+	Method     string
+	URL        string
+	Headers    map[string]string
+	Body       []byte
+	TraceID    string
+	ReceivedAt int64
+	ExpiresAt  int64
+}
+
+type InboxStats struct {
+	Subdomain string `json:"subdomain"`
+	Count     int    `json:"count"`
+	Bytes     int64  `json:"bytes"`
+}
+
+type InboxRepo interface {
+	Save(item *InboxItem) error
+	List(subdomain string) ([]*InboxItem, error)
+	Delete(id string) error
+	DeleteBySubdomain(subdomain string) error
+	Stats(subdomain string) (*InboxStats, error)
+	ListStats() ([]*InboxStats, error)
+	Prune() (int64, error)
+}
+
+// InboxLimits bounds what the offline inbox keeps per subdomain.
+type InboxLimits struct {
+	MaxItems     int
+	MaxBytes     int64
+	MaxBodyBytes int64
+	TTL          time.Duration
+}
+
+var defaultInboxLimits = InboxLimits{
+	MaxItems:     100,
+	MaxBytes:     10 << 20,
+	MaxBodyBytes: 1 << 20,
+	TTL:          24 * time.Hour,
+}
+
+func (l InboxLimits) withDefaults() InboxLimits {
+	if l.MaxItems <= 0 {
+		l.MaxItems = defaultInboxLimits.MaxItems
+	}
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = defaultInboxLimits.MaxBytes
+	}
+	if l.MaxBodyBytes <= 0 {
+		l.MaxBodyBytes = defaultInboxLimits.MaxBodyBytes
+	}
+	if l.TTL <= 0 {
+		l.TTL = defaultInboxLimits.TTL
+	}
+	return l
+}
+
+// HashToken returns the form of a reservation token stored on the server.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateReservationToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// checkReservation reports whether req asks for a reserved subdomain. An
+// error message is returned when the subdomain is reserved for someone else.
+func (s *Server) checkReservation(req *HandshakeRequest) (bool, string) {
+	if s.reservations == nil || req.Subdomain == "" {
+		return false, ""
+	}
+	res, err := s.reservations.Get(req.Subdomain)
+	if err != nil {
+		s.logger.WithError(err).WithFields(logging.Fields{"subdomain": req.Subdomain}).Error("server", "connect", "Reservation lookup failed")
+		return false, "reservation lookup failed"
+	}
+	if res == nil {
+		return false, ""
+	}
+	if req.AuthToken == "" || !tokenMatches(res.TokenHash, HashToken(req.AuthToken)) {
+		return false, fmt.Sprintf("subdomain %s is reserved; connect with its token", req.Subdomain)
+	}
+	return true, ""
+}
+
+// queueInbox stores r in the offline inbox when subdomain is reserved with
+// an inbox. It reports whether a response was written.
+func (s *Server) queueInbox(w http.ResponseWriter, r *http.Request, subdomain, targetPath string) bool {
+	if s.reservations == nil || s.inbox == nil {
+		return false
+	}
+	res, err := s.reservations.Get(subdomain)
+	if err != nil || res == nil || !res.Inbox {
+		return false
+	}
+
+	logger := s.logger.WithFields(logging.Fields{"subdomain": subdomain, "method": r.Method, "path": targetPath})
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.inboxLimits.MaxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeServerJSONError(w, "request too large for offline inbox", http.StatusRequestEntityTooLarge)
+			return true
+		}
+		writeServerJSONError(w, "read body failed", http.StatusBadRequest)
+		return true
+	}
+
+	headers := make(map[string]string)
+	for k, v := range r.Header {
+		if len(v) > 0 {
+			headers[k] = v[0]
+		}
+	}
+	now := time.Now()
+	item := &InboxItem{
+		Subdomain:  subdomain,
+		Method:     r.Method,
+		URL:        targetPath,
+		Headers:    headers,
+		Body:       body,
+		TraceID:    r.Header.Get("X-Trace-ID"),
+		ReceivedAt: now.UnixMilli(),
+		ExpiresAt:  now.Add(s.inboxLimits.TTL).UnixMilli(),
+	}
+	// held from the limit check to the save, so concurrent requests
+	// cannot all pass the check and overfill the inbox
+	s.inboxMu.Lock()
+	s.inbox.Prune()
+	stats, err := s.inbox.Stats(subdomain)
+	if err != nil {
+		s.inboxMu.Unlock()
+		logger.WithError(err).Error("server", "inbox", "Inbox stats failed")
+		writeServerJSONError(w, "inbox unavailable", http.StatusServiceUnavailable)
+		return true
+	}
+	if stats.Count >= s.inboxLimits.MaxItems || stats.Bytes+int64(len(body)) > s.inboxLimits.MaxBytes {
+		s.inboxMu.Unlock()
+		logger.WithFields(logging.Fields{"count": stats.Count, "bytes": stats.Bytes}).Warn("server", "inbox", "Inbox full")
+		writeServerJSONError(w, "offline inbox full", http.StatusInsufficientStorage)
+		return true
+	}
+	err = s.inbox.Save(item)
+	s.inboxMu.Unlock()
+	if err != nil {
+		logger.WithError(err).Error("server", "inbox", "Inbox save failed")
+		writeServerJSONError(w, "inbox unavailable", http.StatusServiceUnavailable)
+		return true
+	}
+
+	logger.WithFields(logging.Fields{"id": item.ID, "bytes": len(body)}).Info("server", "inbox", "Request queued in inbox")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(res.InboxStatus)
+	json.NewEncoder(w).Encode(map[string]any{"queued": true, "id": item.ID})
+	return true
+}
+
+// inboxRecorder captures the status of a delivered inbox request; the
+// original sender is long gone, so the response body is discarded.
+type inboxRecorder struct {
+	header http.Header
+	status int
+}
+
+func (rec *inboxRecorder) Header() http.Header { return rec.header }
+
+func (rec *inboxRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (rec *inboxRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+// undelivered reports whether the tunnel, rather than the local app,
+// answered with a server error: the stream failed, the client timed out or
+// the app could not be reached. Such requests are kept to try again.
+func (rec *inboxRecorder) undelivered() bool {
+	return rec.status >= 500 && rec.header.Get(HeaderTunnelError) != ""
+}
+
+// deliverInbox replays queued requests to sess in arrival order. Delivery
+// stops at the first request the tunnel cannot carry; the rest stay queued
+// for the next connection.
+func (s *Server) deliverInbox(sess *Session) {
+	if s.inbox == nil {
+		return
+	}
+	if _, busy := s.delivering.LoadOrStore(sess.Subdomain, true); busy {
+		return
+	}
+	defer s.delivering.Delete(sess.Subdomain)
+
+	items, err := s.inbox.List(sess.Subdomain)
+	if err != nil {
+		s.logger.WithError(err).WithFields(logging.Fields{"subdomain": sess.Subdomain}).Error("server", "inbox", "Inbox list failed")
+		return
+	}
+
+	for _, item := range items {
+		req, err := http.NewRequest(item.Method, "http://"+sess.Subdomain+item.URL, bytes.NewReader(item.Body))
+		if err != nil {
+			s.inbox.Delete(item.ID)
+			continue
+		}
+		for k, v := range item.Headers {
+			req.Header.Set(k, v)
+		}
+
+		rec := &inboxRecorder{header: http.Header{}}
+		s.proxyToTunnel(rec, req, sess, item.URL, item.ReceivedAt)
+
+		logger := s.logger.WithFields(logging.Fields{
+			"subdomain":  sess.Subdomain,
+			"id":         item.ID,
+			"status":     rec.status,
+			"delayed_ms": time.Now().UnixMilli() - item.ReceivedAt,
+		})
+		if rec.undelivered() {
+			logger.WithFields(logging.Fields{"reason": rec.header.Get(HeaderTunnelError)}).Warn("server", "inbox", "Inbox delivery failed, keeping remaining requests")
+			return
+		}
+		if err := s.inbox.Delete(item.ID); err != nil {
+			logger.WithError(err).Error("server", "inbox", "Inbox delete failed")
+		}
+		logger.Info("server", "inbox", "Inbox request delivered")
+	}
+}
@@ -0,0 +1,447 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memReservationRepo struct {
+	mu   sync.Mutex
+	byID map[string]*Reservation
+}
+
+func newMemReservationRepo() *memReservationRepo {
+	return &memReservationRepo{byID: make(map[string]*Reservation)}
+}
+
+func (m *memReservationRepo) Save(res *Reservation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.byID[res.Subdomain] = res
+	return nil
+}
+
+func (m *memReservationRepo) Get(subdomain string) (*Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.byID[subdomain], nil
+}
+
+func (m *memReservationRepo) List() ([]*Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*Reservation
+	for _, res := range m.byID {
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+func (m *memReservationRepo) Delete(subdomain string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.byID, subdomain)
+	return nil
+}
+
+type memInboxRepo struct {
+	mu    sync.Mutex
+	items map[string]*InboxItem
+}
+
+func newMemInboxRepo() *memInboxRepo {
+	return &memInboxRepo{items: make(map[string]*InboxItem)}
+}
+
+func (m *memInboxRepo) Save(item *InboxItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if item.ID == "" {
+		item.ID = ulid.Make().String()
+	}
+	m.items[item.ID] = item
+	return nil
+}
+
+func (m *memInboxRepo) List(subdomain string) ([]*InboxItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*InboxItem
+	for _, item := range m.items {
+		if item.Subdomain == subdomain {
+			out = append(out, item)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (m *memInboxRepo) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, id)
+	return nil
+}
+
+func (m *memInboxRepo) DeleteBySubdomain(subdomain string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, item := range m.items {
+		if item.Subdomain == subdomain {
+			delete(m.items, id)
+		}
+	}
+	return nil
+}
+
+func (m *memInboxRepo) Stats(subdomain string) (*InboxStats, error) {
+	items, _ := m.List(subdomain)
+	stats := &InboxStats{Subdomain: subdomain, Count: len(items)}
+	for _, item := range items {
+		stats.Bytes += int64(len(item.Body))
+	}
+	return stats, nil
+}
+
+func (m *memInboxRepo) ListStats() ([]*InboxStats, error) {
+	m.mu.Lock()
+	seen := map[string]bool{}
+	for _, item := range m.items {
+		seen[item.Subdomain] = true
+	}
+	m.mu.Unlock()
+
+	var out []*InboxStats
+	for subdomain := range seen {
+		st, _ := m.Stats(subdomain)
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+func (m *memInboxRepo) Prune() (int64, error) {
+	return 0, nil
+}
+
+func startInboxServer(t *testing.T, ctx context.Context, limits InboxLimits) (*Server, *memReservationRepo, *memInboxRepo) {
+	reservations := newMemReservationRepo()
+	inbox := newMemInboxRepo()
+	srv := startTestServer(t, ctx, ServerConfig{
+		Reservations: reservations,
+		Inbox:        inbox,
+		InboxLimits:  limits,
+		AdminToken:   "admin-secret",
+	})
+	return srv, reservations, inbox
+}
+
+func TestReservedSubdomainRequiresToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, reservations, _ := startInboxServer(t, ctx, InboxLimits{})
+	reservations.Save(&Reservation{Subdomain: "hooks", TokenHash: HashToken("right")})
+
+	intruder := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "hooks", AuthToken: "wrong"})
+	intruder.SetReconnect(false)
+	err := intruder.Connect(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reserved")
+
+	owner := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "hooks", AuthToken: "right"})
+	owner.SetReconnect(false)
+	require.NoError(t, owner.Connect(ctx))
+	defer owner.Close()
+	assert.Equal(t, "http://hooks.test.local", owner.PublicURL())
+}
+
+func TestInboxQueuesAndDeliversOnConnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type delivered struct {
+		path    string
+		body    string
+		delayed string
+	}
+	got := make(chan delivered, 2)
+	localServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, r.ContentLength)
+		r.Body.Read(body)
+		got <- delivered{path: r.URL.Path, body: string(body), delayed: r.Header.Get(HeaderDelayed)}
+	}))
+	defer localServer.Close()
+
+	srv, reservations, inbox := startInboxServer(t, ctx, InboxLimits{})
+	reservations.Save(&Reservation{Subdomain: "hooks", TokenHash: HashToken("tok"), Inbox: true, InboxStatus: http.StatusAccepted})
+
+	for _, path := range []string{"/first", "/second"} {
+		req, _ := http.NewRequest("POST", "http://"+srv.Addr()+path, strings.NewReader(`{"n":"`+path+`"}`))
+		req.Host = "hooks.test.local"
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	}
+	stats, _ := inbox.Stats("hooks")
+	assert.Equal(t, 2, stats.Count)
+
+	logged := make(chan *RequestLog, 2)
+	client := NewClient(ClientConfig{
+		ServerAddr: srv.Addr(),
+		LocalPort:  strings.Split(localServer.Listener.Addr().String(), ":")[1],
+		Subdomain:  "hooks",
+		AuthToken:  "tok",
+	})
+	client.SetLogger(chanRequestLogger(logged))
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	for _, want := range []string{"/first", "/second"} {
+		select {
+		case d := <-got:
+			assert.Equal(t, want, d.path)
+			assert.Equal(t, `{"n":"`+want+`"}`, d.body)
+			assert.Equal(t, "true", d.delayed)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s was not delivered", want)
+		}
+		l := <-logged
+		assert.NotZero(t, l.ReceivedAt)
+	}
+
+	require.Eventually(t, func() bool {
+		stats, _ := inbox.Stats("hooks")
+		return stats.Count == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestInboxKeepsRequestsTheTunnelCouldNotDeliver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slowSeen := make(chan struct{}, 1)
+	release := make(chan struct{})
+	localServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			slowSeen <- struct{}{}
+			<-release
+		}
+		// the app's own errors count as delivered
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer localServer.Close()
+	defer close(release)
+
+	reservations := newMemReservationRepo()
+	inbox := newMemInboxRepo()
+	srv := startTestServer(t, ctx, ServerConfig{
+		Reservations: reservations,
+		Inbox:        inbox,
+		Limits:       ServerLimits{UpstreamTimeout: 200 * time.Millisecond},
+	})
+	reservations.Save(&Reservation{Subdomain: "hooks", TokenHash: HashToken("tok"), Inbox: true, InboxStatus: http.StatusAccepted})
+
+	for _, path := range []string{"/broken", "/slow"} {
+		req, _ := http.NewRequest("POST", "http://"+srv.Addr()+path, strings.NewReader("{}"))
+		req.Host = "hooks.test.local"
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	client := NewClient(ClientConfig{
+		ServerAddr: srv.Addr(),
+		LocalPort:  strings.Split(localServer.Listener.Addr().String(), ":")[1],
+		Subdomain:  "hooks",
+		AuthToken:  "tok",
+	})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	select {
+	case <-slowSeen:
+	case <-time.After(2 * time.Second):
+		t.Fatal("/slow was not delivered")
+	}
+	require.Eventually(t, func() bool {
+		_, busy := srv.delivering.Load("hooks")
+		return !busy
+	}, 2*time.Second, 10*time.Millisecond)
+
+	items, _ := inbox.List("hooks")
+	require.Len(t, items, 1, "the timed out request stays queued")
+	assert.Equal(t, "/slow", items[0].URL)
+}
+
+func TestVisitorCannotForgeInboxHeaders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	seen := make(chan http.Header, 1)
+	localServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Clone()
+	}))
+	defer localServer.Close()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	logged := make(chan *RequestLog, 1)
+	client := NewClient(ClientConfig{
+		ServerAddr: srv.Addr(),
+		LocalPort:  strings.Split(localServer.Listener.Addr().String(), ":")[1],
+		Subdomain:  "live",
+	})
+	client.SetLogger(chanRequestLogger(logged))
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	req, _ := http.NewRequest("POST", "http://"+srv.Addr()+"/hook", strings.NewReader("{}"))
+	req.Host = "live.test.local"
+	req.Header.Set(HeaderDelayed, "true")
+	req.Header.Set(HeaderReceivedAt, "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	h := <-seen
+	assert.Empty(t, h.Get(HeaderDelayed))
+	assert.Empty(t, h.Get(HeaderReceivedAt))
+	assert.Zero(t, (<-logged).ReceivedAt)
+}
+
+func TestInboxLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, reservations, _ := startInboxServer(t, ctx, InboxLimits{MaxItems: 1, MaxBodyBytes: 16})
+	reservations.Save(&Reservation{Subdomain: "hooks", TokenHash: HashToken("tok"), Inbox: true, InboxStatus: http.StatusOK})
+
+	post := func(body string) int {
+		req := httptest.NewRequest("POST", "http://hooks.test.local/hook", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		srv.handleSubdomainProxy(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(strings.Repeat("x", 64)))
+	assert.Equal(t, http.StatusOK, post("{}"))
+	assert.Equal(t, http.StatusInsufficientStorage, post("{}"))
+
+	// subdomains without a reservation still fail fast
+	req := httptest.NewRequest("POST", "http://other.test.local/hook", strings.NewReader("{}"))
+	w := httptest.NewRecorder()
+	srv.handleSubdomainProxy(w, req)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
+
+// slowStatsInboxRepo reports an inbox's size a little late, widening the
+// gap between checking it and saving to it.
+type slowStatsInboxRepo struct {
+	*memInboxRepo
+}
+
+func (r slowStatsInboxRepo) Stats(subdomain string) (*InboxStats, error) {
+	stats, err := r.memInboxRepo.Stats(subdomain)
+	time.Sleep(5 * time.Millisecond)
+	return stats, err
+}
+
+func TestInboxLimitsHoldUnderConcurrency(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reservations := newMemReservationRepo()
+	reservations.Save(&Reservation{Subdomain: "hooks", TokenHash: HashToken("tok"), Inbox: true, InboxStatus: http.StatusOK})
+	inbox := newMemInboxRepo()
+	srv := startTestServer(t, ctx, ServerConfig{
+		Reservations: reservations,
+		Inbox:        slowStatsInboxRepo{inbox},
+		InboxLimits:  InboxLimits{MaxItems: 3},
+	})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "http://hooks.test.local/hook", strings.NewReader("{}"))
+			srv.handleSubdomainProxy(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	items, err := inbox.List("hooks")
+	require.NoError(t, err)
+	assert.Len(t, items, 3)
+}
+
+func TestAdminReservationsAPI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, reservations, _ := startInboxServer(t, ctx, InboxLimits{})
+
+	adminRequest := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "http://"+srv.Addr()+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		w := httptest.NewRecorder()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return w
+	}
+
+	w := adminRequest("POST", "/api/admin/reservations", `{"subdomain":"hooks","inbox":true}`, "nope")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = adminRequest("POST", "/api/admin/reservations", `{"subdomain":"Bad_Name"}`, "admin-secret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminRequest("POST", "/api/admin/reservations", `{"subdomain":"hooks","inbox":true}`, "admin-secret")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created APIReservation
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.Token)
+	assert.Equal(t, http.StatusAccepted, created.InboxStatus)
+
+	stored, _ := reservations.Get("hooks")
+	require.NotNil(t, stored)
+	assert.Equal(t, HashToken(created.Token), stored.TokenHash)
+
+	w = adminRequest("GET", "/api/admin/reservations", "", "admin-secret")
+	var list APIReservationsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Reservations, 1)
+	assert.Empty(t, list.Reservations[0].Token)
+
+	w = adminRequest("GET", "/api/admin/inbox?subdomain=hooks", "", "admin-secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var inboxResp APIInboxResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &inboxResp))
+	assert.Equal(t, 100, inboxResp.Limits.MaxItems)
+
+	w = adminRequest("DELETE", "/api/admin/reservations/hooks", "", "admin-secret")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	stored, _ = reservations.Get("hooks")
+	assert.Nil(t, stored)
+}
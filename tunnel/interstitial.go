@@ -0,0 +1,216 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+// HeaderSkipWarning lets API clients and webhooks bypass the interstitial.
+const HeaderSkipWarning = "X-Devtunnel-Skip-Warning"
+
+const (
+	// WarningCookie remembers that a browser has seen the interstitial for
+	// a subdomain; browsers scope it to the subdomain's host.
+	WarningCookie       = "devtunnel_warned"
+	warningCookieMaxAge = 30 * 24 * 60 * 60
+
+	// interstitialPrefix is reserved on tunnel hosts while the interstitial
+	// is enabled, for continuing past it and reporting abuse.
+	interstitialPrefix = "/.devtunnel/"
+	maxAbuseReason     = 2000
+	abuseReportsLimit  = 100
+)
+
+// AbuseReport is a visitor's report that a tunnel hosts abusive content.
+type AbuseReport struct {
+	ID         string `json:"id"`
+	Subdomain  string `json:"subdomain"`
+	URL        string `json:"url"`
+	Reason     string `json:"reason"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+type AbuseReportRepo interface {
+	Save(report *AbuseReport) error
+	List(subdomain string, limit int) ([]*AbuseReport, error)
+}
+
+type APIAbuseReportsResponse struct {
+	Reports []*AbuseReport `json:"reports"`
+}
+
+type InterstitialData struct {
+	Host        string
+	Next        string
+	ContinueURL string
+	ReportURL   string
+	Reported    bool
+}
+
+// needsInterstitial reports whether r is a browser's first visit to a
+// subdomain that has not been exempted.
+func (s *Server) needsInterstitial(r *http.Request, subdomain string) bool {
+	if !s.interstitial || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	if !wantsHTML(r) || r.Header.Get(HeaderSkipWarning) != "" {
+		return false
+	}
+	if _, err := r.Cookie(WarningCookie); err == nil {
+		return false
+	}
+	if s.reservations != nil {
+		if res, err := s.reservations.Get(subdomain); err == nil && res != nil && res.SkipWarning {
+			return false
+		}
+	}
+	return true
+}
+
+// writeInterstitial shows the dev tunnel warning in place of the page the
+// visitor asked for.
+func (s *Server) writeInterstitial(w http.ResponseWriter, data InterstitialData) {
+	if s.templates == nil || s.templates.Lookup("interstitial.html") == nil {
+		http.Error(w, "templates not loaded", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := s.templates.ExecuteTemplate(w, "interstitial.html", data); err != nil {
+		s.logger.WithError(err).WithFields(logging.Fields{"template": "interstitial.html"}).Error("server", "template", "Render failed")
+	}
+}
+
+func (s *Server) showInterstitial(w http.ResponseWriter, r *http.Request) {
+	next := r.URL.RequestURI()
+	s.writeInterstitial(w, InterstitialData{
+		Host:        r.Host,
+		Next:        next,
+		ContinueURL: interstitialPrefix + "continue?next=" + url.QueryEscape(next),
+		ReportURL:   interstitialPrefix + "report",
+	})
+}
+
+// handleInterstitialPath serves the reserved paths under interstitialPrefix,
+// reporting whether r was one of them. They spend from the visitor's per-IP
+// bucket only, not the tunnel's tenant bucket or quotas.
+func (s *Server) handleInterstitialPath(w http.ResponseWriter, r *http.Request, subdomain string) bool {
+	if !s.interstitial || !strings.HasPrefix(r.URL.Path, interstitialPrefix) {
+		return false
+	}
+	if !s.allowVisitor(w, r) {
+		return true
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, interstitialPrefix) {
+	case "continue":
+		http.SetCookie(w, &http.Cookie{
+			Name:     WarningCookie,
+			Value:    "1",
+			Path:     "/",
+			MaxAge:   warningCookieMaxAge,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, safeNext(r.URL.Query().Get("next")), http.StatusSeeOther)
+	case "report":
+		s.handleAbuseReport(w, r, subdomain)
+	default:
+		http.NotFound(w, r)
+	}
+	return true
+}
+
+// safeNext keeps continue redirects on the same host.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (s *Server) handleAbuseReport(w http.ResponseWriter, r *http.Request, subdomain string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.abuseReports == nil {
+		http.Error(w, "abuse reports not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(r.PostForm.Get("reason"))
+	if reason == "" {
+		http.Error(w, "reason required", http.StatusBadRequest)
+		return
+	}
+	if len(reason) > maxAbuseReason {
+		reason = reason[:maxAbuseReason]
+	}
+
+	report := &AbuseReport{
+		Subdomain:  subdomain,
+		URL:        safeNext(r.PostForm.Get("url")),
+		Reason:     reason,
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		CreatedAt:  time.Now().UnixMilli(),
+	}
+	if err := s.abuseReports.Save(report); err != nil {
+		s.logger.WithError(err).WithFields(logging.Fields{"subdomain": subdomain}).Error("server", "abuse", "Save abuse report failed")
+		http.Error(w, "save report failed", http.StatusInternalServerError)
+		return
+	}
+	s.logger.WithFields(logging.Fields{
+		"subdomain":   subdomain,
+		"url":         report.URL,
+		"remote_addr": report.RemoteAddr,
+	}).Warn("server", "abuse", "Abuse reported")
+
+	s.writeInterstitial(w, InterstitialData{Host: r.Host, Reported: true})
+}
+
+// handleAdminAbuseReports lists abuse reports, newest first. ?subdomain=
+// narrows them to one tunnel and ?limit= caps how many are returned.
+func (s *Server) handleAdminAbuseReports(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.abuseReports == nil {
+		writeServerJSONError(w, "abuse reports not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	limit := abuseReportsLimit
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v < limit {
+		limit = v
+	}
+	reports, err := s.abuseReports.List(r.URL.Query().Get("subdomain"), limit)
+	if err != nil {
+		writeServerJSONError(w, "list abuse reports failed", http.StatusInternalServerError)
+		return
+	}
+	if reports == nil {
+		reports = []*AbuseReport{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIAbuseReportsResponse{Reports: reports})
+}
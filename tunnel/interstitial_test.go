@@ -0,0 +1,241 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memAbuseReportRepo struct {
+	mu      sync.Mutex
+	reports []*AbuseReport
+}
+
+func (m *memAbuseReportRepo) Save(report *AbuseReport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if report.ID == "" {
+		report.ID = ulid.Make().String()
+	}
+	m.reports = append(m.reports, report)
+	return nil
+}
+
+func (m *memAbuseReportRepo) List(subdomain string, limit int) ([]*AbuseReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*AbuseReport
+	for i := len(m.reports) - 1; i >= 0 && len(out) < limit; i-- {
+		if subdomain == "" || m.reports[i].Subdomain == subdomain {
+			out = append(out, m.reports[i])
+		}
+	}
+	return out, nil
+}
+
+// startInterstitialServer runs a server with the interstitial on and a
+// client serving "hello from app" on the reserved subdomain "demo".
+func startInterstitialServer(t *testing.T, ctx context.Context, enabled bool) (*Server, *memReservationRepo, *memAbuseReportRepo) {
+	reservations := newMemReservationRepo()
+	reservations.Save(&Reservation{Subdomain: "demo", TokenHash: HashToken("tok")})
+	reports := &memAbuseReportRepo{}
+	srv := startTestTunnel(t, ctx, ServerConfig{
+		Reservations: reservations,
+		AdminToken:   "admin-secret",
+		Interstitial: enabled,
+		AbuseReports: reports,
+	}, ClientConfig{Subdomain: "demo", AuthToken: "tok"}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello from app"))
+	})
+	return srv, reservations, reports
+}
+
+func visitDemo(t *testing.T, srv *Server, method, path string, header http.Header) (*http.Response, string) {
+	req, _ := http.NewRequest(method, "http://"+srv.Addr()+path, nil)
+	req.Host = "demo.test.local"
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestInterstitialShownToFirstTimeBrowsers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _, _ := startInterstitialServer(t, ctx, true)
+	browser := http.Header{"Accept": {"text/html,application/xhtml+xml"}}
+
+	resp, body := visitDemo(t, srv, "GET", "/login?x=1", browser)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	assert.Contains(t, body, "You are about to visit a dev tunnel")
+	assert.Contains(t, body, "/.devtunnel/continue?next="+url.QueryEscape("/login?x=1"))
+	assert.NotContains(t, body, "hello from app")
+
+	_, body = visitDemo(t, srv, "GET", "/", nil)
+	assert.Equal(t, "hello from app", body, "API clients pass straight through")
+
+	_, body = visitDemo(t, srv, "POST", "/", browser)
+	assert.Equal(t, "hello from app", body, "form posts pass straight through")
+
+	skip := http.Header{"Accept": browser["Accept"], HeaderSkipWarning: {"1"}}
+	_, body = visitDemo(t, srv, "GET", "/", skip)
+	assert.Equal(t, "hello from app", body)
+
+	resp, _ = visitDemo(t, srv, "GET", "/.devtunnel/continue?next="+url.QueryEscape("/login?x=1"), browser)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/login?x=1", resp.Header.Get("Location"))
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == WarningCookie {
+			cookie = c
+		}
+	}
+	require.NotNil(t, cookie)
+	assert.True(t, cookie.HttpOnly)
+
+	withCookie := http.Header{"Accept": browser["Accept"], "Cookie": {cookie.String()}}
+	_, body = visitDemo(t, srv, "GET", "/login?x=1", withCookie)
+	assert.Equal(t, "hello from app", body)
+
+	resp, _ = visitDemo(t, srv, "GET", "/.devtunnel/continue?next="+url.QueryEscape("//evil.example/"), browser)
+	assert.Equal(t, "/", resp.Header.Get("Location"))
+}
+
+func TestInterstitialDisabledByDefault(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _, _ := startInterstitialServer(t, ctx, false)
+
+	_, body := visitDemo(t, srv, "GET", "/", http.Header{"Accept": {"text/html"}})
+	assert.Equal(t, "hello from app", body)
+
+	_, body = visitDemo(t, srv, "GET", "/.devtunnel/continue", nil)
+	assert.Equal(t, "hello from app", body, "reserved paths belong to the app when the interstitial is off")
+}
+
+func TestInterstitialAllowlistedReservation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, reservations, _ := startInterstitialServer(t, ctx, true)
+	browser := http.Header{"Accept": {"text/html"}}
+
+	patch := func(body string) int {
+		req, _ := http.NewRequest("PATCH", "http://"+srv.Addr()+"/api/admin/reservations/demo", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, patch(`{"skip_warning":true}`))
+	res, _ := reservations.Get("demo")
+	assert.True(t, res.SkipWarning)
+	assert.Equal(t, HashToken("tok"), res.TokenHash, "updating keeps the token")
+
+	_, body := visitDemo(t, srv, "GET", "/", browser)
+	assert.Equal(t, "hello from app", body)
+
+	require.Equal(t, http.StatusOK, patch(`{"skip_warning":false}`))
+	_, body = visitDemo(t, srv, "GET", "/", browser)
+	assert.Contains(t, body, "You are about to visit a dev tunnel")
+
+	req, _ := http.NewRequest("PATCH", "http://"+srv.Addr()+"/api/admin/reservations/missing", strings.NewReader(`{}`))
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAbuseReport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _, reports := startInterstitialServer(t, ctx, true)
+
+	form := url.Values{"reason": {"imitates a bank login"}, "url": {"/login"}}
+	req, _ := http.NewRequest("POST", "http://"+srv.Addr()+"/.devtunnel/report", strings.NewReader(form.Encode()))
+	req.Host = "demo.test.local"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "test-browser")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "Thanks for your report")
+
+	saved, _ := reports.List("", 10)
+	require.Len(t, saved, 1)
+	assert.Equal(t, "demo", saved[0].Subdomain)
+	assert.Equal(t, "/login", saved[0].URL)
+	assert.Equal(t, "imitates a bank login", saved[0].Reason)
+	assert.Equal(t, "test-browser", saved[0].UserAgent)
+
+	resp, _ = visitDemo(t, srv, "POST", "/.devtunnel/report", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "a report needs a reason")
+
+	req, _ = http.NewRequest("GET", "http://"+srv.Addr()+"/api/admin/abuse-reports?subdomain=demo", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var list APIAbuseReportsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.Len(t, list.Reports, 1)
+	assert.Equal(t, "imitates a bank login", list.Reports[0].Reason)
+}
+
+func TestAbuseReportRateLimited(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _, reports := startInterstitialServer(t, ctx, true)
+	srv.rateLimiter.SetPlans([]RatePlan{
+		{Name: PlanDefault, RequestsPerMin: 1, Burst: 1},
+		{Name: PlanPerIP, RequestsPerMin: 3, Burst: 3},
+	}, nil)
+
+	// The reported tunnel has spent its own limit.
+	tenant := srv.tenantFor("demo")
+	for srv.rateLimiter.Allow(tenant, "").Allowed {
+	}
+
+	report := func() int {
+		form := url.Values{"reason": {"spam"}}
+		req, _ := http.NewRequest("POST", "http://"+srv.Addr()+"/.devtunnel/report", strings.NewReader(form.Encode()))
+		req.Host = "demo.test.local"
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for range 3 {
+		assert.Equal(t, http.StatusOK, report(), "each report spends one token of the visitor's burst")
+	}
+	assert.Equal(t, http.StatusTooManyRequests, report())
+
+	saved, _ := reports.List("", 20)
+	assert.Len(t, saved, 3)
+}
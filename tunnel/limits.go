@@ -0,0 +1,151 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+// ServerLimits bounds what one connection or request may cost the relay.
+// Zero fields take the defaults; a negative value turns that limit off.
+type ServerLimits struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// MaxBodyBytes caps proxied request bodies; a client may ask for a
+	// lower cap on its own tunnel.
+	MaxBodyBytes int64
+	// UpstreamTimeout is how long a proxied request may take end to end.
+	// It is sent to the client with each request as the deadline for its
+	// local app.
+	UpstreamTimeout time.Duration
+}
+
+var defaultServerLimits = ServerLimits{
+	ReadHeaderTimeout: 10 * time.Second,
+	ReadTimeout:       60 * time.Second,
+	WriteTimeout:      2 * time.Minute,
+	IdleTimeout:       2 * time.Minute,
+	MaxHeaderBytes:    64 << 10,
+	MaxBodyBytes:      32 << 20,
+	UpstreamTimeout:   30 * time.Second,
+}
+
+// defaultUpstreamTimeout applies when a request frame carries no deadline,
+// as sent by servers that predate it.
+const defaultUpstreamTimeout = 30 * time.Second
+
+// upstreamGrace is how much longer the server waits on a tunnel stream than
+// the client waits on its local app, so the client's timeout wins.
+const upstreamGrace = 5 * time.Second
+
+func (l ServerLimits) withDefaults() ServerLimits {
+	l.ReadHeaderTimeout = durationOrDefault(l.ReadHeaderTimeout, defaultServerLimits.ReadHeaderTimeout)
+	l.ReadTimeout = durationOrDefault(l.ReadTimeout, defaultServerLimits.ReadTimeout)
+	l.WriteTimeout = durationOrDefault(l.WriteTimeout, defaultServerLimits.WriteTimeout)
+	l.IdleTimeout = durationOrDefault(l.IdleTimeout, defaultServerLimits.IdleTimeout)
+	l.UpstreamTimeout = durationOrDefault(l.UpstreamTimeout, defaultServerLimits.UpstreamTimeout)
+	switch {
+	case l.MaxHeaderBytes == 0:
+		l.MaxHeaderBytes = defaultServerLimits.MaxHeaderBytes
+	case l.MaxHeaderBytes < 0:
+		l.MaxHeaderBytes = 0
+	}
+	switch {
+	case l.MaxBodyBytes == 0:
+		l.MaxBodyBytes = defaultServerLimits.MaxBodyBytes
+	case l.MaxBodyBytes < 0:
+		l.MaxBodyBytes = 0
+	}
+	return l
+}
+
+// durationOrDefault maps the zero value to def and negative values to zero,
+// which net/http and the proxy treat as no limit.
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// newHTTPServer builds an http.Server with the configured limits. Errors
+// net/http would print, such as TLS handshake failures, go to the logger.
+func (s *Server) newHTTPServer(handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: s.limits.ReadHeaderTimeout,
+		ReadTimeout:       s.limits.ReadTimeout,
+		WriteTimeout:      s.limits.WriteTimeout,
+		IdleTimeout:       s.limits.IdleTimeout,
+		MaxHeaderBytes:    s.limits.MaxHeaderBytes,
+		ErrorLog:          log.New(httpErrorWriter{s.logger}, "", 0),
+	}
+}
+
+type httpErrorWriter struct {
+	logger logging.Logger
+}
+
+func (w httpErrorWriter) Write(p []byte) (int, error) {
+	w.logger.Warn("server", "http", strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// bodyLimit is the largest request body sess accepts: the server's cap, or
+// the client's if it asked for a lower one. Zero means unlimited.
+func (s *Server) bodyLimit(requested int64) int64 {
+	limit := s.limits.MaxBodyBytes
+	if requested > 0 && (limit == 0 || requested < limit) {
+		return requested
+	}
+	return limit
+}
+
+// readProxyBody reads r's body up to limit, reporting whether it was too
+// large. A declared Content-Length over the limit is refused unread.
+func readProxyBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, bool, error) {
+	if limit <= 0 {
+		body, err := io.ReadAll(r.Body)
+		return body, false, err
+	}
+	if r.ContentLength > limit {
+		return nil, true, nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, true, nil
+	}
+	return body, false, err
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// limitsConfig describes the limits a client is held to, sent with the
+// control channel's config message.
+func (s *Server) limitsConfig(sess *Session, config map[string]string) {
+	if sess.MaxBodyBytes > 0 {
+		config["max_body_bytes"] = strconv.FormatInt(sess.MaxBodyBytes, 10)
+	}
+	if s.limits.UpstreamTimeout > 0 {
+		config["upstream_timeout_ms"] = strconv.FormatInt(s.limits.UpstreamTimeout.Milliseconds(), 10)
+	}
+}
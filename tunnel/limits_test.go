@@ -0,0 +1,110 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerLimitsWithDefaults(t *testing.T) {
+	l := ServerLimits{}.withDefaults()
+	assert.Equal(t, defaultServerLimits, l)
+
+	l = ServerLimits{ReadTimeout: -1, MaxBodyBytes: -1, MaxHeaderBytes: -1, UpstreamTimeout: time.Second}.withDefaults()
+	assert.Zero(t, l.ReadTimeout)
+	assert.Zero(t, l.MaxBodyBytes)
+	assert.Zero(t, l.MaxHeaderBytes)
+	assert.Equal(t, time.Second, l.UpstreamTimeout)
+	assert.Equal(t, defaultServerLimits.ReadHeaderTimeout, l.ReadHeaderTimeout)
+}
+
+func postLimited(t *testing.T, srv *Server, body io.Reader) (*http.Response, ErrorPageData) {
+	req, _ := http.NewRequest("POST", "http://"+srv.Addr()+"/upload", body)
+	req.Host = "limited.test.local"
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var data ErrorPageData
+	json.NewDecoder(resp.Body).Decode(&data)
+	return resp, data
+}
+
+func TestProxyBodyLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestTunnel(t, ctx, ServerConfig{Limits: ServerLimits{MaxBodyBytes: 1024}}, ClientConfig{Subdomain: "limited"}, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	resp, _ := postLimited(t, srv, bytes.NewReader(make([]byte, 512)))
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, data := postLimited(t, srv, bytes.NewReader(make([]byte, 2048)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Equal(t, ErrorBodyTooLarge, data.Reason)
+
+	// no Content-Length, so the limit is enforced while reading
+	resp, data = postLimited(t, srv, io.MultiReader(bytes.NewReader(make([]byte, 2048))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Equal(t, ErrorBodyTooLarge, data.Reason)
+}
+
+func TestProxyBodyLimitPerTunnel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestTunnel(t, ctx, ServerConfig{Limits: ServerLimits{MaxBodyBytes: 1024}}, ClientConfig{Subdomain: "limited", MaxBodyBytes: 100}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	assert.Equal(t, int64(100), srv.GetSession("limited").MaxBodyBytes)
+
+	resp, _ := postLimited(t, srv, bytes.NewReader(make([]byte, 200)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// a client cannot raise the server's cap
+	assert.Equal(t, int64(1024), srv.bodyLimit(1<<30))
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestTunnel(t, ctx, ServerConfig{Limits: ServerLimits{UpstreamTimeout: 200 * time.Millisecond}}, ClientConfig{Subdomain: "limited"}, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	})
+
+	start := time.Now()
+	resp, data := postLimited(t, srv, nil)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Equal(t, ErrorUpstreamTimeout, data.Reason)
+	assert.Less(t, time.Since(start), time.Second, "the client gives up at the deadline the server sent")
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{Limits: ServerLimits{ReadHeaderTimeout: 100 * time.Millisecond}})
+
+	conn, err := net.Dial("tcp", srv.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// a slowloris client that never finishes its headers
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: slow.test.local\r\n"))
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err, "the server closes the connection instead of waiting forever")
+}
@@ -0,0 +1,115 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var errHelloPeeked = errors.New("client hello peeked")
+
+// readOnlyConn feeds a TLS handshake from a reader and refuses writes, so the
+// ClientHello can be parsed without answering the peer.
+type readOnlyConn struct {
+	r io.Reader
+	net.Conn
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// prefixConn replays bytes that were already read from the underlying conn.
+type prefixConn struct {
+	r io.Reader
+	net.Conn
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// peekClientHello reads the TLS ClientHello from conn and returns the SNI
+// server name along with a conn that still yields every byte read.
+func peekClientHello(conn net.Conn) (string, net.Conn, error) {
+	var buf bytes.Buffer
+	var serverName string
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	err := tls.Server(readOnlyConn{r: io.TeeReader(conn, &buf), Conn: conn}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errHelloPeeked
+		},
+	}).Handshake()
+	conn.SetReadDeadline(time.Time{})
+
+	if !errors.Is(err, errHelloPeeked) {
+		return "", nil, err
+	}
+	return serverName, &prefixConn{r: io.MultiReader(&buf, conn), Conn: conn}, nil
+}
+
+// connListener is a net.Listener fed by the TLS router, handing the HTTPS
+// server the connections that are not passed through to a tunnel.
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *connListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// splice copies bytes in both directions until either side is done.
+func splice(a, b io.ReadWriteCloser) (int64, int64) {
+	var wg sync.WaitGroup
+	var aToB, bToA int64
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		aToB, _ = io.Copy(b, a)
+		b.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		bToA, _ = io.Copy(a, b)
+		a.Close()
+	}()
+	wg.Wait()
+	return aToB, bToA
+}
@@ -0,0 +1,240 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeekClientHello(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		tls.Client(clientConn, &tls.Config{ServerName: "abc.test.local", InsecureSkipVerify: true}).Handshake()
+		clientConn.Close()
+	}()
+
+	name, peeked, err := peekClientHello(serverConn)
+	require.NoError(t, err)
+	assert.Equal(t, "abc.test.local", name)
+
+	// the peeked bytes are replayed: the stream still starts with a TLS handshake record
+	header := make([]byte, 1)
+	_, err = io.ReadFull(peeked, header)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x16), header[0])
+}
+
+func TestTLSPassthroughToLocalTLSServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	localServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret:" + r.URL.Path))
+	}))
+	defer localServer.Close()
+
+	srv := startTestServer(t, ctx, ServerConfig{TLSAddr: "127.0.0.1:0", Passthrough: true})
+
+	client := NewClient(ClientConfig{
+		ServerAddr:     srv.Addr(),
+		LocalPort:      strings.Split(localServer.Listener.Addr().String(), ":")[1],
+		Subdomain:      "secure",
+		TLSPassthrough: true,
+	})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+	assert.True(t, strings.HasPrefix(client.PublicURL(), "https://"))
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true, ServerName: "secure.test.local"},
+	}}
+	resp, err := httpClient.Get("https://" + srv.TLSAddr() + "/records")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "secret:/records", string(body))
+	// the certificate came from the local server, so the relay never held a key
+	assert.Equal(t, localServer.Certificate().Raw, resp.TLS.PeerCertificates[0].Raw)
+}
+
+func TestTLSPassthroughTerminatesLocally(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	localServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain:" + r.Method))
+	}))
+	defer localServer.Close()
+
+	certFile, keyFile := writeTestCert(t, "local.test.local")
+	srv := startTestServer(t, ctx, ServerConfig{TLSAddr: "127.0.0.1:0", Passthrough: true})
+
+	logged := make(chan *RequestLog, 1)
+	client := NewClient(ClientConfig{
+		ServerAddr:     srv.Addr(),
+		LocalPort:      strings.Split(localServer.Listener.Addr().String(), ":")[1],
+		Subdomain:      "local",
+		TLSPassthrough: true,
+		TLSCertFile:    certFile,
+		TLSKeyFile:     keyFile,
+	})
+	client.SetLogger(chanRequestLogger(logged))
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	conn, err := tls.Dial("tcp", srv.TLSAddr(), &tls.Config{InsecureSkipVerify: true, ServerName: "local.test.local"})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "local.test.local", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+
+	req, _ := http.NewRequest(http.MethodPost, "https://local.test.local/hook", strings.NewReader("{}"))
+	require.NoError(t, req.Write(conn))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, "plain:POST", string(body))
+	select {
+	case l := <-logged:
+		assert.Equal(t, "/hook", l.URL)
+	case <-time.After(time.Second):
+		t.Fatal("request was not logged")
+	}
+}
+
+type chanRequestLogger chan *RequestLog
+
+func (c chanRequestLogger) Log(l *RequestLog) error {
+	c <- l
+	return nil
+}
+
+func TestTLSPassthroughRejectedWhenDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", TLSPassthrough: true})
+	client.SetReconnect(false)
+	err := client.Connect(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tls passthrough not enabled")
+}
+
+func TestTLSPassthroughSessionRefusesPlainHTTP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{TLSAddr: "127.0.0.1:0", Passthrough: true})
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "secure", TLSPassthrough: true})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "http://secure.test.local/", nil)
+	w := httptest.NewRecorder()
+	srv.handleSubdomainProxy(w, req)
+	assert.Equal(t, http.StatusMisdirectedRequest, w.Code)
+}
+
+func writeTestCert(t *testing.T, host string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestTLSPassthroughMeteredAndLimited(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	localServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer localServer.Close()
+
+	usage := newMemUsageRepo()
+	srv := startTestServer(t, ctx, ServerConfig{
+		TLSAddr:     "127.0.0.1:0",
+		Passthrough: true,
+		RatePlans:   newMemRatePlanRepo(RatePlan{Name: PlanDefault, RequestsPerMin: 100, DailyRequests: 1}),
+		Usage:       usage,
+	})
+
+	client := NewClient(ClientConfig{
+		ServerAddr:     srv.Addr(),
+		LocalPort:      strings.Split(localServer.Listener.Addr().String(), ":")[1],
+		Subdomain:      "secure",
+		TLSPassthrough: true,
+	})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, ServerName: "secure.test.local"},
+	}}
+	resp, err := httpClient.Get("https://" + srv.TLSAddr() + "/")
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// the connection is recorded once it closes
+	require.Eventually(t, func() bool {
+		srv.flushUsage()
+		total, _ := usage.Totals("ip:127.0.0.1", 0)
+		return total.Requests == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	total, _ := usage.Totals("ip:127.0.0.1", 0)
+	assert.Positive(t, total.BytesIn)
+	assert.Positive(t, total.BytesOut)
+
+	// the daily request quota is spent, so the next connection is refused
+	_, err = httpClient.Get("https://" + srv.TLSAddr() + "/")
+	assert.Error(t, err)
+}
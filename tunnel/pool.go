@@ -0,0 +1,281 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+// Strategies for picking a member of a pool.
+const (
+	PoolRoundRobin    = "round-robin"
+	PoolLeastInflight = "least-inflight"
+	PoolSticky        = "sticky"
+)
+
+// StickyCookie pins a browser to one pool member under PoolSticky.
+const StickyCookie = "devtunnel_member"
+
+// memberCooldown is how long a member that failed a request is skipped.
+const memberCooldown = 10 * time.Second
+
+func validPoolStrategy(strategy string) bool {
+	switch strategy {
+	case PoolRoundRobin, PoolLeastInflight, PoolSticky:
+		return true
+	}
+	return false
+}
+
+// pool is the set of sessions sharing one subdomain. The server's sessions
+// map keeps pointing at one member, so single-session lookups keep working.
+type pool struct {
+	strategy string
+	members  []*Session
+	next     atomic.Uint64
+}
+
+func (p *pool) remove(sess *Session) {
+	for i, m := range p.members {
+		if m == sess {
+			p.members = append(p.members[:i], p.members[i+1:]...)
+			return
+		}
+	}
+}
+
+// pick chooses the member for r. Unhealthy members are skipped unless none
+// are healthy, in which case any member is better than none.
+func (p *pool) pick(w http.ResponseWriter, r *http.Request) *Session {
+	candidates := make([]*Session, 0, len(p.members))
+	for _, m := range p.members {
+		if m.Healthy() {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.members
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch p.strategy {
+	case PoolLeastInflight:
+		best := candidates[0]
+		for _, m := range candidates[1:] {
+			if m.inflight.Load() < best.inflight.Load() {
+				best = m
+			}
+		}
+		return best
+	case PoolSticky:
+		if c, err := r.Cookie(StickyCookie); err == nil {
+			for _, m := range candidates {
+				if m.ID == c.Value {
+					return m
+				}
+			}
+		}
+		m := candidates[p.next.Add(1)%uint64(len(candidates))]
+		http.SetCookie(w, &http.Cookie{Name: StickyCookie, Value: m.ID, Path: "/", HttpOnly: true})
+		return m
+	default:
+		return candidates[p.next.Add(1)%uint64(len(candidates))]
+	}
+}
+
+// Healthy reports whether the pool should route to this session.
+func (sess *Session) Healthy() bool {
+	return time.Now().UnixMilli() >= sess.unhealthyUntil.Load()
+}
+
+// SetHealthy marks the session healthy or takes it out of rotation until
+// marked healthy again.
+func (sess *Session) SetHealthy(healthy bool) {
+	if healthy {
+		sess.unhealthyUntil.Store(0)
+		return
+	}
+	sess.unhealthyUntil.Store(math.MaxInt64)
+}
+
+// markFailed counts a failed request and rests the session for
+// memberCooldown, unless it is already marked unhealthy for longer.
+func (sess *Session) markFailed() {
+	sess.failures.Add(1)
+	until := time.Now().Add(memberCooldown).UnixMilli()
+	if current := sess.unhealthyUntil.Load(); current < until {
+		sess.unhealthyUntil.CompareAndSwap(current, until)
+	}
+}
+
+// checkPool validates a request to join a pool, returning an error message
+// for the client when it may not.
+func (s *Server) checkPool(req *HandshakeRequest, reserved bool) string {
+	if !validPoolStrategy(req.Pool) {
+		return fmt.Sprintf("unknown pool strategy %q; use %s, %s or %s", req.Pool, PoolRoundRobin, PoolLeastInflight, PoolSticky)
+	}
+	if !reserved {
+		return "pools need a reserved subdomain; connect with --subdomain and its --token"
+	}
+	return ""
+}
+
+// joinPool adds sess to the pool on its subdomain, creating the pool when
+// sess is the first member. The pool keeps the strategy it was created with.
+func (s *Server) joinPool(sess *Session, strategy string) {
+	s.mu.Lock()
+	p := s.pools[sess.Subdomain]
+	if p == nil {
+		p = &pool{strategy: strategy}
+		if existing := s.sessions[sess.Subdomain]; existing != nil {
+			p.members = append(p.members, existing)
+		}
+		s.pools[sess.Subdomain] = p
+	}
+	p.members = append(p.members, sess)
+	if s.sessions[sess.Subdomain] == nil {
+		s.sessions[sess.Subdomain] = sess
+	}
+	parked := s.parked[sess.Subdomain]
+	if parked != nil {
+		delete(s.parked, sess.Subdomain)
+		parked.timer.Stop()
+	}
+	size := len(p.members)
+	s.mu.Unlock()
+
+	if parked != nil {
+		parked.release()
+	}
+	fields := logging.Fields{"subdomain": sess.Subdomain, "member": sess.ID, "strategy": p.strategy, "members": size}
+	if strategy != p.strategy {
+		fields["requested_strategy"] = strategy
+	}
+	s.logger.WithFields(fields).Info("server", "pool", "Client joined pool")
+}
+
+// leavePool drops sess from its pool, promoting another member when sess
+// was the one the sessions map pointed at. It reports whether sess was a
+// pool member; the last member leaving dissolves the pool and is handled
+// like a single session.
+func (s *Server) leavePool(sess *Session) bool {
+	p := s.pools[sess.Subdomain]
+	if p == nil {
+		return false
+	}
+	p.remove(sess)
+	if len(p.members) == 0 {
+		delete(s.pools, sess.Subdomain)
+		return false
+	}
+	if s.sessions[sess.Subdomain] == sess {
+		s.sessions[sess.Subdomain] = p.members[0]
+	}
+	return true
+}
+
+// allSessions returns every live session, including all pool members.
+func (s *Server) allSessions() []*Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(s.sessions))
+	for subdomain, sess := range s.sessions {
+		if p := s.pools[subdomain]; p != nil {
+			sessions = append(sessions, p.members...)
+			continue
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+// pickSession returns the session that should serve r on subdomain.
+func (s *Server) pickSession(w http.ResponseWriter, r *http.Request, subdomain string) *Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if p := s.pools[subdomain]; p != nil {
+		return p.pick(w, r)
+	}
+	return s.sessions[subdomain]
+}
+
+type PoolMemberStats struct {
+	ID          string `json:"id"`
+	RemoteAddr  string `json:"remote_addr"`
+	ConnectedAt int64  `json:"connected_at"`
+	Healthy     bool   `json:"healthy"`
+	Inflight    int64  `json:"inflight"`
+	Requests    int64  `json:"requests"`
+	Failures    int64  `json:"failures"`
+}
+
+type PoolStatsResponse struct {
+	Subdomain string            `json:"subdomain"`
+	Strategy  string            `json:"strategy,omitempty"`
+	Members   []PoolMemberStats `json:"members"`
+}
+
+// PoolStats reports each session serving subdomain. A subdomain served by
+// a single client is reported as a pool of one with no strategy.
+func (s *Server) PoolStats(subdomain string) (PoolStatsResponse, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resp := PoolStatsResponse{Subdomain: subdomain, Members: []PoolMemberStats{}}
+	members := []*Session{}
+	if p := s.pools[subdomain]; p != nil {
+		resp.Strategy = p.strategy
+		members = p.members
+	} else if sess := s.sessions[subdomain]; sess != nil {
+		members = []*Session{sess}
+	} else {
+		return resp, false
+	}
+
+	for _, m := range members {
+		resp.Members = append(resp.Members, PoolMemberStats{
+			ID:          m.ID,
+			RemoteAddr:  m.RemoteAddr,
+			ConnectedAt: m.ConnectedAt.UnixMilli(),
+			Healthy:     m.Healthy(),
+			Inflight:    m.inflight.Load(),
+			Requests:    m.requests.Load(),
+			Failures:    m.failures.Load(),
+		})
+	}
+	return resp, true
+}
+
+// handlePoolStats serves /api/pools/{subdomain} to the admin or to holders
+// of the subdomain's reservation token.
+func (s *Server) handlePoolStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	subdomain := strings.TrimPrefix(r.URL.Path, "/api/pools/")
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	isAdmin := s.adminToken != "" && tokenMatches(s.adminToken, token)
+	if !isAdmin && (s.reservations == nil || !s.reservationTokenValid(r, subdomain)) {
+		writeServerJSONError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	stats, ok := s.PoolStats(subdomain)
+	if !ok {
+		writeServerJSONError(w, fmt.Sprintf("no clients connected on %s", subdomain), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,217 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startPoolServer(t *testing.T, ctx context.Context) *Server {
+	reservations := newMemReservationRepo()
+	reservations.Save(&Reservation{Subdomain: "pair", TokenHash: HashToken("tok")})
+	srv := startTestServer(t, ctx, ServerConfig{
+		Reservations: reservations,
+		AdminToken:   "admin-secret",
+	})
+	return srv
+}
+
+// joinTestPool connects a client serving name into the "pair" pool.
+func joinTestPool(t *testing.T, ctx context.Context, srv *Server, strategy, name string) (*Client, *httptest.Server) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+	client := NewClient(ClientConfig{
+		ServerAddr: srv.Addr(),
+		LocalPort:  strings.Split(local.Listener.Addr().String(), ":")[1],
+		Subdomain:  "pair",
+		AuthToken:  "tok",
+		Pool:       strategy,
+	})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	return client, local
+}
+
+func getPair(t *testing.T, srv *Server, cookie *http.Cookie) (*http.Response, string) {
+	req, _ := http.NewRequest("GET", "http://"+srv.Addr()+"/", nil)
+	req.Host = "pair.test.local"
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startPoolServer(t, ctx)
+	alice, aliceLocal := joinTestPool(t, ctx, srv, PoolRoundRobin, "alice")
+	defer alice.Close()
+	defer aliceLocal.Close()
+	bob, bobLocal := joinTestPool(t, ctx, srv, PoolRoundRobin, "bob")
+	defer bob.Close()
+	defer bobLocal.Close()
+
+	assert.Equal(t, alice.PublicURL(), bob.PublicURL())
+	assert.True(t, alice.HasCapability(CapPool))
+
+	hits := map[string]int{}
+	for i := 0; i < 4; i++ {
+		_, body := getPair(t, srv, nil)
+		hits[body]++
+	}
+	assert.Equal(t, map[string]int{"alice": 2, "bob": 2}, hits)
+
+	req, _ := http.NewRequest("GET", "http://"+srv.Addr()+"/api/pools/pair", nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var stats PoolStatsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	assert.Equal(t, PoolRoundRobin, stats.Strategy)
+	require.Len(t, stats.Members, 2)
+	for _, m := range stats.Members {
+		assert.Equal(t, int64(2), m.Requests)
+		assert.True(t, m.Healthy)
+		assert.NotEmpty(t, m.ID)
+	}
+}
+
+func TestPoolSkipsFailedMember(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startPoolServer(t, ctx)
+	alice, aliceLocal := joinTestPool(t, ctx, srv, PoolRoundRobin, "alice")
+	defer alice.Close()
+	bob, bobLocal := joinTestPool(t, ctx, srv, PoolRoundRobin, "bob")
+	defer bob.Close()
+	defer bobLocal.Close()
+
+	// alice's app goes down; after one failed request she is skipped
+	aliceLocal.Close()
+
+	var failed int
+	for i := 0; i < 6; i++ {
+		resp, body := getPair(t, srv, nil)
+		if resp.StatusCode == http.StatusBadGateway {
+			failed++
+			continue
+		}
+		assert.Equal(t, "bob", body)
+	}
+	assert.Equal(t, 1, failed)
+
+	stats, ok := srv.PoolStats("pair")
+	require.True(t, ok)
+	var unhealthy int
+	for _, m := range stats.Members {
+		if !m.Healthy {
+			unhealthy++
+			assert.Equal(t, int64(1), m.Failures)
+		}
+	}
+	assert.Equal(t, 1, unhealthy)
+}
+
+func TestPoolSticky(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startPoolServer(t, ctx)
+	alice, aliceLocal := joinTestPool(t, ctx, srv, PoolSticky, "alice")
+	defer alice.Close()
+	defer aliceLocal.Close()
+	bob, bobLocal := joinTestPool(t, ctx, srv, PoolSticky, "bob")
+	defer bob.Close()
+	defer bobLocal.Close()
+
+	resp, first := getPair(t, srv, nil)
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == StickyCookie {
+			cookie = c
+		}
+	}
+	require.NotNil(t, cookie)
+
+	for i := 0; i < 4; i++ {
+		_, body := getPair(t, srv, cookie)
+		assert.Equal(t, first, body)
+	}
+}
+
+func TestPoolLeastInflight(t *testing.T) {
+	busy, idle := &Session{ID: "busy"}, &Session{ID: "idle"}
+	busy.inflight.Store(3)
+	idle.inflight.Store(1)
+
+	p := &pool{strategy: PoolLeastInflight, members: []*Session{busy, idle}}
+	r := httptest.NewRequest("GET", "/", nil)
+	assert.Equal(t, idle, p.pick(httptest.NewRecorder(), r))
+
+	idle.SetHealthy(false)
+	assert.Equal(t, busy, p.pick(httptest.NewRecorder(), r), "unhealthy members are skipped")
+
+	busy.SetHealthy(false)
+	assert.NotNil(t, p.pick(httptest.NewRecorder(), r), "with no healthy member any member serves")
+}
+
+func TestPoolMemberLeaves(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startPoolServer(t, ctx)
+	alice, aliceLocal := joinTestPool(t, ctx, srv, PoolRoundRobin, "alice")
+	defer aliceLocal.Close()
+	bob, bobLocal := joinTestPool(t, ctx, srv, PoolRoundRobin, "bob")
+	defer bob.Close()
+	defer bobLocal.Close()
+
+	alice.Close()
+	require.Eventually(t, func() bool {
+		stats, _ := srv.PoolStats("pair")
+		return len(stats.Members) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		_, body := getPair(t, srv, nil)
+		assert.Equal(t, "bob", body)
+	}
+}
+
+func TestPoolRequiresReservation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startPoolServer(t, ctx)
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "open", Pool: PoolRoundRobin})
+	client.SetReconnect(false)
+	err := client.Connect(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reserved subdomain")
+
+	client = NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "pair", AuthToken: "tok", Pool: "random"})
+	client.SetReconnect(false)
+	err = client.Connect(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown pool strategy")
+}
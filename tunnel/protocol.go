@@ -5,26 +5,96 @@ import (
 	"encoding/hex"
 )
 
+// Stream kinds carried in RequestFrame.Kind. An empty kind is a regular
+// HTTP request; StreamTLS is followed by the raw bytes of a TLS connection.
+const (
+	StreamHTTP = ""
+	StreamTLS  = "tls"
+)
+
+// HandshakeRequest opens a tunnel. Version is kept for servers that predate
+// negotiation; newer servers pick from Versions and Capabilities.
 type HandshakeRequest struct {
-	Version   string `json:"version"`
-	AuthToken string `json:"auth_token,omitempty"`
-	Subdomain string `json:"subdomain,omitempty"`
+	Version      string   `json:"version"`
+	Versions     []string `json:"versions,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Compression  []string `json:"compression,omitempty"`
+	AuthToken    string   `json:"auth_token,omitempty"`
+	Subdomain    string   `json:"subdomain,omitempty"`
+	ResumeToken  string   `json:"resume_token,omitempty"`
+	Passthrough  bool     `json:"passthrough,omitempty"`
+	Control      bool     `json:"control,omitempty"`
+	// Pool asks to share a reserved subdomain with other clients, naming
+	// the strategy used to pick between them.
+	Pool string `json:"pool,omitempty"`
+	// MaxBodyBytes asks for a lower request body cap than the server's.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
 }
 
+// HandshakeResponse carries the negotiated Version and the Capabilities both
+// sides share. SupportedVersions is always set so a rejected client can tell
+// the user what to upgrade to.
 type HandshakeResponse struct {
-	Success   bool   `json:"success"`
-	Subdomain string `json:"subdomain"`
-	PublicURL string `json:"public_url"`
-	Error     string `json:"error,omitempty"`
+	Success           bool     `json:"success"`
+	Subdomain         string   `json:"subdomain"`
+	PublicURL         string   `json:"public_url"`
+	Error             string   `json:"error,omitempty"`
+	Control           bool     `json:"control,omitempty"`
+	Version           string   `json:"version,omitempty"`
+	SupportedVersions []string `json:"supported_versions,omitempty"`
+	Capabilities      []string `json:"capabilities,omitempty"`
+	Compression       string   `json:"compression,omitempty"`
+	ResumeToken       string   `json:"resume_token,omitempty"`
+	Resumed           bool     `json:"resumed,omitempty"`
+}
+
+// Control message types. When both sides agree on Control at handshake, the
+// handshake stream stays open and carries ControlMessages as JSON lines.
+const (
+	ControlNotice    = "notice"
+	ControlShutdown  = "shutdown"
+	ControlReconnect = "reconnect"
+	ControlRateLimit = "rate_limit"
+	ControlClose     = "close"
+	ControlConfig    = "config"
+	ControlPing      = "ping"
+	ControlPong      = "pong"
+	// ControlHealth carries the client's upstream health in Status.
+	ControlHealth = "health"
+)
+
+// Upstream health reported in ControlHealth messages.
+const (
+	UpstreamUp   = "up"
+	UpstreamDown = "down"
+)
+
+type ControlMessage struct {
+	Type         string            `json:"type"`
+	Message      string            `json:"message,omitempty"`
+	Timestamp    int64             `json:"ts"`
+	RetryAfterMs int64             `json:"retry_after_ms,omitempty"`
+	LatencyMs    int64             `json:"latency_ms,omitempty"`
+	Config       map[string]string `json:"config,omitempty"`
+	Status       string            `json:"status,omitempty"`
 }
 
+// RequestFrame and ResponseFrame bodies are compressed with Encoding when
+// the session negotiated compression; an empty Encoding is a plain body.
 type RequestFrame struct {
-	ID      string            `json:"id"`
-	Method  string            `json:"method"`
-	URL     string            `json:"url"`
-	Headers map[string]string `json:"headers"`
-	Body    []byte            `json:"body"`
-	TraceID string            `json:"trace_id,omitempty"`
+	ID       string            `json:"id"`
+	Kind     string            `json:"kind,omitempty"`
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Headers  map[string]string `json:"headers"`
+	Body     []byte            `json:"body"`
+	Encoding string            `json:"encoding,omitempty"`
+	TraceID  string            `json:"trace_id,omitempty"`
+	// TimeoutMs is how long the client may wait on its local app.
+	TimeoutMs int64 `json:"timeout_ms,omitempty"`
+	// ReceivedAt is set on requests delivered from the offline inbox: when
+	// the server received them, in unix milliseconds.
+	ReceivedAt int64 `json:"received_at,omitempty"`
 }
 
 type ResponseFrame struct {
@@ -32,6 +102,7 @@ type ResponseFrame struct {
 	StatusCode int               `json:"status"`
 	Headers    map[string]string `json:"headers"`
 	Body       []byte            `json:"body"`
+	Encoding   string            `json:"encoding,omitempty"`
 }
 
 func generateSubdomain() string {
@@ -1,106 +1,382 @@
 package tunnel
 
 import (
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-type RateLimiter struct {
-	mu             sync.Mutex
-	requestsPerMin int
-	maxConns       int
-	windows        map[string]*slidingWindow
-	connCounts     map[string]int
+const (
+	// PlanDefault applies to tenants without an override.
+	PlanDefault = "default"
+	// PlanPerIP limits each visiting client IP across all tunnels.
+	PlanPerIP = "per-ip"
+
+	defaultPerIPRequestsPerMin = 600
+
+	// bucketPruneInterval is how often idle buckets are dropped.
+	bucketPruneInterval = time.Minute
+)
+
+// RatePlan is a named set of limits. Requests refill at RequestsPerMin and
+// up to Burst may be made at once. MaxConns caps a tenant's concurrent
+// tunnels and the quotas its traffic per UTC day and calendar month; zero
+// means unlimited.
+type RatePlan struct {
+	Name            string `json:"name"`
+	RequestsPerMin  int    `json:"requests_per_min"`
+	Burst           int    `json:"burst"`
+	MaxConns        int    `json:"max_conns"`
+	DailyRequests   int64  `json:"daily_requests,omitempty"`
+	MonthlyRequests int64  `json:"monthly_requests,omitempty"`
+	DailyBytes      int64  `json:"daily_bytes,omitempty"`
+	MonthlyBytes    int64  `json:"monthly_bytes,omitempty"`
+}
+
+// TenantPlan assigns a plan to one tenant, overriding PlanDefault.
+type TenantPlan struct {
+	Tenant string `json:"tenant"`
+	Plan   string `json:"plan"`
+}
+
+// RatePlanRepo stores plans and tenant overrides, so they survive restarts
+// and can be changed without one.
+type RatePlanRepo interface {
+	ListPlans() ([]*RatePlan, error)
+	SavePlan(plan *RatePlan) error
+	DeletePlan(name string) error
+	ListTenantPlans() ([]*TenantPlan, error)
+	SetTenantPlan(tenant, plan string) error
+	DeleteTenantPlan(tenant string) error
+}
+
+// RateDecision is the outcome of checking a request against a bucket.
+type RateDecision struct {
+	Allowed    bool
+	Plan       string
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
 }
 
-type slidingWindow struct {
-	timestamps []int64
+// RetryAfterSeconds rounds RetryAfter up to whole seconds, as Retry-After
+// requires.
+func (d RateDecision) RetryAfterSeconds() int {
+	return ceilSeconds(d.RetryAfter)
 }
 
+// RateLimiter is a GCRA limiter. Each bucket only stores its theoretical
+// arrival time, so a check costs the same however busy the key is.
+//
+// Tenants are "token:<hash>" for clients holding a reservation token, see
+// tokenTenant, or "ip:<addr>" for anonymous clients, so picking a fresh
+// subdomain does not reset a limit.
+type RateLimiter struct {
+	mu         sync.Mutex
+	plans      map[string]RatePlan
+	tenants    map[string]string
+	buckets    map[string]time.Time
+	connCounts map[string]int
+	lastPrune  time.Time
+}
+
+// NewRateLimiter starts with a default plan allowing requestsPerMin, all of
+// them at once, and maxConns tunnels per tenant.
 func NewRateLimiter(requestsPerMin, maxConns int) *RateLimiter {
-	return &RateLimiter{
-		requestsPerMin: requestsPerMin,
-		maxConns:       maxConns,
-		windows:        make(map[string]*slidingWindow),
-		connCounts:     make(map[string]int),
+	rl := &RateLimiter{
+		buckets:    make(map[string]time.Time),
+		connCounts: make(map[string]int),
+		lastPrune:  time.Now(),
 	}
+	rl.SetPlans([]RatePlan{{Name: PlanDefault, RequestsPerMin: requestsPerMin, Burst: requestsPerMin, MaxConns: maxConns}}, nil)
+	return rl
 }
 
-func (rl *RateLimiter) AllowRequest(subdomain string) (bool, int) {
+// SetPlans replaces the plans and tenant overrides. Buckets keep their
+// state, so a reload does not hand every tenant a fresh burst. Missing
+// default and per-IP plans keep their current values.
+func (rl *RateLimiter) SetPlans(plans []RatePlan, tenants map[string]string) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	now := time.Now().UnixMilli()
-	windowStart := now - 60000 // 1 minute window
+	next := make(map[string]RatePlan, len(plans)+2)
+	for _, name := range []string{PlanDefault, PlanPerIP} {
+		if p, ok := rl.plans[name]; ok {
+			next[name] = p
+		}
+	}
+	if _, ok := next[PlanPerIP]; !ok {
+		next[PlanPerIP] = RatePlan{Name: PlanPerIP, RequestsPerMin: defaultPerIPRequestsPerMin, Burst: defaultPerIPRequestsPerMin}
+	}
+	for _, p := range plans {
+		if p.Burst <= 0 {
+			p.Burst = p.RequestsPerMin
+		}
+		next[p.Name] = p
+	}
+	rl.plans = next
 
-	window, ok := rl.windows[subdomain]
-	if !ok {
-		window = &slidingWindow{}
-		rl.windows[subdomain] = window
+	rl.tenants = make(map[string]string, len(tenants))
+	for tenant, plan := range tenants {
+		rl.tenants[tenant] = plan
 	}
+}
 
-	// prune old timestamps
-	var valid []int64
-	for _, ts := range window.timestamps {
-		if ts > windowStart {
-			valid = append(valid, ts)
+// Plans returns the active plans and tenant overrides.
+func (rl *RateLimiter) Plans() ([]RatePlan, map[string]string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	plans := make([]RatePlan, 0, len(rl.plans))
+	for _, p := range rl.plans {
+		plans = append(plans, p)
+	}
+	tenants := make(map[string]string, len(rl.tenants))
+	for tenant, plan := range rl.tenants {
+		tenants[tenant] = plan
+	}
+	return plans, tenants
+}
+
+// Plan returns the plan tenant is on.
+func (rl *RateLimiter) Plan(tenant string) RatePlan {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.planLocked(tenant)
+}
+
+// VisitorPlan returns the plan each visiting client IP is held to.
+func (rl *RateLimiter) VisitorPlan() RatePlan {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.plans[PlanPerIP]
+}
+
+func (rl *RateLimiter) planLocked(tenant string) RatePlan {
+	if name, ok := rl.tenants[tenant]; ok {
+		if p, ok := rl.plans[name]; ok {
+			return p
 		}
 	}
-	window.timestamps = valid
+	return rl.plans[PlanDefault]
+}
+
+// AllowRequest takes one request from tenant's bucket, returning the
+// seconds to wait when it is empty.
+func (rl *RateLimiter) AllowRequest(tenant string) (bool, int) {
+	d := rl.Allow(tenant, "")
+	return d.Allowed, d.RetryAfterSeconds()
+}
+
+// Allow takes one request from both tenant's bucket and the visiting ip's
+// bucket; either may be empty to skip it. The request is only counted when
+// both allow it. The decision reported is the more restrictive of the two.
+func (rl *RateLimiter) Allow(tenant, ip string) RateDecision {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.pruneLocked(now)
 
-	if len(window.timestamps) >= rl.requestsPerMin {
-		// calculate retry-after (oldest timestamp + 60s - now)
-		oldest := window.timestamps[0]
-		retryAfter := int((oldest + 60000 - now) / 1000)
-		if retryAfter < 1 {
-			retryAfter = 1
+	var checks []rateCheck
+	if tenant != "" {
+		checks = append(checks, rateCheck{"tenant:" + tenant, rl.planLocked(tenant)})
+	}
+	if ip != "" {
+		checks = append(checks, rateCheck{"ip:" + ip, rl.plans[PlanPerIP]})
+	}
+
+	var decision RateDecision
+	tats := make([]time.Time, len(checks))
+	for i, c := range checks {
+		d, tat := rl.gcra(c.key, c.plan, now)
+		tats[i] = tat
+		if i == 0 || d.stricterThan(decision) {
+			decision = d
+		}
+	}
+	if len(checks) == 0 {
+		return RateDecision{Allowed: true}
+	}
+	if decision.Allowed {
+		for i, c := range checks {
+			rl.buckets[c.key] = tats[i]
 		}
-		return false, retryAfter
 	}
+	return decision
+}
+
+// Peek reports what tenant and ip have left without taking a request.
+func (rl *RateLimiter) Peek(tenant, ip string) (tenantDecision, ipDecision RateDecision) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	return rl.peek("tenant:"+tenant, rl.planLocked(tenant), now), rl.peek("ip:"+ip, rl.plans[PlanPerIP], now)
+}
+
+func (d RateDecision) stricterThan(other RateDecision) bool {
+	if d.Allowed != other.Allowed {
+		return !d.Allowed
+	}
+	if !d.Allowed {
+		return d.RetryAfter > other.RetryAfter
+	}
+	return d.Remaining < other.Remaining
+}
+
+type rateCheck struct {
+	key  string
+	plan RatePlan
+}
+
+// gcra checks one request against key, returning the decision and the
+// arrival time to store if the request goes ahead.
+func (rl *RateLimiter) gcra(key string, plan RatePlan, now time.Time) (RateDecision, time.Time) {
+	d := RateDecision{Plan: plan.Name, Limit: plan.Burst, Allowed: true}
+	if plan.RequestsPerMin <= 0 {
+		return d, now
+	}
+	interval := time.Minute / time.Duration(plan.RequestsPerMin)
+	tolerance := interval * time.Duration(plan.Burst)
+
+	tat := rl.buckets[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(interval)
+	allowAt := next.Add(-tolerance)
+	if now.Before(allowAt) {
+		d.Allowed = false
+		d.RetryAfter = allowAt.Sub(now)
+		d.Reset = tat.Sub(now)
+		return d, tat
+	}
+	d.Remaining = int(now.Sub(allowAt) / interval)
+	d.Reset = next.Sub(now)
+	return d, next
+}
+
+// peek is gcra for a request of zero cost.
+func (rl *RateLimiter) peek(key string, plan RatePlan, now time.Time) RateDecision {
+	d := RateDecision{Plan: plan.Name, Limit: plan.Burst, Remaining: plan.Burst, Allowed: true}
+	tat := rl.buckets[key]
+	if plan.RequestsPerMin <= 0 || !tat.After(now) {
+		return d
+	}
+	interval := time.Minute / time.Duration(plan.RequestsPerMin)
+	d.Reset = tat.Sub(now)
+	d.Remaining = plan.Burst - int((d.Reset+interval-1)/interval)
+	if d.Remaining < 0 {
+		d.Remaining = 0
+	}
+	d.Allowed = d.Remaining > 0
+	return d
+}
 
-	window.timestamps = append(window.timestamps, now)
-	return true, 0
+// pruneLocked drops buckets that have refilled, which behave the same as
+// missing ones.
+func (rl *RateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(rl.lastPrune) < bucketPruneInterval {
+		return
+	}
+	rl.lastPrune = now
+	for key, tat := range rl.buckets {
+		if !tat.After(now) {
+			delete(rl.buckets, key)
+		}
+	}
 }
 
-func (rl *RateLimiter) AcquireConnection(subdomain string) bool {
+func (rl *RateLimiter) AcquireConnection(tenant string) bool {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	count := rl.connCounts[subdomain]
-	if count >= rl.maxConns {
+	count := rl.connCounts[tenant]
+	if max := rl.planLocked(tenant).MaxConns; max > 0 && count >= max {
 		return false
 	}
-	rl.connCounts[subdomain] = count + 1
+	rl.connCounts[tenant] = count + 1
 	return true
 }
 
-func (rl *RateLimiter) ReleaseConnection(subdomain string) {
+func (rl *RateLimiter) ReleaseConnection(tenant string) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	count := rl.connCounts[subdomain]
+	count := rl.connCounts[tenant]
 	if count > 0 {
-		rl.connCounts[subdomain] = count - 1
+		rl.connCounts[tenant] = count - 1
 	}
-	if rl.connCounts[subdomain] == 0 {
-		delete(rl.connCounts, subdomain)
+	if rl.connCounts[tenant] == 0 {
+		delete(rl.connCounts, tenant)
 	}
 }
 
-func (rl *RateLimiter) CleanupSubdomain(subdomain string) {
+// CleanupTenant forgets tenant's request bucket and connection count.
+func (rl *RateLimiter) CleanupTenant(tenant string) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
-	delete(rl.windows, subdomain)
-	delete(rl.connCounts, subdomain)
+	delete(rl.buckets, "tenant:"+tenant)
+	delete(rl.connCounts, tenant)
 }
 
+// GetLimits returns the default plan's limits.
 func (rl *RateLimiter) GetLimits() (requestsPerMin, maxConns int) {
-	return rl.requestsPerMin, rl.maxConns
+	p := rl.Plan("")
+	return p.RequestsPerMin, p.MaxConns
+}
+
+// clientIP is the address a request came from, without its port. Behind
+// a trusted proxy it is the last address the proxy put in its header; the
+// ones before it were sent by the visitor and can't be trusted.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.trustedProxyHeader != "" {
+		if v := r.Header.Values(s.trustedProxyHeader); len(v) > 0 {
+			addrs := strings.Split(v[len(v)-1], ",")
+			if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// tokenTenant is the tenant a client holding the token with tokenHash is
+// counted as, however many subdomains it reserves.
+func tokenTenant(tokenHash string) string {
+	return "token:" + tokenHash[:min(len(tokenHash), 16)]
+}
+
+// WriteRateLimitHeaders describes d with the RateLimit-* fields from the
+// IETF httpapi rate limit draft.
+func WriteRateLimitHeaders(w http.ResponseWriter, d RateDecision) {
+	if d.Limit <= 0 {
+		return
+	}
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Second - 1) / time.Second)
 }
 
 func WriteRateLimitExceeded(w http.ResponseWriter, retryAfter int) {
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
 	w.WriteHeader(http.StatusTooManyRequests)
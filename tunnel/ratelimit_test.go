@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -67,10 +68,10 @@ func TestRateLimiterCleanup(t *testing.T) {
 	rl.AllowRequest("sub1")
 	rl.AcquireConnection("sub1")
 
-	rl.CleanupSubdomain("sub1")
+	rl.CleanupTenant("sub1")
 
 	rl.mu.Lock()
-	_, hasWindow := rl.windows["sub1"]
+	_, hasWindow := rl.buckets["tenant:sub1"]
 	_, hasConn := rl.connCounts["sub1"]
 	rl.mu.Unlock()
 
@@ -85,6 +86,95 @@ func TestRateLimiterGetLimits(t *testing.T) {
 	assert.Equal(t, 10, mc)
 }
 
+func TestRateLimiterBurstRefills(t *testing.T) {
+	rl := NewRateLimiter(60, 5)
+	rl.SetPlans([]RatePlan{{Name: PlanDefault, RequestsPerMin: 600, Burst: 2}}, nil)
+
+	d := rl.Allow("sub1", "")
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 2, d.Limit)
+	assert.Equal(t, 1, d.Remaining)
+	assert.True(t, rl.Allow("sub1", "").Allowed)
+
+	d = rl.Allow("sub1", "")
+	assert.False(t, d.Allowed)
+	assert.LessOrEqual(t, d.RetryAfter, 100*time.Millisecond, "one request refills every 100ms")
+	assert.Equal(t, 1, d.RetryAfterSeconds())
+
+	time.Sleep(d.RetryAfter + 5*time.Millisecond)
+	assert.True(t, rl.Allow("sub1", "").Allowed)
+	assert.False(t, rl.Allow("sub1", "").Allowed)
+}
+
+func TestRateLimiterTenantPlans(t *testing.T) {
+	rl := NewRateLimiter(2, 1)
+	rl.SetPlans([]RatePlan{{Name: "pro", RequestsPerMin: 10, MaxConns: 3}}, map[string]string{"acme": "pro"})
+
+	assert.Equal(t, "pro", rl.Plan("acme").Name)
+	assert.Equal(t, 10, rl.Plan("acme").Burst, "burst defaults to a minute of requests")
+	assert.Equal(t, PlanDefault, rl.Plan("ip:192.0.2.1").Name)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, rl.AcquireConnection("acme"))
+	}
+	assert.False(t, rl.AcquireConnection("acme"))
+	assert.True(t, rl.AcquireConnection("other"))
+	assert.False(t, rl.AcquireConnection("other"))
+
+	rl.Allow("other", "")
+	rl.Allow("other", "")
+	assert.False(t, rl.Allow("other", "").Allowed)
+
+	// reloading keeps the buckets, so a reload is not a free reset
+	rl.SetPlans([]RatePlan{{Name: "pro", RequestsPerMin: 10}}, map[string]string{"acme": "pro"})
+	assert.False(t, rl.Allow("other", "").Allowed)
+	rpm, maxConns := rl.GetLimits()
+	assert.Equal(t, 2, rpm, "the default plan survives a reload that omits it")
+	assert.Equal(t, 1, maxConns)
+}
+
+func TestRateLimiterVisitorIP(t *testing.T) {
+	rl := NewRateLimiter(60, 5)
+	rl.SetPlans([]RatePlan{{Name: PlanPerIP, RequestsPerMin: 2}}, nil)
+
+	assert.True(t, rl.Allow("sub1", "192.0.2.1").Allowed)
+	d := rl.Allow("sub2", "192.0.2.1")
+	assert.True(t, d.Allowed)
+	assert.Equal(t, PlanPerIP, d.Plan, "the stricter bucket is reported")
+	assert.Equal(t, 0, d.Remaining)
+
+	d = rl.Allow("sub3", "192.0.2.1")
+	assert.False(t, d.Allowed, "one visitor is limited across tunnels")
+	assert.True(t, rl.Allow("sub3", "192.0.2.2").Allowed)
+
+	tenant, _ := rl.Peek("sub3", "192.0.2.2")
+	assert.Equal(t, 59, tenant.Remaining, "a refused request is not counted against the tenant")
+}
+
+func TestClientIPTrustedProxyHeader(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:4321"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+
+	direct := NewServer(ServerConfig{})
+	assert.Equal(t, "10.0.0.1", direct.clientIP(r), "the header is ignored unless trusted")
+
+	proxied := NewServer(ServerConfig{TrustedProxyHeader: "x-forwarded-for"})
+	assert.Equal(t, "198.51.100.7", proxied.clientIP(r), "the address the proxy appended")
+
+	r.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "10.0.0.1", proxied.clientIP(r))
+}
+
+func TestWriteRateLimitHeaders(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteRateLimitHeaders(w, RateDecision{Limit: 10, Remaining: 3, Reset: 1500 * time.Millisecond})
+
+	assert.Equal(t, "10", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "3", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Reset"))
+}
+
 func TestWriteRateLimitExceeded(t *testing.T) {
 	w := httptest.NewRecorder()
 	WriteRateLimitExceeded(w, 30)
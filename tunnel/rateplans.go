@@ -0,0 +1,285 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+const maxPlanName = 64
+
+type APIRatePlansResponse struct {
+	Plans   []RatePlan   `json:"plans"`
+	Tenants []TenantPlan `json:"tenants"`
+}
+
+type SetTenantPlanRequest struct {
+	Plan string `json:"plan"`
+}
+
+// ReloadRatePlans rereads plans and tenant overrides from the repo. Buckets
+// and open connections carry over, so it is safe while tunnels are busy.
+func (s *Server) ReloadRatePlans() error {
+	if s.ratePlans == nil || s.rateLimiter == nil {
+		return nil
+	}
+	stored, err := s.ratePlans.ListPlans()
+	if err != nil {
+		return fmt.Errorf("list rate plans: %w", err)
+	}
+	overrides, err := s.ratePlans.ListTenantPlans()
+	if err != nil {
+		return fmt.Errorf("list tenant plans: %w", err)
+	}
+
+	plans := make([]RatePlan, len(stored))
+	for i, p := range stored {
+		plans[i] = *p
+	}
+	tenants := make(map[string]string, len(overrides))
+	for _, o := range overrides {
+		tenants[o.Tenant] = o.Plan
+	}
+	s.rateLimiter.SetPlans(plans, tenants)
+
+	s.logger.WithFields(logging.Fields{"plans": len(plans), "tenants": len(tenants)}).Info("server", "ratelimit", "Rate plans loaded")
+	return nil
+}
+
+// tenantFor is who requests to subdomain count against: its tunnel's
+// tenant, or the reservation while the tunnel is offline. Unknown
+// subdomains have none and are only limited per visitor IP.
+func (s *Server) tenantFor(subdomain string) string {
+	if sess := s.GetSession(subdomain); sess != nil {
+		return sess.Tenant
+	}
+	if s.reservations != nil {
+		if res, err := s.reservations.Get(subdomain); err == nil && res != nil {
+			return tokenTenant(res.TokenHash)
+		}
+	}
+	return ""
+}
+
+// allowRequest takes a proxied request from the tenant's and the visitor's
+// buckets, describing what is left in RateLimit-* headers and writing the
+// 429 when either is empty, then checks the tenant's quotas.
+func (s *Server) allowRequest(w http.ResponseWriter, r *http.Request, subdomain string) bool {
+	if s.rateLimiter == nil {
+		return true
+	}
+	tenant := s.tenantFor(subdomain)
+	d := s.rateLimiter.Allow(tenant, s.clientIP(r))
+	WriteRateLimitHeaders(w, d)
+	if !d.Allowed {
+		s.warnRateLimited(subdomain, "rate limit exceeded", d.RetryAfterSeconds())
+		WriteRateLimitExceeded(w, d.RetryAfterSeconds())
+		return false
+	}
+	return s.allowQuota(w, subdomain, tenant)
+}
+
+// allowVisitor takes a request to one of the server's own public
+// endpoints from the caller's per-IP bucket, writing the 429 when it is
+// empty.
+func (s *Server) allowVisitor(w http.ResponseWriter, r *http.Request) bool {
+	if s.rateLimiter == nil {
+		return true
+	}
+	d := s.rateLimiter.Allow("", s.clientIP(r))
+	WriteRateLimitHeaders(w, d)
+	if !d.Allowed {
+		WriteRateLimitExceeded(w, d.RetryAfterSeconds())
+		return false
+	}
+	return true
+}
+
+// handleAdminRatePlans lists the plans and tenant overrides in effect.
+func (s *Server) handleAdminRatePlans(w http.ResponseWriter, r *http.Request) {
+	if !s.requireRatePlans(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	plans, tenants := s.rateLimiter.Plans()
+	resp := APIRatePlansResponse{Plans: plans, Tenants: []TenantPlan{}}
+	for tenant, plan := range tenants {
+		resp.Tenants = append(resp.Tenants, TenantPlan{Tenant: tenant, Plan: plan})
+	}
+	sortRatePlans(resp)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleAdminRatePlanByName creates or replaces a plan with PUT and removes
+// it with DELETE. POST /api/admin/rate-plans/reload rereads the repo, for
+// edits made to the database directly.
+func (s *Server) handleAdminRatePlanByName(w http.ResponseWriter, r *http.Request) {
+	if !s.requireRatePlans(w, r) {
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/api/admin/rate-plans/")
+	switch {
+	case name == "reload" && r.Method == http.MethodPost:
+		if err := s.ReloadRatePlans(); err != nil {
+			s.logger.WithError(err).Error("server", "ratelimit", "Reload rate plans failed")
+			writeServerJSONError(w, "reload rate plans failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		var plan RatePlan
+		if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+			writeServerJSONError(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		plan.Name = name
+		if plan.Burst == 0 {
+			plan.Burst = plan.RequestsPerMin
+		}
+		if msg := validateRatePlan(plan); msg != "" {
+			writeServerJSONError(w, msg, http.StatusBadRequest)
+			return
+		}
+		if err := s.ratePlans.SavePlan(&plan); err != nil {
+			writeServerJSONError(w, "save rate plan failed", http.StatusInternalServerError)
+			return
+		}
+		if !s.reloadAfterChange(w) {
+			return
+		}
+		s.logger.WithFields(logging.Fields{"plan": plan.Name, "requests_per_min": plan.RequestsPerMin, "burst": plan.Burst, "max_conns": plan.MaxConns}).Info("server", "admin", "Rate plan saved")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(plan)
+
+	case r.Method == http.MethodDelete:
+		if name == PlanDefault || name == PlanPerIP {
+			writeServerJSONError(w, "built-in plan cannot be deleted", http.StatusBadRequest)
+			return
+		}
+		if err := s.ratePlans.DeletePlan(name); err != nil {
+			writeServerJSONError(w, "delete rate plan failed", http.StatusInternalServerError)
+			return
+		}
+		if !s.reloadAfterChange(w) {
+			return
+		}
+		s.logger.WithFields(logging.Fields{"plan": name}).Info("server", "admin", "Rate plan deleted")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminTenantPlan puts a tenant on a plan with PUT and back on the
+// default with DELETE.
+func (s *Server) handleAdminTenantPlan(w http.ResponseWriter, r *http.Request) {
+	if !s.requireRatePlans(w, r) {
+		return
+	}
+
+	tenant := strings.TrimPrefix(r.URL.Path, "/api/admin/tenant-plans/")
+	if tenant == "" {
+		writeServerJSONError(w, "missing tenant", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var req SetTenantPlanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeServerJSONError(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		plans, _ := s.rateLimiter.Plans()
+		if !hasRatePlan(plans, req.Plan) {
+			writeServerJSONError(w, "unknown plan", http.StatusBadRequest)
+			return
+		}
+		if err := s.ratePlans.SetTenantPlan(tenant, req.Plan); err != nil {
+			writeServerJSONError(w, "set tenant plan failed", http.StatusInternalServerError)
+			return
+		}
+		if !s.reloadAfterChange(w) {
+			return
+		}
+		s.logger.WithFields(logging.Fields{"tenant": tenant, "plan": req.Plan}).Info("server", "admin", "Tenant plan set")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TenantPlan{Tenant: tenant, Plan: req.Plan})
+
+	case http.MethodDelete:
+		if err := s.ratePlans.DeleteTenantPlan(tenant); err != nil {
+			writeServerJSONError(w, "delete tenant plan failed", http.StatusInternalServerError)
+			return
+		}
+		if !s.reloadAfterChange(w) {
+			return
+		}
+		s.logger.WithFields(logging.Fields{"tenant": tenant}).Info("server", "admin", "Tenant plan removed")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) requireRatePlans(w http.ResponseWriter, r *http.Request) bool {
+	if !s.requireAdmin(w, r) {
+		return false
+	}
+	if s.ratePlans == nil || s.rateLimiter == nil {
+		writeServerJSONError(w, "rate plans not configured", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+func (s *Server) reloadAfterChange(w http.ResponseWriter) bool {
+	if err := s.ReloadRatePlans(); err != nil {
+		s.logger.WithError(err).Error("server", "ratelimit", "Reload rate plans failed")
+		writeServerJSONError(w, "reload rate plans failed", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func validateRatePlan(plan RatePlan) string {
+	switch {
+	case plan.Name == "" || len(plan.Name) > maxPlanName || strings.Contains(plan.Name, "/"):
+		return "invalid plan name"
+	case plan.RequestsPerMin <= 0:
+		return "requests_per_min must be positive"
+	case plan.Burst < 1:
+		return "burst must be positive"
+	case plan.MaxConns < 0:
+		return "max_conns must not be negative"
+	case plan.DailyRequests < 0 || plan.MonthlyRequests < 0 || plan.DailyBytes < 0 || plan.MonthlyBytes < 0:
+		return "quotas must not be negative"
+	}
+	return ""
+}
+
+func hasRatePlan(plans []RatePlan, name string) bool {
+	for _, p := range plans {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sortRatePlans(resp APIRatePlansResponse) {
+	sort.Slice(resp.Plans, func(i, j int) bool { return resp.Plans[i].Name < resp.Plans[j].Name })
+	sort.Slice(resp.Tenants, func(i, j int) bool { return resp.Tenants[i].Tenant < resp.Tenants[j].Tenant })
+}
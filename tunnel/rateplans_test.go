@@ -0,0 +1,236 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memRatePlanRepo struct {
+	mu      sync.Mutex
+	plans   map[string]RatePlan
+	tenants map[string]string
+}
+
+func newMemRatePlanRepo(plans ...RatePlan) *memRatePlanRepo {
+	m := &memRatePlanRepo{plans: make(map[string]RatePlan), tenants: make(map[string]string)}
+	for _, p := range plans {
+		m.plans[p.Name] = p
+	}
+	return m
+}
+
+func (m *memRatePlanRepo) ListPlans() ([]*RatePlan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*RatePlan
+	for _, p := range m.plans {
+		p := p
+		out = append(out, &p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (m *memRatePlanRepo) SavePlan(plan *RatePlan) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.plans[plan.Name] = *plan
+	return nil
+}
+
+func (m *memRatePlanRepo) DeletePlan(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.plans, name)
+	for tenant, plan := range m.tenants {
+		if plan == name {
+			delete(m.tenants, tenant)
+		}
+	}
+	return nil
+}
+
+func (m *memRatePlanRepo) ListTenantPlans() ([]*TenantPlan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*TenantPlan
+	for tenant, plan := range m.tenants {
+		out = append(out, &TenantPlan{Tenant: tenant, Plan: plan})
+	}
+	return out, nil
+}
+
+func (m *memRatePlanRepo) SetTenantPlan(tenant, plan string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tenants[tenant] = plan
+	return nil
+}
+
+func (m *memRatePlanRepo) DeleteTenantPlan(tenant string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tenants, tenant)
+	return nil
+}
+
+// startRatePlanServer runs a server whose plans come from a repo, with an
+// anonymous client on subdomain "metered".
+func startRatePlanServer(t *testing.T, ctx context.Context) (*Server, *memRatePlanRepo) {
+	plans := newMemRatePlanRepo(
+		RatePlan{Name: PlanDefault, RequestsPerMin: 100, Burst: 100, MaxConns: 10},
+		RatePlan{Name: PlanPerIP, RequestsPerMin: 1000, Burst: 1000},
+	)
+	srv := startTestTunnel(t, ctx, ServerConfig{
+		Reservations: newMemReservationRepo(),
+		AdminToken:   "admin-secret",
+		RatePlans:    plans,
+	}, ClientConfig{Subdomain: "metered"}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	return srv, plans
+}
+
+func adminRequest(t *testing.T, srv *Server, method, path, body string) *http.Response {
+	req, _ := http.NewRequest(method, "http://"+srv.Addr()+path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func getRateLimits(t *testing.T, srv *Server, query string) (*http.Response, RateLimitsResponse) {
+	resp, err := http.Get("http://" + srv.Addr() + "/api/rate-limits" + query)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var limits RateLimitsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&limits))
+	return resp, limits
+}
+
+func TestRateLimitsReportsCallerPlan(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _ := startRatePlanServer(t, ctx)
+	assert.Equal(t, "ip:127.0.0.1", srv.GetSession("metered").Tenant)
+
+	resp, limits := getRateLimits(t, srv, "")
+	assert.Equal(t, "ip:127.0.0.1", limits.Tenant)
+	assert.Equal(t, PlanDefault, limits.Plan)
+	assert.Equal(t, 100, limits.RequestsPerMin)
+	assert.Equal(t, 100, limits.Remaining)
+	assert.Equal(t, PlanPerIP, limits.IP.Plan)
+	assert.Equal(t, 1000, limits.IP.Remaining)
+	assert.Equal(t, "100", resp.Header.Get("RateLimit-Limit"))
+
+	req, _ := http.NewRequest("GET", "http://"+srv.Addr()+"/", nil)
+	req.Host = "metered.test.local"
+	proxied, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	proxied.Body.Close()
+	assert.Equal(t, "99", proxied.Header.Get("RateLimit-Remaining"))
+
+	_, limits = getRateLimits(t, srv, "?subdomain=metered")
+	assert.Equal(t, 99, limits.Remaining)
+	assert.Equal(t, 999, limits.IP.Remaining)
+}
+
+func TestReservedTunnelsCountedByToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _ := startRatePlanServer(t, ctx)
+	for _, sub := range []string{"api", "web", "offline"} {
+		srv.reservations.Save(&Reservation{Subdomain: sub, TokenHash: HashToken("shared")})
+	}
+	for _, sub := range []string{"api", "web"} {
+		client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: sub, AuthToken: "shared"})
+		client.SetReconnect(false)
+		require.NoError(t, client.Connect(ctx))
+		defer client.Close()
+	}
+
+	tenant := tokenTenant(HashToken("shared"))
+	assert.Equal(t, tenant, srv.GetSession("api").Tenant)
+	assert.Equal(t, tenant, srv.GetSession("web").Tenant, "one token is one tenant")
+	assert.Equal(t, tenant, srv.tenantFor("offline"))
+	assert.Equal(t, "ip:127.0.0.1", srv.GetSession("metered").Tenant)
+}
+
+func TestAdminRatePlansHotReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, plans := startRatePlanServer(t, ctx)
+
+	resp := adminRequest(t, srv, "PUT", "/api/admin/rate-plans/trial", `{"requests_per_min":2}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = adminRequest(t, srv, "PUT", "/api/admin/tenant-plans/ip:127.0.0.1", `{"plan":"trial"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, limits := getRateLimits(t, srv, "")
+	assert.Equal(t, "trial", limits.Plan)
+	assert.Equal(t, 2, limits.Burst)
+
+	visit := func() *http.Response {
+		req, _ := http.NewRequest("GET", "http://"+srv.Addr()+"/", nil)
+		req.Host = "metered.test.local"
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+	assert.Equal(t, http.StatusOK, visit().StatusCode, "the running tunnel moves to the new plan")
+	assert.Equal(t, http.StatusOK, visit().StatusCode)
+	limited := visit()
+	assert.Equal(t, http.StatusTooManyRequests, limited.StatusCode)
+	assert.NotEmpty(t, limited.Header.Get("Retry-After"))
+	assert.Equal(t, "0", limited.Header.Get("RateLimit-Remaining"))
+
+	resp = adminRequest(t, srv, "GET", "/api/admin/rate-plans", "")
+	var list APIRatePlansResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Len(t, list.Plans, 3)
+	assert.Equal(t, []TenantPlan{{Tenant: "ip:127.0.0.1", Plan: "trial"}}, list.Tenants)
+
+	// edits made behind the server's back apply on reload
+	plans.DeleteTenantPlan("ip:127.0.0.1")
+	_, limits = getRateLimits(t, srv, "")
+	assert.Equal(t, "trial", limits.Plan)
+	resp = adminRequest(t, srv, "POST", "/api/admin/rate-plans/reload", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	_, limits = getRateLimits(t, srv, "")
+	assert.Equal(t, PlanDefault, limits.Plan)
+}
+
+func TestAdminRatePlansValidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, _ := startRatePlanServer(t, ctx)
+
+	resp := adminRequest(t, srv, "PUT", "/api/admin/rate-plans/broken", `{"requests_per_min":0}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = adminRequest(t, srv, "PUT", "/api/admin/tenant-plans/acme", `{"plan":"missing"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = adminRequest(t, srv, "DELETE", "/api/admin/rate-plans/default", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, _ := http.NewRequest("GET", "http://"+srv.Addr()+"/api/admin/rate-plans", nil)
+	unauth, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	unauth.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, unauth.StatusCode)
+}
@@ -0,0 +1,204 @@
+package tunnel
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	mrand "math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+// maxParkWait bounds how long a request waits for its tunnel to resume.
+const maxParkWait = 10 * time.Second
+
+// parkedTunnel reserves a dropped tunnel's subdomain until the client
+// resumes it or the grace window ends. done is closed on either outcome.
+type parkedTunnel struct {
+	token string
+	timer *time.Timer
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (p *parkedTunnel) release() {
+	p.once.Do(func() { close(p.done) })
+}
+
+func generateResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// detachSession removes sess from the live sessions and, when resumption is
+// enabled, parks its subdomain. It reports whether the tunnel was parked.
+func (s *Server) detachSession(sess *Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.leavePool(sess) {
+		return false
+	}
+	if s.sessions[sess.Subdomain] != sess {
+		return false
+	}
+	delete(s.sessions, sess.Subdomain)
+
+	if s.resumeGrace <= 0 || sess.resumeToken == "" || s.draining.Load() {
+		return false
+	}
+
+	p := &parkedTunnel{token: sess.resumeToken, done: make(chan struct{})}
+	p.timer = time.AfterFunc(s.resumeGrace, func() { s.expireParked(sess.Subdomain, p) })
+	s.parked[sess.Subdomain] = p
+	return true
+}
+
+func (s *Server) expireParked(subdomain string, p *parkedTunnel) {
+	s.mu.Lock()
+	expired := s.parked[subdomain] == p
+	if expired {
+		delete(s.parked, subdomain)
+	}
+	s.mu.Unlock()
+
+	p.release()
+	if expired {
+		s.logger.WithFields(logging.Fields{"subdomain": subdomain}).Info("server", "disconnect", "Resume window expired")
+	}
+}
+
+// canResume reports whether token resumes the tunnel on subdomain. The
+// tunnel is either parked or, when the client noticed the drop before the
+// server did, still live; that stale session is returned so it can be
+// replaced.
+func (s *Server) canResume(subdomain, token string) (bool, *Session) {
+	if token == "" {
+		return false, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if p := s.parked[subdomain]; p != nil {
+		return tokenMatches(p.token, token), nil
+	}
+	if sess := s.sessions[subdomain]; sess != nil && tokenMatches(sess.resumeToken, token) {
+		return true, sess
+	}
+	return false, nil
+}
+
+func tokenMatches(want, got string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(want), []byte(got)) == 1
+}
+
+// registerSession makes sess live. A parked tunnel on the same subdomain is
+// being resumed, so requests waiting on it are released to the new session.
+// A pool on the subdomain is taken over by sess and its members closed.
+func (s *Server) registerSession(sess *Session) {
+	s.mu.Lock()
+	s.sessions[sess.Subdomain] = sess
+	p := s.parked[sess.Subdomain]
+	if p != nil {
+		delete(s.parked, sess.Subdomain)
+		p.timer.Stop()
+	}
+	var replaced []*Session
+	if pl := s.pools[sess.Subdomain]; pl != nil {
+		replaced = pl.members
+		delete(s.pools, sess.Subdomain)
+	}
+	s.mu.Unlock()
+
+	if p != nil {
+		p.release()
+	}
+	for _, member := range replaced {
+		member.Session.Close()
+	}
+}
+
+// awaitSession returns the live session for subdomain, writing an error
+// response when there is none. While the tunnel is inside its resume window,
+// idempotent requests wait for the client to come back and the rest are
+// turned away with 503 so the caller can retry. Reserved subdomains with an
+// inbox queue the request instead of failing it.
+func (s *Server) awaitSession(w http.ResponseWriter, r *http.Request, subdomain, targetPath string) *Session {
+	sess := s.pickSession(w, r, subdomain)
+	s.mu.RLock()
+	p := s.parked[subdomain]
+	s.mu.RUnlock()
+
+	if sess != nil {
+		return sess
+	}
+	if p == nil {
+		if s.holdOffline(w, r, subdomain, targetPath) {
+			return nil
+		}
+		s.writeErrorPage(w, r, http.StatusBadGateway, ErrorTunnelOffline, subdomain, "")
+		return nil
+	}
+	if !isIdempotent(r) {
+		if s.holdOffline(w, r, subdomain, targetPath) {
+			return nil
+		}
+		w.Header().Set("Retry-After", "1")
+		s.writeErrorPage(w, r, http.StatusServiceUnavailable, ErrorTunnelReconnecting, subdomain, "")
+		return nil
+	}
+
+	timer := time.NewTimer(maxParkWait)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+	case <-timer.C:
+	case <-r.Context().Done():
+		return nil
+	}
+
+	if sess := s.pickSession(w, r, subdomain); sess != nil {
+		s.logger.WithFields(logging.Fields{"subdomain": subdomain, "method": r.Method, "path": r.URL.Path}).Info("server", "proxy", "Parked request delivered")
+		return sess
+	}
+	if s.holdOffline(w, r, subdomain, targetPath) {
+		return nil
+	}
+	if s.isParked(subdomain) {
+		w.Header().Set("Retry-After", "1")
+		s.writeErrorPage(w, r, http.StatusServiceUnavailable, ErrorTunnelReconnecting, subdomain, "")
+		return nil
+	}
+	s.writeErrorPage(w, r, http.StatusBadGateway, ErrorTunnelOffline, subdomain, "")
+	return nil
+}
+
+func (s *Server) isParked(subdomain string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.parked[subdomain]
+	return ok
+}
+
+// isIdempotent reports whether r is safe to hold and deliver late: an
+// idempotent method, or any request carrying an Idempotency-Key.
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != ""
+}
+
+// fullJitter returns a random wait in [0, d), spreading reconnect attempts
+// so clients dropped together don't return together.
+func fullJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return mrand.N(d)
+}
@@ -0,0 +1,175 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dropConnection kills the client's transport without a clean shutdown and
+// waits until the server has parked the tunnel.
+func dropConnection(t *testing.T, srv *Server, client *Client, subdomain string) {
+	client.Session().Close()
+	require.Eventually(t, func() bool { return srv.isParked(subdomain) }, time.Second, 10*time.Millisecond)
+}
+
+func TestClientResumesSubdomain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{ResumeGrace: 2 * time.Second})
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "keep"})
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+	first := client.Session()
+
+	first.Close()
+	require.Eventually(t, func() bool {
+		return client.IsConnected() && client.Session() != first
+	}, 3*time.Second, 20*time.Millisecond)
+
+	assert.Equal(t, "http://keep.test.local", client.PublicURL())
+	assert.False(t, srv.isParked("keep"))
+	assert.NotNil(t, srv.GetSession("keep"))
+}
+
+func TestParkedRequestDeliveredAfterResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	localServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello " + r.URL.Path))
+	}))
+	defer localServer.Close()
+
+	srv := startTestServer(t, ctx, ServerConfig{ResumeGrace: 2 * time.Second})
+
+	client := NewClient(ClientConfig{
+		ServerAddr: srv.Addr(),
+		LocalPort:  strings.Split(localServer.Listener.Addr().String(), ":")[1],
+		Subdomain:  "blip",
+	})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	dropConnection(t, srv, client, "blip")
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://"+srv.Addr()+"/status", nil)
+		req.Host = "blip.test.local"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		results <- result{status: resp.StatusCode, body: string(body)}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, client.Connect(ctx))
+
+	select {
+	case r := <-results:
+		require.NoError(t, r.err)
+		assert.Equal(t, http.StatusOK, r.status)
+		assert.Equal(t, "hello /status", r.body)
+	case <-time.After(3 * time.Second):
+		t.Fatal("parked request was not delivered")
+	}
+}
+
+func TestNonIdempotentRequestRejectedWhileParked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{ResumeGrace: 2 * time.Second})
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "post"})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	dropConnection(t, srv, client, "post")
+
+	req := httptest.NewRequest(http.MethodPost, "http://post.test.local/hook", strings.NewReader("{}"))
+	w := httptest.NewRecorder()
+	srv.handleSubdomainProxy(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func TestParkedSubdomainNotGivenToOthers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{ResumeGrace: 2 * time.Second})
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "mine"})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	dropConnection(t, srv, client, "mine")
+
+	other := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "mine"})
+	other.SetReconnect(false)
+	require.NoError(t, other.Connect(ctx))
+	defer other.Close()
+	assert.NotEqual(t, "http://mine.test.local", other.PublicURL())
+}
+
+func TestResumeWindowExpires(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{ResumeGrace: 100 * time.Millisecond})
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000", Subdomain: "gone"})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	dropConnection(t, srv, client, "gone")
+
+	req := httptest.NewRequest(http.MethodGet, "http://gone.test.local/", nil)
+	w := httptest.NewRecorder()
+	srv.handleSubdomainProxy(w, req)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.False(t, srv.isParked("gone"))
+}
+
+func TestIsIdempotent(t *testing.T) {
+	assert.True(t, isIdempotent(httptest.NewRequest(http.MethodGet, "/", nil)))
+	assert.True(t, isIdempotent(httptest.NewRequest(http.MethodPut, "/", nil)))
+	assert.False(t, isIdempotent(httptest.NewRequest(http.MethodPost, "/", nil)))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Idempotency-Key", "abc")
+	assert.True(t, isIdempotent(req))
+}
+
+func TestFullJitter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), fullJitter(0))
+	for i := 0; i < 100; i++ {
+		d := fullJitter(time.Second)
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.Less(t, d, time.Second)
+	}
+}
@@ -6,15 +6,17 @@ import (
 	"embed"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/auditmos/devtunnel/logging"
@@ -46,28 +48,89 @@ type Server struct {
 
 	mu       sync.RWMutex
 	sessions map[string]*Session
+	parked   map[string]*parkedTunnel
+	pools    map[string]*pool
 
 	httpServer  *http.Server
 	httpsServer *http.Server
 	listener    net.Listener
 	tlsListener net.Listener
+	tlsAddr     string
 
 	blobRepo      BlobRepo
 	templates     *template.Template
 	certManager   *autocert.Manager
 	enableHTTPS   bool
+	passthrough   bool
 	certsDir      string
 	readyCallback func()
 	version       string
 	rateLimiter   *RateLimiter
+	pingInterval  time.Duration
+	drainTimeout  time.Duration
+	resumeGrace   time.Duration
+	reusePort     bool
 	logger        logging.Logger
+
+	trustedProxyHeader string
+
+	reservations ReservationRepo
+	inbox        InboxRepo
+	inboxLimits  InboxLimits
+	// inboxMu makes checking an inbox's limits and saving to it one step.
+	inboxMu    sync.Mutex
+	limits     ServerLimits
+	adminToken string
+	delivering sync.Map
+	bins       BinRepo
+	binTTL     time.Duration
+
+	interstitial bool
+	abuseReports AbuseReportRepo
+	ratePlans    RatePlanRepo
+	usage        *usageMeter
+	accessLog    *accessLogger
+
+	draining    atomic.Bool
+	compression CompressionStats
+	inflight    atomic.Int64
 }
 
 type Session struct {
-	Subdomain string
-	PublicURL string
-	Session   *yamux.Session
+	Subdomain   string
+	PublicURL   string
+	Session     *yamux.Session
 	ConnectedAt time.Time
+	Passthrough bool
+
+	// Version and Capabilities are what the handshake negotiated.
+	// Compression is the frame body encoding, empty when off.
+	Version      string
+	Capabilities []string
+	Compression  string
+
+	compression CompressionStats
+
+	control     *controlChannel
+	resumeToken string
+
+	// ID and RemoteAddr tell pool members apart.
+	ID         string
+	RemoteAddr string
+
+	// MaxBodyBytes caps request bodies proxied to this tunnel; zero is
+	// unlimited.
+	MaxBodyBytes int64
+
+	// Tenant is who the tunnel's requests and connections are counted
+	// against: "token:<hash>" for holders of a reservation token, or
+	// "ip:<addr>" for anonymous clients.
+	Tenant string
+
+	inflight       atomic.Int64
+	requests       atomic.Int64
+	failures       atomic.Int64
+	unhealthyUntil atomic.Int64
 }
 
 type ServerConfig struct {
@@ -76,16 +139,62 @@ type ServerConfig struct {
 	BlobRepo       BlobRepo
 	AutoDomain     bool
 	EnableHTTPS    bool
+	TLSAddr        string
+	Passthrough    bool
 	CertsDir       string
 	Version        string
 	RequestsPerMin int
 	MaxConns       int
+	PingInterval   time.Duration
 	Logger         logging.Logger
+
+	// DrainTimeout bounds how long shutdown waits for in-flight requests
+	// after asking clients to reconnect. Zero shuts down immediately.
+	DrainTimeout time.Duration
+	// ReusePort binds listeners with SO_REUSEPORT so a new process can take
+	// over the same ports while this one drains.
+	ReusePort bool
+	// ResumeGrace holds a dropped tunnel's subdomain this long for the client
+	// to resume it, parking idempotent requests meanwhile. Zero disables it.
+	ResumeGrace time.Duration
+
+	// Reservations pins subdomains to tokens; reserved subdomains with an
+	// inbox queue requests in Inbox while their client is offline.
+	Reservations ReservationRepo
+	Inbox        InboxRepo
+	InboxLimits  InboxLimits
+	// AdminToken enables the /api/admin endpoints for bearers of it.
+	AdminToken string
+	// Bins hosts request-bin subdomains; it needs Reservations for tokens.
+	// Bins are deleted BinTTL after they are created, 7 days by default.
+	Bins   BinRepo
+	BinTTL time.Duration
+	// OverridesDir holds *.html files that replace the embedded templates
+	// of the same name, such as error.html.
+	OverridesDir string
+	// Limits hardens the HTTP listeners and bounds proxied requests.
+	Limits ServerLimits
+	// Interstitial warns first-time browser visitors that a subdomain is a
+	// dev tunnel; AbuseReports stores what they report from it.
+	Interstitial bool
+	AbuseReports AbuseReportRepo
+	// RatePlans holds rate limit plans and tenant overrides, replacing
+	// RequestsPerMin and MaxConns once loaded. ReloadRatePlans rereads it.
+	RatePlans RatePlanRepo
+	// Usage stores proxied traffic per tenant and subdomain, rolled up
+	// hourly. It enables the quotas in RatePlans and /api/admin/usage.
+	Usage UsageRepo
+	// AccessLog stores a line per request to a subdomain, without bodies,
+	// for /api/admin/access-log. AccessLogRetention bounds what it keeps.
+	AccessLog          AccessLogRepo
+	AccessLogRetention AccessLogRetention
+	// TrustedProxyHeader names the header a reverse proxy in front of the
+	// server puts the visitor's address in, such as X-Forwarded-For. Set
+	// it only when every request comes through that proxy.
+	TrustedProxyHeader string
 }
 
 func NewServer(cfg ServerConfig) *Server {
-	tmpl, _ := template.ParseFS(serverTemplates, "templates/*.html")
-
 	domain := cfg.Domain
 	if domain == "" && cfg.AutoDomain {
 		if detected, err := AutoDetectDomain(); err == nil {
@@ -100,6 +209,11 @@ func NewServer(cfg ServerConfig) *Server {
 		}
 	}
 
+	tlsAddr := cfg.TLSAddr
+	if tlsAddr == "" {
+		tlsAddr = ":443"
+	}
+
 	ver := cfg.Version
 	if ver == "" {
 		ver = "dev"
@@ -114,22 +228,52 @@ func NewServer(cfg ServerConfig) *Server {
 		maxConns = 5
 	}
 
+	pingInterval := cfg.PingInterval
+	if pingInterval <= 0 {
+		pingInterval = 30 * time.Second
+	}
+
+	binTTL := cfg.BinTTL
+	if binTTL <= 0 {
+		binTTL = defaultBinTTL
+	}
+
 	logger := cfg.Logger
 	if logger == nil {
 		logger = logging.NopLogger{}
 	}
 
 	s := &Server{
-		addr:        cfg.Addr,
-		domain:      domain,
-		sessions:    make(map[string]*Session),
-		blobRepo:    cfg.BlobRepo,
-		templates:   tmpl,
-		enableHTTPS: cfg.EnableHTTPS,
-		certsDir:    certsDir,
-		version:     ver,
-		rateLimiter: NewRateLimiter(reqPerMin, maxConns),
-		logger:      logger,
+		addr:         cfg.Addr,
+		domain:       domain,
+		sessions:     make(map[string]*Session),
+		parked:       make(map[string]*parkedTunnel),
+		pools:        make(map[string]*pool),
+		blobRepo:     cfg.BlobRepo,
+		templates:    loadServerTemplates(cfg.OverridesDir, logger),
+		enableHTTPS:  cfg.EnableHTTPS,
+		passthrough:  cfg.Passthrough,
+		tlsAddr:      tlsAddr,
+		certsDir:     certsDir,
+		version:      ver,
+		rateLimiter:  NewRateLimiter(reqPerMin, maxConns),
+		pingInterval: pingInterval,
+		drainTimeout: cfg.DrainTimeout,
+		resumeGrace:  cfg.ResumeGrace,
+		reservations: cfg.Reservations,
+		inbox:        cfg.Inbox,
+		inboxLimits:  cfg.InboxLimits.withDefaults(),
+		limits:       cfg.Limits.withDefaults(),
+		adminToken:   cfg.AdminToken,
+		bins:         cfg.Bins,
+		binTTL:       binTTL,
+		interstitial: cfg.Interstitial,
+		abuseReports: cfg.AbuseReports,
+		ratePlans:    cfg.RatePlans,
+		reusePort:    cfg.ReusePort,
+		logger:       logger,
+
+		trustedProxyHeader: http.CanonicalHeaderKey(cfg.TrustedProxyHeader),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
@@ -146,6 +290,17 @@ func NewServer(cfg ServerConfig) *Server {
 		}
 	}
 
+	if cfg.Usage != nil {
+		s.usage = newUsageMeter(cfg.Usage)
+	}
+	if cfg.AccessLog != nil {
+		s.accessLog = newAccessLogger(cfg.AccessLog, cfg.AccessLogRetention, logger)
+	}
+
+	if err := s.ReloadRatePlans(); err != nil {
+		logger.WithError(err).Warn("server", "config", "Failed to load rate plans, using defaults")
+	}
+
 	return s
 }
 
@@ -167,27 +322,40 @@ func (s *Server) Start(ctx context.Context) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/connect", s.handleConnect)
 	mux.HandleFunc("/health", s.handleHealth)
-	mux.HandleFunc("/proxy/", s.handleProxy)
+	mux.HandleFunc("/proxy/", s.withAccessLog(proxyPathTarget, s.handleProxy))
 	mux.HandleFunc("/api/share", s.handleShare)
 	mux.HandleFunc("/api/blob/", s.handleGetBlob)
 	mux.HandleFunc("/api/rate-limits", s.handleRateLimits)
+	mux.HandleFunc("/api/admin/compression", s.handleAdminCompression)
+	mux.HandleFunc("/api/admin/reservations", s.handleAdminReservations)
+	mux.HandleFunc("/api/admin/reservations/", s.handleAdminReservationByName)
+	mux.HandleFunc("/api/admin/inbox", s.handleAdminInbox)
+	mux.HandleFunc("/api/admin/inbox/", s.handleAdminInboxItem)
+	mux.HandleFunc("/api/admin/abuse-reports", s.handleAdminAbuseReports)
+	mux.HandleFunc("/api/admin/rate-plans", s.handleAdminRatePlans)
+	mux.HandleFunc("/api/admin/rate-plans/", s.handleAdminRatePlanByName)
+	mux.HandleFunc("/api/admin/tenant-plans/", s.handleAdminTenantPlan)
+	mux.HandleFunc("/api/admin/usage", s.handleAdminUsage)
+	mux.HandleFunc("/api/admin/access-log", s.handleAdminAccessLog)
+	mux.HandleFunc("/api/bins", s.handleCreateBin)
+	mux.HandleFunc("/api/bins/", s.handleBinByName)
+	mux.HandleFunc("/api/pools/", s.handlePoolStats)
 	mux.HandleFunc("/shared/", s.handleSharedView)
-	mux.HandleFunc("/", s.handleSubdomainProxy)
+	mux.HandleFunc("/bins/", s.handleBinView)
+	mux.HandleFunc("/", s.withAccessLog(s.hostTarget, s.handleSubdomainProxy))
 
 	var handler http.Handler = mux
 	if s.certManager != nil {
 		handler = s.certManager.HTTPHandler(mux)
 	}
 
-	ln, err := net.Listen("tcp", s.addr)
+	ln, err := s.listen(s.addr)
 	if err != nil {
 		return fmt.Errorf("server listen: %w", err)
 	}
 	s.listener = ln
 
-	s.httpServer = &http.Server{
-		Handler: handler,
-	}
+	s.httpServer = s.newHTTPServer(handler, nil)
 
 	if s.readyCallback != nil {
 		s.readyCallback()
@@ -201,44 +369,232 @@ func (s *Server) Start(ctx context.Context) error {
 	} else if s.domain != "" {
 		s.logger.WithFields(logging.Fields{"public_url": fmt.Sprintf("http://*.%s", s.domain)}).Info("server", "start", "HTTP enabled")
 	}
+	if s.passthrough && s.certManager == nil {
+		go s.startHTTPS(ctx, mux)
+	}
+
+	if s.usage != nil {
+		go s.flushUsageLoop(ctx)
+	}
+	if s.accessLog != nil {
+		go s.accessLog.run()
+	}
+	if s.bins != nil && s.reservations != nil {
+		go s.pruneBinsLoop(ctx)
+	}
 
+	shutdownDone := make(chan struct{})
 	go func() {
 		<-ctx.Done()
-		s.httpServer.Shutdown(context.Background())
-		if s.httpsServer != nil {
-			s.httpsServer.Shutdown(context.Background())
-		}
+		s.shutdown()
+		s.flushUsage()
+		s.stopAccessLog()
+		close(shutdownDone)
 	}()
 
 	if err := s.httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("server serve: %w", err)
 	}
+	<-shutdownDone
 	return nil
 }
 
-func (s *Server) startHTTPS(ctx context.Context, mux *http.ServeMux) {
-	tlsConfig := &tls.Config{
-		GetCertificate: s.certManager.GetCertificate,
-		NextProtos:     []string{"h2", "http/1.1"},
+func (s *Server) listen(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if s.reusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+func (s *Server) shutdown() {
+	if s.drainTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+		defer cancel()
+		s.Drain(ctx)
+		return
+	}
+
+	s.Broadcast(ControlMessage{Type: ControlShutdown, Message: "server shutting down"})
+	s.httpServer.Shutdown(context.Background())
+	if s.httpsServer != nil {
+		s.httpsServer.Shutdown(context.Background())
+	}
+}
+
+// Drain stops accepting handshakes, asks clients to reconnect, and waits for
+// in-flight proxied requests until ctx is done before closing every session.
+func (s *Server) Drain(ctx context.Context) {
+	s.draining.Store(true)
+	s.logger.WithFields(logging.Fields{"sessions": s.SessionCount(), "inflight": s.inflight.Load()}).Info("server", "drain", "Draining sessions")
+
+	retryAfter := int64(time.Second / time.Millisecond)
+	s.Broadcast(ControlMessage{Type: ControlReconnect, Message: "server restarting", RetryAfterMs: retryAfter})
+
+	if s.httpServer != nil {
+		s.httpServer.Shutdown(ctx)
+	}
+	if s.httpsServer != nil {
+		s.httpsServer.Shutdown(ctx)
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for s.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			s.logger.WithFields(logging.Fields{"inflight": s.inflight.Load()}).Warn("server", "drain", "Drain deadline exceeded")
+			s.closeAllSessions()
+			return
+		case <-ticker.C:
+		}
 	}
 
-	tlsLn, err := tls.Listen("tcp", ":443", tlsConfig)
+	s.closeAllSessions()
+	s.logger.Info("server", "drain", "Drain complete")
+}
+
+// Draining reports whether the server has stopped accepting new tunnels.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+func (s *Server) closeAllSessions() {
+	sessions := s.allSessions()
+
+	for _, sess := range sessions {
+		sess.Session.Close()
+	}
+}
+
+func (s *Server) startHTTPS(ctx context.Context, mux *http.ServeMux) {
+	ln, err := s.listen(s.tlsAddr)
 	if err != nil {
 		s.logger.WithError(err).Warn("server", "https", "HTTPS listen failed, fallback to HTTP")
 		return
 	}
-	s.tlsListener = tlsLn
 
-	s.httpsServer = &http.Server{
-		Handler:   mux,
-		TLSConfig: tlsConfig,
+	s.mu.Lock()
+	s.tlsListener = ln
+	s.mu.Unlock()
+
+	var httpsLn *connListener
+	var tlsConfig *tls.Config
+	if s.certManager != nil {
+		tlsConfig = &tls.Config{
+			GetCertificate: s.certManager.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}
+		httpsLn = newConnListener(ln.Addr())
+		s.httpsServer = s.newHTTPServer(mux, tlsConfig)
+		go func() {
+			if err := s.httpsServer.Serve(httpsLn); err != nil && err != http.ErrServerClosed {
+				s.logger.WithError(err).Error("server", "https", "HTTPS serve error")
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+		if httpsLn != nil {
+			httpsLn.Close()
+		}
+	}()
+
+	s.logger.WithFields(logging.Fields{"addr": ln.Addr().String(), "passthrough": s.passthrough}).Info("server", "https", "HTTPS listening")
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.routeTLSConn(conn, httpsLn, tlsConfig)
+	}
+}
+
+// routeTLSConn splices connections for passthrough tunnels straight to the
+// client and terminates TLS locally for everything else.
+func (s *Server) routeTLSConn(conn net.Conn, httpsLn *connListener, tlsConfig *tls.Config) {
+	serverName, peeked, err := peekClientHello(conn)
+	if err != nil {
+		s.logger.WithError(err).Debug("server", "https", "ClientHello peek failed")
+		conn.Close()
+		return
 	}
 
-	s.logger.WithFields(logging.Fields{"addr": ":443"}).Info("server", "https", "HTTPS listening")
+	if s.passthrough {
+		if sess := s.GetSession(s.extractSubdomainFromHost(serverName)); sess != nil && sess.Passthrough {
+			s.proxyPassthrough(peeked, sess, serverName)
+			return
+		}
+	}
 
-	if err := s.httpsServer.Serve(tlsLn); err != nil && err != http.ErrServerClosed {
-		s.logger.WithError(err).Error("server", "https", "HTTPS serve error")
+	if httpsLn == nil {
+		s.logger.WithFields(logging.Fields{"server_name": serverName}).Warn("server", "https", "No passthrough tunnel for server name")
+		conn.Close()
+		return
+	}
+	httpsLn.deliver(tls.Server(peeked, tlsConfig))
+}
+
+func (s *Server) proxyPassthrough(conn net.Conn, sess *Session, serverName string) {
+	s.inflight.Add(1)
+	defer s.inflight.Add(-1)
+
+	traceID := ulid.Make().String()
+	logger := s.logger.WithFields(logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID})
+
+	if !s.allowPassthrough(conn, sess) {
+		conn.Close()
+		return
+	}
+
+	stream, err := sess.Session.Open()
+	if err != nil {
+		logger.WithError(err).Error("server", "passthrough", "Open stream failed")
+		conn.Close()
+		return
+	}
+
+	frame := RequestFrame{
+		ID:      ulid.Make().String(),
+		Kind:    StreamTLS,
+		URL:     serverName,
+		TraceID: traceID,
+	}
+	if err := json.NewEncoder(stream).Encode(&frame); err != nil {
+		logger.WithError(err).Error("server", "passthrough", "Encode request failed")
+		stream.Close()
+		conn.Close()
+		return
+	}
+
+	bytesIn, bytesOut := splice(conn, stream)
+	s.recordUsage(sess, bytesIn, bytesOut)
+	logger.WithFields(logging.Fields{
+		"bytes_in":  bytesIn,
+		"bytes_out": bytesOut,
+	}).Info("server", "passthrough", "TLS connection proxied")
+}
+
+// allowPassthrough counts a passthrough connection as one request against
+// the tenant's and visitor's rate limits and the tenant's quotas. The
+// relay cannot answer inside the client's TLS, so refused connections are
+// just closed.
+func (s *Server) allowPassthrough(conn net.Conn, sess *Session) bool {
+	if s.rateLimiter == nil {
+		return true
+	}
+	ip := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
 	}
+	if d := s.rateLimiter.Allow(sess.Tenant, ip); !d.Allowed {
+		s.warnRateLimited(sess.Subdomain, "rate limit exceeded", d.RetryAfterSeconds())
+		return false
+	}
+	return s.quotaExceeded(sess.Subdomain, sess.Tenant) == nil
 }
 
 func (s *Server) Addr() string {
@@ -248,6 +604,16 @@ func (s *Server) Addr() string {
 	return s.listener.Addr().String()
 }
 
+// TLSAddr returns the address of the TLS listener once it is up.
+func (s *Server) TLSAddr() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.tlsListener == nil {
+		return ""
+	}
+	return s.tlsListener.Addr().String()
+}
+
 func (s *Server) Domain() string {
 	return s.domain
 }
@@ -304,16 +670,73 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.draining.Load() {
+		s.logger.Info("server", "connect", "Handshake rejected while draining")
+		json.NewEncoder(stream).Encode(&HandshakeResponse{
+			Success: false,
+			Error:   "server draining, retry shortly",
+		})
+		stream.Close()
+		session.Close()
+		return
+	}
+
+	version, ok := negotiateVersion(SupportedVersions, offeredVersions(&req))
+	if !ok {
+		s.logger.WithFields(logging.Fields{"versions": strings.Join(offeredVersions(&req), ",")}).Warn("server", "connect", "Unsupported protocol version")
+		json.NewEncoder(stream).Encode(&HandshakeResponse{
+			Success:           false,
+			Error:             versionMismatchError(offeredVersions(&req), SupportedVersions),
+			SupportedVersions: SupportedVersions,
+		})
+		stream.Close()
+		session.Close()
+		return
+	}
+	caps := intersectCapabilities(s.capabilities(), offeredCapabilities(&req))
+	var compression string
+	if hasCapability(caps, CapCompression) {
+		compression = negotiateCompression(compressionPreference, req.Compression)
+	}
+
 	subdomain := generateSubdomain()
-	if req.Subdomain != "" && s.isSubdomainAvailable(req.Subdomain) {
-		subdomain = req.Subdomain
+	reserved, reservationErr := s.checkReservation(&req)
+	if reservationErr == "" && req.Pool != "" {
+		reservationErr = s.checkPool(&req, reserved)
+	}
+	if reservationErr != "" {
+		s.logger.WithFields(logging.Fields{"subdomain": req.Subdomain}).Warn("server", "connect", "Reserved subdomain refused")
+		json.NewEncoder(stream).Encode(&HandshakeResponse{
+			Success: false,
+			Error:   reservationErr,
+		})
+		stream.Close()
+		session.Close()
+		return
 	}
 
-	if s.rateLimiter != nil && !s.rateLimiter.AcquireConnection(subdomain) {
-		s.logger.WithFields(logging.Fields{"subdomain": subdomain}).Warn("server", "connect", "Connection limit exceeded")
+	var resumed bool
+	var stale *Session
+	if req.Subdomain != "" {
+		if resumed, stale = s.canResume(req.Subdomain, req.ResumeToken); resumed {
+			subdomain = req.Subdomain
+		} else if reserved {
+			// the token holder takes over from any earlier connection,
+			// unless it asked to share the subdomain with them
+			subdomain = req.Subdomain
+			if req.Pool == "" {
+				stale = s.GetSession(req.Subdomain)
+			}
+		} else if s.isSubdomainAvailable(req.Subdomain) {
+			subdomain = req.Subdomain
+		}
+	}
+
+	if req.Passthrough && !s.passthrough {
+		s.logger.WithFields(logging.Fields{"subdomain": subdomain}).Warn("server", "connect", "TLS passthrough not enabled")
 		resp := HandshakeResponse{
 			Success: false,
-			Error:   "connection limit exceeded",
+			Error:   "tls passthrough not enabled on server",
 		}
 		json.NewEncoder(stream).Encode(&resp)
 		stream.Close()
@@ -321,26 +744,70 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	publicURL := fmt.Sprintf("http://%s.%s", subdomain, s.domain)
-	if s.domain == "" {
-		publicURL = fmt.Sprintf("http://localhost/%s", subdomain)
+	// clients are counted by the reservation token they proved they hold;
+	// an unchecked token would let anyone start a fresh tenant at will
+	tenant := "ip:" + s.clientIP(r)
+	if reserved {
+		tenant = tokenTenant(HashToken(req.AuthToken))
+	}
+	if s.rateLimiter != nil && !s.rateLimiter.AcquireConnection(tenant) {
+		s.logger.WithFields(logging.Fields{"subdomain": subdomain, "tenant": tenant}).Warn("server", "connect", "Connection limit exceeded")
+		resp := HandshakeResponse{
+			Success: false,
+			Error:   "connection limit exceeded",
+		}
+		json.NewEncoder(stream).Encode(&resp)
+		stream.Close()
+		session.Close()
+		return
 	}
 
+	publicURL := s.publicURL(subdomain, req.Passthrough)
+
 	sess := &Session{
-		Subdomain:   subdomain,
-		PublicURL:   publicURL,
-		Session:     session,
-		ConnectedAt: time.Now(),
+		Subdomain:    subdomain,
+		PublicURL:    publicURL,
+		Session:      session,
+		ConnectedAt:  time.Now(),
+		Passthrough:  req.Passthrough,
+		Version:      version,
+		Capabilities: caps,
+		Compression:  compression,
+		ID:           ulid.Make().String(),
+		RemoteAddr:   r.RemoteAddr,
+		MaxBodyBytes: s.bodyLimit(req.MaxBodyBytes),
+		Tenant:       tenant,
+	}
+	if s.resumeGrace > 0 {
+		sess.resumeToken = generateResumeToken()
+	}
+	if sess.HasCapability(CapControl) {
+		sess.control = newControlChannel(stream, dec)
 	}
 
-	s.mu.Lock()
-	s.sessions[subdomain] = sess
-	s.mu.Unlock()
+	if req.Pool != "" {
+		s.joinPool(sess, req.Pool)
+	} else {
+		s.registerSession(sess)
+	}
+	if stale != nil {
+		stale.Session.Close()
+	}
+	if resumed {
+		s.logger.WithFields(logging.Fields{"subdomain": subdomain}).Info("server", "connect", "Session resumed")
+	}
 
 	resp := HandshakeResponse{
-		Success:   true,
-		Subdomain: subdomain,
-		PublicURL: publicURL,
+		Success:           true,
+		Subdomain:         subdomain,
+		PublicURL:         publicURL,
+		Control:           sess.control != nil,
+		Version:           version,
+		SupportedVersions: SupportedVersions,
+		Capabilities:      caps,
+		Compression:       compression,
+		ResumeToken:       sess.resumeToken,
+		Resumed:           resumed,
 	}
 
 	enc := json.NewEncoder(stream)
@@ -348,24 +815,35 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 		s.logger.WithError(err).Error("server", "handshake", "Handshake response encode failed")
 		s.removeSession(subdomain)
 		if s.rateLimiter != nil {
-			s.rateLimiter.ReleaseConnection(subdomain)
+			s.rateLimiter.ReleaseConnection(sess.Tenant)
 		}
 		stream.Close()
 		session.Close()
 		return
 	}
-	stream.Close()
+	if sess.control != nil {
+		s.startControl(sess)
+	} else {
+		stream.Close()
+	}
 
-	s.logger.WithFields(logging.Fields{"subdomain": subdomain, "public_url": publicURL}).Info("server", "connect", "Client connected")
+	s.logger.WithFields(logging.Fields{"subdomain": subdomain, "public_url": publicURL, "version": version, "capabilities": strings.Join(caps, ","), "compression": compression}).Info("server", "connect", "Client connected")
 
 	go s.monitorSession(sess)
+	if reserved || resumed {
+		go s.deliverInbox(sess)
+	}
 }
 
 func (s *Server) monitorSession(sess *Session) {
 	<-sess.Session.CloseChan()
-	s.removeSession(sess.Subdomain)
+	parked := s.detachSession(sess)
 	if s.rateLimiter != nil {
-		s.rateLimiter.ReleaseConnection(sess.Subdomain)
+		s.rateLimiter.ReleaseConnection(sess.Tenant)
+	}
+	if parked {
+		s.logger.WithFields(logging.Fields{"subdomain": sess.Subdomain, "grace": s.resumeGrace.String()}).Info("server", "disconnect", "Client disconnected, holding tunnel for resume")
+		return
 	}
 	s.logger.WithFields(logging.Fields{"subdomain": sess.Subdomain}).Info("server", "disconnect", "Client disconnected")
 }
@@ -376,11 +854,22 @@ func (s *Server) removeSession(subdomain string) {
 	s.mu.Unlock()
 }
 
+func (s *Server) publicURL(subdomain string, passthrough bool) string {
+	if s.domain == "" {
+		return fmt.Sprintf("http://localhost/%s", subdomain)
+	}
+	if passthrough {
+		return fmt.Sprintf("https://%s.%s", subdomain, s.domain)
+	}
+	return fmt.Sprintf("http://%s.%s", subdomain, s.domain)
+}
+
 func (s *Server) isSubdomainAvailable(subdomain string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	_, exists := s.sessions[subdomain]
-	return !exists
+	_, parked := s.parked[subdomain]
+	return !exists && !parked
 }
 
 func (s *Server) GetSession(subdomain string) *Session {
@@ -422,71 +911,127 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 		targetPath = "/" + parts[1]
 	}
 
-	if s.rateLimiter != nil {
-		if ok, retryAfter := s.rateLimiter.AllowRequest(subdomain); !ok {
-			WriteRateLimitExceeded(w, retryAfter)
-			return
-		}
+	if !s.allowRequest(w, r, subdomain) {
+		return
 	}
 
-	sess := s.GetSession(subdomain)
+	sess := s.awaitSession(w, r, subdomain, targetPath)
 	if sess == nil {
-		http.Error(w, "tunnel not found", http.StatusBadGateway)
 		return
 	}
 
-	s.proxyToTunnel(w, r, sess, targetPath)
+	s.proxyToTunnel(w, r, sess, targetPath, 0)
 }
 
-func (s *Server) proxyToTunnel(w http.ResponseWriter, r *http.Request, sess *Session, targetPath string) {
+func (s *Server) proxyToTunnel(w http.ResponseWriter, r *http.Request, sess *Session, targetPath string, receivedAt int64) {
+	s.inflight.Add(1)
+	defer s.inflight.Add(-1)
+	sess.inflight.Add(1)
+	defer sess.inflight.Add(-1)
+	sess.requests.Add(1)
+
 	traceID := r.Header.Get("X-Trace-ID")
 	if traceID == "" {
 		traceID = ulid.Make().String()
 	}
 
-	stream, err := sess.Session.Open()
+	body, tooLarge, err := readProxyBody(w, r, sess.MaxBodyBytes)
+	if tooLarge {
+		s.logger.WithFields(logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID, "limit": "max_body_bytes", "max": sess.MaxBodyBytes}).Warn("server", "proxy", "Request body over limit")
+		s.writeErrorPage(w, r, http.StatusRequestEntityTooLarge, ErrorBodyTooLarge, sess.Subdomain, traceID)
+		return
+	}
 	if err != nil {
-		s.logger.WithError(err).WithFields(logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID}).Error("server", "proxy", "Open stream failed")
-		http.Error(w, "tunnel unavailable", http.StatusBadGateway)
+		fields := logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID}
+		if isTimeout(err) {
+			fields["limit"] = "read_timeout"
+		}
+		s.logger.WithError(err).WithFields(fields).Error("server", "proxy", "Read body failed")
+		http.Error(w, "read body failed", http.StatusBadRequest)
 		return
 	}
-	defer stream.Close()
 
-	body, err := io.ReadAll(r.Body)
+	stream, err := sess.Session.Open()
 	if err != nil {
-		s.logger.WithError(err).WithFields(logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID}).Error("server", "proxy", "Read body failed")
-		http.Error(w, "read body failed", http.StatusBadRequest)
+		s.logger.WithError(err).WithFields(logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID}).Error("server", "proxy", "Open stream failed")
+		sess.markFailed()
+		s.writeErrorPage(w, r, http.StatusBadGateway, ErrorTunnelFailed, sess.Subdomain, traceID)
 		return
 	}
+	defer stream.Close()
+	if s.limits.UpstreamTimeout > 0 {
+		stream.SetDeadline(time.Now().Add(s.limits.UpstreamTimeout + upstreamGrace))
+	}
 
 	headers := make(map[string]string)
 	for k, v := range r.Header {
-		if len(v) > 0 {
+		// X-Devtunnel-* headers are the tunnel's own; visitors can't set them
+		if len(v) > 0 && !strings.HasPrefix(k, tunnelHeaderPrefix) {
 			headers[k] = v[0]
 		}
 	}
+	if receivedAt != 0 {
+		headers[HeaderDelayed] = "true"
+		headers[HeaderReceivedAt] = strconv.FormatInt(receivedAt, 10)
+	}
+
+	wireBody, encoding := compressBody(sess.Compression, body, headers)
+	s.recordCompression(sess, len(body), len(wireBody))
 
 	reqFrame := RequestFrame{
-		ID:      ulid.Make().String(),
-		Method:  r.Method,
-		URL:     targetPath,
-		Headers: headers,
-		Body:    body,
-		TraceID: traceID,
+		ID:         ulid.Make().String(),
+		Method:     r.Method,
+		URL:        targetPath,
+		Headers:    headers,
+		Body:       wireBody,
+		Encoding:   encoding,
+		TraceID:    traceID,
+		TimeoutMs:  s.limits.UpstreamTimeout.Milliseconds(),
+		ReceivedAt: receivedAt,
 	}
 
 	enc := json.NewEncoder(stream)
 	if err := enc.Encode(&reqFrame); err != nil {
 		s.logger.WithError(err).WithFields(logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID}).Error("server", "proxy", "Encode request failed")
-		http.Error(w, "tunnel write failed", http.StatusBadGateway)
+		sess.markFailed()
+		s.writeErrorPage(w, r, http.StatusBadGateway, ErrorTunnelFailed, sess.Subdomain, traceID)
 		return
 	}
 
 	var respFrame ResponseFrame
 	dec := json.NewDecoder(stream)
 	if err := dec.Decode(&respFrame); err != nil {
+		sess.markFailed()
+		if isTimeout(err) {
+			s.logger.WithFields(logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID, "limit": "upstream_timeout", "max": s.limits.UpstreamTimeout.String()}).Warn("server", "proxy", "Tunnel response timed out")
+			s.writeErrorPage(w, r, http.StatusGatewayTimeout, ErrorUpstreamTimeout, sess.Subdomain, traceID)
+			return
+		}
 		s.logger.WithError(err).WithFields(logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID}).Error("server", "proxy", "Decode response failed")
-		http.Error(w, "tunnel read failed", http.StatusBadGateway)
+		s.writeErrorPage(w, r, http.StatusBadGateway, ErrorTunnelFailed, sess.Subdomain, traceID)
+		return
+	}
+
+	respBody, err := decompressBody(respFrame.Encoding, respFrame.Body, s.limits.MaxBodyBytes)
+	if errors.Is(err, errFrameBodyTooLarge) {
+		s.logger.WithFields(logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID, "limit": "max_body_bytes", "max": s.limits.MaxBodyBytes}).Warn("server", "proxy", "Response body too large")
+		s.writeErrorPage(w, r, http.StatusBadGateway, ErrorBodyTooLarge, sess.Subdomain, traceID)
+		return
+	}
+	if err != nil {
+		s.logger.WithError(err).WithFields(logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID}).Error("server", "proxy", "Decompress response failed")
+		sess.markFailed()
+		s.writeErrorPage(w, r, http.StatusBadGateway, ErrorTunnelFailed, sess.Subdomain, traceID)
+		return
+	}
+	s.recordCompression(sess, len(respBody), len(respFrame.Body))
+	s.recordUsage(sess, int64(len(body)), int64(len(respBody)))
+	if reason := respFrame.Headers[HeaderTunnelError]; reason != "" {
+		if reason == ErrorUpstreamDown {
+			sess.markFailed()
+		}
+		s.logger.WithFields(logging.Fields{"subdomain": sess.Subdomain, "trace_id": traceID, "reason": reason}).Warn("server", "proxy", "Client could not serve request")
+		s.writeErrorPage(w, r, respFrame.StatusCode, reason, sess.Subdomain, traceID)
 		return
 	}
 
@@ -502,7 +1047,7 @@ func (s *Server) proxyToTunnel(w http.ResponseWriter, r *http.Request, sess *Ses
 	}
 	w.Header().Set("X-Trace-ID", traceID)
 	w.WriteHeader(respFrame.StatusCode)
-	w.Write(respFrame.Body)
+	w.Write(respBody)
 }
 
 func (s *Server) extractSubdomainFromHost(host string) string {
@@ -530,16 +1075,16 @@ func (s *Server) handleSubdomainProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if s.rateLimiter != nil {
-		if ok, retryAfter := s.rateLimiter.AllowRequest(subdomain); !ok {
-			WriteRateLimitExceeded(w, retryAfter)
-			return
-		}
+	// The interstitial's own paths are limited per visitor only, so a
+	// throttled tunnel can still be reported.
+	if s.handleInterstitialPath(w, r, subdomain) {
+		return
 	}
-
-	sess := s.GetSession(subdomain)
-	if sess == nil {
-		http.Error(w, "tunnel not found", http.StatusBadGateway)
+	if !s.allowRequest(w, r, subdomain) {
+		return
+	}
+	if s.needsInterstitial(r, subdomain) {
+		s.showInterstitial(w, r)
 		return
 	}
 
@@ -551,7 +1096,16 @@ func (s *Server) handleSubdomainProxy(w http.ResponseWriter, r *http.Request) {
 		targetPath += "?" + r.URL.RawQuery
 	}
 
-	s.proxyToTunnel(w, r, sess, targetPath)
+	sess := s.awaitSession(w, r, subdomain, targetPath)
+	if sess == nil {
+		return
+	}
+	if sess.Passthrough {
+		http.Error(w, "tunnel accepts TLS only", http.StatusMisdirectedRequest)
+		return
+	}
+
+	s.proxyToTunnel(w, r, sess, targetPath, 0)
 }
 
 type ShareRequest struct {
@@ -674,9 +1228,27 @@ func writeServerJSONError(w http.ResponseWriter, msg string, code int) {
 	json.NewEncoder(w).Encode(map[string]string{"error": msg})
 }
 
+// RateLimitsResponse describes the caller's plan and what is left of it.
+// The tenant is the one an anonymous tunnel from the caller's IP would
+// have, or that of ?subdomain= when given.
 type RateLimitsResponse struct {
-	RequestsPerMin     int `json:"requests_per_min"`
-	MaxConcurrentConns int `json:"max_concurrent_conns"`
+	RequestsPerMin     int       `json:"requests_per_min"`
+	MaxConcurrentConns int       `json:"max_concurrent_conns"`
+	Tenant             string    `json:"tenant"`
+	Plan               string    `json:"plan"`
+	Burst              int       `json:"burst"`
+	Remaining          int       `json:"remaining"`
+	ResetSeconds       int       `json:"reset_seconds"`
+	IP                 RateQuota `json:"ip"`
+}
+
+// RateQuota is what is left in one rate limit bucket.
+type RateQuota struct {
+	Plan           string `json:"plan"`
+	RequestsPerMin int    `json:"requests_per_min"`
+	Burst          int    `json:"burst"`
+	Remaining      int    `json:"remaining"`
+	ResetSeconds   int    `json:"reset_seconds"`
 }
 
 func (s *Server) handleRateLimits(w http.ResponseWriter, r *http.Request) {
@@ -685,14 +1257,41 @@ func (s *Server) handleRateLimits(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reqPerMin, maxConns := 60, 5
-	if s.rateLimiter != nil {
-		reqPerMin, maxConns = s.rateLimiter.GetLimits()
+	rl := s.rateLimiter
+	if rl == nil {
+		rl = NewRateLimiter(60, 5)
+	}
+	ip := s.clientIP(r)
+	tenant := "ip:" + ip
+	if sub := r.URL.Query().Get("subdomain"); sub != "" {
+		if t := s.tenantFor(sub); t != "" {
+			tenant = t
+		}
+	}
+
+	plan := rl.Plan(tenant)
+	tenantLeft, ipLeft := rl.Peek(tenant, ip)
+	if ipLeft.stricterThan(tenantLeft) {
+		WriteRateLimitHeaders(w, ipLeft)
+	} else {
+		WriteRateLimitHeaders(w, tenantLeft)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(RateLimitsResponse{
-		RequestsPerMin:     reqPerMin,
-		MaxConcurrentConns: maxConns,
+		RequestsPerMin:     plan.RequestsPerMin,
+		MaxConcurrentConns: plan.MaxConns,
+		Tenant:             tenant,
+		Plan:               plan.Name,
+		Burst:              plan.Burst,
+		Remaining:          tenantLeft.Remaining,
+		ResetSeconds:       ceilSeconds(tenantLeft.Reset),
+		IP: RateQuota{
+			Plan:           ipLeft.Plan,
+			RequestsPerMin: rl.VisitorPlan().RequestsPerMin,
+			Burst:          ipLeft.Limit,
+			Remaining:      ipLeft.Remaining,
+			ResetSeconds:   ceilSeconds(ipLeft.Reset),
+		},
 	})
 }
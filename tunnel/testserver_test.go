@@ -0,0 +1,56 @@
+package tunnel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startTestServer runs a server with cfg and returns once it is listening.
+// Addr and Domain default to a free local port and test.local.
+func startTestServer(t *testing.T, ctx context.Context, cfg ServerConfig) *Server {
+	t.Helper()
+	if cfg.Addr == "" {
+		cfg.Addr = "127.0.0.1:0"
+	}
+	if cfg.Domain == "" {
+		cfg.Domain = "test.local"
+	}
+	srv := NewServer(cfg)
+	ready := make(chan struct{})
+	srv.SetReadyCallback(func() { close(ready) })
+	go srv.Start(ctx)
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not start")
+	}
+	if cfg.Passthrough {
+		require.Eventually(t, func() bool { return srv.TLSAddr() != "" }, 2*time.Second, 10*time.Millisecond)
+	}
+	return srv
+}
+
+// startTestTunnel runs a server with cfg and a connected client whose local
+// app runs handler. client names the subdomain and any other client
+// options; its server address and local port are filled in.
+func startTestTunnel(t *testing.T, ctx context.Context, cfg ServerConfig, client ClientConfig, handler http.HandlerFunc) *Server {
+	t.Helper()
+	srv := startTestServer(t, ctx, cfg)
+
+	local := httptest.NewServer(handler)
+	t.Cleanup(local.Close)
+
+	client.ServerAddr = srv.Addr()
+	client.LocalPort = strings.Split(local.Listener.Addr().String(), ":")[1]
+	c := NewClient(client)
+	c.SetReconnect(false)
+	require.NoError(t, c.Connect(ctx))
+	t.Cleanup(func() { c.Close() })
+	return srv
+}
@@ -0,0 +1,448 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/auditmos/devtunnel/logging"
+)
+
+// StatusBandwidthLimitExceeded is the unofficial 509 hosts answer with once
+// a bandwidth quota is spent.
+const StatusBandwidthLimitExceeded = 509
+
+// usageFlushInterval is how often metered traffic is written to the repo.
+const usageFlushInterval = time.Minute
+
+// UsageCounts is proxied traffic: requests, and body bytes received from
+// visitors (in) and sent back to them (out).
+type UsageCounts struct {
+	Requests int64 `json:"requests"`
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+}
+
+// Bytes is the traffic counted against bandwidth quotas.
+func (u UsageCounts) Bytes() int64 {
+	return u.BytesIn + u.BytesOut
+}
+
+func (u *UsageCounts) add(o UsageCounts) {
+	u.Requests += o.Requests
+	u.BytesIn += o.BytesIn
+	u.BytesOut += o.BytesOut
+}
+
+// UsageRecord is a tenant's traffic through one subdomain in the period
+// starting at Hour (unix milliseconds), an hour as stored.
+type UsageRecord struct {
+	Hour      int64  `json:"hour"`
+	Tenant    string `json:"tenant"`
+	Subdomain string `json:"subdomain"`
+	UsageCounts
+}
+
+// UsageFilter narrows usage queries; empty fields match everything and
+// Until is exclusive.
+type UsageFilter struct {
+	Tenant    string
+	Subdomain string
+	Since     int64
+	Until     int64
+}
+
+// UsageRepo stores traffic rolled up per hour, tenant and subdomain.
+type UsageRepo interface {
+	Add(records []*UsageRecord) error
+	List(filter UsageFilter) ([]*UsageRecord, error)
+	Totals(tenant string, since int64) (UsageCounts, error)
+}
+
+// APIUsageResponse lists usage grouped by hour, day or month. Quota is set
+// when the query names a tenant.
+type APIUsageResponse struct {
+	Group string         `json:"group"`
+	Usage []*UsageRecord `json:"usage"`
+	Total UsageCounts    `json:"total"`
+	Quota *APIQuota      `json:"quota,omitempty"`
+}
+
+// APIQuota is a tenant's plan and what it has used of it so far.
+type APIQuota struct {
+	Plan  RatePlan    `json:"plan"`
+	Today UsageCounts `json:"today"`
+	Month UsageCounts `json:"month"`
+}
+
+// usageMeter counts proxied traffic in memory, writing it out on flush. It
+// also keeps each active tenant's totals for the current day and month so
+// quotas can be checked without a query per request.
+//
+// mu guards the maps and is never held across repo calls. repoMu orders
+// flushes against totals being loaded, so a load never sees traffic both in
+// the repo and in memory, or in neither.
+type usageMeter struct {
+	mu      sync.Mutex
+	repoMu  sync.Mutex
+	repo    UsageRepo
+	pending map[usageKey]*UsageCounts
+	periods map[string]*quotaPeriods
+}
+
+type usageKey struct {
+	hour      int64
+	tenant    string
+	subdomain string
+}
+
+type quotaPeriods struct {
+	day   int64
+	month int64
+	today UsageCounts
+	total UsageCounts
+}
+
+func newUsageMeter(repo UsageRepo) *usageMeter {
+	return &usageMeter{
+		repo:    repo,
+		pending: make(map[usageKey]*UsageCounts),
+		periods: make(map[string]*quotaPeriods),
+	}
+}
+
+func (m *usageMeter) record(tenant, subdomain string, bytesIn, bytesOut int64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := UsageCounts{Requests: 1, BytesIn: bytesIn, BytesOut: bytesOut}
+	// tenants without loaded totals pick this up from pending when they load
+	if p := m.cachedLocked(tenant, now); p != nil {
+		p.today.add(c)
+		p.total.add(c)
+	}
+	m.addPendingLocked(usageKey{hour: periodStart(now, "hour"), tenant: tenant, subdomain: subdomain}, c)
+}
+
+func (m *usageMeter) addPendingLocked(key usageKey, c UsageCounts) {
+	if m.pending[key] == nil {
+		m.pending[key] = &UsageCounts{}
+	}
+	m.pending[key].add(c)
+}
+
+// totals returns what tenant has used today and this month, in UTC.
+func (m *usageMeter) totals(tenant string, now time.Time) (today, month UsageCounts, err error) {
+	m.mu.Lock()
+	if p := m.cachedLocked(tenant, now); p != nil {
+		defer m.mu.Unlock()
+		return p.today, p.total, nil
+	}
+	m.mu.Unlock()
+
+	p, err := m.load(tenant, now)
+	if err != nil {
+		return UsageCounts{}, UsageCounts{}, err
+	}
+	return p.today, p.total, nil
+}
+
+// cachedLocked returns tenant's loaded totals, rolled over to the current
+// day and month, or nil when they have not been loaded.
+func (m *usageMeter) cachedLocked(tenant string, now time.Time) *quotaPeriods {
+	p := m.periods[tenant]
+	if p == nil {
+		return nil
+	}
+	day, month := periodStart(now, "day"), periodStart(now, "month")
+	if p.month != month {
+		p.month, p.total = month, UsageCounts{}
+	}
+	if p.day != day {
+		p.day, p.today = day, UsageCounts{}
+	}
+	return p
+}
+
+// load reads tenant's totals from the repo and adds the traffic still
+// pending. It runs without mu so requests are not held up by the query.
+func (m *usageMeter) load(tenant string, now time.Time) (quotaPeriods, error) {
+	m.repoMu.Lock()
+	defer m.repoMu.Unlock()
+
+	// another request may have loaded them while this one waited
+	m.mu.Lock()
+	if p := m.cachedLocked(tenant, now); p != nil {
+		defer m.mu.Unlock()
+		return *p, nil
+	}
+	m.mu.Unlock()
+
+	p := &quotaPeriods{day: periodStart(now, "day"), month: periodStart(now, "month")}
+	var err error
+	if p.today, err = m.repo.Totals(tenant, p.day); err != nil {
+		return quotaPeriods{}, err
+	}
+	if p.total, err = m.repo.Totals(tenant, p.month); err != nil {
+		return quotaPeriods{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, c := range m.pending {
+		if key.tenant != tenant {
+			continue
+		}
+		if key.hour >= p.day {
+			p.today.add(*c)
+		}
+		if key.hour >= p.month {
+			p.total.add(*c)
+		}
+	}
+	m.periods[tenant] = p
+	return *p, nil
+}
+
+// flush writes pending traffic to the repo. Pending is swapped out first so
+// requests keep recording while the write runs; on failure it is put back
+// to be retried on the next flush.
+func (m *usageMeter) flush(now time.Time) error {
+	m.repoMu.Lock()
+	defer m.repoMu.Unlock()
+
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[usageKey]*UsageCounts)
+	// tenants idle since yesterday reload from the repo when they return
+	day := periodStart(now, "day")
+	for tenant, p := range m.periods {
+		if p.day < day {
+			delete(m.periods, tenant)
+		}
+	}
+	m.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	records := make([]*UsageRecord, 0, len(pending))
+	for key, c := range pending {
+		records = append(records, &UsageRecord{Hour: key.hour, Tenant: key.tenant, Subdomain: key.subdomain, UsageCounts: *c})
+	}
+	if err := m.repo.Add(records); err != nil {
+		m.mu.Lock()
+		for key, c := range pending {
+			m.addPendingLocked(key, *c)
+		}
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// periodStart truncates t to the start of its UTC hour, day or month, in
+// unix milliseconds.
+func periodStart(t time.Time, group string) int64 {
+	t = t.UTC()
+	switch group {
+	case "month":
+		t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case "day":
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	default:
+		t = t.Truncate(time.Hour)
+	}
+	return t.UnixMilli()
+}
+
+func (s *Server) recordUsage(sess *Session, bytesIn, bytesOut int64) {
+	if s.usage == nil {
+		return
+	}
+	s.usage.record(sess.Tenant, sess.Subdomain, bytesIn, bytesOut, time.Now())
+}
+
+func (s *Server) flushUsage() {
+	if s.usage == nil {
+		return
+	}
+	if err := s.usage.flush(time.Now()); err != nil {
+		s.logger.WithError(err).Error("server", "usage", "Flush usage failed")
+	}
+}
+
+func (s *Server) flushUsageLoop(ctx context.Context) {
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.flushUsage()
+		}
+	}
+}
+
+// allowQuota refuses requests once tenant has spent a daily or monthly
+// quota of its plan: 429 for requests, 509 for bandwidth. A request may
+// overshoot a bandwidth quota; the next one is refused. Quotas fail open
+// when usage cannot be read.
+func (s *Server) allowQuota(w http.ResponseWriter, subdomain, tenant string) bool {
+	if q := s.quotaExceeded(subdomain, tenant); q != nil {
+		WriteQuotaExceeded(w, q.status, q.msg, q.retryAfter)
+		return false
+	}
+	return true
+}
+
+// quotaBreach is a spent quota and how to answer requests refused by it.
+type quotaBreach struct {
+	status     int
+	msg        string
+	retryAfter int
+}
+
+// quotaExceeded returns the first of tenant's quotas that is spent, having
+// logged and noticed it, or nil when the tenant may go on.
+func (s *Server) quotaExceeded(subdomain, tenant string) *quotaBreach {
+	if s.usage == nil || s.rateLimiter == nil || tenant == "" {
+		return nil
+	}
+	plan := s.rateLimiter.Plan(tenant)
+	if plan.DailyRequests == 0 && plan.MonthlyRequests == 0 && plan.DailyBytes == 0 && plan.MonthlyBytes == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	today, month, err := s.usage.totals(tenant, now)
+	if err != nil {
+		s.logger.WithError(err).WithFields(logging.Fields{"tenant": tenant}).Warn("server", "usage", "Read usage failed, quota not enforced")
+		return nil
+	}
+
+	nextDay := time.UnixMilli(periodStart(now, "day")).Add(24 * time.Hour)
+	nextMonth := time.UnixMilli(periodStart(now, "month")).AddDate(0, 1, 0)
+	quotas := []struct {
+		limit, used int64
+		status      int
+		msg         string
+		resets      time.Time
+	}{
+		{plan.DailyRequests, today.Requests, http.StatusTooManyRequests, "daily request quota exceeded", nextDay},
+		{plan.MonthlyRequests, month.Requests, http.StatusTooManyRequests, "monthly request quota exceeded", nextMonth},
+		{plan.DailyBytes, today.Bytes(), StatusBandwidthLimitExceeded, "daily bandwidth quota exceeded", nextDay},
+		{plan.MonthlyBytes, month.Bytes(), StatusBandwidthLimitExceeded, "monthly bandwidth quota exceeded", nextMonth},
+	}
+	for _, q := range quotas {
+		if q.limit == 0 || q.used < q.limit {
+			continue
+		}
+		retryAfter := ceilSeconds(q.resets.Sub(now))
+		s.logger.WithFields(logging.Fields{"subdomain": subdomain, "tenant": tenant, "plan": plan.Name, "limit": q.limit, "used": q.used}).Warn("server", "usage", "Quota exceeded")
+		s.warnRateLimited(subdomain, q.msg, retryAfter)
+		return &quotaBreach{status: q.status, msg: q.msg, retryAfter: retryAfter}
+	}
+	return nil
+}
+
+func WriteQuotaExceeded(w http.ResponseWriter, status int, msg string, retryAfter int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// handleAdminUsage reports metered traffic. ?tenant= and ?subdomain= narrow
+// it, ?since= and ?until= (unix milliseconds) bound it, defaulting to the
+// last day, and ?group= rolls it up by hour, day or month.
+func (s *Server) handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.usage == nil {
+		writeServerJSONError(w, "usage metering not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	group := q.Get("group")
+	if group == "" {
+		group = "hour"
+	}
+	if group != "hour" && group != "day" && group != "month" {
+		writeServerJSONError(w, "group must be hour, day or month", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	filter := UsageFilter{Tenant: q.Get("tenant"), Subdomain: q.Get("subdomain"), Since: now.Add(-24 * time.Hour).UnixMilli()}
+	for name, dst := range map[string]*int64{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(name); v != "" {
+			ms, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				writeServerJSONError(w, fmt.Sprintf("invalid %s", name), http.StatusBadRequest)
+				return
+			}
+			*dst = ms
+		}
+	}
+	// hours are stored by their start, so include the one since falls in
+	filter.Since = periodStart(time.UnixMilli(filter.Since), "hour")
+
+	s.flushUsage()
+	records, err := s.usage.repo.List(filter)
+	if err != nil {
+		writeServerJSONError(w, "list usage failed", http.StatusInternalServerError)
+		return
+	}
+
+	resp := APIUsageResponse{Group: group, Usage: groupUsage(records, group)}
+	for _, rec := range records {
+		resp.Total.add(rec.UsageCounts)
+	}
+	if filter.Tenant != "" && s.rateLimiter != nil {
+		today, month, err := s.usage.totals(filter.Tenant, now)
+		if err != nil {
+			writeServerJSONError(w, "read usage failed", http.StatusInternalServerError)
+			return
+		}
+		resp.Quota = &APIQuota{Plan: s.rateLimiter.Plan(filter.Tenant), Today: today, Month: month}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// groupUsage rolls hourly records up into days or months, keeping tenants
+// and subdomains apart.
+func groupUsage(records []*UsageRecord, group string) []*UsageRecord {
+	if group == "hour" {
+		if records == nil {
+			return []*UsageRecord{}
+		}
+		return records
+	}
+	byKey := make(map[usageKey]*UsageRecord)
+	out := []*UsageRecord{}
+	for _, rec := range records {
+		key := usageKey{hour: periodStart(time.UnixMilli(rec.Hour), group), tenant: rec.Tenant, subdomain: rec.Subdomain}
+		g := byKey[key]
+		if g == nil {
+			g = &UsageRecord{Hour: key.hour, Tenant: rec.Tenant, Subdomain: rec.Subdomain}
+			byKey[key] = g
+			out = append(out, g)
+		}
+		g.add(rec.UsageCounts)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Hour < out[j].Hour })
+	return out
+}
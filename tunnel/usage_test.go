@@ -0,0 +1,230 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memUsageRepo struct {
+	mu   sync.Mutex
+	rows map[usageKey]*UsageCounts
+}
+
+func newMemUsageRepo() *memUsageRepo {
+	return &memUsageRepo{rows: make(map[usageKey]*UsageCounts)}
+}
+
+func (m *memUsageRepo) Add(records []*UsageRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rec := range records {
+		key := usageKey{hour: rec.Hour, tenant: rec.Tenant, subdomain: rec.Subdomain}
+		if m.rows[key] == nil {
+			m.rows[key] = &UsageCounts{}
+		}
+		m.rows[key].add(rec.UsageCounts)
+	}
+	return nil
+}
+
+func (m *memUsageRepo) List(filter UsageFilter) ([]*UsageRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []*UsageRecord
+	for key, c := range m.rows {
+		if (filter.Tenant != "" && key.tenant != filter.Tenant) || (filter.Subdomain != "" && key.subdomain != filter.Subdomain) {
+			continue
+		}
+		if key.hour < filter.Since || (filter.Until > 0 && key.hour >= filter.Until) {
+			continue
+		}
+		out = append(out, &UsageRecord{Hour: key.hour, Tenant: key.tenant, Subdomain: key.subdomain, UsageCounts: *c})
+	}
+	return out, nil
+}
+
+func (m *memUsageRepo) Totals(tenant string, since int64) (UsageCounts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total UsageCounts
+	for key, c := range m.rows {
+		if key.tenant == tenant && key.hour >= since {
+			total.add(*c)
+		}
+	}
+	return total, nil
+}
+
+func TestUsageMeterTotals(t *testing.T) {
+	repo := newMemUsageRepo()
+	now := time.Date(2026, 3, 15, 12, 30, 0, 0, time.UTC)
+	yesterday := now.Add(-24 * time.Hour)
+	repo.Add([]*UsageRecord{
+		{Hour: periodStart(yesterday, "hour"), Tenant: "acme", Subdomain: "api", UsageCounts: UsageCounts{Requests: 5, BytesIn: 100}},
+		{Hour: periodStart(now.AddDate(0, -1, 0), "hour"), Tenant: "acme", Subdomain: "api", UsageCounts: UsageCounts{Requests: 50}},
+	})
+
+	m := newUsageMeter(repo)
+	m.record("acme", "api", 10, 20, now)
+	m.record("acme", "web", 1, 2, now)
+
+	today, month, err := m.totals("acme", now)
+	require.NoError(t, err)
+	assert.Equal(t, UsageCounts{Requests: 2, BytesIn: 11, BytesOut: 22}, today)
+	assert.Equal(t, UsageCounts{Requests: 7, BytesIn: 111, BytesOut: 22}, month, "last month does not count")
+
+	require.NoError(t, m.flush(now))
+	records, _ := repo.List(UsageFilter{Tenant: "acme", Since: periodStart(now, "day")})
+	assert.Len(t, records, 2, "one row per subdomain")
+
+	// a restart reloads the totals from the repo
+	m = newUsageMeter(repo)
+	today, _, err = m.totals("acme", now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), today.Requests)
+
+	tomorrow := now.Add(24 * time.Hour)
+	today, month, _ = m.totals("acme", tomorrow)
+	assert.Zero(t, today.Requests, "the day rolls over")
+	assert.Equal(t, int64(7), month.Requests)
+}
+
+// slowUsageRepo holds Add until release is closed, then fails it if fail is set.
+type slowUsageRepo struct {
+	*memUsageRepo
+	adding  chan struct{}
+	release chan struct{}
+	fail    bool
+}
+
+func (r *slowUsageRepo) Add(records []*UsageRecord) error {
+	r.adding <- struct{}{}
+	<-r.release
+	if r.fail {
+		return errors.New("disk full")
+	}
+	return r.memUsageRepo.Add(records)
+}
+
+func TestUsageMeterFlushDoesNotBlockRequests(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 30, 0, 0, time.UTC)
+	repo := &slowUsageRepo{memUsageRepo: newMemUsageRepo(), adding: make(chan struct{}), release: make(chan struct{}), fail: true}
+	m := newUsageMeter(repo)
+	m.record("acme", "api", 10, 20, now)
+	_, _, err := m.totals("acme", now)
+	require.NoError(t, err)
+
+	flushed := make(chan error)
+	go func() { flushed <- m.flush(now) }()
+	<-repo.adding
+
+	// the write is in progress; requests still record and check quotas
+	m.record("acme", "api", 1, 2, now)
+	today, _, err := m.totals("acme", now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), today.Requests)
+
+	close(repo.release)
+	assert.Error(t, <-flushed)
+
+	// the failed write is kept and goes out with the next flush
+	repo.fail = false
+	go func() { <-repo.adding }()
+	require.NoError(t, m.flush(now))
+	total, _ := repo.Totals("acme", periodStart(now, "day"))
+	assert.Equal(t, UsageCounts{Requests: 2, BytesIn: 11, BytesOut: 22}, total)
+}
+
+func TestGroupUsage(t *testing.T) {
+	day := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+	records := []*UsageRecord{
+		{Hour: day.Add(time.Hour).UnixMilli(), Tenant: "acme", Subdomain: "api", UsageCounts: UsageCounts{Requests: 1}},
+		{Hour: day.Add(5 * time.Hour).UnixMilli(), Tenant: "acme", Subdomain: "api", UsageCounts: UsageCounts{Requests: 2}},
+		{Hour: day.Add(26 * time.Hour).UnixMilli(), Tenant: "acme", Subdomain: "api", UsageCounts: UsageCounts{Requests: 4}},
+	}
+
+	grouped := groupUsage(records, "day")
+	require.Len(t, grouped, 2)
+	assert.Equal(t, day.UnixMilli(), grouped[0].Hour)
+	assert.Equal(t, int64(3), grouped[0].Requests)
+
+	grouped = groupUsage(records, "month")
+	require.Len(t, grouped, 1)
+	assert.Equal(t, int64(7), grouped[0].Requests)
+}
+
+// startQuotaServer runs a server metering usage, with an anonymous client
+// on subdomain "quota" that echoes request bodies.
+func startQuotaServer(t *testing.T, ctx context.Context, plan RatePlan) *Server {
+	plan.Name = PlanDefault
+	return startTestTunnel(t, ctx, ServerConfig{
+		Reservations: newMemReservationRepo(),
+		AdminToken:   "admin-secret",
+		RatePlans:    newMemRatePlanRepo(plan),
+		Usage:        newMemUsageRepo(),
+	}, ClientConfig{Subdomain: "quota"}, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+}
+
+func postQuota(t *testing.T, srv *Server, body string) *http.Response {
+	req, _ := http.NewRequest("POST", "http://"+srv.Addr()+"/", strings.NewReader(body))
+	req.Host = "quota.test.local"
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func TestRequestQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startQuotaServer(t, ctx, RatePlan{RequestsPerMin: 100, DailyRequests: 2})
+
+	assert.Equal(t, http.StatusOK, postQuota(t, srv, "a").StatusCode)
+	assert.Equal(t, http.StatusOK, postQuota(t, srv, "b").StatusCode)
+	resp := postQuota(t, srv, "c")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+}
+
+func TestBandwidthQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startQuotaServer(t, ctx, RatePlan{RequestsPerMin: 100, MonthlyBytes: 1000})
+
+	body := string(bytes.Repeat([]byte("x"), 400))
+	assert.Equal(t, http.StatusOK, postQuota(t, srv, body).StatusCode)
+	assert.Equal(t, http.StatusOK, postQuota(t, srv, body).StatusCode, "the request that crosses the quota is served")
+	assert.Equal(t, StatusBandwidthLimitExceeded, postQuota(t, srv, body).StatusCode)
+
+	req, _ := http.NewRequest("GET", "http://"+srv.Addr()+"/api/admin/usage?tenant=ip:127.0.0.1&group=day", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var usage APIUsageResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+	require.Len(t, usage.Usage, 1)
+	assert.Equal(t, "quota", usage.Usage[0].Subdomain)
+	assert.Equal(t, UsageCounts{Requests: 2, BytesIn: 800, BytesOut: 800}, usage.Total)
+	require.NotNil(t, usage.Quota)
+	assert.Equal(t, int64(1000), usage.Quota.Plan.MonthlyBytes)
+	assert.Equal(t, int64(1600), usage.Quota.Month.Bytes())
+}
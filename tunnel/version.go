@@ -0,0 +1,92 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProtocolVersion is the newest handshake version this build speaks.
+// SupportedVersions lists every version it accepts, newest first.
+const ProtocolVersion = "1.1"
+
+var SupportedVersions = []string{"1.1", "1.0"}
+
+// Capability flags exchanged at handshake. A feature is only used when both
+// sides advertise it.
+const (
+	CapControl     = "control"
+	CapCompression = "compression"
+	CapPool        = "pool"
+)
+
+// offeredVersions returns the versions a client offered, treating clients
+// that predate negotiation as speaking only their single Version.
+func offeredVersions(req *HandshakeRequest) []string {
+	if len(req.Versions) > 0 {
+		return req.Versions
+	}
+	if req.Version != "" {
+		return []string{req.Version}
+	}
+	return []string{"1.0"}
+}
+
+// offeredCapabilities returns the capabilities a client offered, mapping the
+// pre-negotiation Control flag for older clients.
+func offeredCapabilities(req *HandshakeRequest) []string {
+	if len(req.Capabilities) > 0 {
+		return req.Capabilities
+	}
+	if req.Control {
+		return []string{CapControl}
+	}
+	return nil
+}
+
+// negotiateVersion picks the newest of supported that the peer also offered.
+func negotiateVersion(supported, offered []string) (string, bool) {
+	for _, v := range supported {
+		for _, o := range offered {
+			if v == o {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
+
+// intersectCapabilities returns the capabilities in a that b also lists,
+// keeping the order of a.
+func intersectCapabilities(a, b []string) []string {
+	var out []string
+	for _, c := range a {
+		if hasCapability(b, c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func hasCapability(caps []string, name string) bool {
+	for _, c := range caps {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func versionMismatchError(offered, supported []string) string {
+	return fmt.Sprintf("unsupported protocol version %s (server speaks %s); upgrade devtunnel to connect",
+		strings.Join(offered, ", "), strings.Join(supported, ", "))
+}
+
+// HasCapability reports whether the session negotiated the named capability.
+func (sess *Session) HasCapability(name string) bool {
+	return hasCapability(sess.Capabilities, name)
+}
+
+// capabilities lists what this server can offer a client.
+func (s *Server) capabilities() []string {
+	return []string{CapControl, CapCompression, CapPool}
+}
@@ -0,0 +1,106 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	v, ok := negotiateVersion([]string{"1.1", "1.0"}, []string{"1.0", "1.1"})
+	assert.True(t, ok)
+	assert.Equal(t, "1.1", v)
+
+	v, ok = negotiateVersion([]string{"1.1", "1.0"}, []string{"1.0"})
+	assert.True(t, ok)
+	assert.Equal(t, "1.0", v)
+
+	_, ok = negotiateVersion([]string{"1.1", "1.0"}, []string{"0.9"})
+	assert.False(t, ok)
+}
+
+func TestIntersectCapabilities(t *testing.T) {
+	got := intersectCapabilities([]string{CapControl, CapCompression, "future"}, []string{"future", CapControl})
+	assert.Equal(t, []string{CapControl, "future"}, got)
+	assert.Empty(t, intersectCapabilities([]string{CapControl}, nil))
+}
+
+func TestOfferedFromLegacyRequest(t *testing.T) {
+	req := &HandshakeRequest{Version: "1.0", Control: true}
+	assert.Equal(t, []string{"1.0"}, offeredVersions(req))
+	assert.Equal(t, []string{CapControl}, offeredCapabilities(req))
+
+	assert.Equal(t, []string{"1.0"}, offeredVersions(&HandshakeRequest{}))
+}
+
+// rawHandshake dials the server and sends req as-is, the way an older or
+// foreign client would.
+func rawHandshake(t *testing.T, addr string, req HandshakeRequest) HandshakeResponse {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/connect", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	session, err := yamux.Client(NewWSConn(conn), yamux.DefaultConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { session.Close() })
+
+	stream, err := session.Open()
+	require.NoError(t, err)
+	require.NoError(t, json.NewEncoder(stream).Encode(&req))
+
+	var resp HandshakeResponse
+	require.NoError(t, json.NewDecoder(stream).Decode(&resp))
+	return resp
+}
+
+func TestHandshakeNegotiatesVersion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	client := NewClient(ClientConfig{ServerAddr: srv.Addr(), LocalPort: "3000"})
+	client.SetReconnect(false)
+	require.NoError(t, client.Connect(ctx))
+	defer client.Close()
+
+	assert.Equal(t, ProtocolVersion, client.ProtocolVersion())
+	assert.True(t, client.HasCapability(CapControl))
+	assert.False(t, client.HasCapability("future"))
+
+	sess := srv.GetSession(client.subdomain)
+	require.NotNil(t, sess)
+	assert.Equal(t, ProtocolVersion, sess.Version)
+	assert.True(t, sess.HasCapability(CapControl))
+}
+
+func TestHandshakeAcceptsLegacyClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	resp := rawHandshake(t, srv.Addr(), HandshakeRequest{Version: "1.0"})
+	require.True(t, resp.Success, resp.Error)
+	assert.Equal(t, "1.0", resp.Version)
+	assert.False(t, resp.Control)
+	assert.Empty(t, resp.Capabilities)
+}
+
+func TestHandshakeRejectsIncompatibleVersion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := startTestServer(t, ctx, ServerConfig{})
+
+	resp := rawHandshake(t, srv.Addr(), HandshakeRequest{Version: "0.9", Versions: []string{"0.9"}})
+	assert.False(t, resp.Success)
+	assert.Contains(t, resp.Error, "upgrade devtunnel")
+	assert.Equal(t, SupportedVersions, resp.SupportedVersions)
+	assert.Equal(t, 0, srv.SessionCount())
+}